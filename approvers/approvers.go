@@ -0,0 +1,252 @@
+// Package approvers computes the approval of a PR from the OWNERS of the
+// files it changes: which directories the given approvals cover and whom
+// to ask for the rest.
+//
+// Files are grouped by their owners directory, the closest directory whose
+// OWNERS give them approvers. A directory is approved once an approver of
+// each of its files approved, the approvers of parent directories
+// counting as approvers of their children.
+package approvers
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// Owners tells who approves the files of a repo, as repoowners.RepoOwners
+// does.
+type Owners interface {
+	// Approvers returns the logins that can approve changes to a path.
+	Approvers(path string) []string
+	// LeafApprovers returns the approvers of a path given by its owners
+	// directory alone.
+	LeafApprovers(path string) []string
+	// ApproversDir returns the owners directory of a path.
+	ApproversDir(path string) (string, bool)
+}
+
+// DirApproval is the approval of the files of an owners directory.
+type DirApproval struct {
+	// Dir is the owners directory, "" being the root of the repo.
+	Dir string
+	// Approvers are those who approved files of the directory.
+	Approvers []string
+	// Approved tells whether every file of the directory is approved.
+	Approved bool
+}
+
+// Approvers is the approval of the files of a PR.
+type Approvers struct {
+	owners Owners
+	// files are the changed files by owners directory.
+	files map[string][]string
+	// approved are the logins who approved, lowercased.
+	approved map[string]bool
+}
+
+// NewApprovers returns the approval of files, yet without approvers.
+func NewApprovers(owners Owners, files []string) *Approvers {
+	a := &Approvers{owners: owners, files: map[string][]string{}, approved: map[string]bool{}}
+	for _, f := range files {
+		dir, ok := owners.ApproversDir(f)
+		if !ok {
+			// Files nobody owns are left to the approvers of the root.
+			dir = ""
+		}
+		a.files[dir] = append(a.files[dir], f)
+	}
+	return a
+}
+
+// AddApprover records the approval of login.
+func (a *Approvers) AddApprover(login string) {
+	a.approved[strings.ToLower(login)] = true
+}
+
+// RemoveApprover withdraws the approval of login.
+func (a *Approvers) RemoveApprover(login string) {
+	delete(a.approved, strings.ToLower(login))
+}
+
+// CanApprove reports whether login is an approver of any of the files.
+func (a *Approvers) CanApprove(login string) bool {
+	login = strings.ToLower(login)
+	for _, files := range a.files {
+		for _, f := range files {
+			if contains(a.owners.Approvers(f), login) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllApprovers returns the logins that can approve any of the files, sorted.
+func (a *Approvers) AllApprovers() []string {
+	set := map[string]bool{}
+	for _, files := range a.files {
+		for _, f := range files {
+			for _, login := range a.owners.Approvers(f) {
+				set[login] = true
+			}
+		}
+	}
+	return sortedKeys(set)
+}
+
+// Dirs returns the approval of each owners directory, sorted.
+func (a *Approvers) Dirs() []DirApproval {
+	dirs := make([]DirApproval, 0, len(a.files))
+	for dir, files := range a.files {
+		d := DirApproval{Dir: dir, Approved: true}
+		by := map[string]bool{}
+		for _, f := range files {
+			approved := false
+			for _, login := range a.owners.Approvers(f) {
+				if a.approved[login] {
+					approved = true
+					by[login] = true
+				}
+			}
+			d.Approved = d.Approved && approved
+		}
+		d.Approvers = sortedKeys(by)
+		dirs = append(dirs, d)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Dir < dirs[j].Dir })
+	return dirs
+}
+
+// UnapprovedDirs returns the owners directories still needing approval.
+func (a *Approvers) UnapprovedDirs() []string {
+	var dirs []string
+	for _, d := range a.Dirs() {
+		if !d.Approved {
+			dirs = append(dirs, d.Dir)
+		}
+	}
+	return dirs
+}
+
+// IsApproved reports whether every changed file is approved.
+func (a *Approvers) IsApproved() bool {
+	return len(a.UnapprovedDirs()) == 0
+}
+
+// SuggestedApprovers returns a small set of approvers who could approve
+// the unapproved files between them, excluding the logins in exclude.
+// Approvers closest to the files are preferred, then those covering the
+// most directories.
+func (a *Approvers) SuggestedApprovers(exclude ...string) []string {
+	excluded := map[string]bool{}
+	for _, e := range exclude {
+		excluded[strings.ToLower(e)] = true
+	}
+
+	// The files of each unapproved directory still needing an approver.
+	pending := map[string][]string{}
+	for dir, files := range a.files {
+		for _, f := range files {
+			if !a.fileApproved(f) {
+				pending[dir] = append(pending[dir], f)
+			}
+		}
+	}
+
+	var suggested []string
+	for len(pending) > 0 {
+		best, covered := a.bestApprover(pending, excluded, true)
+		if best == "" {
+			best, covered = a.bestApprover(pending, excluded, false)
+		}
+		if best == "" {
+			// The remaining files have no approver left to suggest.
+			break
+		}
+		suggested = append(suggested, best)
+		excluded[best] = true
+		for _, dir := range covered {
+			delete(pending, dir)
+		}
+	}
+	sort.Strings(suggested)
+	return suggested
+}
+
+// bestApprover returns the approver, among the leaf approvers or all of
+// them, able to approve every pending file of the most directories, and
+// those directories.
+func (a *Approvers) bestApprover(pending map[string][]string, excluded map[string]bool, leaf bool) (string, []string) {
+	covers := map[string][]string{}
+	for dir, files := range pending {
+		// Approvers of a directory must approve all of its pending files.
+		var candidates map[string]bool
+		for _, f := range files {
+			approvers := a.owners.Approvers(f)
+			if leaf {
+				approvers = a.owners.LeafApprovers(f)
+			}
+			current := map[string]bool{}
+			for _, login := range approvers {
+				if !excluded[login] && (candidates == nil || candidates[login]) {
+					current[login] = true
+				}
+			}
+			candidates = current
+		}
+		for login := range candidates {
+			covers[login] = append(covers[login], dir)
+		}
+	}
+	best := ""
+	for _, login := range sortedKeys(boolSet(covers)) {
+		if best == "" || len(covers[login]) > len(covers[best]) {
+			best = login
+		}
+	}
+	return best, covers[best]
+}
+
+func (a *Approvers) fileApproved(f string) bool {
+	for _, login := range a.owners.Approvers(f) {
+		if a.approved[login] {
+			return true
+		}
+	}
+	return false
+}
+
+// DisplayDir renders an owners directory for humans, the root being "/".
+func DisplayDir(dir string) string {
+	if dir == "" {
+		return "/"
+	}
+	return path.Clean(dir)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func boolSet(m map[string][]string) map[string]bool {
+	set := make(map[string]bool, len(m))
+	for k := range m {
+		set[k] = true
+	}
+	return set
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
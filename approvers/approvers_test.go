@@ -0,0 +1,198 @@
+package approvers
+
+import (
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fakeOwners gives the files of a directory the approvers of the closest
+// directory listed, and of its parents.
+type fakeOwners map[string][]string
+
+func (o fakeOwners) dirs(p string) []string {
+	var dirs []string
+	for dir := parent(p); ; dir = parent(dir) {
+		if _, ok := o[dir]; ok {
+			dirs = append(dirs, dir)
+		}
+		if dir == "" {
+			return dirs
+		}
+	}
+}
+
+func (o fakeOwners) Approvers(p string) []string {
+	set := map[string]bool{}
+	for _, dir := range o.dirs(p) {
+		for _, login := range o[dir] {
+			set[login] = true
+		}
+	}
+	return sortedKeys(set)
+}
+
+func (o fakeOwners) LeafApprovers(p string) []string {
+	dirs := o.dirs(p)
+	if len(dirs) == 0 {
+		return nil
+	}
+	leaf := append([]string{}, o[dirs[0]]...)
+	sort.Strings(leaf)
+	return leaf
+}
+
+func (o fakeOwners) ApproversDir(p string) (string, bool) {
+	dirs := o.dirs(p)
+	if len(dirs) == 0 {
+		return "", false
+	}
+	return dirs[0], true
+}
+
+func parent(p string) string {
+	d := path.Dir(p)
+	if d == "." || d == "/" {
+		return ""
+	}
+	return d
+}
+
+var testOwners = fakeOwners{
+	"":          {"root"},
+	"docs":      {"alice", "bob"},
+	"pkg":       {"carol"},
+	"pkg/api":   {"dave"},
+	"pkg/store": {"dave", "erin"},
+}
+
+func TestApprovers(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		approved   []string
+		removed    []string
+		dirs       []DirApproval
+		unapproved []string
+		suggested  []string
+	}{
+		{
+			name:       "no approval",
+			files:      []string{"docs/a.md", "pkg/api/b.go"},
+			dirs:       []DirApproval{{Dir: "docs", Approvers: []string{}}, {Dir: "pkg/api", Approvers: []string{}}},
+			unapproved: []string{"docs", "pkg/api"},
+			suggested:  []string{"alice", "dave"},
+		},
+		{
+			name:     "leaf approvers",
+			files:    []string{"docs/a.md", "pkg/api/b.go"},
+			approved: []string{"Alice", "dave"},
+			dirs: []DirApproval{
+				{Dir: "docs", Approvers: []string{"alice"}, Approved: true},
+				{Dir: "pkg/api", Approvers: []string{"dave"}, Approved: true},
+			},
+		},
+		{
+			name:     "parent approver",
+			files:    []string{"pkg/api/b.go", "pkg/store/c.go"},
+			approved: []string{"carol"},
+			dirs: []DirApproval{
+				{Dir: "pkg/api", Approvers: []string{"carol"}, Approved: true},
+				{Dir: "pkg/store", Approvers: []string{"carol"}, Approved: true},
+			},
+		},
+		{
+			name:     "root approver",
+			files:    []string{"README.md", "docs/a.md"},
+			approved: []string{"root"},
+			dirs: []DirApproval{
+				{Dir: "", Approvers: []string{"root"}, Approved: true},
+				{Dir: "docs", Approvers: []string{"root"}, Approved: true},
+			},
+		},
+		{
+			name:     "partial approval",
+			files:    []string{"docs/a.md", "pkg/api/b.go", "pkg/store/c.go"},
+			approved: []string{"bob"},
+			dirs: []DirApproval{
+				{Dir: "docs", Approvers: []string{"bob"}, Approved: true},
+				{Dir: "pkg/api", Approvers: []string{}},
+				{Dir: "pkg/store", Approvers: []string{}},
+			},
+			unapproved: []string{"pkg/api", "pkg/store"},
+			suggested:  []string{"dave"},
+		},
+		{
+			name:       "removed approval",
+			files:      []string{"docs/a.md"},
+			approved:   []string{"alice"},
+			removed:    []string{"ALICE"},
+			dirs:       []DirApproval{{Dir: "docs", Approvers: []string{}}},
+			unapproved: []string{"docs"},
+			suggested:  []string{"alice"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := NewApprovers(testOwners, tc.files)
+			for _, login := range tc.approved {
+				a.AddApprover(login)
+			}
+			for _, login := range tc.removed {
+				a.RemoveApprover(login)
+			}
+			if got := a.Dirs(); !reflect.DeepEqual(got, tc.dirs) {
+				t.Errorf("Dirs: expected %+v, got %+v", tc.dirs, got)
+			}
+			if got := a.UnapprovedDirs(); !reflect.DeepEqual(got, tc.unapproved) {
+				t.Errorf("UnapprovedDirs: expected %v, got %v", tc.unapproved, got)
+			}
+			if got := a.IsApproved(); got != (len(tc.unapproved) == 0) {
+				t.Errorf("IsApproved: expected %t, got %t", len(tc.unapproved) == 0, got)
+			}
+			if got := a.SuggestedApprovers(); !reflect.DeepEqual(got, tc.suggested) {
+				t.Errorf("SuggestedApprovers: expected %v, got %v", tc.suggested, got)
+			}
+		})
+	}
+}
+
+func TestSuggestedApproversExclude(t *testing.T) {
+	a := NewApprovers(testOwners, []string{"docs/a.md", "pkg/api/b.go"})
+	// Without leaf approvers left, the parent approver covering the most
+	// directories is suggested.
+	if got, expected := a.SuggestedApprovers("Alice", "bob", "dave"), []string{"root"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestCanApprove(t *testing.T) {
+	a := NewApprovers(testOwners, []string{"pkg/api/b.go"})
+	tests := []struct {
+		login string
+		can   bool
+	}{
+		{login: "dave", can: true},
+		{login: "Carol", can: true},
+		{login: "root", can: true},
+		{login: "erin"},
+		{login: "alice"},
+	}
+	for _, tc := range tests {
+		if got := a.CanApprove(tc.login); got != tc.can {
+			t.Errorf("CanApprove(%q): expected %t, got %t", tc.login, tc.can, got)
+		}
+	}
+	if got, expected := a.AllApprovers(), []string{"carol", "dave", "root"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("AllApprovers: expected %v, got %v", expected, got)
+	}
+}
+
+func TestDisplayDir(t *testing.T) {
+	for dir, expected := range map[string]string{"": "/", "pkg/api": "pkg/api", "pkg/": "pkg"} {
+		if got := DisplayDir(dir); got != expected {
+			t.Errorf("DisplayDir(%q): expected %q, got %q", dir, expected, got)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"ci-bot/handlers"
+
+	"github.com/spf13/pflag"
+)
+
+type options struct {
+	ConfigFile string
+	PolicyFile string
+}
+
+func (o *options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ConfigFile, "config-file", "config.json", "Config file to check.")
+	fs.StringVar(&o.PolicyFile, "policy-file", "", "Optional file with the org policy rules the config must satisfy.")
+}
+
+// checkconfig validates the bot config and, given a policy file, lints it
+// against the org's own rules. It exits non-zero on any problem so it can
+// gate PRs changing the config.
+func main() {
+	o := options{}
+	o.AddFlags(pflag.CommandLine)
+	pflag.Parse()
+
+	if _, err := handlers.LoadConfig(o.ConfigFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if o.PolicyFile == "" {
+		return
+	}
+
+	policy, err := handlers.LoadConfigPolicy(o.PolicyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	raw, err := ioutil.ReadFile(o.ConfigFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	violations, err := handlers.LintConfig(raw, policy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, v := range violations {
+		fmt.Fprintln(os.Stderr, v)
+	}
+	if len(violations) > 0 {
+		os.Exit(1)
+	}
+}
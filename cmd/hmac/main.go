@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ci-bot/handlers"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
+)
+
+type options struct {
+	ConfigFile       string
+	TokenFile        string
+	PropagationDelay time.Duration
+	DryRun           bool
+}
+
+func (o *options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ConfigFile, "config-file", "config.json", "Config file of the bot.")
+	fs.StringVar(&o.TokenFile, "github-token-file", "", "File with a GitHub token allowed to edit the hooks.")
+	fs.DurationVar(&o.PropagationDelay, "propagation-delay", time.Minute, "How long the bot takes to see changes of its webhook secret file, e.g. when mounted from a Kubernetes secret.")
+	fs.BoolVar(&o.DryRun, "dry-run", false, "Only print the hooks that would be updated.")
+}
+
+// hmac rotates the secret of the webhooks the bot registers on the repos of
+// its webhooks config, without dropping deliveries:
+//
+//  1. a new secret is added first to the webhook secret file, so that the
+//     bot accepts both the old and the new one,
+//  2. once the bot sees it, the hooks are updated to sign with the new one,
+//  3. the old secrets are removed from the file.
+func main() {
+	o := options{}
+	o.AddFlags(pflag.CommandLine)
+	pflag.Parse()
+
+	if err := run(o); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(o options) error {
+	config, err := handlers.LoadConfig(o.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if config.WebhookSecretFile == "" {
+		return fmt.Errorf("webhook_secret_file must be set for the secrets to be rotated")
+	}
+	if config.WebhookSecret != "" {
+		return fmt.Errorf("webhook_secret must be unset, as the bot would keep accepting it")
+	}
+	if len(config.Webhooks.Repos) == 0 {
+		return fmt.Errorf("no webhooks to rotate the secret of")
+	}
+	if o.DryRun {
+		for _, target := range config.Webhooks.Repos {
+			fmt.Printf("would update the hook of %s\n", target)
+		}
+		return nil
+	}
+	token, err := ioutil.ReadFile(o.TokenFile)
+	if err != nil {
+		return err
+	}
+	client := github.NewClient(oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: strings.TrimSpace(string(token))},
+	)))
+
+	old, err := config.WebhookSecrets()
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	secret := hex.EncodeToString(b)
+	if err := writeSecrets(config.WebhookSecretFile, append([]string{secret}, old...)); err != nil {
+		return err
+	}
+	fmt.Printf("added a new secret to %s, waiting %s for the bot to see it\n", config.WebhookSecretFile, o.PropagationDelay)
+	time.Sleep(o.PropagationDelay)
+
+	var failed []string
+	for _, target := range config.Webhooks.Repos {
+		if err := handlers.SetWebhookSecret(client, config.Webhooks, target, secret); err != nil {
+			fmt.Fprintf(os.Stderr, "fail to update the hook of %s: %v\n", target, err)
+			failed = append(failed, target)
+			continue
+		}
+		fmt.Printf("updated the hook of %s\n", target)
+	}
+	if len(failed) > 0 {
+		// The old secrets are kept for the hooks still signing with them.
+		return fmt.Errorf("the hooks of %s weren't updated, the old secrets are kept", strings.Join(failed, ", "))
+	}
+	if err := writeSecrets(config.WebhookSecretFile, []string{secret}); err != nil {
+		return err
+	}
+	fmt.Printf("removed the old secrets from %s\n", config.WebhookSecretFile)
+	return nil
+}
+
+// writeSecrets replaces the content of the secret file at once, so that
+// the bot never reads it half written.
+func writeSecrets(path string, secrets []string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".hmac")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(secrets, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"ci-bot/podutils"
+)
+
+const usage = "usage: podutils clonerefs|entrypoint|sidecar"
+
+// podutils is the one binary of the utilities decorating job pods, run as
+// the init container checking out the refs, as the entrypoint of the test
+// container and as the sidecar uploading its logs. All three are
+// configured through env, see package podutils.
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+	var err error
+	switch os.Args[1] {
+	case "clonerefs":
+		err = podutils.CloneRefs()
+	case "entrypoint":
+		os.Exit(podutils.Entrypoint())
+	case "sidecar":
+		err = podutils.Sidecar()
+	default:
+		err = fmt.Errorf(usage)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
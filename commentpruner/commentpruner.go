@@ -0,0 +1,146 @@
+// Package commentpruner lets plugins keep a single comment of theirs on an
+// issue or PR: earlier comments are edited or deleted instead of new ones
+// piling up.
+package commentpruner
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// Client is what the pruner needs of the code host of the issue, see
+// handlers.SCM.
+type Client interface {
+	ListComments(org, repo string, number int) ([]*github.IssueComment, error)
+	CreateComment(org, repo string, number int, body string) (*github.IssueComment, error)
+	EditComment(org, repo string, id int64, body string) (*github.IssueComment, error)
+	DeleteComment(org, repo string, id int64) error
+}
+
+// EventClient prunes the comments of the bot on one issue or PR. Comments
+// are listed once, on first use, so that every plugin handling an event
+// shares a single listing.
+type EventClient struct {
+	client  Client
+	botName string
+	owner   string
+	repo    string
+	number  int
+
+	once     sync.Once
+	lock     sync.Mutex
+	comments []*github.IssueComment
+	err      error
+}
+
+// NewEventClient returns a pruner of the comments botName made on
+// owner/repo#number.
+func NewEventClient(client Client, botName, owner, repo string, number int) *EventClient {
+	return &EventClient{
+		client:  client,
+		botName: botName,
+		owner:   owner,
+		repo:    repo,
+		number:  number,
+	}
+}
+
+// HasMarker matches the comments containing marker, typically an HTML
+// comment like <!-- ci-bot:plugin --> that doesn't render.
+func HasMarker(marker string) func(*github.IssueComment) bool {
+	return func(c *github.IssueComment) bool {
+		return strings.Contains(c.GetBody(), marker)
+	}
+}
+
+func (c *EventClient) fetch() error {
+	c.once.Do(func() {
+		comments, err := c.client.ListComments(c.owner, c.repo, c.number)
+		if err != nil {
+			c.err = err
+			return
+		}
+		for _, comment := range comments {
+			if comment.GetUser().GetLogin() == c.botName {
+				c.comments = append(c.comments, comment)
+			}
+		}
+	})
+	return c.err
+}
+
+// PruneComments deletes the bot's comments matched by shouldPrune.
+func (c *EventClient) PruneComments(shouldPrune func(*github.IssueComment) bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.fetch(); err != nil {
+		glog.Errorf("fail to list comments of %s/%s#%d: %v", c.owner, c.repo, c.number, err)
+		return
+	}
+	var kept []*github.IssueComment
+	for _, comment := range c.comments {
+		if !shouldPrune(comment) {
+			kept = append(kept, comment)
+			continue
+		}
+		if err := c.delete(comment); err != nil {
+			glog.Errorf("fail to delete comment %d: %v", comment.GetID(), err)
+			kept = append(kept, comment)
+		}
+	}
+	c.comments = kept
+}
+
+// UpsertComment makes body the content of the bot's comment containing
+// marker, creating the comment if there is none and deleting any extra
+// ones.
+func (c *EventClient) UpsertComment(marker, body string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.fetch(); err != nil {
+		return err
+	}
+	var current *github.IssueComment
+	var kept []*github.IssueComment
+	for _, comment := range c.comments {
+		if !strings.Contains(comment.GetBody(), marker) {
+			kept = append(kept, comment)
+			continue
+		}
+		if current == nil {
+			current = comment
+			kept = append(kept, comment)
+			continue
+		}
+		if err := c.delete(comment); err != nil {
+			glog.Errorf("fail to delete comment %d: %v", comment.GetID(), err)
+			kept = append(kept, comment)
+		}
+	}
+	c.comments = kept
+
+	if current == nil {
+		created, err := c.client.CreateComment(c.owner, c.repo, c.number, body)
+		if err != nil {
+			return err
+		}
+		c.comments = append(c.comments, created)
+		return nil
+	}
+	if current.GetBody() == body {
+		return nil
+	}
+	edited, err := c.client.EditComment(c.owner, c.repo, current.GetID(), body)
+	if err != nil {
+		return err
+	}
+	*current = *edited
+	return nil
+}
+
+func (c *EventClient) delete(comment *github.IssueComment) error {
+	return c.client.DeleteComment(c.owner, c.repo, comment.GetID())
+}
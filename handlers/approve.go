@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ci-bot/approvers"
+
+	"github.com/google/go-github/github"
+)
+
+const approveCommentMarker = "<!-- ci-bot:approve -->"
+
+// associatedIssueReg finds the issues a PR body says it fixes.
+var associatedIssueReg = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?)\s*:?\s+(?:[-.\w]+/[-.\w]+)?#(\d+)\b`)
+
+// Approve is the config of the approve plugin for a set of repos.
+type Approve struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos []string `json:"repos"`
+	// IssueRequired blocks the approval of PRs that don't fix an issue,
+	// unless an approver uses /approve no-issue.
+	IssueRequired bool `json:"issue_required,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "approve",
+		Events:      []string{"issue_comment", "pull_request"},
+		Description: "The approve plugin implements a pull request approval process that manages the '" + approvedLabel + "' label and an approval notification comment. The comment is kept up to date with who approved, which directories are approved and whom to ask for approval. Approval is granted by approvers commenting /approve and, in repos requiring it, only once the PR fixes an issue.",
+		ConfigKey:   "approve",
+		Commands: []PluginCommand{{
+			Usage:       "/approve [no-issue|cancel]",
+			Description: "Approves a pull request. 'no-issue' approves a PR that doesn't fix an issue in repos requiring one, 'cancel' withdraws the approval of the commenter.",
+			WhoCanUse:   "Approvers of the changed files in OWNERS files, or the collaborators of repos without OWNERS files.",
+			Example:     "/approve\n/approve no-issue\n/approve cancel",
+		}},
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, a := range c.Approve {
+			enabled = append(enabled, a.Repos...)
+		}
+		return enabled
+	})
+}
+
+// approveFor returns the approve config of a repo, repo entries taking
+// precedence over org ones.
+func (c *Config) approveFor(org, repo string) (Approve, bool) {
+	var orgConfig *Approve
+	for i, a := range c.Approve {
+		if stringInSlice(org+"/"+repo, a.Repos) {
+			return a, true
+		}
+		if orgConfig == nil && stringInSlice(org, a.Repos) {
+			orgConfig = &c.Approve[i]
+		}
+	}
+	if orgConfig != nil {
+		return *orgConfig, true
+	}
+	return Approve{}, false
+}
+
+// approvalState is the approval of a PR as given by the comments on it.
+type approvalState struct {
+	// approvers are the approvers who approved, lowercased.
+	approvers map[string]bool
+	// noIssue tells whether an approver waived the issue requirement.
+	noIssue bool
+}
+
+// isApprover reports whether login can approve any PR of repo, which
+// collaborators can. It decides in repos without OWNERS files.
+func (s *Server) isApprover(client *github.Client, repo *github.Repository, login string) (bool, error) {
+	return isCollaborator(client, repo, login)
+}
+
+// ownersApproval returns the approval of the files of a PR by the OWNERS
+// files of its base, so that a PR can't make its author an approver. It
+// is nil for repos without OWNERS files.
+func (s *Server) ownersApproval(client *github.Client, repo *github.Repository, pr *github.PullRequest, files []*github.CommitFile) (*approvers.Approvers, error) {
+	owners, err := s.repoOwners(client, repo, pr.GetBase().GetSHA())
+	if err != nil {
+		return nil, err
+	}
+	if owners.Empty() {
+		return nil, nil
+	}
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, f.GetFilename())
+	}
+	return approvers.NewApprovers(owners, names), nil
+}
+
+// approverCheck returns whether login can approve a PR: an approver of its
+// files when there are OWNERS files, a collaborator otherwise unless the
+// repo skips collaborators.
+func (s *Server) approverCheck(client *github.Client, repo *github.Repository, engine *approvers.Approvers) func(login string) (bool, error) {
+	return func(login string) (bool, error) {
+		if engine != nil {
+			return engine.CanApprove(login), nil
+		}
+		if s.Config.skipCollaborators(repo.GetOwner().GetLogin(), repo.GetName()) {
+			return false, nil
+		}
+		return s.isApprover(client, repo, login)
+	}
+}
+
+// approvalFrom replays the /approve commands of the comments of a PR, in
+// order, keeping the ones of approvers.
+func (s *Server) approvalFrom(comments []*github.IssueComment, canApprove func(login string) (bool, error)) (approvalState, error) {
+	state := approvalState{approvers: map[string]bool{}}
+	noIssueBy := map[string]bool{}
+	isApprover := map[string]bool{}
+	for _, c := range comments {
+		login := strings.ToLower(c.GetUser().GetLogin())
+		if login == strings.ToLower(s.BotName) {
+			continue
+		}
+		matches := approveReg.FindAllStringSubmatch(c.GetBody(), -1)
+		if len(matches) == 0 {
+			continue
+		}
+		approver, ok := isApprover[login]
+		if !ok {
+			var err error
+			if approver, err = canApprove(login); err != nil {
+				return state, err
+			}
+			isApprover[login] = approver
+		}
+		if !approver {
+			continue
+		}
+		for _, m := range matches {
+			switch strings.ToLower(m[1]) {
+			case "cancel":
+				delete(state.approvers, login)
+				delete(noIssueBy, login)
+			case "no-issue":
+				state.approvers[login] = true
+				noIssueBy[login] = true
+			default:
+				state.approvers[login] = true
+			}
+		}
+	}
+	state.noIssue = len(noIssueBy) > 0
+	return state, nil
+}
+
+// handleApprove handles the /approve command.
+func (s *Server) handleApprove(client *github.Client, ic *github.IssueCommentEvent) {
+	if !ic.GetIssue().IsPullRequest() {
+		return
+	}
+	if _, ok := s.Config.approveFor(ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName()); !ok {
+		return
+	}
+	org, name, number := ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName(), ic.GetIssue().GetNumber()
+	pr, err := scmFor(client).GetPullRequest(org, name, number)
+	if err != nil {
+		logError(client, "fail to get PR %s#%d: %v", ic.Repo.GetFullName(), number, err)
+		return
+	}
+	files, err := listPullRequestFiles(client, org, name, number)
+	if err != nil {
+		logError(client, "fail to list files of %s#%d: %v", ic.Repo.GetFullName(), number, err)
+		return
+	}
+	engine, err := s.ownersApproval(client, ic.Repo, pr, files)
+	if err != nil {
+		logError(client, "fail to load OWNERS of %s: %v", ic.Repo.GetFullName(), err)
+		return
+	}
+	login := ic.GetComment().GetUser().GetLogin()
+	approver, err := s.approverCheck(client, ic.Repo, engine)(login)
+	if err != nil {
+		logError(client, "fail to check if %s is an approver: %v", login, err)
+		return
+	}
+	if !approver {
+		createComment(client, ic.Repo, ic.GetIssue().GetNumber(), fmt.Sprintf("@%s: only approvers of %s can approve PRs.", login, ic.Repo.GetFullName()))
+		return
+	}
+	s.updateApproval(client, ic.Repo, ic.GetIssue().GetNumber())
+}
+
+// updateApproval applies or removes the approved label of a PR according
+// to its comments.
+func (s *Server) updateApproval(client *github.Client, repo *github.Repository, number int) {
+	config, ok := s.Config.approveFor(repo.GetOwner().GetLogin(), repo.GetName())
+	if !ok {
+		return
+	}
+	pr, err := scmFor(client).GetPullRequest(repo.GetOwner().GetLogin(), repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to get PR %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	files, err := listPullRequestFiles(client, repo.GetOwner().GetLogin(), repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list files of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	engine, err := s.ownersApproval(client, repo, pr, files)
+	if err != nil {
+		logError(client, "fail to load OWNERS of %s: %v", repo.GetFullName(), err)
+		return
+	}
+	comments, err := listIssueComments(client, repo.GetOwner().GetLogin(), repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list comments of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	state, err := s.approvalFrom(comments, s.approverCheck(client, repo, engine))
+	if err != nil {
+		logError(client, "fail to get approval of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+
+	status := approvalStatus{
+		issueRequired: config.IssueRequired,
+		hasIssue:      associatedIssueReg.MatchString(pr.GetBody()),
+		noIssue:       state.noIssue,
+	}
+	for a := range state.approvers {
+		status.approvers = append(status.approvers, a)
+	}
+	sort.Strings(status.approvers)
+	if engine != nil {
+		for a := range state.approvers {
+			engine.AddApprover(a)
+		}
+		for _, d := range engine.Dirs() {
+			status.dirs = append(status.dirs, dirApproval{dir: approvers.DisplayDir(d.Dir), approved: d.Approved})
+		}
+		exclude := append([]string{pr.GetUser().GetLogin()}, s.unavailableReviewers(engine.AllApprovers())...)
+		status.suggested = engine.SuggestedApprovers(exclude...)
+	} else {
+		for _, d := range changedDirs(files) {
+			status.dirs = append(status.dirs, dirApproval{dir: d, approved: len(state.approvers) > 0})
+		}
+		status.suggested, err = s.suggestApprovers(client, repo, pr, state)
+		if err != nil {
+			logError(client, "fail to suggest approvers of %s#%d: %v", repo.GetFullName(), number, err)
+		}
+	}
+
+	labeled := hasLabel(pr.Labels, approvedLabel)
+	switch approved := status.approved(); {
+	case approved && !labeled:
+		addLabel(client, repo, number, approvedLabel)
+	case !approved && labeled:
+		removeLabel(client, repo, number, approvedLabel)
+	}
+	s.upsertComment(client, repo, number, approveCommentMarker, status.message())
+}
+
+// dirApproval is whether the changes in a directory are approved.
+type dirApproval struct {
+	dir      string
+	approved bool
+}
+
+// approvalStatus is everything the approval comment tells about a PR.
+type approvalStatus struct {
+	approvers     []string
+	dirs          []dirApproval
+	suggested     []string
+	issueRequired bool
+	hasIssue      bool
+	noIssue       bool
+}
+
+func (a approvalStatus) issueMissing() bool {
+	return a.issueRequired && !a.hasIssue && !a.noIssue
+}
+
+func (a approvalStatus) approved() bool {
+	if len(a.approvers) == 0 || a.issueMissing() {
+		return false
+	}
+	for _, d := range a.dirs {
+		if !d.approved {
+			return false
+		}
+	}
+	return true
+}
+
+// message renders the approval comment.
+func (a approvalStatus) message() string {
+	var b strings.Builder
+	b.WriteString(approveCommentMarker + "\n[APPROVALNOTIFIER] This PR is ")
+	if a.approved() {
+		b.WriteString("**APPROVED**\n\n")
+	} else {
+		b.WriteString("**NOT APPROVED**\n\n")
+	}
+	if len(a.approvers) > 0 {
+		fmt.Fprintf(&b, "This pull-request has been approved by: %s\n\n", strings.Join(a.approvers, ", "))
+	} else {
+		b.WriteString("No approver has approved this pull-request yet.\n\n")
+	}
+	if len(a.suggested) > 0 && !a.approved() {
+		fmt.Fprintf(&b, "To complete the approval process, ask for approval from: %s\n\n", strings.Join(a.suggested, ", "))
+	}
+	switch {
+	case a.issueMissing():
+		b.WriteString("**This PR needs an associated issue.** Link the issue it fixes in the PR description with e.g. `Fixes #123`, or an approver can comment `/approve no-issue`.\n\n")
+	case a.issueRequired && a.noIssue && !a.hasIssue:
+		b.WriteString("The issue requirement has been waived with `/approve no-issue`.\n\n")
+	}
+	if len(a.dirs) > 0 {
+		b.WriteString("<details>\n<summary>Approval per directory</summary>\n\n| Directory | Approved |\n| --- | --- |\n")
+		for _, d := range a.dirs {
+			mark := ":x:"
+			if d.approved {
+				mark = ":heavy_check_mark:"
+			}
+			fmt.Fprintf(&b, "| `%s` | %s |\n", d.dir, mark)
+		}
+		b.WriteString("\n</details>\n\n")
+	}
+	b.WriteString("Approvers can indicate their approval by writing `/approve` in a comment, and cancel it by writing `/approve cancel`.")
+	return b.String()
+}
+
+// changedDirs returns the sorted directories holding the files of a PR, the
+// repo root being "/".
+func changedDirs(files []*github.CommitFile) []string {
+	seen := map[string]bool{}
+	var dirs []string
+	for _, f := range files {
+		dir := path.Dir(f.GetFilename())
+		if dir == "." {
+			dir = "/"
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// suggestApprovers picks the assignees and requested reviewers of a PR of
+// a repo without OWNERS files who can approve it but haven't yet.
+func (s *Server) suggestApprovers(client *github.Client, repo *github.Repository, pr *github.PullRequest, state approvalState) ([]string, error) {
+	var candidates []string
+	for _, u := range pr.Assignees {
+		candidates = append(candidates, strings.ToLower(u.GetLogin()))
+	}
+	for _, u := range pr.RequestedReviewers {
+		candidates = append(candidates, strings.ToLower(u.GetLogin()))
+	}
+	var suggested []string
+	for _, c := range candidates {
+		if state.approvers[c] || stringInSlice(c, suggested) || c == strings.ToLower(pr.GetUser().GetLogin()) || s.isUnavailable(c) {
+			continue
+		}
+		approver, err := s.isApprover(client, repo, c)
+		if err != nil {
+			return suggested, err
+		}
+		if approver {
+			suggested = append(suggested, c)
+		}
+	}
+	sort.Strings(suggested)
+	return suggested, nil
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "assign",
+		Events:      []string{"issue_comment"},
+		Description: "The assign plugin assigns or requests reviews from users. Assignees are responsible for the issue or PR, reviewers only for a review of the PR.",
+		Commands: []PluginCommand{{
+			Usage:       "/[un]assign [[@]<username>...]",
+			Description: "Assigns the listed users to the issue or PR, or the commenter when none is listed. /unassign removes them.",
+			WhoCanUse:   "Anyone can use the command, but the target users must be able to be assigned in the repo.",
+			Example:     "/assign @spongebob\n/assign spongebob patrick\n/unassign",
+		}, {
+			Usage:       "/[un]cc [[@]<username>...]",
+			Description: "Requests a review from the listed users on a PR, or from the commenter when none is listed. /uncc removes the review requests.",
+			WhoCanUse:   "Anyone can use the command, but the target users must have access to the repo.",
+			Example:     "/cc @spongebob\n/uncc",
+		}},
+	}, enabledEverywhere)
+}
+
+// parseLogins returns the logins listed by the matches of re in body, or
+// the commenter when a match lists none. The first submatch of re tells
+// whether the command removes, the second holds the logins. Logins are
+// returned lowercased and deduplicated, the ones to add first.
+func parseLogins(re *regexp.Regexp, body, commenter string) (add, remove []string) {
+	seen := map[string]bool{}
+	for _, m := range re.FindAllStringSubmatch(body, -1) {
+		logins := strings.Fields(m[2])
+		if len(logins) == 0 {
+			logins = []string{commenter}
+		}
+		for _, l := range logins {
+			l = strings.ToLower(strings.TrimPrefix(l, "@"))
+			if seen[l] {
+				continue
+			}
+			seen[l] = true
+			if m[1] != "" {
+				remove = append(remove, l)
+			} else {
+				add = append(add, l)
+			}
+		}
+	}
+	return add, remove
+}
+
+// assignable splits logins into the users who have access to repo and the
+// ones who don't.
+func assignable(client *github.Client, repo *github.Repository, logins []string) (valid, invalid []string, err error) {
+	for _, l := range logins {
+		ok, err := scmFor(client).IsAssignee(repo.GetOwner().GetLogin(), repo.GetName(), l)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			valid = append(valid, l)
+		} else {
+			invalid = append(invalid, l)
+		}
+	}
+	return valid, invalid, nil
+}
+
+// handleAssign assigns and unassigns the users listed in a comment.
+func (s *Server) handleAssign(client *github.Client, ic *github.IssueCommentEvent) {
+	owner, repo := ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName()
+	number := ic.GetIssue().GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+	add, remove := parseLogins(assignReg, ic.GetComment().GetBody(), login)
+
+	if len(remove) > 0 {
+		if err := scmFor(client).RemoveAssignees(owner, repo, number, remove...); err != nil && !s.reportUnsupported(client, ic.Repo, number, "assign", err) {
+			logError(client, "fail to unassign %v from %s#%d: %v", remove, ic.Repo.GetFullName(), number, err)
+		}
+	}
+	if len(add) == 0 {
+		return
+	}
+
+	valid, invalid, err := assignable(client, ic.Repo, add)
+	if err != nil {
+		if !s.reportUnsupported(client, ic.Repo, number, "assign", err) {
+			logError(client, "fail to check if %v can be assigned: %v", add, err)
+		}
+		return
+	}
+	if len(valid) > 0 {
+		if err := scmFor(client).AddAssignees(owner, repo, number, valid...); err != nil && !s.reportUnsupported(client, ic.Repo, number, "assign", err) {
+			logError(client, "fail to assign %v to %s#%d: %v", valid, ic.Repo.GetFullName(), number, err)
+		}
+	}
+	if len(invalid) > 0 {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: GitHub didn't allow me to assign the following users: %s.\n\n"+
+			"Note that only users with access to %s can be assigned.",
+			login, strings.Join(invalid, ", "), ic.Repo.GetFullName()))
+	}
+}
+
+// handleCC requests and removes reviews of the users listed in a comment.
+func (s *Server) handleCC(client *github.Client, ic *github.IssueCommentEvent) {
+	if !ic.GetIssue().IsPullRequest() {
+		return
+	}
+	owner, repo := ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName()
+	number := ic.GetIssue().GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+	add, remove := parseLogins(ccReg, ic.GetComment().GetBody(), login)
+
+	if len(remove) > 0 {
+		if err := scmFor(client).RemoveReviewers(owner, repo, number, remove...); err != nil && !s.reportUnsupported(client, ic.Repo, number, "assign", err) {
+			logError(client, "fail to remove review requests of %v from %s#%d: %v", remove, ic.Repo.GetFullName(), number, err)
+		}
+	}
+	if len(add) == 0 {
+		return
+	}
+
+	valid, invalid, err := assignable(client, ic.Repo, add)
+	if err != nil {
+		if !s.reportUnsupported(client, ic.Repo, number, "assign", err) {
+			logError(client, "fail to check if %v can review: %v", add, err)
+		}
+		return
+	}
+	// GitHub refuses review requests for the author of a PR.
+	author := strings.ToLower(ic.GetIssue().GetUser().GetLogin())
+	var reviewers []string
+	for _, l := range valid {
+		if l == author {
+			invalid = append(invalid, l)
+		} else {
+			reviewers = append(reviewers, l)
+		}
+	}
+	valid = reviewers
+	// Only the users listed are skipped when away, not a commenter asking
+	// for their own review.
+	var away []string
+	reviewers = nil
+	for _, l := range valid {
+		if l != strings.ToLower(login) && s.isUnavailable(l) {
+			away = append(away, l)
+		} else {
+			reviewers = append(reviewers, l)
+		}
+	}
+	valid = reviewers
+	if len(away) > 0 {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: the following users are unavailable for reviews, so their review wasn't requested: %s.", login, strings.Join(away, ", ")))
+	}
+	if len(valid) > 0 {
+		if err := scmFor(client).RequestReviewers(owner, repo, number, valid...); err != nil && !s.reportUnsupported(client, ic.Repo, number, "assign", err) {
+			logError(client, "fail to request reviews of %v on %s#%d: %v", valid, ic.Repo.GetFullName(), number, err)
+		}
+	}
+	if len(invalid) > 0 {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: GitHub didn't allow me to request a review from the following users: %s.\n\n"+
+			"Note that only users with access to %s can review, and the author of a PR can't review it.",
+			login, strings.Join(invalid, ", "), ic.Repo.GetFullName()))
+	}
+}
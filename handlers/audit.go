@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// AuditRecord is an action of the bot worth keeping after its visible trace
+// on GitHub is gone.
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	Repo   string    `json:"repo"`
+	Number int       `json:"number"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+// recordAudit appends rec to the audit log as a line of JSON. Without an
+// audit log configured the record only goes to the bot's log.
+func (s *Server) recordAudit(rec AuditRecord) {
+	rec.Time = time.Now().UTC()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		glog.Errorf("fail to marshal: %v", err)
+		return
+	}
+	if s.Config.AuditLog == "" {
+		glog.Infof("audit: %s", line)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(s.Config.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		glog.Errorf("fail to open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		glog.Errorf("fail to write audit log: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// Availability is the config of the reviewers away from reviews.
+type Availability struct {
+	// Unavailable are the logins of reviewers away until they are removed.
+	Unavailable []string `json:"unavailable,omitempty"`
+	// StateFile is where the bot keeps who said /busy, so that it survives
+	// restarts. Without it /busy lasts until the bot restarts.
+	StateFile string `json:"state_file,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "availability",
+		Events:      []string{"issue_comment"},
+		Description: "Keeps track of the reviewers who are away, whom blunderbuss, /cc and the approval suggestions skip until they are back.",
+		ConfigKey:   "availability",
+		Commands: []PluginCommand{{
+			Usage:       "/busy",
+			Description: "Marks the commenter as unavailable for reviews.",
+			WhoCanUse:   "Anyone, for themselves.",
+			Example:     "/busy",
+		}, {
+			Usage:       "/available",
+			Description: "Marks the commenter as available for reviews again.",
+			WhoCanUse:   "Anyone, for themselves.",
+			Example:     "/available",
+		}},
+	}, enabledEverywhere)
+}
+
+// busyReviewers are the lowercased logins who said /busy, with when they
+// did.
+var busyReviewers = struct {
+	sync.Mutex
+	loaded bool
+	since  map[string]time.Time
+}{since: map[string]time.Time{}}
+
+// loadBusyReviewers reads the state file once. It must be called with the
+// lock held.
+func (s *Server) loadBusyReviewers() {
+	if busyReviewers.loaded {
+		return
+	}
+	busyReviewers.loaded = true
+	path := s.Config.Availability.StateFile
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("fail to read availability state: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &busyReviewers.since); err != nil {
+		glog.Errorf("fail to unmarshal: %v", err)
+	}
+}
+
+// saveBusyReviewers writes the state file. It must be called with the lock
+// held.
+func (s *Server) saveBusyReviewers() error {
+	path := s.Config.Availability.StateFile
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(busyReviewers.since, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed so that a crash can't leave half a file.
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// isUnavailable reports whether login is away from reviews.
+func (s *Server) isUnavailable(login string) bool {
+	login = strings.ToLower(login)
+	for _, u := range s.Config.Availability.Unavailable {
+		if strings.ToLower(u) == login {
+			return true
+		}
+	}
+	busyReviewers.Lock()
+	defer busyReviewers.Unlock()
+	s.loadBusyReviewers()
+	_, busy := busyReviewers.since[login]
+	return busy
+}
+
+// unavailableReviewers returns the logins away from reviews among logins.
+func (s *Server) unavailableReviewers(logins []string) []string {
+	var away []string
+	for _, l := range logins {
+		if s.isUnavailable(l) {
+			away = append(away, l)
+		}
+	}
+	return away
+}
+
+// handleAvailability handles the /busy and /available commands.
+func (s *Server) handleAvailability(client *github.Client, ic *github.IssueCommentEvent) {
+	login := ic.GetComment().GetUser().GetLogin()
+	busy := busyReg.MatchString(ic.GetComment().GetBody())
+
+	busyReviewers.Lock()
+	s.loadBusyReviewers()
+	_, wasBusy := busyReviewers.since[strings.ToLower(login)]
+	if busy == wasBusy {
+		busyReviewers.Unlock()
+		return
+	}
+	if busy {
+		busyReviewers.since[strings.ToLower(login)] = time.Now().UTC()
+	} else {
+		delete(busyReviewers.since, strings.ToLower(login))
+	}
+	err := s.saveBusyReviewers()
+	busyReviewers.Unlock()
+	if err != nil {
+		logError(client, "fail to save availability state: %v", err)
+	}
+
+	msg := fmt.Sprintf("@%s: you are now marked as unavailable for reviews. Comment `/available` when you are back.", login)
+	if !busy {
+		msg = fmt.Sprintf("@%s: welcome back, you are available for reviews again.", login)
+	}
+	if stringInSlice(login, s.Config.Availability.Unavailable) {
+		msg += " Note that the bot config still lists you as unavailable."
+	}
+	createComment(client, ic.Repo, ic.GetIssue().GetNumber(), msg)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	backportLabelPrefix   = "backport/"
+	backportedLabelPrefix = "backported/"
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "backport",
+		Events:      []string{"pull_request"},
+		Description: "The backport plugin opens a backport PR into every branch a merged PR is labeled '" + backportLabelPrefix + "<branch>' for, then labels it '" + backportedLabelPrefix + "<branch>'. Like /cherrypick, only the labels added by approvers of the repo are acted on. It is enabled in the repos of the cherrypicker plugin.",
+		ConfigKey:   "cherrypicker",
+	}, func(c *Config) []string {
+		return c.Cherrypicker.Repos
+	})
+}
+
+// handleBackport backports a merged PR into the branches of its backport
+// labels it wasn't backported into yet, provided an approver added them.
+func (s *Server) handleBackport(client *github.Client, pe *github.PullRequestEvent) {
+	pr := pe.GetPullRequest()
+	if !pr.GetMerged() || !s.cherrypickerEnabled(pe.Repo) {
+		return
+	}
+	var labelers map[string]string
+	if pe.GetAction() == "closed" {
+		var err error
+		labelers, err = listLabelers(client, pe.Repo, pr.GetNumber())
+		if err != nil {
+			logError(client, "fail to list who labeled %s#%d: %v", pe.Repo.GetFullName(), pr.GetNumber(), err)
+			return
+		}
+	}
+	for _, l := range pr.Labels {
+		if !strings.HasPrefix(l.GetName(), backportLabelPrefix) {
+			continue
+		}
+		target := strings.TrimPrefix(l.GetName(), backportLabelPrefix)
+		done := backportedLabelPrefix + target
+		if hasLabel(pr.Labels, done) {
+			continue
+		}
+		labeler := labelers[l.GetName()]
+		if pe.GetAction() == "labeled" {
+			if pe.GetLabel().GetName() != l.GetName() {
+				continue
+			}
+			labeler = pe.GetSender().GetLogin()
+		}
+		requester := labeler
+		switch labeler {
+		case "":
+			continue
+		case s.BotName:
+			requester = pr.GetMergedBy().GetLogin()
+		default:
+			approver, err := s.isApprover(client, pe.Repo, labeler)
+			if err != nil {
+				logError(client, "fail to check if %s is an approver: %v", labeler, err)
+				continue
+			}
+			if !approver {
+				createComment(client, pe.Repo, pr.GetNumber(), fmt.Sprintf("@%s: only approvers of %s can request backports, ignoring `%s`.", labeler, pe.Repo.GetFullName(), l.GetName()))
+				continue
+			}
+		}
+		if s.cherryPick(client, pe.Repo, pr, target, requester) {
+			addLabel(client, pe.Repo, pr.GetNumber(), done)
+		}
+	}
+}
+
+// listLabelers returns who last added each label of an issue or PR.
+func listLabelers(client *github.Client, repo *github.Repository, number int) (map[string]string, error) {
+	events, err := scmFor(client).ListIssueEvents(repo.GetOwner().GetLogin(), repo.GetName(), number)
+	if err != nil {
+		return nil, err
+	}
+	labelers := map[string]string{}
+	for _, e := range events {
+		if e.Event == "labeled" {
+			labelers[e.Label] = e.Actor
+		}
+	}
+	return labelers, nil
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	benchmarkMarker           = "<!-- ci-bot:benchmark -->"
+	defaultBenchmarkOutput    = "artifacts/bench.txt"
+	defaultBenchmarkThreshold = 10
+)
+
+// Benchmark compares the benchmarks run by a presubmit with the base
+// branch.
+type Benchmark struct {
+	// Job is the decorated presubmit uploading the output of
+	// go test -bench for PRs.
+	Job string `json:"job"`
+	// BaseJob is the decorated postsubmit uploading it for the base branch,
+	// Job by default.
+	BaseJob string `json:"base_job,omitempty"`
+	// Output is the file the jobs upload, artifacts/bench.txt by default.
+	Output string `json:"output,omitempty"`
+	// Threshold is by how many percent a measure may grow before it's
+	// flagged as a regression, 10 by default.
+	Threshold float64 `json:"threshold,omitempty"`
+	// Context of the status failing on regressions. No status is set
+	// without one.
+	Context string `json:"context,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "benchmark",
+		Description: "Comments on PRs with a comparison of the go test -bench results of a presubmit job with the last run of the matching postsubmit on the base branch, flagging the measures that grew by more than a threshold.",
+		ConfigKey:   "benchmark",
+	}, func(c *Config) []string {
+		var enabled []string
+		for k := range c.Benchmark {
+			enabled = append(enabled, k)
+		}
+		return enabled
+	})
+}
+
+func (c *Config) validateBenchmark() error {
+	for key, benchmarks := range c.Benchmark {
+		for _, b := range benchmarks {
+			if b.Job == "" {
+				return fmt.Errorf("benchmark: job not set for %s", key)
+			}
+			if b.Threshold < 0 {
+				return fmt.Errorf("benchmark: negative threshold for job %s of %s", b.Job, key)
+			}
+		}
+	}
+	return nil
+}
+
+// benchmarkFor returns the benchmark config of the presubmit job of repo.
+func (c *Config) benchmarkFor(repo *github.Repository, job string) (Benchmark, bool) {
+	for _, key := range []string{repo.GetFullName(), repo.GetOwner().GetLogin()} {
+		for _, b := range c.Benchmark[key] {
+			if b.Job == job {
+				if b.BaseJob == "" {
+					b.BaseJob = b.Job
+				}
+				if b.Output == "" {
+					b.Output = defaultBenchmarkOutput
+				}
+				if b.Threshold == 0 {
+					b.Threshold = defaultBenchmarkThreshold
+				}
+				return b, true
+			}
+		}
+	}
+	return Benchmark{}, false
+}
+
+// benchmarkResults map each benchmark to the mean of each of its measures,
+// e.g. "ns/op".
+type benchmarkResults map[string]map[string]float64
+
+// parseBenchmarks reads the output of go test -bench, averaging the runs
+// of benchmarks run several times with -count.
+func parseBenchmarks(r io.Reader) (benchmarkResults, error) {
+	sums := map[string]map[string]float64{}
+	runs := map[string]map[string]int{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// BenchmarkName-8   1000   1234 ns/op   56 B/op   2 allocs/op
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") || len(fields)%2 != 0 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		name := fields[0]
+		if sums[name] == nil {
+			sums[name] = map[string]float64{}
+			runs[name] = map[string]int{}
+		}
+		for i := 2; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			sums[name][fields[i+1]] += value
+			runs[name][fields[i+1]]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	results := benchmarkResults{}
+	for name, measures := range sums {
+		results[name] = map[string]float64{}
+		for unit, sum := range measures {
+			results[name][unit] = sum / float64(runs[name][unit])
+		}
+	}
+	return results, nil
+}
+
+func (s *Server) loadBenchmarks(j *Job, name string) (benchmarkResults, error) {
+	if !j.Decorate || s.Config.Jobs.Decoration == nil {
+		return nil, fmt.Errorf("job %s isn't decorated", j.Name)
+	}
+	f, err := s.Config.Jobs.Decoration.openStored(j, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseBenchmarks(f)
+}
+
+// reportBenchmarks comments on the PR of a presubmit that passed with how
+// its benchmarks compare with the base branch, and sets the benchmark
+// status if configured.
+func (s *Server) reportBenchmarks(client *github.Client, j *Job) {
+	repo := j.Refs.repo()
+	cfg, ok := s.Config.benchmarkFor(repo, j.Name)
+	if !ok {
+		return
+	}
+	head, err := s.loadBenchmarks(j, cfg.Output)
+	if err != nil {
+		logError(client, "fail to load the benchmarks of job %s: %v", j.ID, err)
+		return
+	}
+	number := j.Refs.Pulls[0].Number
+	baseRun, ok := s.lastBaseRun(j, cfg.BaseJob)
+	if !ok {
+		s.upsertComment(client, repo, number, benchmarkMarker, fmt.Sprintf("%s\nThere are no benchmark results of %s to compare %s at %s with.", benchmarkMarker, j.Refs.BaseRef, j.Name, j.sha()))
+		return
+	}
+	base, err := s.loadBenchmarks(&baseRun, cfg.Output)
+	if err != nil {
+		logError(client, "fail to load the benchmarks of job %s: %v", baseRun.ID, err)
+		return
+	}
+
+	lines := []string{
+		benchmarkMarker,
+		fmt.Sprintf("Benchmarks of %s at %s compared with %s at %s:", j.Name, j.sha(), j.Refs.BaseRef, baseRun.Refs.BaseSHA),
+		"",
+		"| Benchmark | Measure | Base | Head | Delta | |",
+		"| --- | --- | --- | --- | --- | --- |",
+	}
+	var regressions []string
+	for _, name := range sortedBenchmarks(head) {
+		for _, unit := range sortedUnits(head[name]) {
+			after := head[name][unit]
+			before, ok := base[name][unit]
+			if !ok {
+				lines = append(lines, fmt.Sprintf("| %s | %s | - | %s | - | new |", name, unit, formatMeasure(after)))
+				continue
+			}
+			delta := 0.0
+			if before != 0 {
+				delta = 100 * (after - before) / before
+			}
+			flag := ""
+			// Every go test -bench measure is better lower.
+			if delta > cfg.Threshold {
+				flag = "**regression**"
+				regressions = append(regressions, name)
+			}
+			lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s | %+.1f%% | %s |", name, unit, formatMeasure(before), formatMeasure(after), delta, flag))
+		}
+	}
+	if len(regressions) > 0 {
+		lines = append(lines, "", fmt.Sprintf("Measures flagged as regressions grew by more than %.0f%%.", cfg.Threshold))
+	}
+	s.upsertComment(client, repo, number, benchmarkMarker, strings.Join(lines, "\n"))
+
+	if cfg.Context == "" {
+		return
+	}
+	state, description := "success", "No benchmark regressed"
+	if len(regressions) > 0 {
+		state, description = "failure", fmt.Sprintf("%d benchmark measures regressed", len(regressions))
+	}
+	createStatus(client, repo, j.sha(), cfg.Context, state, description, j.URL)
+}
+
+func sortedBenchmarks(results benchmarkResults) []string {
+	var names []string
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedUnits(measures map[string]float64) []string {
+	var units []string
+	for unit := range measures {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+	return units
+}
+
+func formatMeasure(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
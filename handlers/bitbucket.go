@@ -0,0 +1,971 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// Bitbucket Cloud webhooks are translated into the GitHub events the
+// plugins handle, and the SCM of a Bitbucket workspace, bitbucketSCM,
+// serves the plugins with the Bitbucket API: comments, build statuses,
+// PRs, reviewers, branches, files, members, and the lgtm label as the
+// approval of the bot. Bitbucket has no labels, the calls for other labels
+// fail, as do those for what else Bitbucket lacks.
+
+const bitbucketAPIURL = "https://api.bitbucket.org/2.0/"
+
+var bitbucketClients = struct {
+	sync.Mutex
+	m map[string]*github.Client
+}{m: map[string]*github.Client{}}
+
+func bitbucketClient(workspace string, p Provider) *github.Client {
+	bitbucketClients.Lock()
+	defer bitbucketClients.Unlock()
+	if client, ok := bitbucketClients.m[workspace]; ok {
+		return client
+	}
+	api := p.URL
+	if api == "" {
+		api = bitbucketAPIURL
+	}
+	base, _ := url.Parse(strings.TrimSuffix(api, "/") + "/")
+	client := github.NewClient(&http.Client{Transport: unsupportedTransport("Bitbucket")})
+	client.BaseURL, _ = url.Parse("https://bitbucket.invalid/")
+	registerSCM(client, &bitbucketSCM{
+		user:     p.User,
+		password: p.Token,
+		api:      base,
+		comments: map[int64]int{},
+	})
+	bitbucketClients.m[workspace] = client
+	return client
+}
+
+// Subsets of the Bitbucket objects in webhooks and API responses.
+type bitbucketUser struct {
+	Nickname  string `json:"nickname"`
+	AccountID string `json:"account_id"`
+}
+
+type bitbucketPullRequest struct {
+	ID          int           `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	State       string        `json:"state"`
+	Author      bitbucketUser `json:"author"`
+	Source      bitbucketRef  `json:"source"`
+	Destination bitbucketRef  `json:"destination"`
+	MergeCommit *struct {
+		Hash string `json:"hash"`
+	} `json:"merge_commit"`
+	UpdatedOn time.Time `json:"updated_on"`
+	Links     struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+	Participants []struct {
+		User     bitbucketUser `json:"user"`
+		Approved bool          `json:"approved"`
+	} `json:"participants"`
+}
+
+type bitbucketRef struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+	Commit struct {
+		Hash string `json:"hash"`
+	} `json:"commit"`
+}
+
+type bitbucketRepository struct {
+	Name       string `json:"name"`
+	FullName   string `json:"full_name"`
+	Mainbranch struct {
+		Name string `json:"name"`
+	} `json:"mainbranch"`
+	Links struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+type bitbucketComment struct {
+	ID      int64 `json:"id"`
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+	User bitbucketUser `json:"user"`
+}
+
+func (r bitbucketRepository) github() *github.Repository {
+	workspace := strings.SplitN(r.FullName, "/", 2)[0]
+	return &github.Repository{
+		Name:          github.String(r.Name),
+		FullName:      github.String(r.FullName),
+		HTMLURL:       github.String(r.Links.HTML.Href),
+		DefaultBranch: github.String(r.Mainbranch.Name),
+		Owner:         &github.User{Login: github.String(workspace), Name: github.String(workspace)},
+	}
+}
+
+func (u bitbucketUser) github() *github.User {
+	return &github.User{Login: github.String(u.Nickname)}
+}
+
+// github returns the PR as GitHub would, labeled lgtm when bot approved it.
+func (pr bitbucketPullRequest) github(bot string) *github.PullRequest {
+	state := "open"
+	if pr.State != "OPEN" {
+		state = "closed"
+	}
+	var labels []*github.Label
+	for _, p := range pr.Participants {
+		if p.Approved && p.User.Nickname == bot {
+			labels = append(labels, &github.Label{Name: github.String(lgtmLabel)})
+		}
+	}
+	gh := &github.PullRequest{
+		Number:  github.Int(pr.ID),
+		Title:   github.String(pr.Title),
+		Body:    github.String(pr.Description),
+		State:   github.String(state),
+		Merged:  github.Bool(pr.State == "MERGED"),
+		User:    pr.Author.github(),
+		HTMLURL: github.String(pr.Links.HTML.Href),
+		Labels:  labels,
+		Head:    &github.PullRequestBranch{Ref: github.String(pr.Source.Branch.Name), SHA: github.String(pr.Source.Commit.Hash)},
+		Base:    &github.PullRequestBranch{Ref: github.String(pr.Destination.Branch.Name), SHA: github.String(pr.Destination.Commit.Hash)},
+	}
+	if pr.State == "MERGED" {
+		gh.MergedAt = &pr.UpdatedOn
+		if pr.MergeCommit != nil {
+			gh.MergeCommitSHA = github.String(pr.MergeCommit.Hash)
+		}
+	}
+	return gh
+}
+
+func (pr bitbucketPullRequest) issue(bot string) *github.Issue {
+	gh := pr.github(bot)
+	return &github.Issue{
+		Number:           gh.Number,
+		Title:            gh.Title,
+		Body:             gh.Body,
+		State:            gh.State,
+		User:             gh.User,
+		HTMLURL:          gh.HTMLURL,
+		Labels:           labelValues(gh.Labels),
+		PullRequestLinks: &github.PullRequestLinks{HTMLURL: gh.HTMLURL},
+	}
+}
+
+func labelValues(labels []*github.Label) []github.Label {
+	var values []github.Label
+	for _, l := range labels {
+		values = append(values, *l)
+	}
+	return values
+}
+
+// serveBitbucketHook validates a webhook of a Bitbucket workspace and
+// invokes the handler of the GitHub event it translates to.
+func (s *Server) serveBitbucketHook(w http.ResponseWriter, r *http.Request) {
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		glog.Errorf("fail to read Bitbucket webhook: %v", err)
+		return
+	}
+	var event struct {
+		Actor       bitbucketUser        `json:"actor"`
+		Repository  bitbucketRepository  `json:"repository"`
+		PullRequest bitbucketPullRequest `json:"pullrequest"`
+		Comment     bitbucketComment     `json:"comment"`
+		Push        struct {
+			Changes []struct {
+				Old *struct {
+					Target struct {
+						Hash string `json:"hash"`
+					} `json:"target"`
+				} `json:"old"`
+				New *struct {
+					Type   string `json:"type"`
+					Name   string `json:"name"`
+					Target struct {
+						Hash string `json:"hash"`
+					} `json:"target"`
+				} `json:"new"`
+			} `json:"changes"`
+		} `json:"push"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	repo := event.Repository.github()
+	workspace := repo.GetOwner().GetLogin()
+	p, ok := s.Config.Providers[workspace]
+	if !ok || p.Type != ProviderBitbucket {
+		http.Error(w, "unknown workspace", http.StatusBadRequest)
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(p.WebhookSecret))
+	mac.Write(payload)
+	signature, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get("X-Hub-Signature"), "sha256="))
+	if err != nil || p.WebhookSecret == "" || !hmac.Equal(signature, mac.Sum(nil)) {
+		glog.Errorf("Invalid Bitbucket payload for %s", workspace)
+		webhooksInvalidSignature.inc(ProviderBitbucket, r.Header.Get("X-Event-Key"))
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	client := bitbucketClient(workspace, p)
+	fmt.Fprint(w, "Received a webhook event")
+
+	pr := event.PullRequest.github(p.User)
+	sender := event.Actor.github()
+	delivery := r.Header.Get("X-Request-UUID")
+	dispatch := func(eventType string, handle func([]byte, *github.Client), e interface{}) {
+		translated, err := json.Marshal(e)
+		if err != nil {
+			glog.Errorf("fail to marshal: %v", err)
+			return
+		}
+		s.handleAsync(eventType, delivery, translated, client, func(client *github.Client) { handle(translated, client) })
+	}
+	review := func(state string) {
+		dispatch("pull_request_review", s.handlePullRequestReviewEvent, github.PullRequestReviewEvent{
+			Action:      github.String("submitted"),
+			Review:      &github.PullRequestReview{State: github.String(state), User: sender},
+			PullRequest: pr,
+			Repo:        repo,
+			Sender:      sender,
+		})
+	}
+	prEvent := func(action string) {
+		dispatch("pull_request", s.handlePullRequestEvent, github.PullRequestEvent{
+			Action:      github.String(action),
+			Number:      pr.Number,
+			PullRequest: pr,
+			Repo:        repo,
+			Sender:      sender,
+		})
+	}
+	switch r.Header.Get("X-Event-Key") {
+	case "pullrequest:created":
+		prEvent("opened")
+	case "pullrequest:updated":
+		prEvent("synchronize")
+	case "pullrequest:fulfilled", "pullrequest:rejected":
+		prEvent("closed")
+	case "pullrequest:comment_created":
+		dispatch("issue_comment", s.handleIssueCommentEvent, github.IssueCommentEvent{
+			Action: github.String("created"),
+			Issue:  event.PullRequest.issue(p.User),
+			Comment: &github.IssueComment{
+				ID:   github.Int64(event.Comment.ID),
+				Body: github.String(event.Comment.Content.Raw),
+				User: event.Comment.User.github(),
+			},
+			Repo:   repo,
+			Sender: sender,
+		})
+	case "pullrequest:approved":
+		review("approved")
+	case "pullrequest:changes_request_created":
+		review("changes_requested")
+	case "repo:push":
+		for _, c := range event.Push.Changes {
+			if c.New == nil || c.New.Type != "branch" {
+				continue
+			}
+			push := github.PushEvent{
+				Ref:    github.String("refs/heads/" + c.New.Name),
+				After:  github.String(c.New.Target.Hash),
+				Sender: sender,
+				Repo: &github.PushEventRepository{
+					Name:     repo.Name,
+					FullName: repo.FullName,
+					HTMLURL:  repo.HTMLURL,
+					Owner:    &github.User{Login: github.String(workspace), Name: github.String(workspace)},
+				},
+			}
+			if c.Old != nil {
+				push.Before = github.String(c.Old.Target.Hash)
+			}
+			dispatch("push", s.handlePushEvent, push)
+		}
+	}
+}
+
+// bitbucketSCM is the SCM of a Bitbucket workspace.
+type bitbucketSCM struct {
+	user     string
+	password string
+	api      *url.URL
+
+	// comments map the IDs of the comments listed to their PR, since
+	// Bitbucket edits and deletes comments by PR.
+	mu       sync.Mutex
+	comments map[int64]int
+}
+
+func (b *bitbucketSCM) GetPullRequest(org, repo string, number int) (*github.PullRequest, error) {
+	var pr bitbucketPullRequest
+	if err := b.call(http.MethodGet, fmt.Sprintf("repositories/%s/%s/pullrequests/%d", org, repo, number), nil, &pr); err != nil {
+		return nil, err
+	}
+	return pr.github(b.user), nil
+}
+
+func (b *bitbucketSCM) ListFiles(org, repo string, number int) ([]*github.CommitFile, error) {
+	changed, err := b.ListChangedFiles(org, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	var files []*github.CommitFile
+	for _, f := range changed {
+		files = append(files, &github.CommitFile{Filename: github.String(f.Filename), Status: github.String(f.Status)})
+	}
+	return files, nil
+}
+
+func (b *bitbucketSCM) ListChangedFiles(org, repo string, number int) ([]ChangedFile, error) {
+	var files []ChangedFile
+	err := b.list(fmt.Sprintf("repositories/%s/%s/pullrequests/%d/diffstat", org, repo, number), func(raw json.RawMessage) error {
+		var d struct {
+			Status string `json:"status"`
+			Old    *struct {
+				Path string `json:"path"`
+			} `json:"old"`
+			New *struct {
+				Path string `json:"path"`
+			} `json:"new"`
+		}
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return err
+		}
+		f := ChangedFile{Status: d.Status}
+		switch {
+		case d.New != nil:
+			f.Filename = d.New.Path
+			if d.Old != nil && d.Old.Path != d.New.Path {
+				f.PreviousFilename = d.Old.Path
+			}
+		case d.Old != nil:
+			f.Filename = d.Old.Path
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+func (b *bitbucketSCM) ListComments(org, repo string, number int) ([]*github.IssueComment, error) {
+	var comments []*github.IssueComment
+	err := b.list(fmt.Sprintf("repositories/%s/%s/pullrequests/%d/comments", org, repo, number), func(raw json.RawMessage) error {
+		var c bitbucketComment
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.comments[c.ID] = number
+		b.mu.Unlock()
+		comments = append(comments, c.github())
+		return nil
+	})
+	return comments, err
+}
+
+func (b *bitbucketSCM) CreateComment(org, repo string, number int, body string) (*github.IssueComment, error) {
+	var out bitbucketComment
+	if err := b.call(http.MethodPost, fmt.Sprintf("repositories/%s/%s/pullrequests/%d/comments", org, repo, number), bitbucketContent(body), &out); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.comments[out.ID] = number
+	b.mu.Unlock()
+	return out.github(), nil
+}
+
+func (b *bitbucketSCM) EditComment(org, repo string, id int64, body string) (*github.IssueComment, error) {
+	path, err := b.commentPath(org, repo, id)
+	if err != nil {
+		return nil, err
+	}
+	var out bitbucketComment
+	if err := b.call(http.MethodPut, path, bitbucketContent(body), &out); err != nil {
+		return nil, err
+	}
+	return out.github(), nil
+}
+
+func (b *bitbucketSCM) DeleteComment(org, repo string, id int64) error {
+	path, err := b.commentPath(org, repo, id)
+	if err != nil {
+		return err
+	}
+	return b.call(http.MethodDelete, path, nil, nil)
+}
+
+// commentPath is the path of a comment listed or created before.
+func (b *bitbucketSCM) commentPath(org, repo string, id int64) (string, error) {
+	b.mu.Lock()
+	number, ok := b.comments[id]
+	b.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("unknown comment %d of %s/%s", id, org, repo)
+	}
+	return fmt.Sprintf("repositories/%s/%s/pullrequests/%d/comments/%d", org, repo, number, id), nil
+}
+
+// AddLabels approves the PR for the lgtm label. Bitbucket has no labels,
+// the others fail rather than pretend they were applied.
+func (b *bitbucketSCM) AddLabels(org, repo string, number int, labels ...string) error {
+	for _, label := range labels {
+		if label != lgtmLabel {
+			return unsupportedLabel(label)
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return b.call(http.MethodPost, fmt.Sprintf("repositories/%s/%s/pullrequests/%d/approve", org, repo, number), nil, nil)
+}
+
+func (b *bitbucketSCM) RemoveLabel(org, repo string, number int, label string) error {
+	if label != lgtmLabel {
+		return unsupportedLabel(label)
+	}
+	return b.call(http.MethodDelete, fmt.Sprintf("repositories/%s/%s/pullrequests/%d/approve", org, repo, number), nil, nil)
+}
+
+func unsupportedLabel(name string) error {
+	return fmt.Errorf("Bitbucket has no labels, only %s can be represented, as the approval of the bot: not %s", lgtmLabel, name)
+}
+
+// IsAssignee reports whether login can review the PRs of the workspace,
+// Bitbucket PRs have reviewers rather than assignees.
+func (b *bitbucketSCM) IsAssignee(org, repo, login string) (bool, error) {
+	return b.IsMember(org, login)
+}
+
+func (b *bitbucketSCM) AddAssignees(org, repo string, number int, logins ...string) error {
+	return unsupported("Bitbucket", "assignees")
+}
+
+func (b *bitbucketSCM) RemoveAssignees(org, repo string, number int, logins ...string) error {
+	return unsupported("Bitbucket", "assignees")
+}
+
+func (b *bitbucketSCM) RequestReviewers(org, repo string, number int, logins ...string) error {
+	return b.editReviewers(org, repo, number, func(reviewers map[string]string, accounts map[string]string) error {
+		for _, l := range logins {
+			id, ok := accounts[strings.ToLower(l)]
+			if !ok {
+				return fmt.Errorf("%s is not a member of %s", l, org)
+			}
+			reviewers[strings.ToLower(l)] = id
+		}
+		return nil
+	})
+}
+
+func (b *bitbucketSCM) RemoveReviewers(org, repo string, number int, logins ...string) error {
+	return b.editReviewers(org, repo, number, func(reviewers map[string]string, _ map[string]string) error {
+		for _, l := range logins {
+			delete(reviewers, strings.ToLower(l))
+		}
+		return nil
+	})
+}
+
+// editReviewers sets the reviewers of a PR to what edit makes of the
+// current ones, by lowercased nickname, given the account IDs of the
+// members of the workspace. Bitbucket sets them by editing the PR, by
+// account ID.
+func (b *bitbucketSCM) editReviewers(org, repo string, number int, edit func(reviewers, accounts map[string]string) error) error {
+	path := fmt.Sprintf("repositories/%s/%s/pullrequests/%d", org, repo, number)
+	var pr struct {
+		Title     string          `json:"title"`
+		Reviewers []bitbucketUser `json:"reviewers"`
+	}
+	if err := b.call(http.MethodGet, path, nil, &pr); err != nil {
+		return err
+	}
+	accounts := map[string]string{}
+	err := b.list(fmt.Sprintf("workspaces/%s/members", org), func(raw json.RawMessage) error {
+		var member struct {
+			User bitbucketUser `json:"user"`
+		}
+		if err := json.Unmarshal(raw, &member); err != nil {
+			return err
+		}
+		accounts[strings.ToLower(member.User.Nickname)] = member.User.AccountID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	reviewers := map[string]string{}
+	for _, r := range pr.Reviewers {
+		reviewers[strings.ToLower(r.Nickname)] = r.AccountID
+	}
+	if err := edit(reviewers, accounts); err != nil {
+		return err
+	}
+	ids := []map[string]string{}
+	for _, id := range reviewers {
+		ids = append(ids, map[string]string{"account_id": id})
+	}
+	return b.call(http.MethodPut, path, map[string]interface{}{"title": pr.Title, "reviewers": ids}, nil)
+}
+
+// IsCollaborator counts every member of the workspace as a collaborator
+// of its repos.
+func (b *bitbucketSCM) IsCollaborator(org, repo, login string) (bool, error) {
+	return b.IsMember(org, login)
+}
+
+func (b *bitbucketSCM) IsMember(org, login string) (bool, error) {
+	found := false
+	err := b.list(fmt.Sprintf("workspaces/%s/members", org), func(raw json.RawMessage) error {
+		var member struct {
+			User bitbucketUser `json:"user"`
+		}
+		if err := json.Unmarshal(raw, &member); err != nil {
+			return err
+		}
+		found = found || strings.EqualFold(member.User.Nickname, login)
+		return nil
+	})
+	return found, err
+}
+
+func (b *bitbucketSCM) CreateStatus(org, repo, sha string, status *github.RepoStatus) error {
+	target := status.GetTargetURL()
+	if target == "" {
+		// Bitbucket requires a link.
+		target = fmt.Sprintf("https://bitbucket.org/%s/%s/commits/%s", org, repo, sha)
+	}
+	return b.call(http.MethodPost, fmt.Sprintf("repositories/%s/%s/commit/%s/statuses/build", org, repo, sha), map[string]string{
+		"key":         bitbucketStatusKey(status.GetContext()),
+		"name":        status.GetContext(),
+		"state":       bitbucketStates[status.GetState()],
+		"description": status.GetDescription(),
+		"url":         target,
+	}, nil)
+}
+
+func (b *bitbucketSCM) GetCombinedStatus(org, repo, sha string) (*github.CombinedStatus, error) {
+	return nil, unsupported("Bitbucket", "combined statuses")
+}
+
+var bitbucketStates = map[string]string{
+	"pending": "INPROGRESS",
+	"success": "SUCCESSFUL",
+	"failure": "FAILED",
+	"error":   "FAILED",
+}
+
+// bitbucketStatusKey identifies a status context in the 40 characters
+// Bitbucket allows.
+func bitbucketStatusKey(context string) string {
+	if len(context) <= 40 {
+		return context
+	}
+	sum := sha1.Sum([]byte(context))
+	return hex.EncodeToString(sum[:])
+}
+
+func bitbucketContent(body string) interface{} {
+	return map[string]map[string]string{"content": {"raw": body}}
+}
+
+func (c bitbucketComment) github() *github.IssueComment {
+	return &github.IssueComment{
+		ID:   github.Int64(c.ID),
+		Body: github.String(c.Content.Raw),
+		User: c.User.github(),
+	}
+}
+
+func (b *bitbucketSCM) ListPullRequests(org, repo, base string) ([]*github.PullRequest, error) {
+	query := url.QueryEscape(fmt.Sprintf("destination.branch.name=%q", base))
+	return b.listPullRequests(fmt.Sprintf("repositories/%s/%s/pullrequests?state=OPEN&q=%s", org, repo, query))
+}
+
+func (b *bitbucketSCM) ListPullRequestsWithCommit(org, repo, sha string) ([]*github.PullRequest, error) {
+	return b.listPullRequests(fmt.Sprintf("repositories/%s/%s/commit/%s/pullrequests", org, repo, sha))
+}
+
+func (b *bitbucketSCM) listPullRequests(path string) ([]*github.PullRequest, error) {
+	var prs []*github.PullRequest
+	err := b.list(path, func(raw json.RawMessage) error {
+		var pr bitbucketPullRequest
+		if err := json.Unmarshal(raw, &pr); err != nil {
+			return err
+		}
+		prs = append(prs, pr.github(b.user))
+		return nil
+	})
+	return prs, err
+}
+
+func (b *bitbucketSCM) ListCommits(org, repo string, number int) ([]*github.RepositoryCommit, error) {
+	var commits []*github.RepositoryCommit
+	err := b.list(fmt.Sprintf("repositories/%s/%s/pullrequests/%d/commits", org, repo, number), func(raw json.RawMessage) error {
+		var c struct {
+			Hash    string `json:"hash"`
+			Message string `json:"message"`
+			Author  struct {
+				Raw  string         `json:"raw"`
+				User *bitbucketUser `json:"user"`
+			} `json:"author"`
+		}
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return err
+		}
+		// The raw author is "Name <email>".
+		author := &github.CommitAuthor{Name: github.String(c.Author.Raw)}
+		if addr, err := mail.ParseAddress(c.Author.Raw); err == nil {
+			author = &github.CommitAuthor{Name: github.String(addr.Name), Email: github.String(addr.Address)}
+		}
+		commit := &github.RepositoryCommit{
+			SHA:    github.String(c.Hash),
+			Commit: &github.Commit{SHA: github.String(c.Hash), Message: github.String(c.Message), Author: author},
+		}
+		if c.Author.User != nil {
+			commit.Author = c.Author.User.github()
+		}
+		commits = append(commits, commit)
+		return nil
+	})
+	return commits, err
+}
+
+func (b *bitbucketSCM) GetPatch(org, repo string, number int) (string, error) {
+	var patch []byte
+	err := b.call(http.MethodGet, fmt.Sprintf("repositories/%s/%s/pullrequests/%d/patch", org, repo, number), nil, &patch)
+	return string(patch), err
+}
+
+func (b *bitbucketSCM) CreatePullRequest(org, repo string, pr *github.NewPullRequest) (*github.PullRequest, error) {
+	branch := func(name string) interface{} {
+		return map[string]map[string]string{"branch": {"name": name}}
+	}
+	var created bitbucketPullRequest
+	err := b.call(http.MethodPost, fmt.Sprintf("repositories/%s/%s/pullrequests", org, repo), map[string]interface{}{
+		"title":       pr.GetTitle(),
+		"description": pr.GetBody(),
+		"source":      branch(pr.GetHead()),
+		"destination": branch(pr.GetBase()),
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+	return created.github(b.user), nil
+}
+
+// CreateReview comments the review, Bitbucket comments lines rather than
+// diff positions.
+func (b *bitbucketSCM) CreateReview(org, repo string, number int, review *github.PullRequestReviewRequest) error {
+	_, err := b.CreateComment(org, repo, number, reviewMessage(review))
+	return err
+}
+
+// GetIssue returns the PR as an issue, the plugins only see Bitbucket PRs.
+func (b *bitbucketSCM) GetIssue(org, repo string, number int) (*github.Issue, error) {
+	var pr bitbucketPullRequest
+	if err := b.call(http.MethodGet, fmt.Sprintf("repositories/%s/%s/pullrequests/%d", org, repo, number), nil, &pr); err != nil {
+		return nil, err
+	}
+	return pr.issue(b.user), nil
+}
+
+// EditIssue retitles or declines the PR. Declined PRs can't be reopened.
+func (b *bitbucketSCM) EditIssue(org, repo string, number int, edit *github.IssueRequest) error {
+	path := fmt.Sprintf("repositories/%s/%s/pullrequests/%d", org, repo, number)
+	if edit.Milestone != nil {
+		return unsupported("Bitbucket", "milestones")
+	}
+	if edit.Title != nil {
+		if err := b.call(http.MethodPut, path, map[string]string{"title": edit.GetTitle()}, nil); err != nil {
+			return err
+		}
+	}
+	switch edit.GetState() {
+	case "closed":
+		return b.call(http.MethodPost, path+"/decline", nil, nil)
+	case "open":
+		return unsupported("Bitbucket", "reopening pull requests")
+	}
+	return nil
+}
+
+func (b *bitbucketSCM) LockIssue(org, repo string, number int, reason string) error {
+	return unsupported("Bitbucket", "locking conversations")
+}
+
+func (b *bitbucketSCM) UnlockIssue(org, repo string, number int) error {
+	return unsupported("Bitbucket", "locking conversations")
+}
+
+// ListIssueEvents lists the approvals of the bot as the lgtm label they
+// stand for.
+func (b *bitbucketSCM) ListIssueEvents(org, repo string, number int) ([]IssueEvent, error) {
+	var events []IssueEvent
+	err := b.list(fmt.Sprintf("repositories/%s/%s/pullrequests/%d/activity", org, repo, number), func(raw json.RawMessage) error {
+		var a struct {
+			Approval *struct {
+				Date time.Time     `json:"date"`
+				User bitbucketUser `json:"user"`
+			} `json:"approval"`
+		}
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return err
+		}
+		if a.Approval != nil && a.Approval.User.Nickname == b.user {
+			events = append(events, IssueEvent{Event: "labeled", Actor: b.user, Label: lgtmLabel, CreatedAt: a.Approval.Date})
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (b *bitbucketSCM) SearchIssues(query, sort string, limit int) ([]github.Issue, int, error) {
+	return nil, 0, unsupported("Bitbucket", "searching issues")
+}
+
+func (b *bitbucketSCM) TransferIssue(org, repo string, number int, dest string) (int, error) {
+	return 0, unsupported("Bitbucket", "transferring issues")
+}
+
+func (b *bitbucketSCM) CreateIssueReaction(org, repo string, number int, reaction string) error {
+	return unsupported("Bitbucket", "reactions")
+}
+
+func (b *bitbucketSCM) CreateCommentReaction(org, repo string, id int64, reaction string) error {
+	return unsupported("Bitbucket", "reactions")
+}
+
+// ListLabels returns the lgtm label, the only one Bitbucket represents.
+func (b *bitbucketSCM) ListLabels(org, repo string) ([]*github.Label, error) {
+	return []*github.Label{{Name: github.String(lgtmLabel)}}, nil
+}
+
+func (b *bitbucketSCM) ListIssueLabels(org, repo string, number int) ([]*github.Label, error) {
+	pr, err := b.GetPullRequest(org, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	return pr.Labels, nil
+}
+
+func (b *bitbucketSCM) ListMilestones(org, repo string) ([]*github.Milestone, error) {
+	return nil, unsupported("Bitbucket", "milestones")
+}
+
+func (b *bitbucketSCM) IsTeamMember(org, team, login string) (bool, error) {
+	return false, unsupported("Bitbucket", "teams")
+}
+
+func (b *bitbucketSCM) GetPermissionLevel(org, repo, login string) (string, error) {
+	query := url.QueryEscape(fmt.Sprintf("user.nickname=%q", login))
+	level := "none"
+	err := b.list(fmt.Sprintf("workspaces/%s/permissions/repositories/%s?q=%s", org, repo, query), func(raw json.RawMessage) error {
+		var p struct {
+			Permission string `json:"permission"`
+		}
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return err
+		}
+		level = p.Permission
+		return nil
+	})
+	return level, err
+}
+
+func (b *bitbucketSCM) GetRepo(org, repo string) (*github.Repository, error) {
+	var r bitbucketRepository
+	if err := b.call(http.MethodGet, fmt.Sprintf("repositories/%s/%s", org, repo), nil, &r); err != nil {
+		return nil, err
+	}
+	return r.github(), nil
+}
+
+// GetBranch counts the branches with restrictions as protected.
+func (b *bitbucketSCM) GetBranch(org, repo, branch string) (*github.Branch, error) {
+	var ref struct {
+		Name   string `json:"name"`
+		Target struct {
+			Hash string `json:"hash"`
+		} `json:"target"`
+	}
+	if err := b.call(http.MethodGet, fmt.Sprintf("repositories/%s/%s/refs/branches/%s", org, repo, pathEscape(branch)), nil, &ref); err != nil {
+		return nil, err
+	}
+	protected := false
+	err := b.list(fmt.Sprintf("repositories/%s/%s/branch-restrictions?pattern=%s", org, repo, url.QueryEscape(branch)), func(json.RawMessage) error {
+		protected = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &github.Branch{
+		Name:      github.String(ref.Name),
+		Commit:    &github.RepositoryCommit{SHA: github.String(ref.Target.Hash)},
+		Protected: github.Bool(protected),
+	}, nil
+}
+
+func (b *bitbucketSCM) DeleteBranch(org, repo, branch string) error {
+	return b.call(http.MethodDelete, fmt.Sprintf("repositories/%s/%s/refs/branches/%s", org, repo, pathEscape(branch)), nil, nil)
+}
+
+// GetRequiredStatusChecks returns none, Bitbucket requires passing builds
+// rather than given ones.
+func (b *bitbucketSCM) GetRequiredStatusChecks(org, repo, branch string) ([]string, error) {
+	return nil, nil
+}
+
+func (b *bitbucketSCM) GetFile(org, repo, path, ref string) ([]byte, error) {
+	if ref == "" {
+		r, err := b.GetRepo(org, repo)
+		if err != nil {
+			return nil, err
+		}
+		ref = r.GetDefaultBranch()
+	}
+	var content []byte
+	err := b.call(http.MethodGet, fmt.Sprintf("repositories/%s/%s/src/%s/%s", org, repo, url.PathEscape(ref), pathEscape(path)), nil, &content)
+	return content, err
+}
+
+func (b *bitbucketSCM) ListTree(org, repo, sha string) ([]string, error) {
+	var paths []string
+	err := b.list(fmt.Sprintf("repositories/%s/%s/src/%s/?max_depth=%d&pagelen=100", org, repo, sha, bitbucketMaxDepth), func(raw json.RawMessage) error {
+		var e struct {
+			Type string `json:"type"`
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return err
+		}
+		if e.Type == "commit_file" {
+			paths = append(paths, e.Path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// bitbucketMaxDepth is how deep the listings of trees go.
+const bitbucketMaxDepth = 100
+
+func (b *bitbucketSCM) GetTreeHash(org, repo, sha string) (string, error) {
+	return "", unsupported("Bitbucket", "tree hashes")
+}
+
+func (b *bitbucketSCM) Blame(org, repo, sha, path string) ([]BlameRange, error) {
+	return nil, unsupported("Bitbucket", "blame")
+}
+
+func (b *bitbucketSCM) ListProjects(org, repo string) ([]*github.Project, error) {
+	return nil, unsupported("Bitbucket", "project boards")
+}
+
+func (b *bitbucketSCM) ListProjectColumns(project int64) ([]*github.ProjectColumn, error) {
+	return nil, unsupported("Bitbucket", "project boards")
+}
+
+func (b *bitbucketSCM) ListProjectCards(column int64) ([]*github.ProjectCard, error) {
+	return nil, unsupported("Bitbucket", "project boards")
+}
+
+func (b *bitbucketSCM) MoveProjectCard(card, column int64) error {
+	return unsupported("Bitbucket", "project boards")
+}
+
+func (b *bitbucketSCM) CreateProjectCard(column, content int64, contentType string) error {
+	return unsupported("Bitbucket", "project boards")
+}
+
+// call sends a request to the Bitbucket API and decodes its
+// response, or stores it as is in a *[]byte out.
+func (b *bitbucketSCM) call(method, path string, in, out interface{}) error {
+	target := path
+	if !strings.HasPrefix(path, "https://") {
+		target = b.api.String() + path
+	}
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, target, &body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.user, b.password)
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &apiError{method: method, path: path, status: resp.StatusCode, body: respBody}
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw = respBody
+		return nil
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// list calls each for the values of every page of a Bitbucket listing.
+func (b *bitbucketSCM) list(path string, each func(json.RawMessage) error) error {
+	for path != "" {
+		var page struct {
+			Values []json.RawMessage `json:"values"`
+			Next   string            `json:"next"`
+		}
+		if err := b.call(http.MethodGet, path, nil, &page); err != nil {
+			return err
+		}
+		for _, v := range page.Values {
+			if err := each(v); err != nil {
+				return err
+			}
+		}
+		path = page.Next
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// maxBlamedFiles bounds the blame queries made for a PR.
+const maxBlamedFiles = 10
+
+// blameQuery returns who last changed each range of lines of a file at a
+// commit, which only the GraphQL API tells.
+const blameQuery = `query($owner: String!, $name: String!, $sha: GitObjectID!, $path: String!) {
+  repository(owner: $owner, name: $name) {
+    object(oid: $sha) {
+      ... on Commit {
+        blame(path: $path) {
+          ranges {
+            startingLine
+            endingLine
+            commit { committedDate author { user { login } } }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type blameRange struct {
+	StartingLine int `json:"startingLine"`
+	EndingLine   int `json:"endingLine"`
+	Commit       struct {
+		CommittedDate time.Time `json:"committedDate"`
+		Author        struct {
+			User *struct {
+				Login string `json:"login"`
+			} `json:"user"`
+		} `json:"author"`
+	} `json:"commit"`
+}
+
+// changedOldLines returns the lines of the old version of a file a patch
+// changes: the removed ones and those new lines are inserted before.
+func changedOldLines(patch string) map[int]bool {
+	lines := map[int]bool{}
+	old := 0
+	for _, l := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(l, "@@") {
+			// @@ -old,count +new,count @@
+			fields := strings.Fields(l)
+			if len(fields) > 1 {
+				old, _ = strconv.Atoi(strings.SplitN(strings.TrimPrefix(fields[1], "-"), ",", 2)[0])
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(l, "-"):
+			lines[old] = true
+			old++
+		case strings.HasPrefix(l, "+"):
+			if old > 0 {
+				lines[old] = true
+			}
+		default:
+			old++
+		}
+	}
+	return lines
+}
+
+// blameTouches returns, by lowercased login, how many of the lines changed
+// by files they last changed since the given time, blaming the files at
+// sha.
+func blameTouches(client *github.Client, repo *github.Repository, sha string, files []*github.CommitFile, since time.Time) (map[string]int, error) {
+	touches := map[string]int{}
+	blamed := 0
+	for _, f := range files {
+		if f.GetStatus() != "modified" || f.GetPatch() == "" {
+			continue
+		}
+		if blamed == maxBlamedFiles {
+			break
+		}
+		blamed++
+		changed := changedOldLines(f.GetPatch())
+		ranges, err := scmFor(client).Blame(repo.GetOwner().GetLogin(), repo.GetName(), sha, f.GetFilename())
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range ranges {
+			if r.Login == "" || r.Date.Before(since) {
+				continue
+			}
+			for l := r.StartLine; l <= r.EndLine; l++ {
+				if changed[l] {
+					touches[strings.ToLower(r.Login)]++
+				}
+			}
+		}
+	}
+	return touches, nil
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const defaultReviewerCount = 2
+
+// Blunderbuss is the config of the automatic review requests.
+type Blunderbuss struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos []string `json:"repos,omitempty"`
+	// ReviewerCount is how many reviewers are requested, 2 by default.
+	ReviewerCount int `json:"request_count,omitempty"`
+	// FileWeightCount weights reviewers by the number of changed lines in
+	// the files they own instead of picking them uniformly.
+	FileWeightCount bool `json:"file_weight_count,omitempty"`
+	// BalanceWorkload makes reviewers with fewer open review requests in
+	// the org more likely to be picked.
+	BalanceWorkload bool `json:"balance_workload,omitempty"`
+	// MaxOpenReviews skips the reviewers with as many open review requests
+	// in the org, when not 0.
+	MaxOpenReviews int `json:"max_open_reviews,omitempty"`
+	// BlameDays makes the reviewers who changed the lines a PR changes in
+	// the last BlameDays more likely to be picked, when not 0.
+	BlameDays int `json:"blame_days,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "blunderbuss",
+		Events:      []string{"pull_request"},
+		Description: "Requests reviews of new pull requests from reviewers picked among the OWNERS of the changed files, at random or weighted by how many of the changed lines they own, optionally favoring reviewers with fewer open review requests or who recently changed the same lines.",
+		ConfigKey:   "blunderbuss",
+	}, func(c *Config) []string {
+		return c.Blunderbuss.Repos
+	})
+}
+
+// reviewLoads caches the number of open review requests of org/login.
+var reviewLoads = struct {
+	sync.Mutex
+	cache map[string]reviewLoad
+}{cache: map[string]reviewLoad{}}
+
+type reviewLoad struct {
+	open    int
+	expires time.Time
+}
+
+// reviewLoadTTL keeps the searches, which are rate limited harder than the
+// rest of the API, to a few per reviewer and hour.
+const reviewLoadTTL = 15 * time.Minute
+
+// openReviews returns the number of open PRs of org requesting a review
+// from login.
+func openReviews(client *github.Client, org, login string) (int, error) {
+	key := org + "/" + login
+	reviewLoads.Lock()
+	cached, ok := reviewLoads.cache[key]
+	reviewLoads.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.open, nil
+	}
+
+	query := fmt.Sprintf("is:pr is:open org:%s review-requested:%s", org, login)
+	_, total, err := scmFor(client).SearchIssues(query, "", 1)
+	if err != nil {
+		return 0, err
+	}
+	reviewLoads.Lock()
+	reviewLoads.cache[key] = reviewLoad{open: total, expires: time.Now().Add(reviewLoadTTL)}
+	reviewLoads.Unlock()
+	return total, nil
+}
+
+var blunderbussRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// reviewerWeights returns the candidate reviewers of the files of a PR, with
+// the number of changed lines they own, or 1 per file they own.
+func reviewerWeights(files []*github.CommitFile, reviewers func(path string) []string, byLines bool) map[string]int {
+	weights := map[string]int{}
+	for _, f := range files {
+		weight := 1
+		if byLines {
+			weight = f.GetChanges()
+			if weight == 0 {
+				// Renames and binary files still need a look.
+				weight = 1
+			}
+		}
+		for _, r := range reviewers(f.GetFilename()) {
+			weights[r] += weight
+		}
+	}
+	return weights
+}
+
+// pickReviewers draws count reviewers without replacement, each with a
+// chance proportional to its weight.
+func pickReviewers(weights map[string]float64, count int) []string {
+	candidates := make([]string, 0, len(weights))
+	for r := range weights {
+		candidates = append(candidates, r)
+	}
+	// Sorted so that a seed gives the same picks.
+	sort.Strings(candidates)
+
+	blunderbussRand.Lock()
+	defer blunderbussRand.Unlock()
+	var picked []string
+	for len(picked) < count && len(candidates) > 0 {
+		total := 0.0
+		for _, c := range candidates {
+			total += weights[c]
+		}
+		n := blunderbussRand.Float64() * total
+		i := 0
+		// The last candidate takes what rounding errors leave over.
+		for ; i < len(candidates)-1; i++ {
+			if n < weights[candidates[i]] {
+				break
+			}
+			n -= weights[candidates[i]]
+		}
+		picked = append(picked, candidates[i])
+		candidates = append(candidates[:i], candidates[i+1:]...)
+	}
+	return picked
+}
+
+// handleBlunderbuss requests reviews of a new PR from the OWNERS of its
+// files.
+func (s *Server) handleBlunderbuss(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	c := s.Config.Blunderbuss
+	org := repo.GetOwner().GetLogin()
+	if !stringInSlice(repo.GetFullName(), c.Repos) && !stringInSlice(org, c.Repos) {
+		return
+	}
+	count := c.ReviewerCount
+	if count == 0 {
+		count = defaultReviewerCount
+	}
+	count -= len(pr.RequestedReviewers)
+	if count <= 0 {
+		return
+	}
+
+	owners, err := s.repoOwners(client, repo, pr.GetBase().GetSHA())
+	if err != nil {
+		if !s.reportUnsupported(client, repo, pr.GetNumber(), "blunderbuss", err) {
+			logError(client, "fail to load OWNERS of %s: %v", repo.GetFullName(), err)
+		}
+		return
+	}
+	if owners.Empty() {
+		return
+	}
+	files, err := listPullRequestFiles(client, org, repo.GetName(), pr.GetNumber())
+	if err != nil {
+		logError(client, "fail to list files of %s#%d: %v", repo.GetFullName(), pr.GetNumber(), err)
+		return
+	}
+	weights := reviewerWeights(files, func(path string) []string {
+		if reviewers := owners.Reviewers(path); len(reviewers) > 0 {
+			return reviewers
+		}
+		return owners.Approvers(path)
+	}, c.FileWeightCount)
+	delete(weights, strings.ToLower(pr.GetUser().GetLogin()))
+	for _, r := range pr.RequestedReviewers {
+		delete(weights, strings.ToLower(r.GetLogin()))
+	}
+	for r := range weights {
+		if s.isUnavailable(r) {
+			delete(weights, r)
+		}
+	}
+
+	var touches map[string]int
+	if c.BlameDays > 0 {
+		touches, err = blameTouches(client, repo, pr.GetBase().GetSHA(), files, time.Now().AddDate(0, 0, -c.BlameDays))
+		if err != nil && !s.reportUnsupported(client, repo, pr.GetNumber(), "blunderbuss", err) {
+			logError(client, "fail to blame the files of %s#%d: %v", repo.GetFullName(), pr.GetNumber(), err)
+		}
+	}
+
+	balanced := map[string]float64{}
+	for r, w := range weights {
+		weight := 1.0
+		if c.FileWeightCount {
+			weight = float64(w)
+		}
+		// Each recently changed line counts as much as a line to review.
+		weight += float64(touches[r])
+		if !c.BalanceWorkload && c.MaxOpenReviews == 0 {
+			balanced[r] = weight
+			continue
+		}
+		open, err := openReviews(client, org, r)
+		if err != nil {
+			if !s.reportUnsupported(client, repo, pr.GetNumber(), "blunderbuss", err) {
+				logError(client, "fail to count the open reviews of %s: %v", r, err)
+			}
+			balanced[r] = weight
+			continue
+		}
+		if c.MaxOpenReviews > 0 && open >= c.MaxOpenReviews {
+			continue
+		}
+		balanced[r] = weight
+		if c.BalanceWorkload {
+			balanced[r] /= float64(1 + open)
+		}
+	}
+	reviewers := pickReviewers(balanced, count)
+	if len(reviewers) == 0 {
+		return
+	}
+	err = scmFor(client).RequestReviewers(org, repo.GetName(), pr.GetNumber(), reviewers...)
+	if err != nil && !s.reportUnsupported(client, repo, pr.GetNumber(), "blunderbuss", err) {
+		logError(client, "fail to request reviews of %s#%d from %v: %v", repo.GetFullName(), pr.GetNumber(), reviewers, err)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"github.com/google/go-github/github"
+)
+
+// BranchCleaner deletes the source branches of merged PRs.
+type BranchCleaner struct {
+	// Repos are the orgs and org/repos whose merged branches are deleted.
+	Repos []string `json:"repos,omitempty"`
+	// PreservedBranches are never deleted, e.g. release branches merged
+	// back into master.
+	PreservedBranches []string `json:"preserved_branches,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "branchcleaner",
+		Events:      []string{"pull_request"},
+		Description: "The branchcleaner plugin automatically deletes source branches for merged PRs between two branches on the same repository. This is helpful to keep repos that don't allow forking clean.",
+		ConfigKey:   "branch_cleaner",
+	}, func(c *Config) []string {
+		return c.BranchCleaner.Repos
+	})
+}
+
+// handleBranchCleaner deletes the branch of a merged PR unless it lives in a
+// fork.
+func (s *Server) handleBranchCleaner(client *github.Client, pe *github.PullRequestEvent) {
+	repo := pe.GetRepo()
+	if !stringInSlice(repo.GetFullName(), s.Config.BranchCleaner.Repos) && !stringInSlice(repo.GetOwner().GetLogin(), s.Config.BranchCleaner.Repos) {
+		return
+	}
+	head := pe.GetPullRequest().GetHead()
+	if head.GetRepo().GetID() != repo.GetID() {
+		return
+	}
+	branch := head.GetRef()
+	if branch == repo.GetDefaultBranch() || stringInSlice(branch, s.Config.BranchCleaner.PreservedBranches) {
+		return
+	}
+
+	if err := scmFor(client).DeleteBranch(repo.GetOwner().GetLogin(), repo.GetName(), branch); err != nil {
+		logError(client, "fail to delete branch %s of %s: %v", branch, repo.GetFullName(), err)
+		return
+	}
+	s.recordAudit(AuditRecord{
+		Repo:   repo.GetFullName(),
+		Number: pe.GetNumber(),
+		Action: "delete-branch",
+		Detail: branch + " at " + head.GetSHA(),
+	})
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// mergeWindowInterval is how often the summary status of the PRs subject
+// to merge windows is re-evaluated, so that a PR blocked outside of a
+// window is unblocked once it opens without anyone touching it.
+const mergeWindowInterval = 5 * time.Minute
+
+func init() {
+	registerPeriodic("merge-windows", mergeWindowInterval, func(c *Config) bool {
+		return len(c.mergeWindowStates(time.Now())) > 0
+	}, (*Server).refreshMergeWindows)
+}
+
+// OrgBranchPolicy declares branch policies once for every repo of an org.
+// Repos inherit Branches and may override them through Repos.
+type OrgBranchPolicy struct {
+	Branches []BranchPolicy            `json:"branches,omitempty"`
+	Repos    map[string][]BranchPolicy `json:"repos,omitempty"`
+}
+
+// BranchPolicy applies to every branch whose name matches Pattern, a
+// path.Match style glob such as "release-*" or "stable/*". Unset fields are
+// inherited from less specific policies.
+type BranchPolicy struct {
+	Pattern              string            `json:"pattern"`
+	Protect              *bool             `json:"protect,omitempty"`
+	RequiredStatusChecks []string          `json:"required_status_checks,omitempty"`
+	MergeWindows         []MergeWindow     `json:"merge_windows,omitempty"`
+	CherryPick           *CherryPickPolicy `json:"cherry_pick,omitempty"`
+}
+
+// MergeWindow is a daily span of time during which merges are allowed.
+type MergeWindow struct {
+	// Days are weekday names, e.g. "Mon" or "monday", in any case. Empty
+	// means every day.
+	Days []string `json:"days,omitempty"`
+	// Start and End are "15:04" formatted times. A window whose End is
+	// before its Start spans midnight.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Timezone is an IANA zone name, UTC by default.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// CherryPickPolicy controls whether changes may be cherry-picked onto a branch.
+type CherryPickPolicy struct {
+	Allowed   bool     `json:"allowed"`
+	Approvers []string `json:"approvers,omitempty"`
+}
+
+// BranchPolicyFor resolves the effective policy for a branch of org/repo.
+// Org level policies are applied first, then repo level ones, each in
+// declaration order, so later matches override earlier ones.
+func (c *Config) BranchPolicyFor(org, repo, branch string) BranchPolicy {
+	resolved := BranchPolicy{Pattern: branch}
+	op, ok := c.BranchPolicies[org]
+	if !ok {
+		return resolved
+	}
+	policies := make([]BranchPolicy, 0, len(op.Branches)+len(op.Repos[repo]))
+	policies = append(policies, op.Branches...)
+	policies = append(policies, op.Repos[repo]...)
+	for _, p := range policies {
+		if matched, _ := path.Match(p.Pattern, branch); matched {
+			resolved.merge(p)
+		}
+	}
+	return resolved
+}
+
+func (p *BranchPolicy) merge(o BranchPolicy) {
+	if o.Protect != nil {
+		p.Protect = o.Protect
+	}
+	if o.RequiredStatusChecks != nil {
+		p.RequiredStatusChecks = o.RequiredStatusChecks
+	}
+	if o.MergeWindows != nil {
+		p.MergeWindows = o.MergeWindows
+	}
+	if o.CherryPick != nil {
+		p.CherryPick = o.CherryPick
+	}
+}
+
+// CanMergeAt reports whether t falls in one of the merge windows of the
+// policy. A policy without merge windows allows merging at any time.
+func (p BranchPolicy) CanMergeAt(t time.Time) bool {
+	if len(p.MergeWindows) == 0 {
+		return true
+	}
+	for _, w := range p.MergeWindows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w MergeWindow) contains(t time.Time) bool {
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return false
+	}
+	t = t.In(loc)
+	if len(w.Days) > 0 {
+		found := false
+		for _, d := range w.Days {
+			if day, ok := parseWeekday(d); ok && day == t.Weekday().String()[:3] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from <= to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
+
+func (c *Config) validateBranchPolicies() error {
+	for org, op := range c.BranchPolicies {
+		policies := append([]BranchPolicy{}, op.Branches...)
+		for _, ps := range op.Repos {
+			policies = append(policies, ps...)
+		}
+		for _, p := range policies {
+			if _, err := path.Match(p.Pattern, ""); err != nil {
+				return fmt.Errorf("org %s: invalid branch pattern %q: %v", org, p.Pattern, err)
+			}
+			for _, w := range p.MergeWindows {
+				for _, d := range w.Days {
+					if _, ok := parseWeekday(d); !ok {
+						return fmt.Errorf("org %s: branch pattern %q: invalid merge window day %q", org, p.Pattern, d)
+					}
+				}
+				if _, err := time.LoadLocation(w.Timezone); err != nil {
+					return fmt.Errorf("org %s: branch pattern %q: %v", org, p.Pattern, err)
+				}
+				if _, err := time.Parse("15:04", w.Start); err != nil {
+					return fmt.Errorf("org %s: branch pattern %q: invalid merge window start %q", org, p.Pattern, w.Start)
+				}
+				if _, err := time.Parse("15:04", w.End); err != nil {
+					return fmt.Errorf("org %s: branch pattern %q: invalid merge window end %q", org, p.Pattern, w.End)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseWeekday returns the abbreviated form, e.g. "Mon", of a full or
+// abbreviated weekday name in any case.
+func parseWeekday(name string) (string, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		full := d.String()
+		if strings.EqualFold(name, full) || strings.EqualFold(name, full[:3]) {
+			return full[:3], true
+		}
+	}
+	return "", false
+}
+
+// mergeWindowStates returns whether each merge window policy of the orgs
+// having any is open at t. A branch is subject to one of these policies,
+// so its PRs only need updating when the states of their org change.
+func (c *Config) mergeWindowStates(t time.Time) map[string]string {
+	states := map[string]string{}
+	for org, op := range c.BranchPolicies {
+		policies := append([]BranchPolicy{}, op.Branches...)
+		for _, repo := range sortedBranchPolicyRepos(op) {
+			policies = append(policies, op.Repos[repo]...)
+		}
+		var state []string
+		for _, p := range policies {
+			if len(p.MergeWindows) > 0 {
+				state = append(state, fmt.Sprint(p.CanMergeAt(t)))
+			}
+		}
+		if len(state) > 0 {
+			states[org] = strings.Join(state, ",")
+		}
+	}
+	return states
+}
+
+func sortedBranchPolicyRepos(op OrgBranchPolicy) []string {
+	repos := make(map[string]bool, len(op.Repos))
+	for r := range op.Repos {
+		repos[r] = true
+	}
+	return sortedKeys(repos)
+}
+
+// mergeWindowRefresh is shared by the snapshots of a server, see current,
+// for the merge window states of the last refresh to outlive them.
+type mergeWindowRefresh struct {
+	states map[string]string
+}
+
+// refreshMergeWindows updates the summary status of the open PRs of the
+// orgs whose merge windows opened or closed since the last refresh, every
+// PR being refreshed on the first one. Other events update it as they
+// come.
+func (s *Server) refreshMergeWindows(client *github.Client) {
+	now := time.Now()
+	states := s.Config.mergeWindowStates(now)
+	for org, state := range states {
+		if last, ok := s.mergeWindows.states[org]; ok && last == state {
+			continue
+		}
+		issues, err := searchIssues(client, "is:pr is:open org:"+org)
+		if err != nil {
+			logError(client, "fail to search the open PRs of %s: %v", org, err)
+			// Retried on the next refresh.
+			delete(states, org)
+			continue
+		}
+		for _, issue := range issues {
+			repo, err := searchResultRepo(issue)
+			if err != nil {
+				logError(client, "fail to get the repo of a search result: %v", err)
+				continue
+			}
+			pr, err := scmFor(client).GetPullRequest(org, repo.GetName(), issue.GetNumber())
+			if err != nil {
+				logError(client, "fail to get %s#%d: %v", repo.GetFullName(), issue.GetNumber(), err)
+				continue
+			}
+			if len(s.Config.BranchPolicyFor(org, repo.GetName(), pr.GetBase().GetRef()).MergeWindows) == 0 {
+				continue
+			}
+			s.runPlugin("merge-gate", "periodic", client, func(client *github.Client) { s.updateSummaryStatus(client, repo, pr) })
+		}
+	}
+	s.mergeWindows.states = states
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestBranchPolicyFor(t *testing.T) {
+	c := &Config{BranchPolicies: map[string]OrgBranchPolicy{
+		"org": {
+			Branches: []BranchPolicy{
+				{Pattern: "*", Protect: boolPtr(true), RequiredStatusChecks: []string{"ci"}},
+				{Pattern: "release-*", MergeWindows: []MergeWindow{{Start: "09:00", End: "17:00"}}},
+			},
+			Repos: map[string][]BranchPolicy{
+				"repo": {
+					{Pattern: "release-*", RequiredStatusChecks: []string{"ci", "e2e"}},
+					{Pattern: "dev", Protect: boolPtr(false)},
+				},
+			},
+		},
+	}}
+	tests := []struct {
+		name     string
+		org      string
+		repo     string
+		branch   string
+		expected BranchPolicy
+	}{
+		{
+			name:     "org policy",
+			org:      "org",
+			repo:     "other",
+			branch:   "master",
+			expected: BranchPolicy{Pattern: "master", Protect: boolPtr(true), RequiredStatusChecks: []string{"ci"}},
+		},
+		{
+			name:   "org policies combined",
+			org:    "org",
+			repo:   "other",
+			branch: "release-1.0",
+			expected: BranchPolicy{
+				Pattern:              "release-1.0",
+				Protect:              boolPtr(true),
+				RequiredStatusChecks: []string{"ci"},
+				MergeWindows:         []MergeWindow{{Start: "09:00", End: "17:00"}},
+			},
+		},
+		{
+			name:   "repo policy overrides",
+			org:    "org",
+			repo:   "repo",
+			branch: "release-1.0",
+			expected: BranchPolicy{
+				Pattern:              "release-1.0",
+				Protect:              boolPtr(true),
+				RequiredStatusChecks: []string{"ci", "e2e"},
+				MergeWindows:         []MergeWindow{{Start: "09:00", End: "17:00"}},
+			},
+		},
+		{
+			name:     "repo policy unsets",
+			org:      "org",
+			repo:     "repo",
+			branch:   "dev",
+			expected: BranchPolicy{Pattern: "dev", Protect: boolPtr(false), RequiredStatusChecks: []string{"ci"}},
+		},
+		{
+			name:     "unknown org",
+			org:      "other",
+			repo:     "repo",
+			branch:   "master",
+			expected: BranchPolicy{Pattern: "master"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := c.BranchPolicyFor(tc.org, tc.repo, tc.branch); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestMergeWindowContains(t *testing.T) {
+	// A Wednesday.
+	at := func(clock string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", "2026-10-14 "+clock)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		name     string
+		window   MergeWindow
+		t        time.Time
+		expected bool
+	}{
+		{
+			name:     "inside",
+			window:   MergeWindow{Start: "09:00", End: "17:00"},
+			t:        at("12:00"),
+			expected: true,
+		},
+		{
+			name:     "start is inclusive",
+			window:   MergeWindow{Start: "09:00", End: "17:00"},
+			t:        at("09:00"),
+			expected: true,
+		},
+		{
+			name:   "end is exclusive",
+			window: MergeWindow{Start: "09:00", End: "17:00"},
+			t:      at("17:00"),
+		},
+		{
+			name:     "spanning midnight, before it",
+			window:   MergeWindow{Start: "22:00", End: "02:00"},
+			t:        at("23:30"),
+			expected: true,
+		},
+		{
+			name:     "spanning midnight, after it",
+			window:   MergeWindow{Start: "22:00", End: "02:00"},
+			t:        at("01:00"),
+			expected: true,
+		},
+		{
+			name:   "spanning midnight, outside",
+			window: MergeWindow{Start: "22:00", End: "02:00"},
+			t:      at("12:00"),
+		},
+		{
+			name:     "abbreviated day",
+			window:   MergeWindow{Days: []string{"Mon", "Wed"}, Start: "09:00", End: "17:00"},
+			t:        at("12:00"),
+			expected: true,
+		},
+		{
+			name:     "full day name in any case",
+			window:   MergeWindow{Days: []string{"WEDNESDAY"}, Start: "09:00", End: "17:00"},
+			t:        at("12:00"),
+			expected: true,
+		},
+		{
+			name:   "other day",
+			window: MergeWindow{Days: []string{"tue"}, Start: "09:00", End: "17:00"},
+			t:      at("12:00"),
+		},
+		{
+			name:     "timezone",
+			window:   MergeWindow{Start: "09:00", End: "17:00", Timezone: "America/New_York"},
+			t:        at("14:00"),
+			expected: true,
+		},
+		{
+			name:   "timezone changes the day",
+			window: MergeWindow{Days: []string{"Wed"}, Start: "00:00", End: "23:59", Timezone: "Asia/Tokyo"},
+			t:      at("20:00"),
+		},
+		{
+			name:   "invalid start",
+			window: MergeWindow{Start: "9am", End: "17:00"},
+			t:      at("12:00"),
+		},
+		{
+			name:   "invalid timezone",
+			window: MergeWindow{Start: "09:00", End: "17:00", Timezone: "Nowhere/Else"},
+			t:      at("12:00"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.window.contains(tc.t); got != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateBranchPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy BranchPolicy
+		valid  bool
+	}{
+		{
+			name:   "valid",
+			policy: BranchPolicy{Pattern: "release-*", MergeWindows: []MergeWindow{{Days: []string{"monday", "Fri"}, Start: "09:00", End: "17:00", Timezone: "Europe/Paris"}}},
+			valid:  true,
+		},
+		{
+			name:   "invalid pattern",
+			policy: BranchPolicy{Pattern: "release-["},
+		},
+		{
+			name:   "invalid day",
+			policy: BranchPolicy{Pattern: "*", MergeWindows: []MergeWindow{{Days: []string{"someday"}, Start: "09:00", End: "17:00"}}},
+		},
+		{
+			name:   "invalid end",
+			policy: BranchPolicy{Pattern: "*", MergeWindows: []MergeWindow{{Start: "09:00", End: "25:00"}}},
+		},
+		{
+			name:   "invalid timezone",
+			policy: BranchPolicy{Pattern: "*", MergeWindows: []MergeWindow{{Start: "09:00", End: "17:00", Timezone: "Nowhere/Else"}}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Config{BranchPolicies: map[string]OrgBranchPolicy{"org": {Branches: []BranchPolicy{tc.policy}}}}
+			if err := c.validateBranchPolicies(); (err == nil) != tc.valid {
+				t.Errorf("expected valid %t, got error %v", tc.valid, err)
+			}
+		})
+	}
+}
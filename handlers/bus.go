@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// Bus is the NATS message bus the bot consumes webhooks from and publishes
+// the events it handles to, so that several consumers can share them.
+// Kafka isn't supported, as the bot has no client for it.
+type Bus struct {
+	// URL of the NATS server, as nats://[user:password@]host:port.
+	URL string `json:"url,omitempty"`
+	// Token authenticates the bot when the server doesn't use passwords.
+	Token string `json:"token,omitempty"`
+	// Subject the webhooks are consumed from, as JSON messages of the
+	// event and payload of a GitHub webhook. The subject can be the deliver
+	// subject of a JetStream push consumer, for the webhooks to be kept
+	// while the bot is down: messages are acked once handled.
+	Subject string `json:"subject,omitempty"`
+	// Queue group the replicas share the webhooks in, ci-bot by default.
+	Queue string `json:"queue,omitempty"`
+	// PublishSubject is the prefix of the subjects the events handled are
+	// published to as ProxyEvents, under <prefix>.<type>.
+	PublishSubject string `json:"publish_subject,omitempty"`
+}
+
+// busMessage is a webhook consumed from the bus.
+type busMessage struct {
+	// Event is the X-GitHub-Event of the webhook.
+	Event string `json:"event"`
+	// Delivery is the optional X-GitHub-Delivery of the webhook.
+	Delivery string          `json:"delivery,omitempty"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "bus",
+		Events:      webhookEventTypes,
+		Description: "The bus plugin handles GitHub webhooks consumed from a NATS subject, and publishes the events the bot handles to NATS subjects in the schema of the proxy plugin.",
+		ConfigKey:   "bus",
+	}, func(c *Config) []string {
+		if c.Bus.URL != "" {
+			return []string{"*"}
+		}
+		return nil
+	})
+}
+
+func (c *Config) validateBus() error {
+	if c.Bus.URL == "" {
+		if c.Bus.Subject != "" || c.Bus.PublishSubject != "" {
+			return fmt.Errorf("bus: url must be set")
+		}
+		return nil
+	}
+	u, err := url.Parse(c.Bus.URL)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return fmt.Errorf("bus: invalid url %q, only nats://host:port is supported", c.Bus.URL)
+	}
+	if c.Bus.Subject == "" && c.Bus.PublishSubject == "" {
+		return fmt.Errorf("bus: subject or publish_subject must be set")
+	}
+	return nil
+}
+
+// natsConn is the connection of the bot to the NATS server.
+var natsConn = struct {
+	sync.Mutex
+	conn net.Conn
+}{}
+
+// runBus connects to the bus and keeps consuming its subject, reconnecting
+// as needed.
+func (s *Server) runBus(client *github.Client) {
+	if s.Config.Bus.URL == "" {
+		return
+	}
+	go func() {
+		for {
+			if err := s.consumeBus(client); err != nil {
+				logError(client, "fail to consume %s: %v", s.Config.Bus.URL, err)
+			}
+			time.Sleep(10 * time.Second)
+		}
+	}()
+}
+
+func (s *Server) consumeBus(client *github.Client) error {
+	u, err := url.Parse(s.Config.Bus.URL)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	// The server greets clients with its INFO.
+	if _, err := r.ReadString('\n'); err != nil {
+		return err
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "ci-bot"}
+	if u.User != nil {
+		opts["user"] = u.User.Username()
+		opts["pass"], _ = u.User.Password()
+	}
+	if s.Config.Bus.Token != "" {
+		opts["auth_token"] = s.Config.Bus.Token
+	}
+	connect, _ := json.Marshal(opts)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		return err
+	}
+	if s.Config.Bus.Subject != "" {
+		queue := s.Config.Bus.Queue
+		if queue == "" {
+			queue = "ci-bot"
+		}
+		if _, err := fmt.Fprintf(conn, "SUB %s %s 1\r\n", s.Config.Bus.Subject, queue); err != nil {
+			return err
+		}
+	}
+	natsConn.Lock()
+	natsConn.conn = conn
+	natsConn.Unlock()
+	defer func() {
+		natsConn.Lock()
+		natsConn.conn = nil
+		natsConn.Unlock()
+	}()
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch op := strings.Fields(line); {
+		case len(op) == 0:
+		case op[0] == "PING":
+			if err := natsWrite([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case op[0] == "-ERR":
+			return fmt.Errorf("%s", line)
+		case op[0] == "MSG" && (len(op) == 4 || len(op) == 5):
+			// MSG <subject> <sid> [reply-to] <size>
+			size, err := strconv.Atoi(op[len(op)-1])
+			if err != nil {
+				return fmt.Errorf("invalid message %q", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return err
+			}
+			s.handleBusMessage(client, payload[:size])
+			if len(op) == 5 {
+				// JetStream consumers expect an ack on the reply subject.
+				if err := natsPublish(op[3], nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (s *Server) handleBusMessage(client *github.Client, data []byte) {
+	var m busMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		logError(client, "fail to unmarshal bus message: %v", err)
+		return
+	}
+	event, err := github.ParseWebHook(m.Event, m.Payload)
+	if err != nil {
+		logError(client, "fail to parse %s webhook from the bus: %v", m.Event, err)
+		return
+	}
+	if err := s.dispatchEvent(event, m.Event, m.Delivery, m.Payload, client); err != nil {
+		glog.V(2).Infof("ignoring %s event %s from the bus: %v", m.Event, m.Delivery, err)
+	}
+}
+
+// publishBusEvent publishes an event handled by the bot to the bus.
+func (s *Server) publishBusEvent(event *ProxyEvent, body []byte) {
+	if s.Config.Bus.PublishSubject == "" {
+		return
+	}
+	if err := natsPublish(s.Config.Bus.PublishSubject+"."+event.Type, body); err != nil {
+		glog.Errorf("fail to publish %s event %s: %v", event.Type, event.ID, err)
+	}
+}
+
+func natsPublish(subject string, data []byte) error {
+	msg := append([]byte(fmt.Sprintf("PUB %s %d\r\n", subject, len(data))), data...)
+	return natsWrite(append(msg, '\r', '\n'))
+}
+
+func natsWrite(b []byte) error {
+	natsConn.Lock()
+	defer natsConn.Unlock()
+	if natsConn.conn == nil {
+		return fmt.Errorf("not connected to the bus")
+	}
+	_, err := natsConn.conn.Write(b)
+	return err
+}
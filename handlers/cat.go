@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const catURL = "https://api.thecatapi.com/v1/images/search"
+
+// Cat is the config of the cat plugin.
+type Cat struct {
+	// KeyPath is the file holding the thecatapi.com API key. The API works
+	// without a key, rate limited.
+	KeyPath string `json:"key_path,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "cat",
+		Events:      []string{"issue_comment"},
+		Description: "The cat plugin adds a cat image to an issue or PR in response to the `/meow` command.",
+		ConfigKey:   "cat",
+		Commands: []PluginCommand{{
+			Usage:       "/meow(vie)",
+			Description: "Add a cat image to the issue or PR, /meowvie for a gif",
+			WhoCanUse:   "Anyone",
+			Example:     "/meow",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleCat replies with a random cat picture.
+func (s *Server) handleCat(client *github.Client, ic *github.IssueCommentEvent) {
+	header := http.Header{}
+	if s.Config.Cat.KeyPath != "" {
+		key, err := ioutil.ReadFile(s.Config.Cat.KeyPath)
+		if err != nil {
+			logError(client, "fail to read cat api key: %v", err)
+			return
+		}
+		header.Set("x-api-key", strings.TrimSpace(string(key)))
+	}
+	url := catURL
+	if m := meowReg.FindStringSubmatch(ic.GetComment().GetBody()); m != nil && m[1] != "" {
+		url += "?mime_types=gif"
+	}
+	var cats []struct {
+		URL string `json:"url"`
+	}
+	if err := getJSON(url, header, &cats); err != nil {
+		logError(client, "fail to get a cat: %v", err)
+		return
+	}
+	if len(cats) == 0 {
+		logError(client, "fail to get a cat: no image returned")
+		return
+	}
+	createComment(client, ic.Repo, ic.GetIssue().GetNumber(), imageComment(ic.GetComment().GetUser().GetLogin(), cats[0].URL))
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// Cherrypicker is the config of the cherrypicker plugin. It pushes to the
+// repos with the git_hub_token of the config.
+type Cherrypicker struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos []string `json:"repos,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "cherrypicker",
+		Events:      []string{"issue_comment", "pull_request"},
+		Description: "The cherrypicker plugin cherry-picks merged PRs into other branches by opening a new PR with their changes against each branch. Conflicts are reported back on the original PR.",
+		ConfigKey:   "cherrypicker",
+		Commands: []PluginCommand{{
+			Usage:       "/cherrypick <branch>",
+			Description: "Cherry-picks a PR into a branch once it's merged, or right away if it is merged already.",
+			WhoCanUse:   "Approvers of the repo.",
+			Example:     "/cherrypick release-1.3",
+		}},
+	}, func(c *Config) []string {
+		return c.Cherrypicker.Repos
+	})
+}
+
+func (s *Server) cherrypickerEnabled(repo *github.Repository) bool {
+	return stringInSlice(repo.GetFullName(), s.Config.Cherrypicker.Repos) || stringInSlice(repo.GetOwner().GetLogin(), s.Config.Cherrypicker.Repos)
+}
+
+// handleCherryPick handles the /cherrypick command.
+func (s *Server) handleCherryPick(client *github.Client, ic *github.IssueCommentEvent) {
+	if !ic.GetIssue().IsPullRequest() || !s.cherrypickerEnabled(ic.Repo) {
+		return
+	}
+	number := ic.GetIssue().GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+	approver, err := s.isApprover(client, ic.Repo, login)
+	if err != nil {
+		logError(client, "fail to check if %s is an approver: %v", login, err)
+		return
+	}
+	if !approver {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: only approvers of %s can request cherry-picks.", login, ic.Repo.GetFullName()))
+		return
+	}
+
+	pr, err := scmFor(client).GetPullRequest(ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to get PR %s#%d: %v", ic.Repo.GetFullName(), number, err)
+		return
+	}
+	for _, m := range cherrypickReg.FindAllStringSubmatch(ic.GetComment().GetBody(), -1) {
+		target := m[1]
+		if !pr.GetMerged() {
+			createComment(client, ic.Repo, number, fmt.Sprintf("@%s: once this PR merges, I will cherry-pick it into `%s`.", login, target))
+			continue
+		}
+		s.cherryPick(client, ic.Repo, pr, target, login)
+	}
+}
+
+// handleCherryPickMerged carries out the cherry-picks approvers requested
+// before a PR merged.
+func (s *Server) handleCherryPickMerged(client *github.Client, pe *github.PullRequestEvent) {
+	if !s.cherrypickerEnabled(pe.Repo) {
+		return
+	}
+	number := pe.GetNumber()
+	comments, err := listIssueComments(client, pe.Repo.GetOwner().GetLogin(), pe.Repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list comments of %s#%d: %v", pe.Repo.GetFullName(), number, err)
+		return
+	}
+	requested := map[string]string{}
+	var targets []string
+	for _, c := range comments {
+		matches := cherrypickReg.FindAllStringSubmatch(c.GetBody(), -1)
+		if len(matches) == 0 {
+			continue
+		}
+		login := c.GetUser().GetLogin()
+		approver, err := s.isApprover(client, pe.Repo, login)
+		if err != nil {
+			logError(client, "fail to check if %s is an approver: %v", login, err)
+			return
+		}
+		if !approver {
+			continue
+		}
+		for _, m := range matches {
+			if _, ok := requested[m[1]]; !ok {
+				targets = append(targets, m[1])
+			}
+			requested[m[1]] = login
+		}
+	}
+	for _, target := range targets {
+		s.cherryPick(client, pe.Repo, pe.PullRequest, target, requested[target])
+	}
+}
+
+// cherryPick opens a PR applying the changes of the merged pr onto target,
+// and reports whether it did.
+func (s *Server) cherryPick(client *github.Client, repo *github.Repository, pr *github.PullRequest, target, requester string) bool {
+	scm := scmFor(client)
+	owner := repo.GetOwner().GetLogin()
+	number := pr.GetNumber()
+	fail := func(format string, args ...interface{}) {
+		createComment(client, repo, number, fmt.Sprintf("@%s: ", requester)+fmt.Sprintf(format, args...))
+	}
+
+	patch, err := scm.GetPatch(owner, repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to get patch of %s#%d: %v", repo.GetFullName(), number, err)
+		return false
+	}
+	r, err := cloneRepo(s.Config.GitHubToken, repo.GetFullName(), target, s.BotName)
+	if err != nil {
+		logError(client, "fail to clone %s@%s: %v", repo.GetFullName(), target, err)
+		fail("cannot cherry-pick into `%s`, the branch could not be checked out.", target)
+		return false
+	}
+	defer r.clean()
+
+	branch := fmt.Sprintf("cherry-pick-%d-to-%s", number, target)
+	if _, err := r.git("checkout", "-b", branch); err != nil {
+		logError(client, "fail to create branch %s: %v", branch, err)
+		return false
+	}
+	if conflicts, err := r.am(patch); err != nil {
+		glog.Infof("cherry-pick of %s#%d into %s failed: %v", repo.GetFullName(), number, target, err)
+		msg := fmt.Sprintf("#%d failed to apply on top of branch `%s`", number, target)
+		if len(conflicts) > 0 {
+			msg += ", the following files conflict:\n* `" + strings.Join(conflicts, "`\n* `") + "`"
+		}
+		fail("%s\n\nPlease cherry-pick it manually.", msg)
+		return false
+	}
+	if _, err := r.git("push", "--force", "origin", branch); err != nil {
+		logError(client, "fail to push %s: %v", branch, strings.Replace(err.Error(), s.Config.GitHubToken, "<token>", -1))
+		fail("cannot push the cherry-pick into `%s`.", target)
+		return false
+	}
+
+	created, err := scm.CreatePullRequest(owner, repo.GetName(), &github.NewPullRequest{
+		Title: github.String(fmt.Sprintf("[%s] %s", target, pr.GetTitle())),
+		Head:  github.String(branch),
+		Base:  github.String(target),
+		Body:  github.String(fmt.Sprintf("This is an automated cherry-pick of #%d\n\n/assign %s", number, requester)),
+	})
+	if err != nil {
+		logError(client, "fail to open cherry-pick PR of %s#%d: %v", repo.GetFullName(), number, err)
+		fail("pushed the cherry-pick into `%s` as `%s`, but could not open a PR for it: %v", target, branch, err)
+		return false
+	}
+	createComment(client, repo, number, fmt.Sprintf("@%s: new pull request created: #%d", requester, created.GetNumber()))
+	return true
+}
+
+func (c *Config) validateCherrypicker() error {
+	if len(c.Cherrypicker.Repos) > 0 && c.GitHubToken == "" {
+		return fmt.Errorf("cherrypicker: git_hub_token is required to push")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"ci-bot/commentpruner"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	cherryPickApprovedLabel    = "cherry-pick-approved"
+	cherryPickUnapprovedLabel  = "do-not-merge/cherry-pick-not-approved"
+	cherryPickUnapprovedMarker = "<!-- ci-bot:cherry-pick-unapproved -->"
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "cherry-pick-unapproved",
+		Events:      []string{"pull_request"},
+		Description: "The cherry-pick-unapproved plugin applies the '" + cherryPickUnapprovedLabel + "' label to PRs against branches whose branch policy restricts cherry-picks, until they get the '" + cherryPickApprovedLabel + "' label from one of the cherry-pick approvers of the branch.",
+		ConfigKey:   "branch_policies",
+	}, func(c *Config) []string {
+		orgs := make([]string, 0, len(c.BranchPolicies))
+		for org := range c.BranchPolicies {
+			orgs = append(orgs, org)
+		}
+		return orgs
+	})
+}
+
+// handleCherryPickUnapproved blocks PRs against branches with a cherry-pick
+// policy until they are approved for cherry-picking.
+func (s *Server) handleCherryPickUnapproved(client *github.Client, pe *github.PullRequestEvent) {
+	pr := pe.GetPullRequest()
+	branch := pr.GetBase().GetRef()
+	policy := s.Config.BranchPolicyFor(pe.Repo.GetOwner().GetLogin(), pe.Repo.GetName(), branch).CherryPick
+	if policy == nil {
+		return
+	}
+	number := pr.GetNumber()
+	approved := hasLabel(pr.Labels, cherryPickApprovedLabel)
+	if pe.GetAction() == "labeled" && pe.GetLabel().GetName() == cherryPickApprovedLabel && !policy.canApprove(pe.GetSender().GetLogin()) {
+		removeLabel(client, pe.Repo, number, cherryPickApprovedLabel)
+		approved = false
+	}
+
+	if approved && policy.Allowed {
+		if hasLabel(pr.Labels, cherryPickUnapprovedLabel) {
+			removeLabel(client, pe.Repo, number, cherryPickUnapprovedLabel)
+		}
+		s.commentPruner(client, pe.Repo, number).PruneComments(commentpruner.HasMarker(cherryPickUnapprovedMarker))
+		return
+	}
+	if !hasLabel(pr.Labels, cherryPickUnapprovedLabel) {
+		addLabel(client, pe.Repo, number, cherryPickUnapprovedLabel)
+	}
+	var body string
+	switch {
+	case !policy.Allowed:
+		body = fmt.Sprintf("Cherry-picks onto `%s` are not allowed by its branch policy.", branch)
+	case len(policy.Approvers) > 0:
+		body = fmt.Sprintf("Cherry-picks onto `%s` must be approved: one of %s must add the `%s` label.", branch, strings.Join(policy.Approvers, ", "), cherryPickApprovedLabel)
+	default:
+		body = fmt.Sprintf("Cherry-picks onto `%s` must be approved with the `%s` label.", branch, cherryPickApprovedLabel)
+	}
+	s.upsertComment(client, pe.Repo, number, cherryPickUnapprovedMarker, cherryPickUnapprovedMarker+"\n"+body)
+}
+
+// canApprove reports whether login may approve cherry-picks, anyone may
+// when the policy names no approvers.
+func (p *CherryPickPolicy) canApprove(login string) bool {
+	if len(p.Approvers) == 0 {
+		return true
+	}
+	for _, a := range p.Approvers {
+		if strings.EqualFold(a, login) {
+			return true
+		}
+	}
+	return false
+}
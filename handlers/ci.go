@@ -6,62 +6,294 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ci-bot/commentpruner"
 
 	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const (
+	CircleCIAPIURL  = "https://circleci.com/api/v2"
+	ContentTypeJSON = "application/json"
+
+	circleCIInterval = time.Minute
+	// circleCIContextPrefix prefixes the status context of each workflow.
+	circleCIContextPrefix = "ci/circleci: "
+	circleCIMarker        = "<!-- ci-bot:circleci -->"
+	// circleCIMaxAge is how long a pipeline is polled before giving up.
+	circleCIMaxAge = 24 * time.Hour
 )
 
-type CircleCIInfo struct {
-	CircleJob string `json:"build_parameters[CIRCLE_JOB]"`
-	Revision  string `json:"revision"`
+// CircleCI is the config of the pipelines triggered on CircleCI, with the
+// token of the top-level CircleCIToken.
+type CircleCI struct {
+	// Repos are the orgs and org/repos whose PRs are tested on CircleCI.
+	Repos []string `json:"repos,omitempty"`
 }
 
-type CircleCIResp struct {
-	BuildURL string `json:"build_url"`
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "circleci",
+		Description: "Triggers CircleCI pipelines for the branch of a PR, reports each workflow of the pipeline as a commit status and comments the failing workflows.",
+		ConfigKey:   "circleci",
+		Commands: []PluginCommand{{
+			Usage:       "/test [all|circleci]",
+			Description: "Triggers a CircleCI pipeline for the PR.",
+			WhoCanUse:   trustedUsers,
+			Example:     "/test",
+		}, {
+			Usage:       "/retest",
+			Description: "Triggers a CircleCI pipeline for the PR again.",
+			WhoCanUse:   trustedUsers,
+			Example:     "/retest",
+		}},
+	}, func(c *Config) []string {
+		return c.CircleCI.Repos
+	})
+	registerPeriodic("circleci", circleCIInterval, func(c *Config) bool {
+		return len(c.CircleCI.Repos) > 0 && c.CircleCIToken != ""
+	}, (*Server).processCircleCIPipelines)
 }
 
-const (
-	CircleCIGithubURL = "https://circleci.com/api/v1.1/project/github"
-	ContentTypeJSON   = "application/json"
-)
+// circleCIPipeline is a pipeline triggered for a PR, polled until all of
+// its workflows are done.
+type circleCIPipeline struct {
+	ID      string             `json:"id"`
+	Repo    *github.Repository `json:"repo"`
+	Number  int                `json:"number"`
+	SHA     string             `json:"sha"`
+	Created time.Time          `json:"created"`
+	// Statuses are the last reported statuses of the workflows, by ID.
+	Statuses map[string]string `json:"statuses"`
+}
 
-func (s *Server) SendToCI(info map[string]string) {
-	glog.Info("going to send test request to circle ci")
+// circleCIWorkflow is a workflow of a pipeline as the API returns it.
+type circleCIWorkflow struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Status         string `json:"status"`
+	PipelineNumber int    `json:"pipeline_number"`
+	ProjectSlug    string `json:"project_slug"`
+}
 
-	// TODO: the current way to trigger CircleCI is stupid, find a better way if any
+// circleCIPipelines are the pipelines being polled, by ID. With leader
+// election, they are those the replicas share, see modifyState.
+var circleCIPipelines = struct {
+	sync.Mutex
+	m map[string]*circleCIPipeline
+}{m: map[string]*circleCIPipeline{}}
 
-	client := &http.Client{}
-	// TODO: substitute with specified job name
-	circleCIInfo := CircleCIInfo{
-		CircleJob: "build",
-		Revision:  info["Commit_ID"],
+// withCircleCIPipelines runs modify on the pipelines being polled.
+func (s *Server) withCircleCIPipelines(modify func() bool) {
+	circleCIPipelines.Lock()
+	defer circleCIPipelines.Unlock()
+	if err := s.modifyState("circleci", &circleCIPipelines.m, modify); err != nil {
+		glog.Errorf("fail to update the shared CircleCI pipelines: %v", err)
 	}
-	jsonStr, err := json.Marshal(circleCIInfo)
-	if err != nil {
-		glog.Errorf("fail to marshal: %v", err)
+}
+
+func (c *Config) circleCIEnabled(repo *github.Repository) bool {
+	return c.CircleCIToken != "" &&
+		(stringInSlice(repo.GetFullName(), c.CircleCI.Repos) || stringInSlice(repo.GetOwner().GetLogin(), c.CircleCI.Repos))
+}
+
+// circleCIRequest calls the CircleCI API, encoding in as the body and
+// decoding the response into out.
+func (s *Server) circleCIRequest(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = b
 	}
-	url := fmt.Sprintf("%s/%s/%s/pulls/%s", CircleCIGithubURL, s.Config.Owner, s.Config.Repo, info["PR_ID"])
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonStr))
+	req, err := http.NewRequest(method, CircleCIAPIURL+path, bytes.NewReader(body))
 	if err != nil {
-		glog.Errorf("%v", err)
+		return err
 	}
 	req.Header.Set("Content-Type", ContentTypeJSON)
-	req.SetBasicAuth(s.Config.CircleCIToken, "")
-	resp, err := client.Do(req)
-	var circleCIResp CircleCIResp
-	body, err := ioutil.ReadAll(resp.Body)
+	req.Header.Set("Accept", ContentTypeJSON)
+	req.Header.Set("Circle-Token", s.Config.CircleCIToken)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
 	if err != nil {
-		glog.Errorf("fail to read resp: %v", err)
+		return err
 	}
-	err = json.Unmarshal(body, circleCIResp)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		glog.Errorf("fail to unmarshal: %v", err)
+		return err
 	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, b)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
 
-	// buildURL is the CircleCI link of the test for PR
-	buildURL := circleCIResp.BuildURL
-	glog.Infof("the CircleCI test link: %s", buildURL)
+// isCircleCITest reports whether a comment asks for the CircleCI pipeline:
+// /retest, or /test for all jobs or for circleci.
+func isCircleCITest(comment string) bool {
+	if retestReg.MatchString(comment) {
+		return true
+	}
+	for _, m := range testReg.FindAllStringSubmatch(comment, -1) {
+		switch strings.ToLower(m[1]) {
+		case "", "all", "circleci":
+			return true
+		}
+	}
+	return false
 }
 
-func (s *Server) SendToCircleCI(b []byte) {
+// triggerCircleCI starts a pipeline for the head of pr and keeps track of
+// it until its workflows are done.
+func (s *Server) triggerCircleCI(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	var created struct {
+		ID     string `json:"id"`
+		Number int    `json:"number"`
+	}
+	path := fmt.Sprintf("/project/gh/%s/%s/pipeline", repo.GetOwner().GetLogin(), repo.GetName())
+	branch := fmt.Sprintf("pull/%d/head", pr.GetNumber())
+	if err := s.circleCIRequest(http.MethodPost, path, map[string]string{"branch": branch}, &created); err != nil {
+		logError(client, "fail to trigger CircleCI pipeline for %s#%d: %v", repo.GetFullName(), pr.GetNumber(), err)
+		createComment(client, repo, pr.GetNumber(), transientMarker("retest")+"\nFailed to trigger the CircleCI pipeline, please try again later.")
+		return
+	}
+	glog.Infof("triggered CircleCI pipeline %d for %s#%d", created.Number, repo.GetFullName(), pr.GetNumber())
+
+	p := circleCIPipeline{
+		ID:       created.ID,
+		Repo:     stateRepo(repo),
+		Number:   pr.GetNumber(),
+		SHA:      pr.GetHead().GetSHA(),
+		Created:  time.Now(),
+		Statuses: map[string]string{},
+	}
+	s.withCircleCIPipelines(func() bool {
+		added := p
+		circleCIPipelines.m[p.ID] = &added
+		return true
+	})
+}
+
+// processCircleCIPipelines reports the workflows of the pipelines being
+// polled, forgetting the pipelines that are done.
+func (s *Server) processCircleCIPipelines(client *github.Client) {
+	var pipelines []circleCIPipeline
+	s.withCircleCIPipelines(func() bool {
+		pipelines = nil
+		for _, p := range circleCIPipelines.m {
+			pipelines = append(pipelines, *p)
+		}
+		return false
+	})
+
+	for i := range pipelines {
+		p := &pipelines[i]
+		before := p.Statuses
+		p.Statuses = map[string]string{}
+		for id, status := range before {
+			p.Statuses[id] = status
+		}
+		done := s.reportCircleCIPipeline(client, p) || time.Since(p.Created) > circleCIMaxAge
+		if !done && reflect.DeepEqual(before, p.Statuses) {
+			continue
+		}
+		s.withCircleCIPipelines(func() bool {
+			if _, ok := circleCIPipelines.m[p.ID]; !ok {
+				return false
+			}
+			if done {
+				delete(circleCIPipelines.m, p.ID)
+			} else {
+				updated := *p
+				circleCIPipelines.m[p.ID] = &updated
+			}
+			return true
+		})
+	}
+}
+
+// circleCIState maps the status of a workflow to the state of a commit
+// status, telling whether the workflow is done.
+func circleCIState(status string) (string, bool) {
+	switch status {
+	case "success":
+		return "success", true
+	case "failed":
+		return "failure", true
+	case "error", "unauthorized", "canceled", "not_run":
+		return "error", true
+	default:
+		// running, on_hold and failing workflows are still going.
+		return "pending", false
+	}
+}
+
+// reportCircleCIPipeline sets a status per workflow of a pipeline and,
+// once all are done, comments the failing ones. It reports whether the
+// pipeline is done.
+func (s *Server) reportCircleCIPipeline(client *github.Client, p *circleCIPipeline) bool {
+	var workflows struct {
+		Items []circleCIWorkflow `json:"items"`
+	}
+	if err := s.circleCIRequest(http.MethodGet, "/pipeline/"+p.ID+"/workflow", nil, &workflows); err != nil {
+		logError(client, "fail to get the workflows of CircleCI pipeline %s: %v", p.ID, err)
+		return false
+	}
+	if len(workflows.Items) == 0 {
+		// Workflows show up a little after the pipeline is created.
+		return false
+	}
+
+	done := true
+	var failed []circleCIWorkflow
+	for _, w := range workflows.Items {
+		state, finished := circleCIState(w.Status)
+		done = done && finished
+		if state == "failure" || state == "error" {
+			failed = append(failed, w)
+		}
+		if p.Statuses[w.ID] != w.Status {
+			p.Statuses[w.ID] = w.Status
+			createStatus(client, p.Repo, p.SHA, circleCIContextPrefix+w.Name, state, "Workflow "+w.Status, circleCIWorkflowURL(w))
+		}
+	}
+	if !done {
+		return false
+	}
+
+	if len(failed) == 0 {
+		s.commentPruner(client, p.Repo, p.Number).PruneComments(commentpruner.HasMarker(circleCIMarker))
+		return true
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Name < failed[j].Name })
+	lines := []string{
+		circleCIMarker,
+		transientMarker("circleci"),
+		fmt.Sprintf("The following CircleCI workflows failed for commit %s:", p.SHA),
+		"",
+		"| Workflow | Status | Details |",
+		"| --- | --- | --- |",
+	}
+	for _, w := range failed {
+		lines = append(lines, fmt.Sprintf("| %s | %s | [link](%s) |", w.Name, w.Status, circleCIWorkflowURL(w)))
+	}
+	lines = append(lines, "", "Comment `/retest` to run them again.")
+	s.upsertComment(client, p.Repo, p.Number, circleCIMarker, strings.Join(lines, "\n"))
+	return true
+}
 
+// circleCIWorkflowURL is the page of a workflow in the CircleCI app.
+func circleCIWorkflowURL(w circleCIWorkflow) string {
+	return fmt.Sprintf("https://app.circleci.com/pipelines/%s/%d/workflows/%s", w.ProjectSlug, w.PipelineNumber, w.ID)
 }
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"ci-bot/commentpruner"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	claYesLabel      = "cncf-cla: yes"
+	claNoLabel       = "cncf-cla: no"
+	claCommentMarker = "<!-- ci-bot:cla -->"
+)
+
+// Cla is the config of the CLA check for an org or repo. Signers are looked
+// up at URL or, without it, in File of Repo.
+type Cla struct {
+	// URL is queried with {login} replaced by the GitHub login of each
+	// author. It must answer with a JSON object like {"signed": true}.
+	URL string `json:"url,omitempty"`
+	// Repo is the org/repo holding File, which lists the GitHub logins of
+	// the signers one per line. Lines starting with # are comments.
+	Repo string `json:"repo,omitempty"`
+	File string `json:"file,omitempty"`
+	// Branch of Repo to read File from, its default branch by default.
+	Branch string `json:"branch,omitempty"`
+	// SignURL is where authors are pointed to sign the CLA.
+	SignURL string `json:"sign_url,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "cla",
+		Events:      []string{"issue_comment", "pull_request"},
+		Description: "The cla plugin checks that the author and every commit author of a PR signed the CLA, applying the '" + claYesLabel + "' or the '" + claNoLabel + "' label. PRs labeled '" + claNoLabel + "' cannot merge.",
+		ConfigKey:   "cla",
+		Commands: []PluginCommand{{
+			Usage:       "/check-cla",
+			Description: "Forces rechecking of the CLA status.",
+			WhoCanUse:   "Anyone",
+			Example:     "/check-cla",
+		}},
+	}, func(c *Config) []string {
+		var enabled []string
+		for k := range c.Cla {
+			enabled = append(enabled, k)
+		}
+		return enabled
+	})
+}
+
+// claFor returns the CLA config of a repo, repo entries taking precedence
+// over org ones.
+func (c *Config) claFor(org, repo string) (Cla, bool) {
+	if cla, ok := c.Cla[org+"/"+repo]; ok {
+		return cla, true
+	}
+	cla, ok := c.Cla[org]
+	return cla, ok
+}
+
+// claSigners returns the logins listed in the signers file.
+func claSigners(client *github.Client, cla Cla) (map[string]bool, error) {
+	parts := strings.SplitN(cla.Repo, "/", 2)
+	content, err := scmFor(client).GetFile(parts[0], parts[1], cla.File, cla.Branch)
+	if err != nil {
+		return nil, err
+	}
+	signers := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		signers[strings.ToLower(strings.TrimPrefix(line, "@"))] = true
+	}
+	return signers, scanner.Err()
+}
+
+// unsignedAuthors returns the logins among authors that didn't sign the CLA.
+func unsignedAuthors(client *github.Client, cla Cla, authors []string) ([]string, error) {
+	var unsigned []string
+	if cla.URL != "" {
+		for _, a := range authors {
+			var result struct {
+				Signed bool `json:"signed"`
+			}
+			if err := getJSON(strings.Replace(cla.URL, "{login}", url.PathEscape(a), -1), nil, &result); err != nil {
+				return nil, err
+			}
+			if !result.Signed {
+				unsigned = append(unsigned, a)
+			}
+		}
+		return unsigned, nil
+	}
+	signers, err := claSigners(client, cla)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range authors {
+		if !signers[strings.ToLower(a)] {
+			unsigned = append(unsigned, a)
+		}
+	}
+	return unsigned, nil
+}
+
+// handleCLA checks that the author and the commit authors of a PR signed
+// the CLA.
+func (s *Server) handleCLA(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	org := repo.GetOwner().GetLogin()
+	cla, ok := s.Config.claFor(org, repo.GetName())
+	if !ok {
+		return
+	}
+	number := pr.GetNumber()
+	commits, err := scmFor(client).ListCommits(org, repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list commits of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	authors := []string{pr.GetUser().GetLogin()}
+	var unknown []string
+	for _, c := range commits {
+		login := c.GetAuthor().GetLogin()
+		if login == "" {
+			// The commit email isn't linked to any GitHub account.
+			unknown = append(unknown, fmt.Sprintf("* %s by %s", c.GetSHA()[:7], c.GetCommit().GetAuthor().GetEmail()))
+			continue
+		}
+		if !stringInSlice(login, authors) {
+			authors = append(authors, login)
+		}
+	}
+	unsigned, err := unsignedAuthors(client, cla, authors)
+	if err != nil {
+		logError(client, "fail to check the CLA of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	sort.Strings(unsigned)
+
+	labels := pr.Labels
+	if len(unsigned) == 0 && len(unknown) == 0 {
+		if hasLabel(labels, claNoLabel) {
+			removeLabel(client, repo, number, claNoLabel)
+		}
+		if !hasLabel(labels, claYesLabel) {
+			addLabel(client, repo, number, claYesLabel)
+		}
+		s.commentPruner(client, repo, number).PruneComments(commentpruner.HasMarker(claCommentMarker))
+		return
+	}
+	if hasLabel(labels, claYesLabel) {
+		removeLabel(client, repo, number, claYesLabel)
+	}
+	if !hasLabel(labels, claNoLabel) {
+		addLabel(client, repo, number, claNoLabel)
+	}
+
+	msg := claCommentMarker + "\nThanks for your pull request. Before we can look at it, every author of its commits needs to sign the CLA."
+	if cla.SignURL != "" {
+		msg += fmt.Sprintf(" Please follow [the instructions](%s) to sign it.", cla.SignURL)
+	}
+	if len(unsigned) > 0 {
+		msg += "\n\nThe following authors haven't signed the CLA:\n* @" + strings.Join(unsigned, "\n* @")
+	}
+	if len(unknown) > 0 {
+		msg += "\n\nThe following commits are authored with an email that isn't linked to a GitHub account:\n" + strings.Join(unknown, "\n")
+	}
+	msg += "\n\nOnce signed, comment `/check-cla` to check again."
+	s.upsertComment(client, repo, number, claCommentMarker, msg)
+}
+
+// handleCheckCLA handles the /check-cla command.
+func (s *Server) handleCheckCLA(client *github.Client, ic *github.IssueCommentEvent) {
+	if !ic.GetIssue().IsPullRequest() {
+		return
+	}
+	pr, err := scmFor(client).GetPullRequest(ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName(), ic.GetIssue().GetNumber())
+	if err != nil {
+		logError(client, "fail to get PR %s#%d: %v", ic.Repo.GetFullName(), ic.GetIssue().GetNumber(), err)
+		return
+	}
+	s.handleCLA(client, ic.Repo, pr)
+}
+
+func (c *Config) validateCla() error {
+	for k, cla := range c.Cla {
+		if cla.URL != "" {
+			continue
+		}
+		if len(strings.SplitN(cla.Repo, "/", 2)) != 2 || cla.File == "" {
+			return fmt.Errorf("cla %s: either url or an org/repo and file are required", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	cleanupDelete   = "delete"
+	cleanupCollapse = "collapse"
+)
+
+var transientMarkerReg = regexp.MustCompile(`<!-- ci-bot:transient:([\w-]+) -->`)
+
+// CommentCleanup holds the policies for transient bot comments.
+type CommentCleanup struct {
+	// Policies maps a kind of transient comment to either "delete" or
+	// "collapse". The kinds are job-results, circleci, retest, flaky,
+	// needs-rebase, title, lgtm and review-reminder. Comments of other
+	// kinds are left alone.
+	Policies map[string]string `json:"policies,omitempty"`
+}
+
+// transientMarker tags a bot comment as transient so it can be cleaned up
+// once the issue or PR it was posted on is closed.
+func transientMarker(kind string) string {
+	return fmt.Sprintf("<!-- ci-bot:transient:%s -->", kind)
+}
+
+// listIssueComments returns every comment of an issue or PR.
+func listIssueComments(client *github.Client, owner, repo string, number int) ([]*github.IssueComment, error) {
+	return scmFor(client).ListComments(owner, repo, number)
+}
+
+// cleanupTransientComments applies the cleanup policies to the transient
+// comments the bot left on a closed issue or PR. The body of every comment
+// touched is kept in the audit log.
+func (s *Server) cleanupTransientComments(client *github.Client, repo *github.Repository, number int) {
+	if len(s.Config.CommentCleanup.Policies) == 0 {
+		return
+	}
+	owner := repo.GetOwner().GetLogin()
+	comments, err := listIssueComments(client, owner, repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list comments of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+
+	for _, c := range comments {
+		if c.GetUser().GetLogin() != s.BotName {
+			continue
+		}
+		m := transientMarkerReg.FindStringSubmatch(c.GetBody())
+		if m == nil {
+			continue
+		}
+		kind := m[1]
+		policy := s.Config.CommentCleanup.Policies[kind]
+		switch policy {
+		case cleanupDelete:
+			err = scmFor(client).DeleteComment(owner, repo.GetName(), c.GetID())
+		case cleanupCollapse:
+			body := strings.Replace(c.GetBody(), m[0], fmt.Sprintf("<!-- ci-bot:collapsed:%s -->", kind), 1)
+			body = fmt.Sprintf("<details><summary>Outdated %s comment</summary>\n\n%s\n</details>", kind, body)
+			_, err = scmFor(client).EditComment(owner, repo.GetName(), c.GetID(), body)
+		default:
+			continue
+		}
+		if err != nil {
+			logError(client, "fail to %s comment %d: %v", policy, c.GetID(), err)
+			continue
+		}
+		s.recordAudit(AuditRecord{
+			Repo:   repo.GetFullName(),
+			Number: number,
+			Action: policy + "-comment",
+			Actor:  s.BotName,
+			Detail: c.GetBody(),
+		})
+	}
+}
+
+func (c *Config) validateCommentCleanup() error {
+	for kind, policy := range c.CommentCleanup.Policies {
+		if policy != cleanupDelete && policy != cleanupCollapse {
+			return fmt.Errorf("comment cleanup of %q: unknown policy %q", kind, policy)
+		}
+	}
+	return nil
+}
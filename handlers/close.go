@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/google/go-github/github"
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "close",
+		Events:      []string{"issue_comment"},
+		Description: "The close plugin closes and reopens issues and PRs on request of their author, assignees or the collaborators of the repo.",
+		Commands: []PluginCommand{{
+			Usage:       "/close",
+			Description: "Closes an issue or PR.",
+			WhoCanUse:   "Authors, assignees and collaborators.",
+			Example:     "/close",
+		}, {
+			Usage:       "/reopen",
+			Description: "Reopens an issue or PR.",
+			WhoCanUse:   "Authors, assignees and collaborators.",
+			Example:     "/reopen",
+		}},
+	}, enabledEverywhere)
+}
+
+// canCloseOrReopen reports whether login may close or reopen issue: its
+// author, its assignees and the collaborators of the repo can.
+func canCloseOrReopen(client *github.Client, repo *github.Repository, issue *github.Issue, login string) (bool, error) {
+	if issue.GetUser().GetLogin() == login {
+		return true, nil
+	}
+	for _, a := range issue.Assignees {
+		if a.GetLogin() == login {
+			return true, nil
+		}
+	}
+	return isCollaborator(client, repo, login)
+}
+
+// handleClose closes or reopens an issue or PR.
+func (s *Server) handleClose(client *github.Client, ic *github.IssueCommentEvent) {
+	issue := ic.GetIssue()
+	login := ic.GetComment().GetUser().GetLogin()
+	state, verb := "closed", "close"
+	if reopenReg.MatchString(ic.GetComment().GetBody()) {
+		state, verb = "open", "reopen"
+	}
+	if issue.GetState() == state {
+		return
+	}
+
+	allowed, err := canCloseOrReopen(client, ic.Repo, issue, login)
+	if err != nil {
+		logError(client, "fail to check whether %s is a collaborator of %s: %v", login, ic.Repo.GetFullName(), err)
+		return
+	}
+	kind := "issue"
+	if issue.IsPullRequest() {
+		kind = "pull request"
+	}
+	if !allowed {
+		createComment(client, ic.Repo, issue.GetNumber(), fmt.Sprintf("@%s: You can't %s this %s unless you authored it, are assigned to it or are a collaborator.", login, verb, kind))
+		return
+	}
+
+	err = scmFor(client).EditIssue(ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName(), issue.GetNumber(), &github.IssueRequest{State: github.String(state)})
+	if err != nil {
+		logError(client, "fail to %s %s#%d: %v", verb, ic.Repo.GetFullName(), issue.GetNumber(), err)
+		createComment(client, ic.Repo, issue.GetNumber(), fmt.Sprintf("@%s: Failed to %s this %s.", login, verb, kind))
+	}
+}
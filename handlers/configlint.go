@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigPolicy is a set of org specific invariants the config must satisfy
+// on top of what the bot itself validates.
+type ConfigPolicy struct {
+	Rules []ConfigRule `json:"rules"`
+}
+
+// ConfigRule is a single invariant. The rule is evaluated against every
+// value matched by Scope; for each one where When holds, Require must hold
+// as well. A Scope matching nothing is an error, as it's most likely a
+// typo. For example, "every org or repo checking signers at a URL must
+// link to where the CLA is signed":
+//
+//	{
+//	  "name": "cla-sign-url",
+//	  "scope": "cla.*",
+//	  "when": {"path": "url", "exists": true},
+//	  "require": {"path": "sign_url", "exists": true}
+//	}
+type ConfigRule struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Scope is a dot separated path into the config, where "*" matches
+	// every key of an object or element of an array. Empty means the
+	// whole config.
+	Scope   string           `json:"scope,omitempty"`
+	When    *ConfigCondition `json:"when,omitempty"`
+	Require ConfigCondition  `json:"require"`
+}
+
+// ConfigCondition tests the value found at Path, relative to the scope of
+// the rule. Every check that is set has to pass.
+type ConfigCondition struct {
+	Path string `json:"path,omitempty"`
+	// Exists checks whether Path is set to a non-empty value.
+	Exists *bool `json:"exists,omitempty"`
+	// Equals checks that every value found at Path equals it.
+	Equals interface{} `json:"equals,omitempty"`
+	// Contains checks that an array found at Path contains it.
+	Contains interface{} `json:"contains,omitempty"`
+}
+
+// LoadConfigPolicy reads the policy file at path.
+func LoadConfigPolicy(path string) (ConfigPolicy, error) {
+	var policy ConfigPolicy
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return policy, fmt.Errorf("could not read policy file: %v", err)
+	}
+	if err := json.Unmarshal(content, &policy); err != nil {
+		return policy, fmt.Errorf("fail to unmarshal: %v", err)
+	}
+	for i, r := range policy.Rules {
+		if r.Name == "" {
+			return policy, fmt.Errorf("rule %d has no name", i)
+		}
+	}
+	return policy, nil
+}
+
+// LintConfig evaluates the rules of policy against the raw JSON config and
+// returns one error per violation.
+func LintConfig(raw []byte, policy ConfigPolicy) ([]error, error) {
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal: %v", err)
+	}
+
+	var violations []error
+	for _, r := range policy.Rules {
+		matches := lookupConfigPath(doc, r.Scope, "")
+		if len(matches) == 0 {
+			violations = append(violations, fmt.Errorf("%s: scope %q matches nothing in the config", r.Name, r.Scope))
+			continue
+		}
+		for _, m := range matches {
+			if r.When != nil && !r.When.holds(m.value) {
+				continue
+			}
+			if !r.Require.holds(m.value) {
+				msg := fmt.Sprintf("%s: violated at %q", r.Name, m.path)
+				if r.Description != "" {
+					msg += ": " + r.Description
+				}
+				violations = append(violations, fmt.Errorf("%s", msg))
+			}
+		}
+	}
+	return violations, nil
+}
+
+func (c ConfigCondition) holds(scope interface{}) bool {
+	matches := lookupConfigPath(scope, c.Path, "")
+	if c.Exists != nil {
+		exists := false
+		for _, m := range matches {
+			if !isEmptyConfigValue(m.value) {
+				exists = true
+				break
+			}
+		}
+		if exists != *c.Exists {
+			return false
+		}
+	}
+	if c.Equals != nil {
+		for _, m := range matches {
+			if !reflect.DeepEqual(m.value, c.Equals) {
+				return false
+			}
+		}
+	}
+	if c.Contains != nil {
+		found := false
+		for _, m := range matches {
+			list, _ := m.value.([]interface{})
+			for _, v := range list {
+				if reflect.DeepEqual(v, c.Contains) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type configMatch struct {
+	path  string
+	value interface{}
+}
+
+// lookupConfigPath returns the values of doc found at path, in a stable
+// order.
+func lookupConfigPath(doc interface{}, path, prefix string) []configMatch {
+	if path == "" {
+		return []configMatch{{prefix, doc}}
+	}
+	segment, rest := path, ""
+	if i := strings.Index(path, "."); i >= 0 {
+		segment, rest = path[:i], path[i+1:]
+	}
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	var matches []configMatch
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		if segment != "*" {
+			if child, ok := v[segment]; ok {
+				matches = lookupConfigPath(child, rest, join(segment))
+			}
+			return matches
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			matches = append(matches, lookupConfigPath(v[k], rest, join(k))...)
+		}
+	case []interface{}:
+		for i, child := range v {
+			key := fmt.Sprintf("%d", i)
+			if segment == "*" || segment == key {
+				matches = append(matches, lookupConfigPath(child, rest, join(key))...)
+			}
+		}
+	}
+	return matches
+}
+
+func isEmptyConfigValue(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return t == ""
+	case []interface{}:
+		return len(t) == 0
+	case map[string]interface{}:
+		return len(t) == 0
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-github/github"
+)
+
+// ConfigUpdater syncs files of a repo into Kubernetes ConfigMaps whenever a
+// PR changing them merges.
+type ConfigUpdater struct {
+	// Repos are the org/repos the updater acts on.
+	Repos []string `json:"repos,omitempty"`
+	// Maps is keyed by a path.Match pattern of files in the repo.
+	Maps map[string]ConfigMapSpec `json:"maps,omitempty"`
+	Kube KubeConfig               `json:"kube,omitempty"`
+}
+
+// ConfigMapSpec is the ConfigMap a file is stored in.
+type ConfigMapSpec struct {
+	Name string `json:"name"`
+	// Key is the key the file is stored under, its base name by default.
+	Key string `json:"key,omitempty"`
+	// Namespace defaults to the namespace of the Kubernetes client.
+	Namespace string `json:"namespace,omitempty"`
+	// AdditionalNamespaces also get a copy of the ConfigMap.
+	AdditionalNamespaces []string `json:"additional_namespaces,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "config-updater",
+		Events:      []string{"pull_request"},
+		Description: "The config-updater plugin automatically redeploys configuration and plugin configuration files when they change. The plugin watches for pull request merges that modify the configured files and updates the matching ConfigMaps, in every namespace they are configured for.",
+		ConfigKey:   "config_updater",
+	}, func(c *Config) []string {
+		return c.ConfigUpdater.Repos
+	})
+}
+
+// namespaces resolves every namespace the ConfigMap lives in.
+func (s ConfigMapSpec) namespaces(defaultNamespace string) []string {
+	ns := s.Namespace
+	if ns == "" {
+		ns = defaultNamespace
+	}
+	return append([]string{ns}, s.AdditionalNamespaces...)
+}
+
+type configMapID struct {
+	namespace string
+	name      string
+}
+
+// handleConfigUpdater updates the ConfigMaps of the files changed by a
+// merged PR and reports the outcome on the PR.
+func (s *Server) handleConfigUpdater(client *github.Client, pe *github.PullRequestEvent) {
+	if !stringInSlice(pe.Repo.GetFullName(), s.Config.ConfigUpdater.Repos) {
+		return
+	}
+	scm := scmFor(client)
+	owner := pe.Repo.GetOwner().GetLogin()
+	repo := pe.Repo.GetName()
+	number := pe.GetNumber()
+
+	files, err := scm.ListChangedFiles(owner, repo, number)
+	if err != nil {
+		logError(client, "fail to list files of %s#%d: %v", pe.Repo.GetFullName(), number, err)
+		return
+	}
+	patterns := make([]string, 0, len(s.Config.ConfigUpdater.Maps))
+	for p := range s.Config.ConfigUpdater.Maps {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+
+	kube, err := newKubeClient(s.Config.ConfigUpdater.Kube)
+	if err != nil {
+		logError(client, "fail to create kubernetes client: %v", err)
+		return
+	}
+
+	// A renamed file is removed under its previous name. Removals come
+	// first, so that a key removed and added back keeps the new content.
+	var removed, added []string
+	for _, f := range files {
+		if f.PreviousFilename != "" {
+			removed = append(removed, f.PreviousFilename)
+		}
+		if f.Status == "removed" {
+			removed = append(removed, f.Filename)
+		} else {
+			added = append(added, f.Filename)
+		}
+	}
+
+	// updates holds the new content per key of every ConfigMap touched, nil
+	// content removes the key.
+	updates := map[configMapID]map[string]*string{}
+	// unread lists the files that couldn't be read, which are left out of
+	// the updates while the other files still go through.
+	var unread []string
+	for i, name := range append(removed, added...) {
+		for _, p := range patterns {
+			if matched, _ := path.Match(p, name); !matched {
+				continue
+			}
+			spec := s.Config.ConfigUpdater.Maps[p]
+			key := spec.Key
+			if key == "" {
+				key = path.Base(name)
+			}
+			var content *string
+			if i >= len(removed) {
+				data, err := scm.GetFile(owner, repo, name, pe.GetPullRequest().GetMergeCommitSHA())
+				if err != nil {
+					logError(client, "fail to get %s: %v", name, err)
+					unread = append(unread, fmt.Sprintf("* `%s`: %v", name, err))
+					break
+				}
+				decoded := string(data)
+				content = &decoded
+			}
+			for _, ns := range spec.namespaces(kube.namespace) {
+				id := configMapID{ns, spec.Name}
+				if updates[id] == nil {
+					updates[id] = map[string]*string{}
+				}
+				updates[id][key] = content
+			}
+			break
+		}
+	}
+	if len(updates) == 0 && len(unread) == 0 {
+		return
+	}
+
+	var updated, failed []string
+	for id, data := range updates {
+		name := fmt.Sprintf("`%s` in namespace `%s`", id.name, id.namespace)
+		if err := updateConfigMap(kube, id, data); err != nil {
+			logError(client, "fail to update configmap %s/%s: %v", id.namespace, id.name, err)
+			failed = append(failed, fmt.Sprintf("* %s: %v", name, err))
+			continue
+		}
+		updated = append(updated, "* "+name)
+	}
+	sort.Strings(updated)
+	sort.Strings(failed)
+
+	var msg []string
+	if len(updated) > 0 {
+		msg = append(msg, "Updated the following ConfigMaps:", strings.Join(updated, "\n"))
+	}
+	if len(failed) > 0 {
+		msg = append(msg, "Failed to update the following ConfigMaps:", strings.Join(failed, "\n"))
+	}
+	if len(unread) > 0 {
+		msg = append(msg, "Failed to read the following files, their keys were not updated:", strings.Join(unread, "\n"))
+	}
+	_, err = scm.CreateComment(owner, repo, number, strings.Join(msg, "\n\n"))
+	if err != nil {
+		logError(client, "fail to comment on %s#%d: %v", pe.Repo.GetFullName(), number, err)
+	}
+}
+
+func updateConfigMap(kube *kubeClient, id configMapID, data map[string]*string) error {
+	cm, err := kube.getConfigMap(id.namespace, id.name)
+	create := false
+	if _, ok := err.(kubeNotFound); ok {
+		create = true
+		cm = ConfigMap{Metadata: ObjectMeta{Name: id.name, Namespace: id.namespace}}
+	} else if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	if cm.BinaryData == nil {
+		cm.BinaryData = map[string][]byte{}
+	}
+	for key, content := range data {
+		delete(cm.Data, key)
+		delete(cm.BinaryData, key)
+		switch {
+		case content == nil:
+		case utf8.ValidString(*content):
+			cm.Data[key] = *content
+		default:
+			cm.BinaryData[key] = []byte(*content)
+		}
+	}
+	if create {
+		return kube.createConfigMap(cm)
+	}
+	return kube.replaceConfigMap(cm)
+}
+
+func (c *Config) validateConfigUpdater() error {
+	for p, spec := range c.ConfigUpdater.Maps {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("config_updater: invalid pattern %q: %v", p, err)
+		}
+		if spec.Name == "" {
+			return fmt.Errorf("config_updater: no configmap name for %q", p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	coverageMarker         = "<!-- ci-bot:coverage -->"
+	defaultCoverageProfile = "artifacts/coverage.out"
+)
+
+// Coverage reports how a PR changes the coverage measured by a presubmit.
+type Coverage struct {
+	// Job is the decorated presubmit uploading the coverage profile of PRs.
+	Job string `json:"job"`
+	// BaseJob is the decorated postsubmit uploading the profile of the base
+	// branch, Job by default.
+	BaseJob string `json:"base_job,omitempty"`
+	// Profile is the Go coverage profile the jobs upload,
+	// artifacts/coverage.out by default.
+	Profile string `json:"profile,omitempty"`
+	// Context of the status failing when coverage drops by more than
+	// Threshold. No status is set without one.
+	Context string `json:"context,omitempty"`
+	// Threshold is by how many percentage points coverage may drop.
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "coverage",
+		Description: "Comments on PRs with how they change the test coverage measured by a presubmit job, compared with the last run of the matching postsubmit on the base branch, and optionally fails a status when coverage drops by more than a threshold.",
+		ConfigKey:   "coverage",
+	}, func(c *Config) []string {
+		var enabled []string
+		for k := range c.Coverage {
+			enabled = append(enabled, k)
+		}
+		return enabled
+	})
+}
+
+func (c *Config) validateCoverage() error {
+	for key, coverages := range c.Coverage {
+		for _, cov := range coverages {
+			if cov.Job == "" {
+				return fmt.Errorf("coverage: job not set for %s", key)
+			}
+			if cov.Threshold < 0 {
+				return fmt.Errorf("coverage: negative threshold for job %s of %s", cov.Job, key)
+			}
+		}
+	}
+	return nil
+}
+
+// coverageFor returns the coverage config of the presubmit job of repo.
+func (c *Config) coverageFor(repo *github.Repository, job string) (Coverage, bool) {
+	for _, key := range []string{repo.GetFullName(), repo.GetOwner().GetLogin()} {
+		for _, cov := range c.Coverage[key] {
+			if cov.Job == job {
+				if cov.BaseJob == "" {
+					cov.BaseJob = cov.Job
+				}
+				if cov.Profile == "" {
+					cov.Profile = defaultCoverageProfile
+				}
+				return cov, true
+			}
+		}
+	}
+	return Coverage{}, false
+}
+
+// coverageProfile is the statements of each file of a coverage profile,
+// and how many of them are covered.
+type coverageProfile map[string]*fileCoverage
+
+type fileCoverage struct {
+	statements int
+	covered    int
+}
+
+func (p coverageProfile) percent(file string) float64 {
+	if file == "" {
+		var total fileCoverage
+		for _, f := range p {
+			total.statements += f.statements
+			total.covered += f.covered
+		}
+		return total.percent()
+	}
+	if f, ok := p[file]; ok {
+		return f.percent()
+	}
+	return math.NaN()
+}
+
+func (f fileCoverage) percent() float64 {
+	if f.statements == 0 {
+		return 100
+	}
+	return 100 * float64(f.covered) / float64(f.statements)
+}
+
+// parseCoverageProfile reads a profile written by go test -coverprofile.
+// Profiles of several packages may be concatenated, the blocks covered
+// by any of them count as covered.
+func parseCoverageProfile(r io.Reader) (coverageProfile, error) {
+	type block struct {
+		statements int
+		covered    bool
+	}
+	blocks := map[string]map[string]*block{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// file.go:line.col,line.col statements count
+		colon := strings.LastIndex(line, ":")
+		fields := strings.Fields(line[colon+1:])
+		if colon < 0 || len(fields) != 3 {
+			return nil, fmt.Errorf("invalid coverage profile line %q", line)
+		}
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid coverage profile line %q", line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid coverage profile line %q", line)
+		}
+		file := line[:colon]
+		if blocks[file] == nil {
+			blocks[file] = map[string]*block{}
+		}
+		b, ok := blocks[file][fields[0]]
+		if !ok {
+			b = &block{statements: statements}
+			blocks[file][fields[0]] = b
+		}
+		b.covered = b.covered || count > 0
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	profile := coverageProfile{}
+	for file, fileBlocks := range blocks {
+		f := &fileCoverage{}
+		for _, b := range fileBlocks {
+			f.statements += b.statements
+			if b.covered {
+				f.covered += b.statements
+			}
+		}
+		profile[file] = f
+	}
+	return profile, nil
+}
+
+// loadCoverageProfile reads the profile a decorated job uploaded.
+func (s *Server) loadCoverageProfile(j *Job, name string) (coverageProfile, error) {
+	if !j.Decorate || s.Config.Jobs.Decoration == nil {
+		return nil, fmt.Errorf("job %s isn't decorated", j.Name)
+	}
+	f, err := s.Config.Jobs.Decoration.openStored(j, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCoverageProfile(f)
+}
+
+// lastBaseRun returns the last successful run of the postsubmit job on
+// the base branch of a presubmit.
+func (s *Server) lastBaseRun(presubmit *Job, job string) (Job, bool) {
+	runs := s.listJobs(func(j *Job) bool {
+		return j.Type == PostsubmitJob && j.Name == job && j.State == SuccessState &&
+			j.Refs.Org == presubmit.Refs.Org && j.Refs.Repo == presubmit.Refs.Repo && j.Refs.BaseRef == presubmit.Refs.BaseRef
+	})
+	if len(runs) == 0 {
+		return Job{}, false
+	}
+	return runs[len(runs)-1], true
+}
+
+// reportCoverage comments on the PR of a presubmit that passed with how
+// the PR changes coverage, and sets the coverage status if configured.
+func (s *Server) reportCoverage(client *github.Client, j *Job) {
+	repo := j.Refs.repo()
+	cov, ok := s.Config.coverageFor(repo, j.Name)
+	if !ok {
+		return
+	}
+	head, err := s.loadCoverageProfile(j, cov.Profile)
+	if err != nil {
+		logError(client, "fail to load the coverage of job %s: %v", j.ID, err)
+		return
+	}
+	number := j.Refs.Pulls[0].Number
+	f := s.Config.FormatterFor(repo.GetOwner().GetLogin())
+	lines := []string{coverageMarker}
+	total := head.percent("")
+
+	baseRun, ok := s.lastBaseRun(j, cov.BaseJob)
+	var base coverageProfile
+	if ok {
+		if base, err = s.loadCoverageProfile(&baseRun, cov.Profile); err != nil {
+			logError(client, "fail to load the coverage of job %s: %v", baseRun.ID, err)
+		}
+	}
+	if base == nil {
+		lines = append(lines, fmt.Sprintf("Coverage of %s at %s is **%s**. There is no coverage of %s to compare with.", j.Name, j.sha(), f.Percent(total/100), j.Refs.BaseRef))
+		s.upsertComment(client, repo, number, coverageMarker, strings.Join(lines, "\n"))
+		return
+	}
+
+	delta := total - base.percent("")
+	lines = append(lines,
+		fmt.Sprintf("Coverage of %s at %s is **%s** (%s compared with %s at %s).", j.Name, j.sha(), f.Percent(total/100), f.PercentChange(delta/100), j.Refs.BaseRef, baseRun.Refs.BaseSHA),
+	)
+	var changed []string
+	for file := range head {
+		if math.Abs(head.percent(file)-base.percent(file)) >= 0.05 || math.IsNaN(base.percent(file)) {
+			changed = append(changed, file)
+		}
+	}
+	for file := range base {
+		if _, ok := head[file]; !ok {
+			changed = append(changed, file)
+		}
+	}
+	sort.Strings(changed)
+	if len(changed) > 0 {
+		lines = append(lines, "", "| File | Base | Head | Delta |", "| --- | --- | --- | --- |")
+		for _, file := range changed {
+			before, after := base.percent(file), head.percent(file)
+			delta := "-"
+			if !math.IsNaN(before) && !math.IsNaN(after) {
+				delta = f.PercentChange((after - before) / 100)
+			}
+			lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s |", file, f.Percent(before/100), f.Percent(after/100), delta))
+		}
+	}
+	s.upsertComment(client, repo, number, coverageMarker, strings.Join(lines, "\n"))
+
+	if cov.Context == "" {
+		return
+	}
+	state, description := "success", fmt.Sprintf("Coverage %.1f%% (%+.1f%%)", total, delta)
+	if -delta > cov.Threshold {
+		state = "failure"
+		description += fmt.Sprintf(", drops by more than %.1f%%", cov.Threshold)
+	}
+	createStatus(client, repo, j.sha(), cov.Context, state, description, j.URL)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ci-bot/commentpruner"
+
+	"github.com/google/go-github/github"
+)
+
+const crossLinkMarker = "<!-- ci-bot:crosslink -->"
+
+// CrossLink links the keys of external trackers mentioned in issues and PRs
+// of a set of repos.
+type CrossLink struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos []string `json:"repos"`
+	// Trackers maps the regexp of the keys of a tracker, e.g. "PROJ-\d+",
+	// to the URL template of a key, in which {key} is replaced by the key,
+	// e.g. "https://jira.example.com/browse/{key}".
+	Trackers map[string]string `json:"trackers"`
+
+	res map[string]*regexp.Regexp
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "crosslink",
+		Events:      []string{"issues", "pull_request"},
+		Description: "The crosslink plugin links issues and PRs to the external trackers (Jira, Bugzilla...) their title or description mention, in a comment kept up to date as they are edited.",
+		ConfigKey:   "crosslink",
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, l := range c.CrossLink {
+			enabled = append(enabled, l.Repos...)
+		}
+		return enabled
+	})
+}
+
+// crossLinks returns the markdown links to the tracker keys found in text.
+func (l CrossLink) crossLinks(text string) []string {
+	seen := map[string]bool{}
+	var links []string
+	for pattern, re := range l.res {
+		for _, key := range re.FindAllString(text, -1) {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			links = append(links, fmt.Sprintf("[%s](%s)", key, strings.Replace(l.Trackers[pattern], "{key}", key, -1)))
+		}
+	}
+	sort.Strings(links)
+	return links
+}
+
+// handleCrossLink keeps the comment linking the tracker keys of an issue or
+// PR in sync with its title and description.
+func (s *Server) handleCrossLink(client *github.Client, repo *github.Repository, number int, title, body string) {
+	org := repo.GetOwner().GetLogin()
+	configured := false
+	var links []string
+	for _, l := range s.Config.CrossLink {
+		if !stringInSlice(repo.GetFullName(), l.Repos) && !stringInSlice(org, l.Repos) {
+			continue
+		}
+		configured = true
+		for _, link := range l.crossLinks(title + "\n" + body) {
+			if !stringInSlice(link, links) {
+				links = append(links, link)
+			}
+		}
+	}
+	if !configured {
+		return
+	}
+	if len(links) == 0 {
+		s.commentPruner(client, repo, number).PruneComments(commentpruner.HasMarker(crossLinkMarker))
+		return
+	}
+	s.upsertComment(client, repo, number, crossLinkMarker, crossLinkMarker+"\nRelated tracker items:\n* "+strings.Join(links, "\n* "))
+}
+
+func (c *Config) validateCrossLink() error {
+	for i, l := range c.CrossLink {
+		c.CrossLink[i].res = map[string]*regexp.Regexp{}
+		for pattern, tmpl := range l.Trackers {
+			if !strings.Contains(tmpl, "{key}") {
+				return fmt.Errorf("crosslink %d: url template %q has no {key}", i, tmpl)
+			}
+			re, err := regexp.Compile(`\b(?:` + pattern + `)\b`)
+			if err != nil {
+				return fmt.Errorf("crosslink %d: invalid regexp %q: %v", i, pattern, err)
+			}
+			c.CrossLink[i].res[pattern] = re
+		}
+	}
+	return nil
+}
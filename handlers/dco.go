@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"ci-bot/commentpruner"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	dcoContext       = "dco"
+	dcoNoLabel       = "dco-signoff: no"
+	dcoCommentMarker = "<!-- ci-bot:dco -->"
+)
+
+var signedOffReg = regexp.MustCompile(`(?mi)^Signed-off-by:\s*(.*?)\s*<(.*)>\s*$`)
+
+// Dco is the config of the DCO check for an org or repo.
+type Dco struct {
+	// SkipDCOCheck turns the check off, e.g. for a repo of an org that is
+	// checked otherwise.
+	SkipDCOCheck bool `json:"skip_dco_check,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "dco",
+		Events:      []string{"pull_request"},
+		Description: "The dco plugin checks that every commit of a pull request carries a 'Signed-off-by' line of its author, as required by the Developer Certificate of Origin. It sets the '" + dcoContext + "' status, applies the '" + dcoNoLabel + "' label while commits lack a sign off and explains how to fix them.",
+		ConfigKey:   "dco",
+	}, func(c *Config) []string {
+		var enabled []string
+		for k, d := range c.Dco {
+			if !d.SkipDCOCheck {
+				enabled = append(enabled, k)
+			}
+		}
+		return enabled
+	})
+}
+
+// dcoFor returns the DCO config of a repo, repo entries taking precedence
+// over org ones.
+func (c *Config) dcoFor(org, repo string) (Dco, bool) {
+	if d, ok := c.Dco[org+"/"+repo]; ok {
+		return d, true
+	}
+	d, ok := c.Dco[org]
+	return d, ok
+}
+
+// isSignedOff reports whether a commit is signed off by its author. The
+// email identifies the author: names are neither unique nor verified.
+func isSignedOff(commit *github.Commit) bool {
+	email := commit.GetAuthor().GetEmail()
+	if email == "" {
+		return false
+	}
+	for _, m := range signedOffReg.FindAllStringSubmatch(commit.GetMessage(), -1) {
+		if strings.EqualFold(m[2], email) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleDCO checks the sign off of every commit of a PR.
+func (s *Server) handleDCO(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	org := repo.GetOwner().GetLogin()
+	if d, ok := s.Config.dcoFor(org, repo.GetName()); !ok || d.SkipDCOCheck {
+		return
+	}
+	number := pr.GetNumber()
+	commits, err := scmFor(client).ListCommits(org, repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list commits of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	var unsigned []string
+	for _, c := range commits {
+		if !isSignedOff(c.GetCommit()) {
+			unsigned = append(unsigned, fmt.Sprintf("* %s %s", c.GetSHA()[:7], strings.SplitN(c.GetCommit().GetMessage(), "\n", 2)[0]))
+		}
+	}
+
+	state, description := "success", "All commits are signed off"
+	if len(unsigned) > 0 {
+		state, description = "failure", "Commits in PR missing Signed-off-by"
+	}
+	createStatus(client, repo, pr.GetHead().GetSHA(), dcoContext, state, description, "")
+
+	labeled := hasLabel(pr.Labels, dcoNoLabel)
+	if len(unsigned) == 0 {
+		if labeled {
+			removeLabel(client, repo, number, dcoNoLabel)
+		}
+		s.commentPruner(client, repo, number).PruneComments(commentpruner.HasMarker(dcoCommentMarker))
+		return
+	}
+	if !labeled {
+		addLabel(client, repo, number, dcoNoLabel)
+	}
+	body := fmt.Sprintf("%s\nThanks for your pull request. Before we can look at it, you'll need to add a 'DCO signoff' to your commits.\n\n"+
+		"The following commits are missing a `Signed-off-by` line of their author:\n%s\n\n"+
+		"To sign off the last commit, run `git commit --amend --signoff` and force push. "+
+		"To sign off all of them, run `git rebase --signoff %s` and force push.",
+		dcoCommentMarker, strings.Join(unsigned, "\n"), pr.GetBase().GetSHA())
+	s.upsertComment(client, repo, number, dcoCommentMarker, body)
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"ci-bot/podutils"
+)
+
+const (
+	defaultDecorationTimeout     = 2 * time.Hour
+	defaultDecorationGracePeriod = 15 * time.Second
+
+	// Where the volumes shared by the utilities and the test are mounted.
+	decorationLogsDir  = "/logs"
+	decorationToolsDir = "/tools"
+	decorationCodeDir  = "/home/ci-bot/go/src"
+	decorationCredsDir = "/secrets/storage"
+)
+
+// DecorationConfig configures the utilities decorating the pods of jobs:
+// they check out the refs of the job, enforce its timeout and upload its
+// log and artifacts.
+type DecorationConfig struct {
+	// UtilityImage has the podutils binary as /podutils, and git.
+	UtilityImage string `json:"utility_image"`
+	// Timeout of the test, 2h by default.
+	Timeout string `json:"timeout,omitempty"`
+	// GracePeriod the test has to exit once interrupted, 15s by default.
+	GracePeriod string `json:"grace_period,omitempty"`
+	// Storage is where logs and artifacts are uploaded; its token file is
+	// read from the credentials secret.
+	Storage podutils.Storage `json:"storage"`
+	// CredentialsSecret is mounted into the sidecar for the token file.
+	CredentialsSecret string `json:"credentials_secret,omitempty"`
+
+	timeout     time.Duration
+	gracePeriod time.Duration
+}
+
+func (c *Config) validateDecoration() error {
+	d := c.Jobs.Decoration
+	if d == nil {
+		return nil
+	}
+	if d.UtilityImage == "" {
+		return fmt.Errorf("jobs: decoration has no utility image")
+	}
+	if d.Storage.URL == "" {
+		return fmt.Errorf("jobs: decoration has no storage url")
+	}
+	d.timeout, d.gracePeriod = defaultDecorationTimeout, defaultDecorationGracePeriod
+	for _, f := range []struct {
+		name  string
+		value string
+		out   *time.Duration
+	}{{"timeout", d.Timeout, &d.timeout}, {"grace_period", d.GracePeriod, &d.gracePeriod}} {
+		if f.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(f.value)
+		if err != nil {
+			return fmt.Errorf("jobs: invalid decoration %s: %v", f.name, err)
+		}
+		*f.out = parsed
+	}
+	return nil
+}
+
+// validateDecorated checks a job can be decorated: the entrypoint wraps
+// the command of its single container.
+func (c *Config) validateDecorated(j JobBase) error {
+	if !j.Decorate {
+		return nil
+	}
+	if c.Jobs.Decoration == nil {
+		return fmt.Errorf("decorated but decoration isn't configured")
+	}
+	if len(j.Spec.Containers) != 1 {
+		return fmt.Errorf("decorated jobs must have a single container")
+	}
+	if len(j.Spec.Containers[0].Command) == 0 {
+		return fmt.Errorf("decorated jobs must set the command of their container")
+	}
+	return nil
+}
+
+// uploadPath is where the files of a job are in the storage.
+func (j *Job) uploadPath() string {
+	if j.Refs.Org == "" {
+		return path.Join(string(j.Type), j.Name, j.ID)
+	}
+	repo := j.Refs.Org + "_" + j.Refs.Repo
+	if len(j.Refs.Pulls) > 0 {
+		return path.Join(string(j.Type), repo, strconv.Itoa(j.Refs.Pulls[0].Number), j.Name, j.ID)
+	}
+	return path.Join(string(j.Type), repo, j.Name, j.ID)
+}
+
+// logURL links to the build log of a decorated job, when the storage is
+// served over http.
+func (d *DecorationConfig) logURL(j *Job) string {
+	if !strings.HasPrefix(d.Storage.URL, "http://") && !strings.HasPrefix(d.Storage.URL, "https://") {
+		return ""
+	}
+	return strings.TrimSuffix(d.Storage.URL, "/") + "/" + j.uploadPath() + "/build-log.txt"
+}
+
+// decorate wraps the test container of spec in the utilities: an init
+// container checks out the refs into the working dir of the test, the
+// test runs under the entrypoint and a sidecar uploads what it logged.
+func (d *DecorationConfig) decorate(j *Job, spec *PodSpec, env []EnvVar) {
+	var (
+		logs     = VolumeMount{Name: "logs", MountPath: decorationLogsDir}
+		tools    = VolumeMount{Name: "tools", MountPath: decorationToolsDir}
+		code     = VolumeMount{Name: "code", MountPath: decorationCodeDir}
+		marker   = path.Join(decorationLogsDir, "marker-file.txt")
+		log      = path.Join(decorationLogsDir, "process-log.txt")
+		cloneLog = path.Join(decorationLogsDir, "clone-log.txt")
+		artifact = path.Join(decorationLogsDir, "artifacts")
+		options  = func(v interface{}) string { b, _ := json.Marshal(v); return string(b) }
+	)
+	spec.Volumes = append(spec.Volumes,
+		Volume{Name: logs.Name, EmptyDir: &EmptyDirVolumeSource{}},
+		Volume{Name: tools.Name, EmptyDir: &EmptyDirVolumeSource{}},
+		Volume{Name: code.Name, EmptyDir: &EmptyDirVolumeSource{}},
+	)
+
+	test := &spec.Containers[0]
+	if test.WorkingDir == "" && j.Refs.Repo != "" {
+		test.WorkingDir = path.Join(decorationCodeDir, j.Refs.Repo)
+	}
+	entrypoint := podutils.EntrypointOptions{
+		Args:        append(append([]string{}, test.Command...), test.Args...),
+		Timeout:     podutils.Duration{Duration: d.timeout},
+		GracePeriod: podutils.Duration{Duration: d.gracePeriod},
+		ProcessLog:  log,
+		MarkerFile:  marker,
+		ArtifactDir: artifact,
+	}
+	test.Command = []string{path.Join(decorationToolsDir, "podutils"), "entrypoint"}
+	test.Args = nil
+	test.Env = append(test.Env, EnvVar{Name: podutils.EntrypointOptionsEnv, Value: options(entrypoint)})
+	test.VolumeMounts = append(test.VolumeMounts, logs, tools, code)
+
+	spec.InitContainers = append(spec.InitContainers,
+		Container{
+			Name:         "clonerefs",
+			Image:        d.UtilityImage,
+			Command:      []string{"/podutils", "clonerefs"},
+			Env:          append(append([]EnvVar{}, env...), EnvVar{Name: podutils.CloneRefsOptionsEnv, Value: options(podutils.CloneRefsOptions{Dir: decorationCodeDir, Log: cloneLog})}),
+			VolumeMounts: []VolumeMount{logs, code},
+		},
+		Container{
+			Name:         "place-tools",
+			Image:        d.UtilityImage,
+			Command:      []string{"cp", "/podutils", path.Join(decorationToolsDir, "podutils")},
+			VolumeMounts: []VolumeMount{tools},
+		},
+	)
+
+	storage := d.Storage
+	sidecarMounts := []VolumeMount{logs}
+	if d.CredentialsSecret != "" {
+		spec.Volumes = append(spec.Volumes, Volume{Name: "storage-credentials", Secret: &SecretVolumeSource{SecretName: d.CredentialsSecret}})
+		sidecarMounts = append(sidecarMounts, VolumeMount{Name: "storage-credentials", MountPath: decorationCredsDir, ReadOnly: true})
+		if storage.TokenFile != "" && !path.IsAbs(storage.TokenFile) {
+			storage.TokenFile = path.Join(decorationCredsDir, storage.TokenFile)
+		}
+	}
+	sidecar := podutils.SidecarOptions{
+		ProcessLog:  log,
+		MarkerFile:  marker,
+		ArtifactDir: artifact,
+		CloneLog:    cloneLog,
+		Storage:     storage,
+		Path:        j.uploadPath(),
+	}
+	spec.Containers = append(spec.Containers, Container{
+		Name:         "sidecar",
+		Image:        d.UtilityImage,
+		Command:      []string{"/podutils", "sidecar"},
+		Env:          append(append([]EnvVar{}, env...), EnvVar{Name: podutils.SidecarOptionsEnv, Value: options(sidecar)}),
+		VolumeMounts: sidecarMounts,
+	})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const dogURL = "https://random.dog/woof.json"
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "dog",
+		Events:      []string{"issue_comment"},
+		Description: "The dog plugin adds a dog image to an issue or PR in response to the `/woof` command.",
+		Commands: []PluginCommand{{
+			Usage:       "/(woof|bark)",
+			Description: "Add a dog image to the issue or PR",
+			WhoCanUse:   "Anyone",
+			Example:     "/woof",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleDog replies with a random dog picture. Videos are skipped, they
+// don't render in comments.
+func (s *Server) handleDog(client *github.Client, ic *github.IssueCommentEvent) {
+	for i := 0; i < 5; i++ {
+		var dog struct {
+			URL string `json:"url"`
+		}
+		if err := getJSON(dogURL, nil, &dog); err != nil {
+			logError(client, "fail to get a dog: %v", err)
+			return
+		}
+		lower := strings.ToLower(dog.URL)
+		if strings.HasSuffix(lower, ".mp4") || strings.HasSuffix(lower, ".webm") {
+			continue
+		}
+		createComment(client, ic.Repo, ic.GetIssue().GetNumber(), imageComment(ic.GetComment().GetUser().GetLogin(), dog.URL))
+		return
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	duplicateLabel = "triage/duplicate"
+	// defaultDuplicateSimilarity is the share of title words two issues
+	// must have in common to be reported as possible duplicates.
+	defaultDuplicateSimilarity = 0.6
+	maxDuplicateCandidates     = 5
+	// maxDuplicateSearchWords keeps the search query within the 5 OR
+	// operators GitHub allows.
+	maxDuplicateSearchWords = 6
+)
+
+// Duplicate is the config of the duplicate issue detection.
+type Duplicate struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos []string `json:"repos,omitempty"`
+	// Similarity is the title similarity, in (0,1], from which open issues
+	// are reported as possible duplicates. Defaults to 0.6.
+	Similarity float64 `json:"similarity,omitempty"`
+}
+
+// titleStopWords are too common to tell issues apart.
+var titleStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "not": true, "when": true,
+	"from": true, "does": true, "doesn": true, "should": true, "can": true, "cannot": true,
+	"are": true, "was": true, "this": true, "that": true, "into": true, "after": true,
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "duplicate",
+		Events:      []string{"issue_comment", "issues"},
+		Description: "Comments the open issues with similar titles on newly opened issues, and closes issues marked as duplicates of another with the '" + duplicateLabel + "' label.",
+		ConfigKey:   "duplicate",
+		Commands: []PluginCommand{{
+			Usage:       "/duplicate #<number>",
+			Description: "Labels the issue as a duplicate of another one and closes it.",
+			WhoCanUse:   "Collaborators of the repo.",
+			Example:     "/duplicate #123",
+		}},
+	}, func(c *Config) []string {
+		return c.Duplicate.Repos
+	})
+}
+
+func (c *Config) duplicateEnabled(repo *github.Repository) bool {
+	return stringInSlice(repo.GetFullName(), c.Duplicate.Repos) || stringInSlice(repo.GetOwner().GetLogin(), c.Duplicate.Repos)
+}
+
+// titleWords returns the distinct significant words of a title.
+func titleWords(title string) []string {
+	seen := map[string]bool{}
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(w) < 3 || titleStopWords[w] || seen[w] {
+			continue
+		}
+		seen[w] = true
+		words = append(words, w)
+	}
+	return words
+}
+
+// titleSimilarity is the Jaccard index of the significant words of two titles.
+func titleSimilarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := map[string]bool{}
+	for _, w := range a {
+		set[w] = true
+	}
+	common := 0
+	for _, w := range b {
+		if set[w] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// handleDuplicateDetection comments the open issues whose titles look like
+// the one of a newly opened issue.
+func (s *Server) handleDuplicateDetection(client *github.Client, repo *github.Repository, issue *github.Issue) {
+	if !s.Config.duplicateEnabled(repo) || issue.IsPullRequest() {
+		return
+	}
+	words := titleWords(issue.GetTitle())
+	if len(words) == 0 {
+		return
+	}
+	similarity := s.Config.Duplicate.Similarity
+	if similarity == 0 {
+		similarity = defaultDuplicateSimilarity
+	}
+
+	terms := words
+	if len(terms) > maxDuplicateSearchWords {
+		terms = terms[:maxDuplicateSearchWords]
+	}
+	query := fmt.Sprintf("repo:%s is:issue is:open in:title %s", repo.GetFullName(), strings.Join(terms, " OR "))
+	// The first page of best matches is enough, the rest is unlikely to
+	// reach the similarity.
+	found, _, err := scmFor(client).SearchIssues(query, "", 100)
+	if err != nil {
+		logError(client, "fail to search issues similar to %s#%d: %v", repo.GetFullName(), issue.GetNumber(), err)
+		return
+	}
+	var candidates []string
+	for _, i := range found {
+		if i.GetNumber() == issue.GetNumber() || i.IsPullRequest() {
+			continue
+		}
+		if titleSimilarity(words, titleWords(i.GetTitle())) < similarity {
+			continue
+		}
+		candidates = append(candidates, fmt.Sprintf("- #%d %s", i.GetNumber(), i.GetTitle()))
+		if len(candidates) == maxDuplicateCandidates {
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	createComment(client, repo, issue.GetNumber(), fmt.Sprintf("@%s: This issue looks similar to the following open issues:\n%s\n\n"+
+		"If it is a duplicate of one of them, please close it and add your details there. A collaborator can also comment `/duplicate #<number>`.",
+		issue.GetUser().GetLogin(), strings.Join(candidates, "\n")))
+}
+
+// handleDuplicate labels an issue as a duplicate of another and closes it.
+func (s *Server) handleDuplicate(client *github.Client, ic *github.IssueCommentEvent) {
+	if !s.Config.duplicateEnabled(ic.Repo) {
+		return
+	}
+	issue := ic.GetIssue()
+	number := issue.GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+	m := duplicateReg.FindStringSubmatch(ic.GetComment().GetBody())
+	original, _ := strconv.Atoi(m[1])
+
+	ok, err := isCollaborator(client, ic.Repo, login)
+	if err != nil {
+		logError(client, "fail to check whether %s is a collaborator of %s: %v", login, ic.Repo.GetFullName(), err)
+		return
+	}
+	if !ok {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Only collaborators can mark issues as duplicates.", login))
+		return
+	}
+	if original == number {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: An issue can't be a duplicate of itself.", login))
+		return
+	}
+
+	scm := scmFor(client)
+	owner, name := ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName()
+	if _, err := scm.GetIssue(owner, name, original); err != nil {
+		logError(client, "fail to get %s#%d: %v", ic.Repo.GetFullName(), original, err)
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Can't find #%d.", login, original))
+		return
+	}
+	if !hasLabel(issueLabels(issue), duplicateLabel) {
+		addLabel(client, ic.Repo, number, duplicateLabel)
+	}
+	// "Duplicate of #N" is also how GitHub itself links duplicates.
+	createComment(client, ic.Repo, number, fmt.Sprintf("Duplicate of #%d\n\nClosing on request of @%s, please follow up there.", original, login))
+	if issue.GetState() == "closed" {
+		return
+	}
+	if err := scm.EditIssue(owner, name, number, &github.IssueRequest{State: github.String("closed")}); err != nil {
+		logError(client, "fail to close %s#%d: %v", ic.Repo.GetFullName(), number, err)
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// The events emails can be sent for.
+const (
+	emailEventJobFailure   = "job_failure"
+	emailEventLabeledIssue = "labeled_issue"
+)
+
+var emailEvents = []string{emailEventJobFailure, emailEventLabeledIssue}
+
+var defaultEmailTemplates = map[string][2]string{
+	emailEventJobFailure: {
+		"[{{.Repo}}] {{.Context}} failed on {{.Branch}}",
+		"{{.Context}} failed on {{.Repo}}@{{.SHA}} ({{.Branch}}): {{.Description}}\n\n{{.URL}}\n",
+	},
+	emailEventLabeledIssue: {
+		"[{{.Repo}}] {{.Label}}: {{.Title}} (#{{.Number}})",
+		"{{.Sender}} labeled {{.Repo}}#{{.Number}} {{.Label}}.\n\n{{.Title}}\n{{.URL}}\n",
+	},
+}
+
+// Email is the config of the email notifications.
+type Email struct {
+	SMTP          SMTP                `json:"smtp,omitempty"`
+	Notifications []EmailNotification `json:"notifications,omitempty"`
+}
+
+// SMTP is the server the emails are sent through.
+type SMTP struct {
+	Host string `json:"host"`
+	// Port of the server, 587 by default.
+	Port int    `json:"port,omitempty"`
+	User string `json:"user,omitempty"`
+	// PasswordFile holds the password of User.
+	PasswordFile string `json:"password_file,omitempty"`
+	From         string `json:"from"`
+}
+
+// EmailNotification emails To about Events of Repos.
+type EmailNotification struct {
+	// Repos are the orgs and org/repos the notification applies to.
+	Repos []string `json:"repos"`
+	To    []string `json:"to"`
+	// Events are any of job_failure (a failing status on a branch) and
+	// labeled_issue (an issue getting one of Labels).
+	Events []string `json:"events"`
+	// Branches are regexps of the branches job failures are reported for,
+	// e.g. release-.*, all by default.
+	Branches []string `json:"branches,omitempty"`
+	Labels   []string `json:"labels,omitempty"`
+	// Subject and Body are Go templates of the email, executed on the
+	// emailData of the event. Each event has a default.
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body,omitempty"`
+
+	branches []*regexp.Regexp
+	subject  *template.Template
+	body     *template.Template
+}
+
+// emailData is what the templates of an email can refer to.
+type emailData struct {
+	Event  string
+	Repo   string
+	Sender string
+	URL    string
+	// Number, Title and Label are set for labeled_issue.
+	Number int
+	Title  string
+	Label  string
+	// SHA, Branch, Context, State and Description are set for
+	// job_failure.
+	SHA         string
+	Branch      string
+	Context     string
+	State       string
+	Description string
+}
+
+func (n EmailNotification) appliesTo(repo *github.Repository, event string) bool {
+	if !stringInSlice(event, n.Events) {
+		return false
+	}
+	return stringInSlice(repo.GetFullName(), n.Repos) || stringInSlice(repo.GetOwner().GetLogin(), n.Repos)
+}
+
+func (n EmailNotification) matchesBranch(branch string) bool {
+	if len(n.branches) == 0 {
+		return true
+	}
+	for _, re := range n.branches {
+		if re.MatchString(branch) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "email",
+		Events:      []string{"issues", "status"},
+		Description: "The email plugin emails the configured addresses when jobs fail on the configured branches, e.g. release branches, and when issues get one of the configured labels, e.g. security. Emails are rendered from per-notification templates.",
+		ConfigKey:   "email",
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, n := range c.Email.Notifications {
+			enabled = append(enabled, n.Repos...)
+		}
+		return enabled
+	})
+}
+
+func (c *Config) validateEmail() error {
+	if len(c.Email.Notifications) > 0 && (c.Email.SMTP.Host == "" || c.Email.SMTP.From == "") {
+		return fmt.Errorf("email: smtp host and from must be set")
+	}
+	for i := range c.Email.Notifications {
+		n := &c.Email.Notifications[i]
+		if len(n.Repos) == 0 || len(n.To) == 0 {
+			return fmt.Errorf("email: notification %d needs repos and to", i)
+		}
+		if len(n.Events) != 1 && (n.Subject != "" || n.Body != "") {
+			return fmt.Errorf("email: notification %d has templates but not exactly one event", i)
+		}
+		for _, e := range n.Events {
+			if !stringInSlice(e, emailEvents) {
+				return fmt.Errorf("email: unknown event %q in notification %d", e, i)
+			}
+		}
+		if stringInSlice(emailEventLabeledIssue, n.Events) && len(n.Labels) == 0 {
+			return fmt.Errorf("email: notification %d needs labels for %s", i, emailEventLabeledIssue)
+		}
+		n.branches = nil
+		for _, b := range n.Branches {
+			re, err := regexp.Compile("^(?:" + b + ")$")
+			if err != nil {
+				return fmt.Errorf("email: invalid branch %q in notification %d: %v", b, i, err)
+			}
+			n.branches = append(n.branches, re)
+		}
+		var err error
+		if n.Subject != "" {
+			if n.subject, err = template.New("subject").Parse(n.Subject); err != nil {
+				return fmt.Errorf("email: invalid subject in notification %d: %v", i, err)
+			}
+		}
+		if n.Body != "" {
+			if n.body, err = template.New("body").Parse(n.Body); err != nil {
+				return fmt.Errorf("email: invalid body in notification %d: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// handleEmailStatus emails about failing statuses of the branches of the
+// commit.
+func (s *Server) handleEmailStatus(se *github.StatusEvent) {
+	if se.GetState() != "failure" && se.GetState() != "error" {
+		return
+	}
+	data := emailData{
+		Event:       emailEventJobFailure,
+		Repo:        se.Repo.GetFullName(),
+		Sender:      se.GetSender().GetLogin(),
+		URL:         se.GetTargetURL(),
+		SHA:         se.GetSHA(),
+		Context:     se.GetContext(),
+		State:       se.GetState(),
+		Description: se.GetDescription(),
+	}
+	for _, n := range s.Config.Email.Notifications {
+		if !n.appliesTo(se.Repo, emailEventJobFailure) {
+			continue
+		}
+		for _, b := range se.Branches {
+			if n.matchesBranch(b.GetName()) {
+				data.Branch = b.GetName()
+				s.sendEmail(n, data)
+				break
+			}
+		}
+	}
+}
+
+// handleEmailIssue emails about issues getting one of the labels of a
+// notification.
+func (s *Server) handleEmailIssue(ie *github.IssuesEvent) {
+	if ie.GetAction() != "labeled" || ie.GetIssue().IsPullRequest() {
+		return
+	}
+	data := emailData{
+		Event:  emailEventLabeledIssue,
+		Repo:   ie.Repo.GetFullName(),
+		Sender: ie.GetSender().GetLogin(),
+		URL:    ie.GetIssue().GetHTMLURL(),
+		Number: ie.GetIssue().GetNumber(),
+		Title:  ie.GetIssue().GetTitle(),
+		Label:  ie.GetLabel().GetName(),
+	}
+	for _, n := range s.Config.Email.Notifications {
+		if n.appliesTo(ie.Repo, emailEventLabeledIssue) && stringInSlice(data.Label, n.Labels) {
+			s.sendEmail(n, data)
+		}
+	}
+}
+
+func (s *Server) sendEmail(n EmailNotification, data emailData) {
+	subject, body := n.subject, n.body
+	if subject == nil {
+		subject = template.Must(template.New("subject").Parse(defaultEmailTemplates[data.Event][0]))
+	}
+	if body == nil {
+		body = template.Must(template.New("body").Parse(defaultEmailTemplates[data.Event][1]))
+	}
+	var subjectText, bodyText bytes.Buffer
+	if err := subject.Execute(&subjectText, data); err != nil {
+		glog.Errorf("fail to render email subject: %v", err)
+		return
+	}
+	if err := body.Execute(&bodyText, data); err != nil {
+		glog.Errorf("fail to render email body: %v", err)
+		return
+	}
+	if err := s.sendMail(n.To, strings.Replace(subjectText.String(), "\n", " ", -1), bodyText.String()); err != nil {
+		glog.Errorf("fail to email %v about %s: %v", n.To, data.Repo, err)
+	}
+}
+
+// sendMail sends a plain text email through the SMTP server.
+func (s *Server) sendMail(to []string, subject, body string) error {
+	c := s.Config.Email.SMTP
+	port := c.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if c.User != "" {
+		password, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", c.User, strings.TrimSpace(string(password)), c.Host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return smtp.SendMail(net.JoinHostPort(c.Host, strconv.Itoa(port)), auth, c.From, to, msg.Bytes())
+}
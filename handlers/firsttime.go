@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	firstTimeContributorLabel = "first-time-contributor"
+	// contributorCacheTTL is how long authors without merged PRs are
+	// cached, those with merged PRs stay contributors for good.
+	contributorCacheTTL = time.Hour
+)
+
+// FirstTimeContributor is the config of the first-time contributor labeling.
+type FirstTimeContributor struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos []string `json:"repos,omitempty"`
+}
+
+// contributors caches whether org/login has merged PRs in org.
+var contributors = struct {
+	sync.Mutex
+	merged  map[string]bool
+	expires map[string]time.Time
+}{merged: map[string]bool{}, expires: map[string]time.Time{}}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "first-time-contributor",
+		Events:      []string{"pull_request"},
+		Description: "Labels PRs of authors without merged PRs in the org as '" + firstTimeContributorLabel + "', so reviewers and the welcome plugin can treat them differently.",
+		ConfigKey:   "first_time_contributor",
+	}, func(c *Config) []string {
+		return c.FirstTimeContributor.Repos
+	})
+}
+
+// hasMergedPRs reports whether login authored a merged PR in org.
+func hasMergedPRs(client *github.Client, org, login string) (bool, error) {
+	key := org + "/" + login
+	contributors.Lock()
+	merged, ok := contributors.merged[key]
+	expires := contributors.expires[key]
+	contributors.Unlock()
+	if ok && (merged || time.Now().Before(expires)) {
+		return merged, nil
+	}
+
+	query := fmt.Sprintf("is:pr is:merged org:%s author:%s", org, login)
+	_, total, err := scmFor(client).SearchIssues(query, "", 1)
+	if err != nil {
+		return false, err
+	}
+	merged = total > 0
+
+	contributors.Lock()
+	contributors.merged[key] = merged
+	contributors.expires[key] = time.Now().Add(contributorCacheTTL)
+	contributors.Unlock()
+	return merged, nil
+}
+
+// handleFirstTimeContributor labels the PRs of authors who never had a PR
+// merged in the org.
+func (s *Server) handleFirstTimeContributor(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	org := repo.GetOwner().GetLogin()
+	if !stringInSlice(repo.GetFullName(), s.Config.FirstTimeContributor.Repos) && !stringInSlice(org, s.Config.FirstTimeContributor.Repos) {
+		return
+	}
+	login := pr.GetUser().GetLogin()
+	if login == s.BotName || hasLabel(pr.Labels, firstTimeContributorLabel) {
+		return
+	}
+	merged, err := hasMergedPRs(client, org, login)
+	if err != nil {
+		logError(client, "fail to search merged PRs of %s in %s: %v", login, org, err)
+		return
+	}
+	if !merged {
+		addLabel(client, repo, pr.GetNumber(), firstTimeContributorLabel)
+	}
+}
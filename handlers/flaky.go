@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/google/go-github/github"
+)
+
+// FlakyContext marks the presubmit reporting a context as known to fail
+// now and then without the PR being at fault.
+type FlakyContext struct {
+	Context string `json:"context"`
+	// MaxRetries is how many times the bot runs the job again by itself
+	// when it fails on a commit.
+	MaxRetries int `json:"max_retries"`
+}
+
+func (c *Config) validateFlaky() error {
+	for key, flaky := range c.Jobs.Flaky {
+		for _, f := range flaky {
+			if f.Context == "" {
+				return fmt.Errorf("jobs: flaky context without context for %s", key)
+			}
+			if f.MaxRetries <= 0 {
+				return fmt.Errorf("jobs: flaky context %s for %s must have a positive max_retries", f.Context, key)
+			}
+		}
+	}
+	return nil
+}
+
+// maxRetries is how many times the presubmit reporting context is retried
+// on repo, 0 for the ones that aren't flaky.
+func (c JobConfig) maxRetries(repo *github.Repository, context string) int {
+	for _, key := range []string{repo.GetFullName(), repo.GetOwner().GetLogin()} {
+		for _, f := range c.Flaky[key] {
+			if f.Context == context {
+				return f.MaxRetries
+			}
+		}
+	}
+	return 0
+}
+
+// retryFlaky runs again a flaky presubmit that just failed when no other
+// presubmit fails on the commit and it has retries left, noting the retry
+// on the PR. It reports whether the job was retried.
+func (s *Server) retryFlaky(client *github.Client, failed *Job) bool {
+	repo := failed.Refs.repo()
+	max := s.Config.Jobs.maxRetries(repo, failed.Context)
+	if max == 0 {
+		return false
+	}
+	sha := failed.sha()
+	number := failed.Refs.Pulls[0].Number
+	runs := s.listJobs(func(j *Job) bool {
+		return j.Type == PresubmitJob && j.Refs.Org == failed.Refs.Org && j.Refs.Repo == failed.Refs.Repo &&
+			j.Refs.Pulls[0].Number == number && j.sha() == sha && j.State != AbortedState
+	})
+	attempts := 0
+	latest := map[string]Job{}
+	for _, j := range runs {
+		if j.Name == failed.Name {
+			attempts++
+		}
+		latest[j.Name] = j
+	}
+	if attempts > max {
+		return false
+	}
+	for _, j := range latest {
+		if j.Name != failed.Name && (j.State == FailureState || j.State == ErrorState) {
+			// The PR is likely at fault.
+			return false
+		}
+	}
+
+	var presubmit *Presubmit
+	for _, p := range s.Config.Jobs.presubmitsFor(repo) {
+		if p.Name == failed.Name {
+			p := p
+			presubmit = &p
+			break
+		}
+	}
+	if presubmit == nil {
+		// Removed from the config since it was triggered.
+		return false
+	}
+	retry := s.createJob(Job{
+		Type:     PresubmitJob,
+		Name:     presubmit.Name,
+		Context:  presubmit.context(),
+		Labels:   presubmit.Labels,
+		Spec:     *presubmit.Spec,
+		Decorate: presubmit.Decorate,
+		Refs:     failed.Refs,
+	})
+	reportJob(client, &retry)
+	details := ""
+	if failed.URL != "" {
+		details = fmt.Sprintf(" ([logs](%s))", failed.URL)
+	}
+	createComment(client, repo, number, transientMarker("flaky")+"\n"+fmt.Sprintf(
+		"Job %s failed on %s%s. It is known to be flaky, so it is running again (retry %d of %d).",
+		failed.Name, sha, details, attempts, max))
+	return true
+}
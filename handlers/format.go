@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultLocale = "en-US"
+
+// LocaleConfig controls how reports posted in the repos of an org render
+// dates, durations and numbers.
+type LocaleConfig struct {
+	// Locale is one of the locales in localeFormats, en-US by default.
+	Locale string `json:"locale,omitempty"`
+	// Timezone is an IANA zone name, UTC by default.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+type localeFormat struct {
+	dateLayout string
+	thousands  string
+	decimal    string
+	// units holds the singular and plural names of days, hours and minutes.
+	units [3][2]string
+	// spaced is false for locales that don't separate a number from its unit.
+	spaced bool
+}
+
+var localeFormats = map[string]localeFormat{
+	"en-US": {"Jan 2, 2006 15:04 MST", ",", ".", [3][2]string{{"day", "days"}, {"hour", "hours"}, {"minute", "minutes"}}, true},
+	"en-GB": {"2 Jan 2006 15:04 MST", ",", ".", [3][2]string{{"day", "days"}, {"hour", "hours"}, {"minute", "minutes"}}, true},
+	"de-DE": {"02.01.2006 15:04 MST", ".", ",", [3][2]string{{"Tag", "Tage"}, {"Stunde", "Stunden"}, {"Minute", "Minuten"}}, true},
+	"fr-FR": {"02/01/2006 15:04 MST", " ", ",", [3][2]string{{"jour", "jours"}, {"heure", "heures"}, {"minute", "minutes"}}, true},
+	"zh-CN": {"2006-01-02 15:04 MST", ",", ".", [3][2]string{{"天", "天"}, {"小时", "小时"}, {"分钟", "分钟"}}, false},
+	"ja-JP": {"2006/01/02 15:04 MST", ",", ".", [3][2]string{{"日", "日"}, {"時間", "時間"}, {"分", "分"}}, false},
+}
+
+// Formatter renders values for reports according to the locale of an org.
+type Formatter struct {
+	loc    *time.Location
+	format localeFormat
+}
+
+// FormatterFor returns the report formatter configured for org.
+func (c *Config) FormatterFor(org string) Formatter {
+	f := Formatter{loc: time.UTC, format: localeFormats[defaultLocale]}
+	lc, ok := c.Locales[org]
+	if !ok {
+		return f
+	}
+	if lf, ok := localeFormats[lc.Locale]; ok {
+		f.format = lf
+	}
+	if loc, err := time.LoadLocation(lc.Timezone); err == nil {
+		f.loc = loc
+	}
+	return f
+}
+
+// Date formats t in the timezone of the org.
+func (f Formatter) Date(t time.Time) string {
+	return t.In(f.loc).Format(f.format.dateLayout)
+}
+
+// Duration formats d using its two most significant units, e.g. "3 days
+// 4 hours". A zero second unit is left out rather than replaced by the
+// next one, so 1 day and 5 minutes is "1 day", not "1 day 5 minutes".
+func (f Formatter) Duration(d time.Duration) string {
+	values := []int64{
+		int64(d / (24 * time.Hour)),
+		int64(d % (24 * time.Hour) / time.Hour),
+		int64(d % time.Hour / time.Minute),
+	}
+	first := len(values) - 1
+	for i, v := range values {
+		if v != 0 {
+			first = i
+			break
+		}
+	}
+	var parts []string
+	for i := first; i < len(values) && i < first+2; i++ {
+		v := values[i]
+		if v == 0 && i > first {
+			break
+		}
+		unit := f.format.units[i][1]
+		if v == 1 {
+			unit = f.format.units[i][0]
+		}
+		if f.format.spaced {
+			parts = append(parts, fmt.Sprintf("%s %s", f.Number(v), unit))
+		} else {
+			parts = append(parts, f.Number(v)+unit)
+		}
+	}
+	sep := " "
+	if !f.format.spaced {
+		sep = ""
+	}
+	return strings.Join(parts, sep)
+}
+
+// Number formats n with the thousands separator of the locale.
+func (f Formatter) Number(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	var groups []string
+	for len(digits) > 3 {
+		groups = append([]string{digits[len(digits)-3:]}, groups...)
+		digits = digits[:len(digits)-3]
+	}
+	groups = append([]string{digits}, groups...)
+	return sign + strings.Join(groups, f.format.thousands)
+}
+
+// Percent formats a ratio in [0,1] as a percentage with one decimal, "-"
+// for NaN, the ratio of nothing.
+func (f Formatter) Percent(ratio float64) string {
+	if math.IsNaN(ratio) {
+		return "-"
+	}
+	s := strconv.FormatFloat(ratio*100, 'f', 1, 64)
+	return strings.Replace(s, ".", f.format.decimal, 1) + "%"
+}
+
+// PercentChange formats a difference of ratios like Percent, always
+// signed, e.g. "+1.5%".
+func (f Formatter) PercentChange(delta float64) string {
+	if delta >= 0 {
+		return "+" + f.Percent(delta)
+	}
+	return f.Percent(delta)
+}
+
+func (c *Config) validateLocales() error {
+	for org, lc := range c.Locales {
+		if lc.Locale != "" {
+			if _, ok := localeFormats[lc.Locale]; !ok {
+				return fmt.Errorf("org %s: unsupported locale %q", org, lc.Locale)
+			}
+		}
+		if _, err := time.LoadLocation(lc.Timezone); err != nil {
+			return fmt.Errorf("org %s: %v", org, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const (
+	// summaryContext is the status context carrying the merge gate
+	// decision.
+	summaryContext = "ci-bot/summary"
+	// mergeBlockersContext is the status context failing while the PR has
+	// any do-not-merge label, for branch protection to require.
+	mergeBlockersContext = "ci-bot/merge-blockers"
+	doNotMergePrefix     = "do-not-merge/"
+)
+
+// MergeBlocker is a single reason why a pull request cannot be merged yet.
+type MergeBlocker struct {
+	Kind   string `json:"kind"`
+	Label  string `json:"label,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// GateDecision is the machine-readable form of the merge gate, served as
+// JSON on /gate so tools don't have to scrape comments.
+type GateDecision struct {
+	Repo      string         `json:"repo"`
+	Number    int            `json:"number"`
+	SHA       string         `json:"sha"`
+	Mergeable bool           `json:"mergeable"`
+	Blockers  []MergeBlocker `json:"blockers"`
+}
+
+type gateLabel struct {
+	name   string
+	reason string
+}
+
+var (
+	// requiredLabels must be present before a PR can merge.
+	requiredLabels = []gateLabel{
+		{lgtmLabel, "a reviewer must comment /lgtm"},
+		{approvedLabel, "an approver must comment /approve"},
+	}
+	// blockingLabels prevent a PR from merging while present.
+	blockingLabels = []gateLabel{
+		{needsOKtoTest, "an org member must comment /ok-to-test"},
+		{holdLabel, "whoever put the PR on hold must comment /hold cancel"},
+		{wipLabel, "the PR must leave draft state and its title must not start with WIP"},
+		{needsRebaseLabel, "the PR must be rebased onto its base branch"},
+		{claNoLabel, "every author must sign the CLA"},
+		{dcoNoLabel, "every commit must be signed off by its author"},
+		{cherryPickUnapprovedLabel, "a cherry-pick approver of the base branch must add the " + cherryPickApprovedLabel + " label"},
+	}
+)
+
+// mergeBlockers evaluates the merge gate against the labels of a PR.
+func mergeBlockers(labels []*github.Label) []MergeBlocker {
+	blockers := make([]MergeBlocker, 0)
+	for _, l := range requiredLabels {
+		if !hasLabel(labels, l.name) {
+			blockers = append(blockers, MergeBlocker{Kind: "missing-label", Label: l.name, Reason: l.reason})
+		}
+	}
+	for _, l := range blockingLabels {
+		if hasLabel(labels, l.name) {
+			blockers = append(blockers, MergeBlocker{Kind: "blocking-label", Label: l.name, Reason: l.reason})
+		}
+	}
+	return blockers
+}
+
+// gateDecision evaluates the merge gate for the head of pr.
+func (s *Server) gateDecision(repo *github.Repository, pr *github.PullRequest) GateDecision {
+	decision := GateDecision{
+		Repo:     repo.GetFullName(),
+		Number:   pr.GetNumber(),
+		SHA:      pr.GetHead().GetSHA(),
+		Blockers: mergeBlockers(pr.Labels),
+	}
+	policy := s.Config.BranchPolicyFor(repo.GetOwner().GetLogin(), repo.GetName(), pr.GetBase().GetRef())
+	if !policy.CanMergeAt(time.Now()) {
+		decision.Blockers = append(decision.Blockers, MergeBlocker{
+			Kind:   "merge-window",
+			Reason: fmt.Sprintf("branch %s is outside of its merge windows", pr.GetBase().GetRef()),
+		})
+	}
+	decision.Mergeable = len(decision.Blockers) == 0
+	return decision
+}
+
+// updateSummaryStatus publishes the merge gate decision for the head of pr
+// as a commit status. Check runs can only be created by GitHub Apps, while
+// a status is set in place for its context, so the head keeps a single
+// summary. The status links to the decision as JSON on /gate when GateURL
+// is set and /gate serves the repo.
+func (s *Server) updateSummaryStatus(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	decision := s.gateDecision(repo, pr)
+	state, description := "success", "Ready to merge"
+	if !decision.Mergeable {
+		// Missing lgtm or approval is the normal state of a PR under
+		// review, so it's only pending; anything else fails.
+		state = "pending"
+		names := make([]string, 0, len(decision.Blockers))
+		for _, b := range decision.Blockers {
+			if b.Kind != "missing-label" {
+				state = "failure"
+			}
+			if b.Label == "" {
+				names = append(names, b.Kind)
+				continue
+			}
+			names = append(names, b.Label)
+		}
+		description = fmt.Sprintf("%d merge blocker(s): %s", len(names), strings.Join(names, ", "))
+		// Status descriptions are limited to 140 characters.
+		if len(description) > 140 {
+			description = description[:137] + "..."
+		}
+	}
+	targetURL := ""
+	if s.Config.GateURL != "" && s.gateServes(repo.GetOwner().GetLogin(), repo.GetName()) {
+		targetURL = fmt.Sprintf("%s?repo=%s&pr=%d", s.Config.GateURL, url.QueryEscape(decision.Repo), decision.Number)
+	}
+	createStatus(client, repo, decision.SHA, summaryContext, state, description, targetURL)
+}
+
+// serveGate serves /gate, the merge gate decision of the PR given by
+// repo=org/repo and pr=<number> as JSON. It's evaluated afresh from the
+// PR rather than remembered, for any replica to answer. Only clients
+// sending GateToken as a bearer token get the decisions, and only those of
+// the repos in GateRepos.
+func (s *Server) serveGate(w http.ResponseWriter, r *http.Request) {
+	if s.Config.GateToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.Config.GateToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	q := r.URL.Query()
+	parts := strings.SplitN(q.Get("repo"), "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "repo must be org/repo", http.StatusBadRequest)
+		return
+	}
+	if !s.gateServes(parts[0], parts[1]) {
+		http.Error(w, "repo not served", http.StatusForbidden)
+		return
+	}
+	number, err := strconv.Atoi(q.Get("pr"))
+	if err != nil {
+		http.Error(w, "invalid pr", http.StatusBadRequest)
+		return
+	}
+	client := s.providerClient(parts[0], s.GithubClient)
+	pr, err := scmFor(client).GetPullRequest(parts[0], parts[1], number)
+	if err != nil {
+		glog.Errorf("fail to get %s#%d: %v", q.Get("repo"), number, err)
+		http.Error(w, "fail to get the pull request", http.StatusBadGateway)
+		return
+	}
+	repo := &github.Repository{
+		Name:     github.String(parts[1]),
+		FullName: github.String(q.Get("repo")),
+		Owner:    &github.User{Login: github.String(parts[0])},
+	}
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	if err := json.NewEncoder(w).Encode(s.gateDecision(repo, pr)); err != nil {
+		glog.Errorf("fail to encode gate decision: %v", err)
+	}
+}
+
+// gateServes reports whether /gate serves the decisions of the PRs of
+// org/repo.
+func (s *Server) gateServes(org, repo string) bool {
+	if s.Config.GateToken == "" {
+		return false
+	}
+	return stringInSlice(org+"/"+repo, s.Config.GateRepos) || stringInSlice(org, s.Config.GateRepos)
+}
+
+// doNotMergeLabels returns the labels of a PR that forbid merging it.
+func doNotMergeLabels(labels []*github.Label) []string {
+	var found []string
+	for _, l := range labels {
+		name := l.GetName()
+		if strings.HasPrefix(name, doNotMergePrefix) || name == needsRebaseLabel || name == needsOKtoTest {
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+// updateMergeBlockersStatus sets the merge blockers status of the head of pr
+// from its labels.
+func (s *Server) updateMergeBlockersStatus(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	state, description := "success", "No do-not-merge labels"
+	if found := doNotMergeLabels(pr.Labels); len(found) > 0 {
+		state = "failure"
+		description = "Not mergeable. Needs removal of: " + strings.Join(found, ", ")
+		// Status descriptions are limited to 140 characters.
+		if len(description) > 140 {
+			description = description[:137] + "..."
+		}
+	}
+	createStatus(client, repo, pr.GetHead().GetSHA(), mergeBlockersContext, state, description, "")
+}
@@ -0,0 +1,904 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// Gerrit has no webhooks the bot can rely on, so the changes of the
+// projects of a Gerrit provider are polled: new patch sets are handled as
+// pushes to a PR and new messages as comments, so that /test, /lgtm and
+// /approve work as on GitHub. Code-Review votes count as reviews, +1 and
+// +2 as approving ones and +2 as /approve too. The SCM of a Gerrit
+// provider, gerritSCM, posts comments as review messages, the lgtm and
+// approved labels as the Code-Review votes of the bot, and the
+// ci-bot/required-jobs status as its Verified vote.
+//
+// The org of the repos of a Gerrit provider is its key in the config,
+// their name the project, and the number of their PRs the change number.
+
+const gerritPollInterval = time.Minute
+
+func init() {
+	registerPeriodic("gerrit", gerritPollInterval, func(c *Config) bool {
+		for _, p := range c.Providers {
+			if p.Type == ProviderGerrit {
+				return true
+			}
+		}
+		return false
+	}, (*Server).pollGerrit)
+}
+
+// gerritTimeLayout is how Gerrit writes timestamps, in UTC.
+const gerritTimeLayout = "2006-01-02 15:04:05.000000000"
+
+type gerritTime struct {
+	time.Time
+}
+
+func (t *gerritTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseInLocation(gerritTimeLayout, s, time.UTC)
+	t.Time = parsed
+	return err
+}
+
+// Subsets of the Gerrit REST API entities.
+type gerritAccount struct {
+	AccountID int    `json:"_account_id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+}
+
+type gerritChange struct {
+	Number          int                       `json:"_number"`
+	Project         string                    `json:"project"`
+	Branch          string                    `json:"branch"`
+	Subject         string                    `json:"subject"`
+	Status          string                    `json:"status"`
+	Owner           gerritAccount             `json:"owner"`
+	Created         gerritTime                `json:"created"`
+	Updated         gerritTime                `json:"updated"`
+	CurrentRevision string                    `json:"current_revision"`
+	Revisions       map[string]gerritRevision `json:"revisions"`
+	Messages        []gerritMessage           `json:"messages"`
+	Labels          map[string]struct {
+		All []struct {
+			gerritAccount
+			Value int `json:"value"`
+		} `json:"all"`
+	} `json:"labels"`
+}
+
+type gerritRevision struct {
+	Ref    string `json:"ref"`
+	Commit struct {
+		Parents []struct {
+			Commit string `json:"commit"`
+		} `json:"parents"`
+		Author struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"author"`
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+
+type gerritMessage struct {
+	ID      string        `json:"id"`
+	Author  gerritAccount `json:"author"`
+	Date    gerritTime    `json:"date"`
+	Message string        `json:"message"`
+}
+
+// gerritVoteReg matches the votes in the header of a review message, e.g.
+// "Patch Set 2: Code-Review+2 Verified-1".
+var gerritVoteReg = regexp.MustCompile(`\bCode-Review([+-]\d)\b`)
+
+// comment is the message as a comment: without the patch set header, and
+// with /approve for Code-Review+2.
+func (m gerritMessage) comment() string {
+	lines := strings.SplitN(m.Message, "\n", 2)
+	body := ""
+	if len(lines) == 2 {
+		body = strings.TrimSpace(lines[1])
+	}
+	if !strings.HasPrefix(lines[0], "Patch Set") {
+		body = strings.TrimSpace(m.Message)
+	}
+	if m.vote() == 2 {
+		body = strings.TrimSpace(body + "\n/approve")
+	}
+	return body
+}
+
+// vote is the Code-Review vote the message casts, 0 for none.
+func (m gerritMessage) vote() int {
+	header := strings.SplitN(m.Message, "\n", 2)[0]
+	match := gerritVoteReg.FindStringSubmatch(header)
+	if match == nil {
+		return 0
+	}
+	var vote int
+	fmt.Sscanf(match[1], "%d", &vote)
+	return vote
+}
+
+func (m gerritMessage) github() *github.IssueComment {
+	h := fnv.New64a()
+	h.Write([]byte(m.ID))
+	return &github.IssueComment{
+		ID:        github.Int64(int64(h.Sum64() >> 1)),
+		Body:      github.String(m.comment()),
+		User:      m.Author.github(),
+		CreatedAt: &m.Date.Time,
+	}
+}
+
+func (a gerritAccount) github() *github.User {
+	login := a.Username
+	if login == "" {
+		login = fmt.Sprintf("%d", a.AccountID)
+	}
+	return &github.User{Login: github.String(login), Name: github.String(a.Name)}
+}
+
+func gerritRepo(org, project string) *github.Repository {
+	return &github.Repository{
+		Name:     github.String(project),
+		FullName: github.String(org + "/" + project),
+		Owner:    &github.User{Login: github.String(org)},
+	}
+}
+
+// github returns the change as a PR, labeled lgtm and approved by the
+// Code-Review votes of bot.
+func (c gerritChange) github(bot string) *github.PullRequest {
+	state := "open"
+	if c.Status != "NEW" {
+		state = "closed"
+	}
+	revision := c.Revisions[c.CurrentRevision]
+	base := ""
+	if len(revision.Commit.Parents) > 0 {
+		base = revision.Commit.Parents[0].Commit
+	}
+	var labels []*github.Label
+	for _, vote := range c.Labels["Code-Review"].All {
+		if vote.Username != bot {
+			continue
+		}
+		if vote.Value >= 1 {
+			labels = append(labels, &github.Label{Name: github.String(lgtmLabel)})
+		}
+		if vote.Value == 2 {
+			labels = append(labels, &github.Label{Name: github.String(approvedLabel)})
+		}
+	}
+	pr := &github.PullRequest{
+		Number: github.Int(c.Number),
+		Title:  github.String(c.Subject),
+		Body:   github.String(revision.Commit.Message),
+		State:  github.String(state),
+		Merged: github.Bool(c.Status == "MERGED"),
+		User:   c.Owner.github(),
+		Labels: labels,
+		Head:   &github.PullRequestBranch{Ref: github.String(revision.Ref), SHA: github.String(c.CurrentRevision)},
+		Base:   &github.PullRequestBranch{Ref: github.String(c.Branch), SHA: github.String(base)},
+	}
+	if c.Status == "MERGED" {
+		pr.MergedAt = &c.Updated.Time
+		pr.MergeCommitSHA = github.String(c.CurrentRevision)
+	}
+	return pr
+}
+
+func (c gerritChange) issue(bot string) *github.Issue {
+	pr := c.github(bot)
+	return &github.Issue{
+		Number:           pr.Number,
+		Title:            pr.Title,
+		State:            pr.State,
+		User:             pr.User,
+		Labels:           labelValues(pr.Labels),
+		PullRequestLinks: &github.PullRequestLinks{},
+	}
+}
+
+// gerritPolls is what the last poll of each Gerrit provider saw.
+var gerritPolls = struct {
+	sync.Mutex
+	m map[string]*gerritPoll
+}{m: map[string]*gerritPoll{}}
+
+type gerritPoll struct {
+	last time.Time
+	// revisions are the current revisions of the changes seen.
+	revisions map[int]string
+}
+
+var gerritClients = struct {
+	sync.Mutex
+	m   map[string]*github.Client
+	scm map[string]*gerritSCM
+}{m: map[string]*github.Client{}, scm: map[string]*gerritSCM{}}
+
+// gerritClient returns the client of a Gerrit provider and its SCM.
+func gerritClient(org string, p Provider) (*github.Client, *gerritSCM) {
+	gerritClients.Lock()
+	defer gerritClients.Unlock()
+	if client, ok := gerritClients.m[org]; ok {
+		return client, gerritClients.scm[org]
+	}
+	base, _ := url.Parse(strings.TrimSuffix(p.URL, "/") + "/a/")
+	scm := &gerritSCM{
+		user:         p.User,
+		password:     p.Token,
+		api:          base,
+		trustedGroup: p.TrustedGroup,
+	}
+	client := github.NewClient(&http.Client{Transport: unsupportedTransport("Gerrit")})
+	client.BaseURL, _ = url.Parse("https://gerrit.invalid/")
+	registerSCM(client, scm)
+	gerritClients.m[org], gerritClients.scm[org] = client, scm
+	return client, scm
+}
+
+// pollGerrit handles what changed in the open changes of every Gerrit
+// provider since its last poll. The first poll only records the changes.
+func (s *Server) pollGerrit(_ *github.Client) {
+	for org, p := range s.Config.Providers {
+		if p.Type == ProviderGerrit {
+			s.pollGerritProvider(org, p)
+		}
+	}
+}
+
+func (s *Server) pollGerritProvider(org string, p Provider) {
+	gerritPolls.Lock()
+	poll, seen := gerritPolls.m[org]
+	if !seen {
+		poll = &gerritPoll{revisions: map[int]string{}}
+		gerritPolls.m[org] = poll
+	}
+	gerritPolls.Unlock()
+
+	now := time.Now()
+	since := poll.last
+	if !seen {
+		since = now.Add(-gerritPollInterval)
+	}
+	var projects []string
+	for _, project := range p.Projects {
+		projects = append(projects, "project:"+project)
+	}
+	query := fmt.Sprintf(`status:open after:"%s"`, since.UTC().Add(-gerritPollInterval).Format("2006-01-02 15:04:05"))
+	if len(projects) > 0 {
+		query += " (" + strings.Join(projects, " OR ") + ")"
+	}
+	client, scm := gerritClient(org, p)
+	var changes []gerritChange
+	path := "changes/?o=CURRENT_REVISION&o=CURRENT_COMMIT&o=MESSAGES&o=DETAILED_ACCOUNTS&o=DETAILED_LABELS&q=" + url.QueryEscape(query)
+	if err := scm.call(http.MethodGet, path, nil, &changes); err != nil {
+		glog.Errorf("fail to poll Gerrit %s: %v", org, err)
+		return
+	}
+	poll.last = now
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Updated.Before(changes[j].Updated.Time) })
+
+	for _, c := range changes {
+		previous, known := poll.revisions[c.Number]
+		poll.revisions[c.Number] = c.CurrentRevision
+		if !seen {
+			continue
+		}
+		repo := gerritRepo(org, c.Project)
+		pr := c.github(p.User)
+		if previous != c.CurrentRevision {
+			action := "synchronize"
+			if !known && c.Created.After(since) {
+				action = "opened"
+			}
+			s.dispatchGerrit("pull_request", s.handlePullRequestEvent, client, github.PullRequestEvent{
+				Action:      github.String(action),
+				Number:      pr.Number,
+				PullRequest: pr,
+				Repo:        repo,
+				Sender:      pr.User,
+			})
+		}
+		for _, m := range c.Messages {
+			if !m.Date.After(since) || m.Author.Username == p.User {
+				continue
+			}
+			comment := m.github()
+			issue := c.issue(p.User)
+			if comment.GetBody() != "" {
+				s.dispatchGerrit("issue_comment", s.handleIssueCommentEvent, client, github.IssueCommentEvent{
+					Action:  github.String("created"),
+					Issue:   issue,
+					Comment: comment,
+					Repo:    repo,
+					Sender:  comment.User,
+				})
+			}
+			state := ""
+			switch vote := m.vote(); {
+			case vote > 0:
+				state = "approved"
+			case vote < 0:
+				state = "changes_requested"
+			}
+			if state != "" {
+				s.dispatchGerrit("pull_request_review", s.handlePullRequestReviewEvent, client, github.PullRequestReviewEvent{
+					Action:      github.String("submitted"),
+					Review:      &github.PullRequestReview{State: github.String(state), User: comment.User},
+					PullRequest: pr,
+					Repo:        repo,
+					Sender:      comment.User,
+				})
+			}
+		}
+	}
+}
+
+// dispatchGerrit handles an event translated from Gerrit. Events are
+// handled in order, unlike webhooks.
+func (s *Server) dispatchGerrit(eventType string, handle func([]byte, *github.Client), client *github.Client, event interface{}) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logError(client, "fail to marshal: %v", err)
+		return
+	}
+	s.handleEvent(eventType, "", payload, client, func(client *github.Client) { handle(payload, client) })
+}
+
+// gerritSCM is the SCM of a Gerrit provider.
+type gerritSCM struct {
+	user         string
+	password     string
+	api          *url.URL
+	trustedGroup string
+}
+
+func (g *gerritSCM) GetPullRequest(org, repo string, number int) (*github.PullRequest, error) {
+	var c gerritChange
+	if err := g.call(http.MethodGet, fmt.Sprintf("changes/%d?%s", number, gerritChangeOptions), nil, &c); err != nil {
+		return nil, err
+	}
+	return c.github(g.user), nil
+}
+
+func (g *gerritSCM) ListFiles(org, repo string, number int) ([]*github.CommitFile, error) {
+	changed, err := g.ListChangedFiles(org, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	files := []*github.CommitFile{}
+	for _, f := range changed {
+		files = append(files, &github.CommitFile{Filename: github.String(f.Filename), Status: github.String(f.Status)})
+	}
+	return files, nil
+}
+
+// gerritFileStatuses are the statuses of files as GitHub names them,
+// Gerrit omits the one of modified files.
+var gerritFileStatuses = map[string]string{
+	"":  "modified",
+	"A": "added",
+	"D": "removed",
+	"R": "renamed",
+	"C": "added",
+	"W": "modified",
+}
+
+func (g *gerritSCM) ListChangedFiles(org, repo string, number int) ([]ChangedFile, error) {
+	var files map[string]struct {
+		Status  string `json:"status"`
+		OldPath string `json:"old_path"`
+	}
+	if err := g.call(http.MethodGet, fmt.Sprintf("changes/%d/revisions/current/files", number), nil, &files); err != nil {
+		return nil, err
+	}
+	list := []ChangedFile{}
+	for name, f := range files {
+		if name == "/COMMIT_MSG" || name == "/MERGE_LIST" {
+			continue
+		}
+		changed := ChangedFile{Filename: name, Status: gerritFileStatuses[f.Status]}
+		if f.Status == "R" {
+			changed.PreviousFilename = f.OldPath
+		}
+		list = append(list, changed)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Filename < list[j].Filename })
+	return list, nil
+}
+
+// ListComments lists the review messages of the change.
+func (g *gerritSCM) ListComments(org, repo string, number int) ([]*github.IssueComment, error) {
+	var messages []gerritMessage
+	if err := g.call(http.MethodGet, fmt.Sprintf("changes/%d/messages", number), nil, &messages); err != nil {
+		return nil, err
+	}
+	comments := []*github.IssueComment{}
+	for _, m := range messages {
+		comments = append(comments, m.github())
+	}
+	return comments, nil
+}
+
+// CreateComment posts body as a review message of the current revision.
+func (g *gerritSCM) CreateComment(org, repo string, number int, body string) (*github.IssueComment, error) {
+	if err := g.review(fmt.Sprintf("%d", number), "current", body, nil); err != nil {
+		return nil, err
+	}
+	return &github.IssueComment{Body: github.String(body), User: &github.User{Login: github.String(g.user)}}, nil
+}
+
+func (g *gerritSCM) EditComment(org, repo string, id int64, body string) (*github.IssueComment, error) {
+	return nil, unsupported("Gerrit", "editing messages")
+}
+
+func (g *gerritSCM) DeleteComment(org, repo string, id int64) error {
+	return unsupported("Gerrit", "deleting messages")
+}
+
+// gerritVotes are the Code-Review votes of the bot standing for labels.
+var gerritVotes = map[string]int{lgtmLabel: 1, approvedLabel: 2}
+
+// AddLabels votes for the lgtm and approved labels, Gerrit has no others.
+func (g *gerritSCM) AddLabels(org, repo string, number int, labels ...string) error {
+	for _, label := range labels {
+		if vote, ok := gerritVotes[label]; ok {
+			if err := g.review(fmt.Sprintf("%d", number), "current", "", map[string]int{"Code-Review": vote}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *gerritSCM) RemoveLabel(org, repo string, number int, label string) error {
+	if _, ok := gerritVotes[label]; !ok {
+		return nil
+	}
+	return g.review(fmt.Sprintf("%d", number), "current", "", map[string]int{"Code-Review": 0})
+}
+
+// IsAssignee reports whether login has a Gerrit account, any account can
+// be assigned changes and review them.
+func (g *gerritSCM) IsAssignee(org, repo, login string) (bool, error) {
+	err := g.call(http.MethodGet, "accounts/"+url.PathEscape(login), nil, nil)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// AddAssignees assigns the change, to a single account as Gerrit does.
+func (g *gerritSCM) AddAssignees(org, repo string, number int, logins ...string) error {
+	if len(logins) > 1 {
+		return unsupported("Gerrit", "several assignees")
+	}
+	return g.call(http.MethodPut, fmt.Sprintf("changes/%d/assignee", number), map[string]string{"assignee": logins[0]}, nil)
+}
+
+func (g *gerritSCM) RemoveAssignees(org, repo string, number int, logins ...string) error {
+	path := fmt.Sprintf("changes/%d/assignee", number)
+	var assignee gerritAccount
+	err := g.call(http.MethodGet, path, nil, &assignee)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, l := range logins {
+		if strings.EqualFold(l, assignee.Username) {
+			return g.call(http.MethodDelete, path, nil, nil)
+		}
+	}
+	return nil
+}
+
+func (g *gerritSCM) RequestReviewers(org, repo string, number int, logins ...string) error {
+	for _, l := range logins {
+		if err := g.call(http.MethodPost, fmt.Sprintf("changes/%d/reviewers", number), map[string]string{"reviewer": l}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *gerritSCM) RemoveReviewers(org, repo string, number int, logins ...string) error {
+	for _, l := range logins {
+		if err := g.call(http.MethodDelete, fmt.Sprintf("changes/%d/reviewers/%s", number, url.PathEscape(l)), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsCollaborator counts the members of the trusted group as the
+// collaborators.
+func (g *gerritSCM) IsCollaborator(org, repo, login string) (bool, error) {
+	return g.IsMember(org, login)
+}
+
+func (g *gerritSCM) IsMember(org, login string) (bool, error) {
+	if g.trustedGroup == "" {
+		return false, nil
+	}
+	var member gerritAccount
+	err := g.call(http.MethodGet, fmt.Sprintf("groups/%s/members/%s", url.PathEscape(g.trustedGroup), url.PathEscape(login)), nil, &member)
+	return err == nil, nil
+}
+
+// CreateStatus posts the status as a review message of the revision, with
+// the Verified vote of the bot for the required jobs. Pending statuses are
+// not posted.
+func (g *gerritSCM) CreateStatus(org, repo, sha string, status *github.RepoStatus) error {
+	if status.GetState() == "pending" {
+		return nil
+	}
+	var changes []gerritChange
+	if err := g.call(http.MethodGet, "changes/?q="+url.QueryEscape("commit:"+sha), nil, &changes); err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("no change has commit %s", sha)
+	}
+	message := fmt.Sprintf("%s: %s %s", status.GetContext(), status.GetState(), status.GetDescription())
+	if status.GetTargetURL() != "" {
+		message += "\n" + status.GetTargetURL()
+	}
+	var labels map[string]int
+	if status.GetContext() == requiredJobsContext {
+		labels = map[string]int{"Verified": 1}
+		if status.GetState() != "success" {
+			labels["Verified"] = -1
+		}
+	}
+	return g.review(fmt.Sprintf("%d", changes[0].Number), sha, message, labels)
+}
+
+func (g *gerritSCM) GetCombinedStatus(org, repo, sha string) (*github.CombinedStatus, error) {
+	return nil, unsupported("Gerrit", "combined statuses")
+}
+
+// gerritChangeOptions are the options of the changes read as PRs.
+const gerritChangeOptions = "o=CURRENT_REVISION&o=CURRENT_COMMIT&o=DETAILED_ACCOUNTS&o=DETAILED_LABELS"
+
+func (g *gerritSCM) ListPullRequests(org, repo, base string) ([]*github.PullRequest, error) {
+	return g.queryChanges(fmt.Sprintf("project:%s branch:%s status:open", repo, base))
+}
+
+func (g *gerritSCM) ListPullRequestsWithCommit(org, repo, sha string) ([]*github.PullRequest, error) {
+	return g.queryChanges(fmt.Sprintf("project:%s commit:%s", repo, sha))
+}
+
+func (g *gerritSCM) queryChanges(query string) ([]*github.PullRequest, error) {
+	var changes []gerritChange
+	if err := g.call(http.MethodGet, "changes/?"+gerritChangeOptions+"&q="+url.QueryEscape(query), nil, &changes); err != nil {
+		return nil, err
+	}
+	var prs []*github.PullRequest
+	for _, c := range changes {
+		prs = append(prs, c.github(g.user))
+	}
+	return prs, nil
+}
+
+// ListCommits returns the commit of the current patch set, a change is a
+// single commit.
+func (g *gerritSCM) ListCommits(org, repo string, number int) ([]*github.RepositoryCommit, error) {
+	var c gerritChange
+	if err := g.call(http.MethodGet, fmt.Sprintf("changes/%d?%s", number, gerritChangeOptions), nil, &c); err != nil {
+		return nil, err
+	}
+	commit := c.Revisions[c.CurrentRevision].Commit
+	return []*github.RepositoryCommit{{
+		SHA: github.String(c.CurrentRevision),
+		Commit: &github.Commit{
+			SHA:     github.String(c.CurrentRevision),
+			Message: github.String(commit.Message),
+			Author:  &github.CommitAuthor{Name: github.String(commit.Author.Name), Email: github.String(commit.Author.Email)},
+		},
+		Author: c.Owner.github(),
+	}}, nil
+}
+
+func (g *gerritSCM) GetPatch(org, repo string, number int) (string, error) {
+	patch, err := g.getBase64(fmt.Sprintf("changes/%d/revisions/current/patch", number))
+	return string(patch), err
+}
+
+func (g *gerritSCM) CreatePullRequest(org, repo string, pr *github.NewPullRequest) (*github.PullRequest, error) {
+	return nil, unsupported("Gerrit", "opening changes")
+}
+
+// CreateReview posts the review as a review message, Gerrit comments lines
+// rather than diff positions.
+func (g *gerritSCM) CreateReview(org, repo string, number int, review *github.PullRequestReviewRequest) error {
+	return g.review(fmt.Sprintf("%d", number), "current", reviewMessage(review), nil)
+}
+
+// GetIssue returns the change as an issue, Gerrit has no issues.
+func (g *gerritSCM) GetIssue(org, repo string, number int) (*github.Issue, error) {
+	var c gerritChange
+	if err := g.call(http.MethodGet, fmt.Sprintf("changes/%d?%s", number, gerritChangeOptions), nil, &c); err != nil {
+		return nil, err
+	}
+	return c.issue(g.user), nil
+}
+
+// EditIssue abandons or restores the change. Its title is the subject of
+// its commit, which only its owner changes.
+func (g *gerritSCM) EditIssue(org, repo string, number int, edit *github.IssueRequest) error {
+	if edit.Title != nil {
+		return unsupported("Gerrit", "retitling changes")
+	}
+	if edit.Milestone != nil {
+		return unsupported("Gerrit", "milestones")
+	}
+	switch edit.GetState() {
+	case "closed":
+		return g.call(http.MethodPost, fmt.Sprintf("changes/%d/abandon", number), nil, nil)
+	case "open":
+		return g.call(http.MethodPost, fmt.Sprintf("changes/%d/restore", number), nil, nil)
+	}
+	return nil
+}
+
+func (g *gerritSCM) LockIssue(org, repo string, number int, reason string) error {
+	return unsupported("Gerrit", "locking conversations")
+}
+
+func (g *gerritSCM) UnlockIssue(org, repo string, number int) error {
+	return unsupported("Gerrit", "locking conversations")
+}
+
+// ListIssueEvents lists the Code-Review votes of the bot as the labels
+// they stand for.
+func (g *gerritSCM) ListIssueEvents(org, repo string, number int) ([]IssueEvent, error) {
+	var messages []gerritMessage
+	if err := g.call(http.MethodGet, fmt.Sprintf("changes/%d/messages", number), nil, &messages); err != nil {
+		return nil, err
+	}
+	var events []IssueEvent
+	for _, m := range messages {
+		if m.Author.Username != g.user {
+			continue
+		}
+		vote := m.vote()
+		for label, v := range gerritVotes {
+			if vote >= v {
+				events = append(events, IssueEvent{Event: "labeled", Actor: g.user, Label: label, CreatedAt: m.Date.Time})
+			}
+		}
+	}
+	return events, nil
+}
+
+func (g *gerritSCM) SearchIssues(query, sort string, limit int) ([]github.Issue, int, error) {
+	return nil, 0, unsupported("Gerrit", "searching issues")
+}
+
+func (g *gerritSCM) TransferIssue(org, repo string, number int, dest string) (int, error) {
+	return 0, unsupported("Gerrit", "transferring issues")
+}
+
+func (g *gerritSCM) CreateIssueReaction(org, repo string, number int, reaction string) error {
+	return unsupported("Gerrit", "reactions")
+}
+
+func (g *gerritSCM) CreateCommentReaction(org, repo string, id int64, reaction string) error {
+	return unsupported("Gerrit", "reactions")
+}
+
+// ListLabels returns the labels the votes of the bot stand for.
+func (g *gerritSCM) ListLabels(org, repo string) ([]*github.Label, error) {
+	var labels []*github.Label
+	for label := range gerritVotes {
+		labels = append(labels, &github.Label{Name: github.String(label)})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	return labels, nil
+}
+
+func (g *gerritSCM) ListIssueLabels(org, repo string, number int) ([]*github.Label, error) {
+	pr, err := g.GetPullRequest(org, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	return pr.Labels, nil
+}
+
+func (g *gerritSCM) ListMilestones(org, repo string) ([]*github.Milestone, error) {
+	return nil, unsupported("Gerrit", "milestones")
+}
+
+// IsTeamMember counts the members of the group named team as its members.
+func (g *gerritSCM) IsTeamMember(org, team, login string) (bool, error) {
+	err := g.call(http.MethodGet, fmt.Sprintf("groups/%s/members/%s", url.PathEscape(team), url.PathEscape(login)), nil, nil)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetPermissionLevel gives the members of the trusted group write access,
+// the others read access.
+func (g *gerritSCM) GetPermissionLevel(org, repo, login string) (string, error) {
+	member, err := g.IsMember(org, login)
+	if err != nil || !member {
+		return "read", err
+	}
+	return "write", nil
+}
+
+func (g *gerritSCM) GetRepo(org, repo string) (*github.Repository, error) {
+	if err := g.call(http.MethodGet, "projects/"+url.PathEscape(repo), nil, nil); err != nil {
+		return nil, err
+	}
+	var head string
+	if err := g.call(http.MethodGet, fmt.Sprintf("projects/%s/HEAD", url.PathEscape(repo)), nil, &head); err != nil {
+		return nil, err
+	}
+	r := gerritRepo(org, repo)
+	r.DefaultBranch = github.String(strings.TrimPrefix(head, "refs/heads/"))
+	return r, nil
+}
+
+// GetBranch counts every branch as protected, changes reach them through
+// review.
+func (g *gerritSCM) GetBranch(org, repo, branch string) (*github.Branch, error) {
+	var b struct {
+		Ref      string `json:"ref"`
+		Revision string `json:"revision"`
+	}
+	if err := g.call(http.MethodGet, fmt.Sprintf("projects/%s/branches/%s", url.PathEscape(repo), url.PathEscape(branch)), nil, &b); err != nil {
+		return nil, err
+	}
+	return &github.Branch{
+		Name:      github.String(strings.TrimPrefix(b.Ref, "refs/heads/")),
+		Commit:    &github.RepositoryCommit{SHA: github.String(b.Revision)},
+		Protected: github.Bool(true),
+	}, nil
+}
+
+func (g *gerritSCM) DeleteBranch(org, repo, branch string) error {
+	return g.call(http.MethodDelete, fmt.Sprintf("projects/%s/branches/%s", url.PathEscape(repo), url.PathEscape(branch)), nil, nil)
+}
+
+// GetRequiredStatusChecks returns none, Gerrit requires votes rather than
+// statuses.
+func (g *gerritSCM) GetRequiredStatusChecks(org, repo, branch string) ([]string, error) {
+	return nil, nil
+}
+
+// gerritSHAReg matches commit hashes, which refs are read at rather than
+// branches.
+var gerritSHAReg = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+func (g *gerritSCM) GetFile(org, repo, path, ref string) ([]byte, error) {
+	at := "branches/" + url.PathEscape(ref)
+	switch {
+	case ref == "":
+		at = "branches/HEAD"
+	case gerritSHAReg.MatchString(ref):
+		at = "commits/" + ref
+	}
+	return g.getBase64(fmt.Sprintf("projects/%s/%s/files/%s/content", url.PathEscape(repo), at, url.PathEscape(path)))
+}
+
+func (g *gerritSCM) ListTree(org, repo, sha string) ([]string, error) {
+	return nil, unsupported("Gerrit", "listing trees")
+}
+
+func (g *gerritSCM) GetTreeHash(org, repo, sha string) (string, error) {
+	return "", unsupported("Gerrit", "tree hashes")
+}
+
+func (g *gerritSCM) Blame(org, repo, sha, path string) ([]BlameRange, error) {
+	return nil, unsupported("Gerrit", "blame")
+}
+
+func (g *gerritSCM) ListProjects(org, repo string) ([]*github.Project, error) {
+	return nil, unsupported("Gerrit", "project boards")
+}
+
+func (g *gerritSCM) ListProjectColumns(project int64) ([]*github.ProjectColumn, error) {
+	return nil, unsupported("Gerrit", "project boards")
+}
+
+func (g *gerritSCM) ListProjectCards(column int64) ([]*github.ProjectCard, error) {
+	return nil, unsupported("Gerrit", "project boards")
+}
+
+func (g *gerritSCM) MoveProjectCard(card, column int64) error {
+	return unsupported("Gerrit", "project boards")
+}
+
+func (g *gerritSCM) CreateProjectCard(column, content int64, contentType string) error {
+	return unsupported("Gerrit", "project boards")
+}
+
+// getBase64 returns the decoded content Gerrit serves base64 encoded.
+func (g *gerritSCM) getBase64(path string) ([]byte, error) {
+	var encoded []byte
+	if err := g.call(http.MethodGet, path, nil, &encoded); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+}
+
+// review posts a review of a revision of a change, with a message and
+// votes.
+func (g *gerritSCM) review(change, revision, message string, labels map[string]int) error {
+	in := map[string]interface{}{}
+	if message != "" {
+		in["message"] = message
+	}
+	if len(labels) > 0 {
+		in["labels"] = labels
+	}
+	return g.call(http.MethodPost, fmt.Sprintf("changes/%s/revisions/%s/review", change, revision), in, nil)
+}
+
+// call sends a request to the Gerrit REST API and decodes its
+// response, or stores it as is in a *[]byte out.
+func (g *gerritSCM) call(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, g.api.String()+path, &body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(g.user, g.password)
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &apiError{method: method, path: path, status: resp.StatusCode, body: respBody}
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw = respBody
+		return nil
+	}
+	if out == nil {
+		return nil
+	}
+	// Gerrit prefixes JSON against XSSI.
+	return json.Unmarshal(bytes.TrimPrefix(respBody, []byte(")]}'")), out)
+}
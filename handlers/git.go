@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// gitRepo is a local clone of a GitHub repo, for the plugins that need to
+// commit and push.
+type gitRepo struct {
+	dir string
+}
+
+// cloneRepo clones branch of the org/repo fullName in a temporary
+// directory, authenticating with token. The caller must clean the clone up.
+func cloneRepo(token, fullName, branch, botName string) (*gitRepo, error) {
+	dir, err := ioutil.TempDir("", "ci-bot-git")
+	if err != nil {
+		return nil, err
+	}
+	r := &gitRepo{dir: dir}
+	url := fmt.Sprintf("https://x-access-token:%s@github.com/%s.git", token, fullName)
+	if _, err := r.git("clone", "--single-branch", "--branch", branch, url, "."); err != nil {
+		r.clean()
+		// The token is part of the URL, keep it out of the error.
+		return nil, fmt.Errorf("%s", strings.Replace(err.Error(), token, "<token>", -1))
+	}
+	for _, kv := range [][]string{{"user.name", botName}, {"user.email", botName + "@users.noreply.github.com"}} {
+		if _, err := r.git("config", kv[0], kv[1]); err != nil {
+			r.clean()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// git runs a git command in the clone and returns its combined output.
+func (r *gitRepo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git %s: %v: %s", args[0], err, out)
+	}
+	return string(out), nil
+}
+
+// am applies a patch in mailbox format on the current branch, aborting on
+// conflicts. The conflicting files are returned with the error.
+func (r *gitRepo) am(patch string) ([]string, error) {
+	f, err := ioutil.TempFile("", "ci-bot-patch")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(patch); err != nil {
+		f.Close()
+		return nil, err
+	}
+	f.Close()
+	if _, err := r.git("am", "--3way", f.Name()); err != nil {
+		out, _ := r.git("diff", "--name-only", "--diff-filter=U")
+		r.git("am", "--abort")
+		return strings.Fields(out), err
+	}
+	return nil, nil
+}
+
+func (r *gitRepo) clean() {
+	os.RemoveAll(r.dir)
+}
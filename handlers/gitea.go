@@ -0,0 +1,449 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// Gitea sends webhooks shaped like the ones of GitHub and serves a REST
+// API mostly following the one of GitHub, so the plugins run unchanged on
+// Gitea repos: webhooks are checked and dispatched like GitHub ones, and
+// the SCM of the org is the one of GitHub but for the few calls Gitea
+// serves differently, see giteaSCM.
+
+// giteaClients are the clients of the orgs on Gitea, by org.
+var giteaClients = struct {
+	sync.Mutex
+	m map[string]*github.Client
+}{m: map[string]*github.Client{}}
+
+func giteaClient(org string, p Provider) (*github.Client, error) {
+	giteaClients.Lock()
+	defer giteaClients.Unlock()
+	if client, ok := giteaClients.m[org]; ok {
+		return client, nil
+	}
+	base, err := url.Parse(strings.TrimSuffix(p.URL, "/") + "/api/v1/")
+	if err != nil {
+		return nil, err
+	}
+	client := github.NewClient(&http.Client{Transport: &giteaTransport{token: p.Token}})
+	client.BaseURL = base
+	registerSCM(client, &giteaSCM{githubSCM: githubSCM{client}, token: p.Token, base: base})
+	giteaClients.m[org] = client
+	return client, nil
+}
+
+// serveGiteaHook validates a webhook of a Gitea org and invokes its
+// handler.
+func (s *Server) serveGiteaHook(w http.ResponseWriter, r *http.Request) {
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		glog.Errorf("fail to read Gitea webhook: %v", err)
+		return
+	}
+	var event struct {
+		Action     string `json:"action"`
+		Repository struct {
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	org := event.Repository.Owner.Login
+	p, ok := s.Config.Providers[org]
+	if !ok || p.Type != ProviderGitea {
+		http.Error(w, "unknown org", http.StatusBadRequest)
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(p.WebhookSecret))
+	mac.Write(payload)
+	signature, err := hex.DecodeString(r.Header.Get("X-Gitea-Signature"))
+	if err != nil || p.WebhookSecret == "" || !hmac.Equal(signature, mac.Sum(nil)) {
+		glog.Errorf("Invalid Gitea payload for %s", org)
+		webhooksInvalidSignature.inc(ProviderGitea, r.Header.Get("X-Gitea-Event"))
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	client, err := giteaClient(org, p)
+	if err != nil {
+		glog.Errorf("fail to create Gitea client for %s: %v", org, err)
+		return
+	}
+	fmt.Fprint(w, "Received a webhook event")
+
+	// Gitea names a few actions differently.
+	if action, ok := giteaActions[event.Action]; ok {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(payload, &fields); err == nil {
+			fields["action"], _ = json.Marshal(action)
+			payload, _ = json.Marshal(fields)
+		}
+	}
+	eventType, delivery := r.Header.Get("X-Gitea-Event"), r.Header.Get("X-Gitea-Delivery")
+	dispatch := func(handle func([]byte, *github.Client)) {
+		s.handleAsync(eventType, delivery, payload, client, func(client *github.Client) { handle(payload, client) })
+	}
+	switch eventType {
+	case "issues":
+		dispatch(s.handleIssueEvent)
+	case "issue_comment":
+		dispatch(s.handleIssueCommentEvent)
+	case "pull_request":
+		dispatch(s.handlePullRequestEvent)
+	case "push":
+		dispatch(s.handlePushEvent)
+	}
+}
+
+var giteaActions = map[string]string{
+	"synchronized":  "synchronize",
+	"label_updated": "labeled",
+	"label_cleared": "unlabeled",
+}
+
+// giteaTransport authenticates the requests of a Gitea client.
+type giteaTransport struct {
+	token string
+}
+
+func (t *giteaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", "token "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// giteaSCM is the SCM of a Gitea org: the one of GitHub, but for the
+// calls Gitea serves differently, and those it has no equivalent of.
+type giteaSCM struct {
+	githubSCM
+	token string
+	base  *url.URL
+}
+
+// giteaLabel is a label as Gitea lists it, with the ID it's removed by.
+type giteaLabel struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func (g *giteaSCM) AddLabels(org, repo string, number int, labels ...string) error {
+	// GitHub takes a list of names, Gitea an object listing them.
+	return g.call(http.MethodPost, fmt.Sprintf("repos/%s/%s/issues/%d/labels", org, repo, number), map[string][]string{"labels": labels}, nil)
+}
+
+func (g *giteaSCM) RemoveLabel(org, repo string, number int, label string) error {
+	path := fmt.Sprintf("repos/%s/%s/issues/%d/labels", org, repo, number)
+	var labels []giteaLabel
+	if err := g.call(http.MethodGet, path, nil, &labels); err != nil {
+		return err
+	}
+	for _, l := range labels {
+		if l.Name == label {
+			return g.call(http.MethodDelete, fmt.Sprintf("%s/%d", path, l.ID), nil, nil)
+		}
+	}
+	return fmt.Errorf("%s/%s#%d has no label %s", org, repo, number, label)
+}
+
+func (g *giteaSCM) IsAssignee(org, repo, login string) (bool, error) {
+	// Gitea only lists the users issues can be assigned to.
+	var users []github.User
+	if err := g.call(http.MethodGet, fmt.Sprintf("repos/%s/%s/assignees", org, repo), nil, &users); err != nil {
+		return false, err
+	}
+	for _, u := range users {
+		if strings.EqualFold(u.GetLogin(), login) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (g *giteaSCM) AddAssignees(org, repo string, number int, logins ...string) error {
+	return g.editAssignees(org, repo, number, func(assignees []string) []string {
+		for _, l := range logins {
+			if !stringInSlice(l, assignees) {
+				assignees = append(assignees, l)
+			}
+		}
+		return assignees
+	})
+}
+
+func (g *giteaSCM) RemoveAssignees(org, repo string, number int, logins ...string) error {
+	return g.editAssignees(org, repo, number, func(assignees []string) []string {
+		kept := []string{}
+		for _, a := range assignees {
+			if !stringInSlice(a, logins) {
+				kept = append(kept, a)
+			}
+		}
+		return kept
+	})
+}
+
+// editAssignees sets the assignees of an issue to what edit makes of the
+// current ones, since Gitea sets them by editing the issue.
+func (g *giteaSCM) editAssignees(org, repo string, number int, edit func([]string) []string) error {
+	path := fmt.Sprintf("repos/%s/%s/issues/%d", org, repo, number)
+	var issue github.Issue
+	if err := g.call(http.MethodGet, path, nil, &issue); err != nil {
+		return err
+	}
+	assignees := []string{}
+	for _, a := range issue.Assignees {
+		assignees = append(assignees, a.GetLogin())
+	}
+	return g.call(http.MethodPatch, path, map[string][]string{"assignees": edit(assignees)}, nil)
+}
+
+// ListPullRequestsWithCommit returns the PR Gitea knows sha from, Gitea
+// looks up a single one.
+func (g *giteaSCM) ListPullRequestsWithCommit(org, repo, sha string) ([]*github.PullRequest, error) {
+	var pr github.PullRequest
+	err := g.call(http.MethodGet, fmt.Sprintf("repos/%s/%s/commits/%s/pull", org, repo, sha), nil, &pr)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []*github.PullRequest{&pr}, nil
+}
+
+func (g *giteaSCM) GetPatch(org, repo string, number int) (string, error) {
+	var patch []byte
+	err := g.call(http.MethodGet, fmt.Sprintf("repos/%s/%s/pulls/%d.patch", org, repo, number), nil, &patch)
+	return string(patch), err
+}
+
+func (g *giteaSCM) LockIssue(org, repo string, number int, reason string) error {
+	return unsupported("Gitea", "locking conversations")
+}
+
+func (g *giteaSCM) UnlockIssue(org, repo string, number int) error {
+	return unsupported("Gitea", "locking conversations")
+}
+
+// ListIssueEvents lists the label and review request events of the
+// timeline of an issue. Gitea tells additions from removals by the body of
+// the event.
+func (g *giteaSCM) ListIssueEvents(org, repo string, number int) ([]IssueEvent, error) {
+	var timeline []struct {
+		Type string `json:"type"`
+		Body string `json:"body"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Label struct {
+			Name string `json:"name"`
+		} `json:"label"`
+		Assignee struct {
+			Login string `json:"login"`
+		} `json:"assignee"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	if err := g.call(http.MethodGet, fmt.Sprintf("repos/%s/%s/issues/%d/timeline", org, repo, number), nil, &timeline); err != nil {
+		return nil, err
+	}
+	var events []IssueEvent
+	for _, t := range timeline {
+		e := IssueEvent{Actor: t.User.Login, CreatedAt: t.CreatedAt}
+		switch {
+		case t.Type == "label" && t.Body == "1":
+			e.Event, e.Label = "labeled", t.Label.Name
+		case t.Type == "label":
+			e.Event, e.Label = "unlabeled", t.Label.Name
+		case t.Type == "review_request" && t.Body != "false":
+			e.Event, e.RequestedReviewer = "review_requested", t.Assignee.Login
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (g *giteaSCM) SearchIssues(query, sort string, limit int) ([]github.Issue, int, error) {
+	return nil, 0, unsupported("Gitea", "searching issues")
+}
+
+func (g *giteaSCM) TransferIssue(org, repo string, number int, dest string) (int, error) {
+	return 0, unsupported("Gitea", "transferring issues")
+}
+
+// ListMilestones lists the milestones of repo. Gitea edits the milestone
+// of an issue by its ID, which is returned as the number.
+func (g *giteaSCM) ListMilestones(org, repo string) ([]*github.Milestone, error) {
+	var milestones []struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+	}
+	if err := g.call(http.MethodGet, fmt.Sprintf("repos/%s/%s/milestones?state=all&limit=100", org, repo), nil, &milestones); err != nil {
+		return nil, err
+	}
+	var list []*github.Milestone
+	for _, m := range milestones {
+		list = append(list, &github.Milestone{Number: github.Int(m.ID), Title: github.String(m.Title)})
+	}
+	return list, nil
+}
+
+// IsTeamMember looks the team up by name, Gitea teams have no slug.
+func (g *giteaSCM) IsTeamMember(org, team, login string) (bool, error) {
+	var teams []struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := g.call(http.MethodGet, fmt.Sprintf("orgs/%s/teams", org), nil, &teams); err != nil {
+		return false, err
+	}
+	for _, t := range teams {
+		if !strings.EqualFold(t.Name, team) {
+			continue
+		}
+		err := g.call(http.MethodGet, fmt.Sprintf("teams/%d/members/%s", t.ID, login), nil, nil)
+		if isNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, fmt.Errorf("there is no team %s in %s", team, org)
+}
+
+func (g *giteaSCM) DeleteBranch(org, repo, branch string) error {
+	return g.call(http.MethodDelete, fmt.Sprintf("repos/%s/%s/branches/%s", org, repo, pathEscape(branch)), nil, nil)
+}
+
+func (g *giteaSCM) GetRequiredStatusChecks(org, repo, branch string) ([]string, error) {
+	var protection struct {
+		EnableStatusCheck   bool     `json:"enable_status_check"`
+		StatusCheckContexts []string `json:"status_check_contexts"`
+	}
+	err := g.call(http.MethodGet, fmt.Sprintf("repos/%s/%s/branch_protections/%s", org, repo, pathEscape(branch)), nil, &protection)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil || !protection.EnableStatusCheck {
+		return nil, err
+	}
+	return protection.StatusCheckContexts, nil
+}
+
+// GetTreeHash reads the tree of the commit, which Gitea nests in it.
+func (g *giteaSCM) GetTreeHash(org, repo, sha string) (string, error) {
+	var commit struct {
+		Commit struct {
+			Tree struct {
+				SHA string `json:"sha"`
+			} `json:"tree"`
+		} `json:"commit"`
+	}
+	if err := g.call(http.MethodGet, fmt.Sprintf("repos/%s/%s/git/commits/%s", org, repo, sha), nil, &commit); err != nil {
+		return "", err
+	}
+	return commit.Commit.Tree.SHA, nil
+}
+
+func (g *giteaSCM) Blame(org, repo, sha, path string) ([]BlameRange, error) {
+	return nil, unsupported("Gitea", "blame")
+}
+
+func (g *giteaSCM) ListProjects(org, repo string) ([]*github.Project, error) {
+	return nil, unsupported("Gitea", "project boards")
+}
+
+func (g *giteaSCM) ListProjectColumns(project int64) ([]*github.ProjectColumn, error) {
+	return nil, unsupported("Gitea", "project boards")
+}
+
+func (g *giteaSCM) ListProjectCards(column int64) ([]*github.ProjectCard, error) {
+	return nil, unsupported("Gitea", "project boards")
+}
+
+func (g *giteaSCM) MoveProjectCard(card, column int64) error {
+	return unsupported("Gitea", "project boards")
+}
+
+func (g *giteaSCM) CreateProjectCard(column, content int64, contentType string) error {
+	return unsupported("Gitea", "project boards")
+}
+
+// call sends a request to the Gitea API and decodes its
+// response, or stores it as is in a *[]byte out.
+func (g *giteaSCM) call(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, g.base.String()+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+g.token)
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	req.Header.Set("Accept", ContentTypeJSON)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &apiError{method: method, path: path, status: resp.StatusCode, body: respBody}
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw = respBody
+		return nil
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = append([]string{}, v...)
+	}
+	return clone
+}
+
+func emptyResponse(req *http.Request, code int) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}
+}
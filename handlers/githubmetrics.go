@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// The rate limits a request to the GitHub API can be refused by.
+const (
+	rateLimitPrimary   = "primary"
+	rateLimitSecondary = "secondary"
+)
+
+var (
+	githubRequests           = newCounterVec("ci_bot_github_requests_total", "Requests to the GitHub API by plugin or periodic task, and status code.", "plugin", "code")
+	githubThrottled          = newCounterVec("ci_bot_github_throttled_total", "Requests to the GitHub API refused by the primary or secondary rate limit, by plugin.", "plugin", "limit")
+	githubRateLimit          = newGaugeVec("ci_bot_github_rate_limit", "Requests the token can make per rate limit window, by resource.", "resource")
+	githubRateLimitRemaining = newGaugeVec("ci_bot_github_rate_limit_remaining", "Requests left to the token in the current rate limit window, by resource.", "resource")
+	githubRateLimitReset     = newGaugeVec("ci_bot_github_rate_limit_reset_timestamp_seconds", "Time the current rate limit window ends at, by resource.", "resource")
+)
+
+// githubTransport records the requests of the GitHub client of the bot, on
+// behalf of plugin, and the rate limit of its token. The requests are
+// traced within span, and their failures reported along event, if any.
+type githubTransport struct {
+	base   http.RoundTripper
+	plugin string
+	span   *span
+	event  *webhookContext
+}
+
+func (t *githubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sp := t.span.child("GitHub "+req.Method, spanKindClient)
+	defer sp.finish()
+	if sp != nil {
+		sp.setAttribute("http.method", req.Method)
+		sp.setAttribute("http.url", req.URL.String())
+		req = req.Clone(req.Context())
+		req.Header.Set("traceparent", sp.traceparent())
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		githubRequests.inc(t.plugin, "error")
+		sp.setError(err.Error())
+		reportError(fmt.Sprintf("GitHub request %s %s failed: %v", req.Method, req.URL.Path, err), t.tags())
+		return nil, err
+	}
+	githubRequests.inc(t.plugin, strconv.Itoa(resp.StatusCode))
+	sp.setAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		sp.setError(resp.Status)
+	}
+	if resp.StatusCode >= 500 {
+		reportError(fmt.Sprintf("GitHub request %s %s failed: %s", req.Method, req.URL.Path, resp.Status), t.tags())
+	}
+	resource := resp.Header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = "core"
+		if strings.HasPrefix(req.URL.Path, "/search/") {
+			resource = "search"
+		}
+	}
+	if v, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Limit"), 64); err == nil {
+		githubRateLimit.set(v, resource)
+	}
+	if v, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64); err == nil {
+		githubRateLimitRemaining.set(v, resource)
+	}
+	if v, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset"), 64); err == nil {
+		githubRateLimitReset.set(v, resource)
+	}
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
+		if limit := throttledBy(resp); limit != "" {
+			githubThrottled.inc(t.plugin, limit)
+		}
+	}
+	return resp, nil
+}
+
+func (t *githubTransport) tags() map[string]string {
+	tags := t.event.tags()
+	if t.plugin != "" {
+		tags["plugin"] = t.plugin
+	}
+	return tags
+}
+
+// throttledBy returns the rate limit that refused a 403 or 429 response,
+// if any. The body is read and put back for the client.
+func throttledBy(resp *http.Response) string {
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return rateLimitPrimary
+	}
+	if resp.Header.Get("Retry-After") != "" {
+		return rateLimitSecondary
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err == nil && bytes.Contains(bytes.ToLower(body), []byte("secondary rate limit")) {
+		return rateLimitSecondary
+	}
+	return ""
+}
+
+// githubClients are the GitHub clients created by newGitHubClient and
+// derived from them, with their transport, and the clients of the plugins.
+var githubClients = struct {
+	sync.Mutex
+	transports map[*github.Client]*githubTransport
+	plugins    map[pluginClientKey]*github.Client
+}{transports: map[*github.Client]*githubTransport{}, plugins: map[pluginClientKey]*github.Client{}}
+
+type pluginClientKey struct {
+	client *github.Client
+	plugin string
+}
+
+// newGitHubClient returns a GitHub client sending its requests through
+// transport, whose use of the API is recorded in the GitHub metrics.
+func newGitHubClient(transport http.RoundTripper) *github.Client {
+	githubClients.Lock()
+	defer githubClients.Unlock()
+	return deriveGitHubClient(nil, &githubTransport{base: transport})
+}
+
+// deriveGitHubClient returns a client like parent sending its requests
+// through t. githubClients must be locked.
+func deriveGitHubClient(parent *github.Client, t *githubTransport) *github.Client {
+	client := github.NewClient(&http.Client{Transport: t})
+	if parent != nil {
+		client.BaseURL, client.UploadURL, client.UserAgent = parent.BaseURL, parent.UploadURL, parent.UserAgent
+	}
+	githubClients.transports[client] = t
+	return client
+}
+
+// tracedClient returns the client plugin makes its requests with, for them
+// to be recorded as the plugin's, and traced within sp if any. Traced
+// clients and the clients of webhooks are per operation, and must be
+// released once it is done. Clients not created by newGitHubClient, e.g. of
+// other providers, are returned as is.
+func tracedClient(client *github.Client, plugin string, sp *span) *github.Client {
+	githubClients.Lock()
+	defer githubClients.Unlock()
+	t, ok := githubClients.transports[client]
+	if !ok {
+		return client
+	}
+	if sp != nil || t.event != nil {
+		return deriveGitHubClient(client, &githubTransport{base: t.base, plugin: plugin, span: sp, event: t.event})
+	}
+	key := pluginClientKey{client, plugin}
+	c, ok := githubClients.plugins[key]
+	if !ok {
+		c = deriveGitHubClient(client, &githubTransport{base: t.base, plugin: plugin})
+		githubClients.plugins[key] = c
+	}
+	return c
+}
+
+// webhookClient returns the client the handlers of a webhook make their
+// requests with, traced within sp if any. It must be released once the
+// webhook is handled.
+func webhookClient(client *github.Client, event *webhookContext, sp *span) *github.Client {
+	githubClients.Lock()
+	defer githubClients.Unlock()
+	t, ok := githubClients.transports[client]
+	if !ok {
+		return client
+	}
+	return deriveGitHubClient(client, &githubTransport{base: t.base, span: sp, event: event})
+}
+
+// clientScope returns the span the requests of client are traced within
+// and the webhook they are made for, if any.
+func clientScope(client *github.Client) (*span, *webhookContext) {
+	githubClients.Lock()
+	defer githubClients.Unlock()
+	if t, ok := githubClients.transports[client]; ok {
+		return t.span, t.event
+	}
+	return nil, nil
+}
+
+// clientTags returns the tags of the errors of the requests of client, the
+// webhook and plugin they are made for.
+func clientTags(client *github.Client) map[string]string {
+	githubClients.Lock()
+	defer githubClients.Unlock()
+	if t, ok := githubClients.transports[client]; ok {
+		return t.tags()
+	}
+	return map[string]string{}
+}
+
+// releaseClient forgets a client of an operation.
+func releaseClient(client *github.Client) {
+	githubClients.Lock()
+	defer githubClients.Unlock()
+	if t, ok := githubClients.transports[client]; ok && (t.span != nil || t.event != nil) {
+		delete(githubClients.transports, client)
+	}
+}
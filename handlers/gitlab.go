@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const (
+	defaultGitLabURL     = "https://gitlab.com"
+	defaultGitLabContext = "ci/gitlab"
+	gitLabInterval       = time.Minute
+	// gitLabMaxAge is how long a pipeline is polled before giving up.
+	gitLabMaxAge = 24 * time.Hour
+)
+
+// GitLabCI is the config of the pipelines run on GitLab CI for the PRs of
+// repos mirrored to GitLab.
+type GitLabCI struct {
+	// URL of the GitLab instance, https://gitlab.com by default.
+	URL string `json:"url,omitempty"`
+	// Token is an API token able to read the pipelines of the projects.
+	Token string `json:"token,omitempty"`
+	// Projects maps an org/repo to its mirror on GitLab.
+	Projects map[string]GitLabProject `json:"projects,omitempty"`
+}
+
+// GitLabProject is the mirror of a repo on GitLab.
+type GitLabProject struct {
+	// Project is the path of the mirror, e.g. group/repo.
+	Project string `json:"project"`
+	// TriggerToken is a pipeline trigger token of the project.
+	TriggerToken string `json:"trigger_token"`
+	// Context of the commit status mirroring the pipeline, ci/gitlab by
+	// default.
+	Context string `json:"context,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "gitlab-ci",
+		Description: "Triggers GitLab CI pipelines on the GitLab mirror of a repo when PRs of trusted authors are opened or pushed to, passing the PR as pipeline variables, and mirrors the status of each pipeline to the PR as a commit status.",
+		ConfigKey:   "gitlab_ci",
+		Commands: []PluginCommand{{
+			Usage:       "/test [all|gitlab]",
+			Description: "Triggers a GitLab CI pipeline for the PR.",
+			WhoCanUse:   trustedUsers,
+			Example:     "/test gitlab",
+		}, {
+			Usage:       "/retest",
+			Description: "Triggers a GitLab CI pipeline for the PR again if the last one failed.",
+			WhoCanUse:   trustedUsers,
+			Example:     "/retest",
+		}},
+	}, func(c *Config) []string {
+		var enabled []string
+		for k := range c.GitLabCI.Projects {
+			enabled = append(enabled, k)
+		}
+		return enabled
+	})
+	registerPeriodic("gitlab-ci", gitLabInterval, func(c *Config) bool {
+		return len(c.GitLabCI.Projects) > 0
+	}, (*Server).processGitLabPipelines)
+}
+
+func (c *Config) validateGitLabCI() error {
+	for repo, p := range c.GitLabCI.Projects {
+		if !strings.Contains(repo, "/") {
+			return fmt.Errorf("gitlab_ci: %q is not an org/repo", repo)
+		}
+		if p.Project == "" || p.TriggerToken == "" {
+			return fmt.Errorf("gitlab_ci: %s needs a project and a trigger_token", repo)
+		}
+	}
+	return nil
+}
+
+func (p GitLabProject) context() string {
+	if p.Context != "" {
+		return p.Context
+	}
+	return defaultGitLabContext
+}
+
+// gitLabProjectFor returns the GitLab mirror of repo, if any.
+func (c GitLabCI) gitLabProjectFor(repo *github.Repository) (GitLabProject, bool) {
+	p, ok := c.Projects[repo.GetFullName()]
+	return p, ok
+}
+
+// gitLabPipeline is a pipeline triggered for a PR, polled until it's done.
+type gitLabPipeline struct {
+	ID      int                `json:"id"`
+	Project GitLabProject      `json:"project"`
+	Repo    *github.Repository `json:"repo"`
+	SHA     string             `json:"sha"`
+	Created time.Time          `json:"created"`
+	// Status is the last status reported.
+	Status string `json:"status"`
+}
+
+// gitLabPipelines are the pipelines being polled, by project and ID. With
+// leader election, they are those the replicas share, see modifyState.
+var gitLabPipelines = struct {
+	sync.Mutex
+	m map[string]*gitLabPipeline
+}{m: map[string]*gitLabPipeline{}}
+
+// withGitLabPipelines runs modify on the pipelines being polled.
+func (s *Server) withGitLabPipelines(modify func() bool) {
+	gitLabPipelines.Lock()
+	defer gitLabPipelines.Unlock()
+	if err := s.modifyState("gitlab", &gitLabPipelines.m, modify); err != nil {
+		glog.Errorf("fail to update the shared GitLab pipelines: %v", err)
+	}
+}
+
+// gitLabAPIURL is the API URL of a project path.
+func (s *Server) gitLabAPIURL(project, path string) string {
+	base := s.Config.GitLabCI.URL
+	if base == "" {
+		base = defaultGitLabURL
+	}
+	return fmt.Sprintf("%s/api/v4/projects/%s%s", strings.TrimSuffix(base, "/"), url.PathEscape(project), path)
+}
+
+// isGitLabTest reports whether a /test comment asks for the GitLab
+// pipeline of project.
+func isGitLabTest(comment string, project GitLabProject) bool {
+	for _, m := range testReg.FindAllStringSubmatch(comment, -1) {
+		switch strings.ToLower(m[1]) {
+		case "", "all", "gitlab", strings.ToLower(project.context()):
+			return true
+		}
+	}
+	return false
+}
+
+// triggerGitLabComment triggers the GitLab pipeline of a PR a /test or
+// /retest comment asks for.
+func (s *Server) triggerGitLabComment(client *github.Client, repo *github.Repository, pr *github.PullRequest, comment string) {
+	project, ok := s.Config.GitLabCI.gitLabProjectFor(repo)
+	if !ok {
+		return
+	}
+	run := isGitLabTest(comment, project)
+	if !run && retestReg.MatchString(comment) {
+		failed, err := failedContexts(client, repo, pr.GetHead().GetSHA())
+		if err != nil {
+			logError(client, "fail to get statuses of %s@%s: %v", repo.GetFullName(), pr.GetHead().GetSHA(), err)
+			return
+		}
+		run = failed[project.context()]
+	}
+	if run {
+		s.triggerGitLab(client, repo, pr, project)
+	}
+}
+
+// triggerGitLab triggers a pipeline on the base branch of the mirror of
+// repo, with the PR to test as variables.
+func (s *Server) triggerGitLab(client *github.Client, repo *github.Repository, pr *github.PullRequest, project GitLabProject) {
+	sha := pr.GetHead().GetSHA()
+	form := url.Values{
+		"token":                    {project.TriggerToken},
+		"ref":                      {pr.GetBase().GetRef()},
+		"variables[REPO_OWNER]":    {repo.GetOwner().GetLogin()},
+		"variables[REPO_NAME]":     {repo.GetName()},
+		"variables[PULL_NUMBER]":   {strconv.Itoa(pr.GetNumber())},
+		"variables[PULL_BASE_REF]": {pr.GetBase().GetRef()},
+		"variables[PULL_BASE_SHA]": {pr.GetBase().GetSHA()},
+		"variables[PULL_HEAD_REF]": {pr.GetHead().GetRef()},
+		"variables[PULL_PULL_SHA]": {sha},
+	}
+	var created struct {
+		ID     int    `json:"id"`
+		WebURL string `json:"web_url"`
+	}
+	resp, err := http.PostForm(s.gitLabAPIURL(project.Project, "/trigger/pipeline"), form)
+	if err == nil {
+		err = decodeGitLabResponse(resp, &created)
+	}
+	if err != nil {
+		logError(client, "fail to trigger GitLab pipeline of %s for %s#%d: %v", project.Project, repo.GetFullName(), pr.GetNumber(), err)
+		createStatus(client, repo, sha, project.context(), "error", "Failed to trigger the pipeline", "")
+		return
+	}
+	createStatus(client, repo, sha, project.context(), "pending", "Pipeline created", created.WebURL)
+
+	// The trigger token isn't needed to poll, and isn't kept in the
+	// shared state.
+	project.TriggerToken = ""
+	p := gitLabPipeline{
+		ID:      created.ID,
+		Project: project,
+		Repo:    stateRepo(repo),
+		SHA:     sha,
+		Created: time.Now(),
+		Status:  "created",
+	}
+	s.withGitLabPipelines(func() bool {
+		added := p
+		gitLabPipelines.m[fmt.Sprintf("%s#%d", project.Project, created.ID)] = &added
+		return true
+	})
+}
+
+// processGitLabPipelines mirrors the status of the pipelines being polled,
+// forgetting those that are done.
+func (s *Server) processGitLabPipelines(client *github.Client) {
+	var pipelines map[string]gitLabPipeline
+	s.withGitLabPipelines(func() bool {
+		pipelines = make(map[string]gitLabPipeline, len(gitLabPipelines.m))
+		for k, p := range gitLabPipelines.m {
+			pipelines[k] = *p
+		}
+		return false
+	})
+
+	for k, p := range pipelines {
+		status := p.Status
+		done := s.reportGitLabPipeline(client, &p)
+		if !done && time.Since(p.Created) > gitLabMaxAge {
+			createStatus(client, p.Repo, p.SHA, p.Project.context(), "error", "Timed out", "")
+			done = true
+		}
+		if !done && p.Status == status {
+			continue
+		}
+		s.withGitLabPipelines(func() bool {
+			if _, ok := gitLabPipelines.m[k]; !ok {
+				return false
+			}
+			if done {
+				delete(gitLabPipelines.m, k)
+			} else {
+				updated := p
+				gitLabPipelines.m[k] = &updated
+			}
+			return true
+		})
+	}
+}
+
+// gitLabState maps the status of a pipeline to the state of a commit
+// status, telling whether the pipeline is done.
+func gitLabState(status string) (string, bool) {
+	switch status {
+	case "success":
+		return "success", true
+	case "failed":
+		return "failure", true
+	case "canceled", "skipped":
+		return "error", true
+	default:
+		// created, waiting_for_resource, preparing, pending, running,
+		// manual and scheduled pipelines may still run.
+		return "pending", false
+	}
+}
+
+// reportGitLabPipeline mirrors the status of a pipeline, reporting
+// whether it's done.
+func (s *Server) reportGitLabPipeline(client *github.Client, p *gitLabPipeline) bool {
+	req, err := http.NewRequest(http.MethodGet, s.gitLabAPIURL(p.Project.Project, fmt.Sprintf("/pipelines/%d", p.ID)), nil)
+	if err != nil {
+		logError(client, "fail to create request: %v", err)
+		return false
+	}
+	if s.Config.GitLabCI.Token != "" {
+		req.Header.Set("PRIVATE-TOKEN", s.Config.GitLabCI.Token)
+	}
+	var pipeline struct {
+		Status string `json:"status"`
+		WebURL string `json:"web_url"`
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err == nil {
+		err = decodeGitLabResponse(resp, &pipeline)
+	}
+	if err != nil {
+		logError(client, "fail to get GitLab pipeline %d of %s: %v", p.ID, p.Project.Project, err)
+		return false
+	}
+	state, done := gitLabState(pipeline.Status)
+	if pipeline.Status == p.Status {
+		return done
+	}
+	p.Status = pipeline.Status
+	createStatus(client, p.Repo, p.SHA, p.Project.context(), state, "Pipeline "+strings.Replace(pipeline.Status, "_", " ", -1), pipeline.WebURL)
+	return done
+}
+
+// decodeGitLabResponse decodes the JSON body of a successful response of
+// the GitLab API into out.
+func decodeGitLabResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", resp.Status, b)
+	}
+	return json.Unmarshal(b, out)
+}
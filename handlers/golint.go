@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	defaultMinimumConfidence = 0.8
+	// maxLintComments caps the inline comments of a single lint review.
+	maxLintComments = 20
+)
+
+var (
+	hunkReg = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+	// golint prints problems as "path:line:column: text".
+	lintProblemReg = regexp.MustCompile(`^(.+\.go):(\d+):\d+: (.*)$`)
+)
+
+type lintProblem struct {
+	file string
+	line int
+	text string
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "golint",
+		Events:      []string{"issue_comment"},
+		Description: "The golint plugin runs golint on changes made to *.go files in a PR. It then creates a new review on the pull request and leaves golint warnings at the appropriate lines of code.",
+		ConfigKey:   "golint",
+		Commands: []PluginCommand{{
+			Usage:       "/lint",
+			Description: "Runs golint on changes made to *.go files in a PR",
+			WhoCanUse:   "Anyone can trigger this command on a PR.",
+			Example:     "/lint",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleLint runs golint over the Go files changed by a PR and reviews the
+// problems found on lines the PR adds.
+func (s *Server) handleLint(client *github.Client, ic *github.IssueCommentEvent) {
+	scm := scmFor(client)
+	owner := ic.Repo.GetOwner().GetLogin()
+	repo := ic.Repo.GetName()
+	number := ic.GetIssue().GetNumber()
+
+	pr, err := scm.GetPullRequest(owner, repo, number)
+	if err != nil {
+		logError(client, "fail to get PR %d: %v", number, err)
+		return
+	}
+	files, err := listPullRequestFiles(client, owner, repo, number)
+	if err != nil {
+		logError(client, "fail to list files of PR %d: %v", number, err)
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "golint")
+	if err != nil {
+		logError(client, "fail to create temp dir: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// positions maps a file to the diff positions of its added lines.
+	positions := map[string]map[int]int{}
+	// packages groups the fetched files by directory since golint lints a
+	// single package at a time.
+	packages := map[string][]string{}
+	head := pr.GetHead()
+	for _, f := range files {
+		name := f.GetFilename()
+		if f.GetStatus() == "removed" || !strings.HasSuffix(name, ".go") || strings.HasPrefix(name, "vendor/") {
+			continue
+		}
+		content, err := scm.GetFile(head.GetRepo().GetOwner().GetLogin(), head.GetRepo().GetName(), name, head.GetSHA())
+		if err != nil {
+			logError(client, "fail to get %s: %v", name, err)
+			continue
+		}
+		local := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			logError(client, "fail to create dir for %s: %v", name, err)
+			continue
+		}
+		if err := ioutil.WriteFile(local, content, 0644); err != nil {
+			logError(client, "fail to write %s: %v", name, err)
+			continue
+		}
+		positions[name] = addedLines(f.GetPatch())
+		packages[filepath.Dir(name)] = append(packages[filepath.Dir(name)], name)
+	}
+	if len(packages) == 0 {
+		return
+	}
+
+	confidence := defaultMinimumConfidence
+	if s.Config.Golint.MinimumConfidence != nil {
+		confidence = *s.Config.Golint.MinimumConfidence
+	}
+	var problems []lintProblem
+	for _, names := range packages {
+		args := []string{"-min_confidence", strconv.FormatFloat(confidence, 'f', -1, 64)}
+		cmd := exec.Command("golint", append(args, names...)...)
+		cmd.Dir = dir
+		// golint exits non-zero only when it can't lint, problems go to stdout.
+		out, err := cmd.Output()
+		if err != nil {
+			logError(client, "fail to run golint on %v: %v", names, err)
+			continue
+		}
+		problems = append(problems, parseLintProblems(string(out))...)
+	}
+
+	var comments []*github.DraftReviewComment
+	for _, p := range problems {
+		pos, ok := positions[p.file][p.line]
+		if !ok {
+			continue
+		}
+		comments = append(comments, &github.DraftReviewComment{
+			Path:     github.String(p.file),
+			Position: github.Int(pos),
+			Body:     github.String(fmt.Sprintf("Golint %s", p.text)),
+		})
+	}
+	body := "No lint warnings found."
+	if len(comments) > maxLintComments {
+		body = fmt.Sprintf("%d warnings. Showing the first %d.", len(comments), maxLintComments)
+		comments = comments[:maxLintComments]
+	} else if len(comments) > 0 {
+		body = fmt.Sprintf("%d warning(s).", len(comments))
+	}
+
+	err = scm.CreateReview(owner, repo, number, &github.PullRequestReviewRequest{
+		CommitID: github.String(head.GetSHA()),
+		Body:     github.String(body),
+		Event:    github.String("COMMENT"),
+		Comments: comments,
+	})
+	if err != nil {
+		logError(client, "fail to create lint review on PR %d: %v", number, err)
+	}
+}
+
+func parseLintProblems(out string) []lintProblem {
+	var problems []lintProblem
+	for _, l := range strings.Split(out, "\n") {
+		m := lintProblemReg.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		line, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+		problems = append(problems, lintProblem{file: filepath.ToSlash(m[1]), line: line, text: m[3]})
+	}
+	return problems
+}
+
+// addedLines maps the lines added by a unified diff patch to their position
+// in the diff, which is what review comments are anchored on.
+func addedLines(patch string) map[int]int {
+	lines := map[int]int{}
+	line := 0
+	for pos, l := range strings.Split(patch, "\n") {
+		if m := hunkReg.FindStringSubmatch(l); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(l, "+"):
+			lines[line] = pos
+			line++
+		case strings.HasPrefix(l, "-"):
+		default:
+			line++
+		}
+	}
+	return lines
+}
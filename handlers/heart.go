@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+
+	"github.com/google/go-github/github"
+)
+
+// heartReactions are the emojis the bot picks from when adding a reaction.
+var heartReactions = []string{"+1", "laugh", "heart", "hooray"}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "heart",
+		Events:      []string{"issue_comment", "pull_request"},
+		Description: "The heart plugin celebrates certain GitHub actions with the reaction emojis. Emojis are added to pull requests that make deletions and to comments left by the configured adorees.",
+		ConfigKey:   "heart",
+	}, func(c *Config) []string {
+		if len(c.Heart.Adorees) == 0 {
+			return nil
+		}
+		return []string{"*"}
+	})
+}
+
+func (c *Config) validateHeart() error {
+	if c.Heart.CommentRegexp == "" {
+		return nil
+	}
+	re, err := regexp.Compile(c.Heart.CommentRegexp)
+	if err != nil {
+		return fmt.Errorf("heart: invalid comment_regexp %q: %v", c.Heart.CommentRegexp, err)
+	}
+	c.Heart.commentRe = re
+	return nil
+}
+
+// handleHeart reacts to comments made by one of the configured adorees.
+func (s *Server) handleHeart(client *github.Client, ic *github.IssueCommentEvent) {
+	login := ic.GetComment().GetUser().GetLogin()
+	if !stringInSlice(login, s.Config.Heart.Adorees) {
+		return
+	}
+
+	if re := s.Config.Heart.commentRe; re != nil && !re.MatchString(ic.GetComment().GetBody()) {
+		return
+	}
+
+	reaction := heartReactions[rand.Intn(len(heartReactions))]
+	err := scmFor(client).CreateCommentReaction(ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName(), ic.GetComment().GetID(), reaction)
+	if err != nil {
+		logError(client, "fail to react to comment %d: %v", ic.GetComment().GetID(), err)
+	}
+}
+
+// handlePRHeart shows some love for newly opened PRs that delete more code
+// than they add.
+func (s *Server) handlePRHeart(client *github.Client, pe *github.PullRequestEvent) {
+	if len(s.Config.Heart.Adorees) == 0 {
+		return
+	}
+	pr := pe.GetPullRequest()
+	if pr.GetDeletions() <= pr.GetAdditions() {
+		return
+	}
+
+	err := scmFor(client).CreateIssueReaction(pe.Repo.GetOwner().GetLogin(), pe.Repo.GetName(), pr.GetNumber(), "heart")
+	if err != nil {
+		logError(client, "fail to react to PR %d: %v", pr.GetNumber(), err)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/google/go-github/github"
+)
+
+// Help is the config for the help plugin.
+type Help struct {
+	// HelpGuidelinesURL is the URL of the help page, which provides guidance
+	// on how and when to use the help wanted and good first issue labels.
+	HelpGuidelinesURL string `json:"help_guidelines_url,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "help",
+		Events:      []string{"issue_comment"},
+		Description: "The help plugin provides commands that add or remove the '" + helpWantedLabel + "' and the '" + goodFirstIssueLabel + "' labels from issues.",
+		ConfigKey:   "help",
+		Commands: []PluginCommand{{
+			Usage:       "/[remove-](help|good-first-issue)",
+			Description: "Applies or removes the '" + helpWantedLabel + "' and '" + goodFirstIssueLabel + "' labels to an issue. A good first issue always also wants help, so /remove-help removes both.",
+			WhoCanUse:   "Anyone can trigger this command on an issue.",
+			Example:     "/help\n/good-first-issue\n/remove-help",
+		}},
+	}, enabledEverywhere)
+}
+
+// helpMessage is the comment explaining the label just applied.
+func (s *Server) helpMessage(login, what, removeCommand string) string {
+	msg := fmt.Sprintf("@%s:\nThis request has been marked as %s.\n\n", login, what)
+	if s.Config.Help.HelpGuidelinesURL != "" {
+		msg += fmt.Sprintf("Please ensure the request meets the requirements listed [here](%s).\n\n", s.Config.Help.HelpGuidelinesURL)
+	}
+	return msg + fmt.Sprintf("If this request no longer meets these requirements, the label can be removed\nby commenting with the `%s` command.", removeCommand)
+}
+
+// handleHelp adds or removes the help wanted and good first issue labels.
+func (s *Server) handleHelp(client *github.Client, ic *github.IssueCommentEvent) {
+	if ic.GetIssue().IsPullRequest() {
+		return
+	}
+	body := ic.GetComment().GetBody()
+	number := ic.GetIssue().GetNumber()
+	labels := issueLabels(ic.GetIssue())
+	hasHelp := hasLabel(labels, helpWantedLabel)
+	hasGoodFirstIssue := hasLabel(labels, goodFirstIssueLabel)
+
+	switch {
+	case helpRemoveReg.MatchString(body):
+		if hasHelp {
+			removeLabel(client, ic.Repo, number, helpWantedLabel)
+		}
+		if hasGoodFirstIssue {
+			removeLabel(client, ic.Repo, number, goodFirstIssueLabel)
+		}
+	case goodFirstIssueRemoveReg.MatchString(body):
+		if hasGoodFirstIssue {
+			removeLabel(client, ic.Repo, number, goodFirstIssueLabel)
+		}
+	case goodFirstIssueReg.MatchString(body):
+		if hasGoodFirstIssue {
+			return
+		}
+		if !hasHelp {
+			addLabel(client, ic.Repo, number, helpWantedLabel)
+		}
+		if addLabel(client, ic.Repo, number, goodFirstIssueLabel) == nil {
+			createComment(client, ic.Repo, number, s.helpMessage(ic.GetComment().GetUser().GetLogin(), "suitable for new contributors", "/remove-good-first-issue"))
+		}
+	case helpReg.MatchString(body):
+		if hasHelp {
+			return
+		}
+		if addLabel(client, ic.Repo, number, helpWantedLabel) == nil {
+			createComment(client, ic.Repo, number, s.helpMessage(ic.GetComment().GetUser().GetLogin(), "needing help from a contributor", "/remove-help"))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// WebhookSecrets returns the secrets webhooks may be signed with, the one
+// hooks are created with first: the secrets of WebhookSecretFile, one per
+// line, then WebhookSecret. The file is read every time, so that rotating
+// the secrets needs no restart.
+func (c *Config) WebhookSecrets() ([]string, error) {
+	var secrets []string
+	if c.WebhookSecretFile != "" {
+		b, err := ioutil.ReadFile(c.WebhookSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				secrets = append(secrets, line)
+			}
+		}
+	}
+	if c.WebhookSecret != "" {
+		secrets = append(secrets, c.WebhookSecret)
+	}
+	return secrets, nil
+}
+
+// validateWebhook returns the payload of a webhook signed with any of the
+// secrets, so that deliveries signed with the old and the new secret are
+// both accepted while it's rotated.
+func (s *Server) validateWebhook(r *http.Request) ([]byte, error) {
+	secrets, err := s.Config.WebhookSecrets()
+	if err != nil {
+		return nil, fmt.Errorf("fail to read webhook secrets: %v", err)
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = fmt.Errorf("no webhook secret")
+	for _, secret := range secrets {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		var payload []byte
+		if payload, err = github.ValidatePayload(r, []byte(secret)); err == nil {
+			return payload, nil
+		}
+	}
+	return nil, err
+}
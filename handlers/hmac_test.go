@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidateWebhook(t *testing.T) {
+	f, err := ioutil.TempFile("", "webhook-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("new\n\n  old  \n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	const body = `{"zen":"Keep it logically awesome."}`
+	tests := []struct {
+		name        string
+		config      Config
+		signature   string
+		contentType string
+		valid       bool
+	}{
+		{
+			name:      "secret",
+			config:    Config{WebhookSecret: "secret"},
+			signature: sign("secret", body),
+			valid:     true,
+		},
+		{
+			name:      "first secret of the file",
+			config:    Config{WebhookSecret: "secret", WebhookSecretFile: f.Name()},
+			signature: sign("new", body),
+			valid:     true,
+		},
+		{
+			name:      "rotated secret of the file",
+			config:    Config{WebhookSecret: "secret", WebhookSecretFile: f.Name()},
+			signature: sign("old", body),
+			valid:     true,
+		},
+		{
+			name:      "secret along the file",
+			config:    Config{WebhookSecret: "secret", WebhookSecretFile: f.Name()},
+			signature: sign("secret", body),
+			valid:     true,
+		},
+		{
+			name:      "wrong secret",
+			config:    Config{WebhookSecret: "secret"},
+			signature: sign("other", body),
+		},
+		{
+			name:      "tampered payload",
+			config:    Config{WebhookSecret: "secret"},
+			signature: sign("secret", body+" "),
+		},
+		{
+			name:   "missing signature",
+			config: Config{WebhookSecret: "secret"},
+		},
+		{
+			name:        "unsupported content type",
+			config:      Config{WebhookSecret: "secret"},
+			signature:   sign("secret", body),
+			contentType: "text/plain",
+		},
+		{
+			name:      "no secret",
+			signature: sign("", body),
+		},
+		{
+			name:      "missing secret file",
+			config:    Config{WebhookSecret: "secret", WebhookSecretFile: f.Name() + ".missing"},
+			signature: sign("secret", body),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+			contentType := tc.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			r.Header.Set("Content-Type", contentType)
+			if tc.signature != "" {
+				r.Header.Set("X-Hub-Signature", tc.signature)
+			}
+			s := &Server{Config: tc.config}
+			payload, err := s.validateWebhook(r)
+			if (err == nil) != tc.valid {
+				t.Fatalf("expected valid %t, got error %v", tc.valid, err)
+			}
+			if tc.valid && string(payload) != body {
+				t.Errorf("expected payload %q, got %q", body, payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"github.com/google/go-github/github"
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "hold",
+		Events:      []string{"issue_comment"},
+		Description: "The hold plugin allows anyone to add or remove the '" + holdLabel + "' label from a pull request in order to temporarily prevent the PR from merging without withholding approval.",
+		Commands: []PluginCommand{{
+			Usage:       "/hold [cancel]",
+			Description: "Adds or removes the `" + holdLabel + "` label which is used to indicate that the PR should not be automatically merged.",
+			WhoCanUse:   "Anyone can use the /hold command to add or remove the '" + holdLabel + "' label.",
+			Example:     "/hold",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleHold puts a PR on hold or takes it off hold.
+func (s *Server) handleHold(client *github.Client, ic *github.IssueCommentEvent) {
+	if !ic.GetIssue().IsPullRequest() {
+		return
+	}
+	number := ic.GetIssue().GetNumber()
+	held := hasLabel(issueLabels(ic.GetIssue()), holdLabel)
+	if holdCancelReg.MatchString(ic.GetComment().GetBody()) {
+		if held {
+			removeLabel(client, ic.Repo, number, holdLabel)
+		}
+		return
+	}
+	if !held {
+		addLabel(client, ic.Repo, number, holdLabel)
+	}
+}
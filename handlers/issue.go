@@ -2,8 +2,6 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
-	"context"
 //	"io/ioutil"
 //	"net/http"
 //	"regexp"
@@ -15,20 +13,55 @@ import (
 
 type GithubIssue github.Issue
 
-func (s *Server) handleIssueEvent(body []byte) {
+func (s *Server) handleIssueEvent(body []byte, client *github.Client) {
+	if !s.receiveEvent(proxyEventIssue, body) {
+		return
+	}
 	glog.Infof("Received an Issue Event")
 
+	var ie github.IssuesEvent
+	err := json.Unmarshal(body, &ie)
+	if err != nil {
+		logError(client, "fail to unmarshal: %v", err)
+		return
+	}
+
+	switch ie.GetAction() {
+	case "closed":
+		s.runPlugin("comment-cleanup", "issues", client, func(client *github.Client) { s.cleanupTransientComments(client, ie.Repo, ie.GetIssue().GetNumber()) })
+	case "opened", "reopened", "labeled", "unlabeled":
+		s.runPlugin("require-matching-label", "issues", client, func(client *github.Client) { s.handleRequireMatchingLabel(client, ie.Repo, ie.GetIssue().GetNumber(), false, "", ie.GetAction()) })
+	}
+	switch ie.GetAction() {
+	case "opened", "labeled", "milestoned":
+		s.runPlugin("project", "issues", client, func(client *github.Client) { s.handleProjectColumns(client, ie.Repo, ie.GetIssue().GetNumber(), issueLabels(ie.GetIssue()), ie.GetIssue().GetMilestone().GetTitle()) })
+	}
+	switch ie.GetAction() {
+	case "opened", "edited":
+		s.runPlugin("crosslink", "issues", client, func(client *github.Client) { s.handleCrossLink(client, ie.Repo, ie.GetIssue().GetNumber(), ie.GetIssue().GetTitle(), ie.GetIssue().GetBody()) })
+	}
+	s.runPlugin("email", "issues", client, func(client *github.Client) { s.handleEmailIssue(&ie) })
+	if ie.GetAction() == "opened" {
+		s.runPlugin("sigmention", "issues", client, func(client *github.Client) { s.handleSigMention(client, ie.Repo, ie.GetIssue().GetNumber(), ie.GetIssue().GetBody(), issueLabels(ie.GetIssue())) })
+		s.runPlugin("duplicate", "issues", client, func(client *github.Client) { s.handleDuplicateDetection(client, ie.Repo, ie.GetIssue()) })
+	}
 }
 
 func (s *Server) handleIssueCommentEvent(body []byte, client * github.Client) {
+	if !s.receiveEvent(proxyEventIssueComment, body) {
+		return
+	}
 	glog.Infof("Received an IssueComment Event")
 
 	var prc github.IssueCommentEvent
 	err := json.Unmarshal(body, &prc)
 	if err != nil {
 		glog.Errorf("fail to unmarshal: %v", err)
+		return
+	}
+	if prc.GetAction() != "created" {
+		return
 	}
-	glog.Infof("prc: %v", prc)
 /*	comment := *prc.Comment.Body
 
 	 //https://github.com/islinwb/test/pull/1
@@ -67,40 +100,93 @@ func (s *Server) handleIssueCommentEvent(body []byte, client * github.Client) {
 	} else if testReg.MatchString(comment) {
 		// TODO: trigger particular job(s)
 		s.SendToCircleCI(body)
-	}*/ 
-	
-	ctx := context.Background()
+	}*/
 
-	list,_,err := client.Repositories.ListCollaborators(ctx,"swx457056","test-ci-bot",nil)
-	if err != nil{
-		glog.Fatal("Cannot List the Collaborators",err)
+	s.runPlugin("heart", "issue_comment", client, func(client *github.Client) { s.handleHeart(client, &prc) })
+	if prc.GetComment().GetUser().GetLogin() != s.BotName {
+		s.runPlugin("sigmention", "issue_comment", client, func(client *github.Client) { s.handleSigMention(client, prc.Repo, prc.GetIssue().GetNumber(), prc.GetComment().GetBody(), issueLabels(prc.GetIssue())) })
 	}
-	fmt.Println("list",list)
 
-	assign,_,err := client.Repositories.IsCollaborator(ctx, "swx457056", "test-ci-bot", "sids-b")
-	fmt.Println("assign",assign)
-	if err != nil {
-		glog.Fatal("Not the collaborator",err)
+	s.runPlugin("stale", "issue_comment", client, func(client *github.Client) { s.handleStaleActivity(client, &prc) })
 
+	comment := prc.GetComment().GetBody()
+	if assignReg.MatchString(comment) {
+		s.runPlugin("assign", "issue_comment", client, func(client *github.Client) { s.handleAssign(client, &prc) })
 	}
-
-//	var assignees github.IssueRequest
-//	get := assignees.GetAssignees()
-	get:=make([]string,0)
-	get = append(get,"sids-b")
-	fmt.Println("***********get***************",get)
-
-
-	if assign {
-		 fmt.Println("Add Assignee")
-
-			issue,_,err := client.Issues.AddAssignees(ctx,"swx457056", "test-ci-bot",1,get)
-			fmt.Println("err",err)
-			fmt.Println("issue",issue)
-		
-
-
+	if ccReg.MatchString(comment) {
+		s.runPlugin("assign", "issue_comment", client, func(client *github.Client) { s.handleCC(client, &prc) })
+	}
+	if lgtmReg.MatchString(comment) {
+		s.runPlugin("lgtm", "issue_comment", client, func(client *github.Client) { s.handleLgtm(client, &prc) })
+	}
+	if approveReg.MatchString(comment) {
+		s.runPlugin("approve", "issue_comment", client, func(client *github.Client) { s.handleApprove(client, &prc) })
+	}
+	if holdReg.MatchString(comment) {
+		s.runPlugin("hold", "issue_comment", client, func(client *github.Client) { s.handleHold(client, &prc) })
+	}
+	if closeReg.MatchString(comment) || reopenReg.MatchString(comment) {
+		s.runPlugin("close", "issue_comment", client, func(client *github.Client) { s.handleClose(client, &prc) })
+	}
+	if retitleReg.MatchString(comment) {
+		s.runPlugin("retitle", "issue_comment", client, func(client *github.Client) { s.handleRetitle(client, &prc) })
+	}
+	if lifecycleReg.MatchString(comment) {
+		s.runPlugin("lifecycle", "issue_comment", client, func(client *github.Client) { s.handleLifecycle(client, &prc) })
+	}
+	if stageReg.MatchString(comment) {
+		s.runPlugin("stage", "issue_comment", client, func(client *github.Client) { s.handleStage(client, &prc) })
+	}
+	if skipReg.MatchString(comment) {
+		s.runPlugin("skip", "issue_comment", client, func(client *github.Client) { s.handleSkip(client, &prc) })
+	}
+	if overrideReg.MatchString(comment) {
+		s.runPlugin("override", "issue_comment", client, func(client *github.Client) { s.handleOverride(client, &prc) })
+	}
+	if projectReg.MatchString(comment) {
+		s.runPlugin("project", "issue_comment", client, func(client *github.Client) { s.handleProject(client, &prc) })
+	}
+	if woofReg.MatchString(comment) {
+		s.runPlugin("dog", "issue_comment", client, func(client *github.Client) { s.handleDog(client, &prc) })
+	}
+	if meowReg.MatchString(comment) {
+		s.runPlugin("cat", "issue_comment", client, func(client *github.Client) { s.handleCat(client, &prc) })
+	}
+	if ponyReg.MatchString(comment) {
+		s.runPlugin("pony", "issue_comment", client, func(client *github.Client) { s.handlePony(client, &prc) })
+	}
+	if shrugReg.MatchString(comment) {
+		s.runPlugin("shrug", "issue_comment", client, func(client *github.Client) { s.handleShrug(client, &prc) })
+	}
+	if jokeReg.MatchString(comment) {
+		s.runPlugin("joke", "issue_comment", client, func(client *github.Client) { s.handleJoke(client, &prc) })
+	}
+	if checkCLAReg.MatchString(comment) {
+		s.runPlugin("cla", "issue_comment", client, func(client *github.Client) { s.handleCheckCLA(client, &prc) })
+	}
+	if cherrypickReg.MatchString(comment) {
+		s.runPlugin("cherrypicker", "issue_comment", client, func(client *github.Client) { s.handleCherryPick(client, &prc) })
+	}
+	if duplicateReg.MatchString(comment) {
+		s.runPlugin("duplicate", "issue_comment", client, func(client *github.Client) { s.handleDuplicate(client, &prc) })
+	}
+	if transferIssueReg.MatchString(comment) {
+		s.runPlugin("transfer-issue", "issue_comment", client, func(client *github.Client) { s.handleTransferIssue(client, &prc) })
+	}
+	if lockReg.MatchString(comment) {
+		s.runPlugin("lock", "issue_comment", client, func(client *github.Client) { s.handleLock(client, &prc) })
+	}
+	if testReg.MatchString(comment) || retestReg.MatchString(comment) {
+		s.runPlugin("jobs", "issue_comment", client, func(client *github.Client) { s.handleTrigger(client, &prc) })
+	}
+	if busyReg.MatchString(comment) || availableReg.MatchString(comment) {
+		s.runPlugin("availability", "issue_comment", client, func(client *github.Client) { s.handleAvailability(client, &prc) })
+	}
+	if helpReg.MatchString(comment) || helpRemoveReg.MatchString(comment) ||
+		goodFirstIssueReg.MatchString(comment) || goodFirstIssueRemoveReg.MatchString(comment) {
+		s.runPlugin("help", "issue_comment", client, func(client *github.Client) { s.handleHelp(client, &prc) })
+	}
+	if lintReg.MatchString(comment) && prc.GetIssue().IsPullRequest() {
+		s.runPlugin("golint", "issue_comment", client, func(client *github.Client) { s.handleLint(client, &prc) })
 	}
-
-
 }
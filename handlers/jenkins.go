@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const (
+	jenkinsInterval = time.Minute
+	// jenkinsMaxAge is how long a build is polled before giving up.
+	jenkinsMaxAge = 24 * time.Hour
+)
+
+// Jenkins is the config of the jobs run on Jenkins for PRs.
+type Jenkins struct {
+	// URL of the Jenkins server, e.g. https://jenkins.example.com.
+	URL string `json:"url,omitempty"`
+	// User and Token are the credentials of the bot on Jenkins, Token
+	// being an API token.
+	User  string `json:"user,omitempty"`
+	Token string `json:"token,omitempty"`
+	// Jobs maps an org or org/repo to the jobs run for its PRs.
+	Jobs map[string][]JenkinsJob `json:"jobs,omitempty"`
+}
+
+// JenkinsJob is a Jenkins job run for PRs.
+type JenkinsJob struct {
+	// Name of the job on Jenkins, folders separated by slashes.
+	Name string `json:"name"`
+	// Context of the commit status reporting the job, jenkins/<name> by
+	// default.
+	Context string `json:"context,omitempty"`
+	// AlwaysRun runs the job whenever a PR of a trusted author is opened
+	// or pushed to, rather than only on /test.
+	AlwaysRun bool `json:"always_run,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "jenkins",
+		Description: "Enqueues the Jenkins builds of the jobs configured for a repo with the parameters of a PR, and reports their results as commit statuses linking to the build logs. Jobs that always run are triggered when PRs of trusted authors are opened or pushed to.",
+		ConfigKey:   "jenkins",
+		Commands: []PluginCommand{{
+			Usage:       "/test [all|<job>]",
+			Description: "Runs every Jenkins job of the repo, or the given one.",
+			WhoCanUse:   trustedUsers,
+			Example:     "/test unit",
+		}, {
+			Usage:       "/retest",
+			Description: "Runs again the Jenkins jobs that failed on the head of the PR.",
+			WhoCanUse:   trustedUsers,
+			Example:     "/retest",
+		}},
+	}, func(c *Config) []string {
+		var enabled []string
+		for k := range c.Jenkins.Jobs {
+			enabled = append(enabled, k)
+		}
+		return enabled
+	})
+	registerPeriodic("jenkins", jenkinsInterval, func(c *Config) bool {
+		return len(c.Jenkins.Jobs) > 0
+	}, (*Server).processJenkinsBuilds)
+}
+
+func (c *Config) validateJenkins() error {
+	if len(c.Jenkins.Jobs) > 0 && c.Jenkins.URL == "" {
+		return fmt.Errorf("jenkins: url is required to run jobs")
+	}
+	for key, jobs := range c.Jenkins.Jobs {
+		contexts := map[string]bool{}
+		for _, j := range jobs {
+			if j.Name == "" {
+				return fmt.Errorf("jenkins: job without name for %s", key)
+			}
+			if contexts[j.context()] {
+				return fmt.Errorf("jenkins: duplicate context %q for %s", j.context(), key)
+			}
+			contexts[j.context()] = true
+		}
+	}
+	return nil
+}
+
+func (j JenkinsJob) context() string {
+	if j.Context != "" {
+		return j.Context
+	}
+	return "jenkins/" + j.Name
+}
+
+// jobsFor returns the jobs of repo, those of the repo then those of its org.
+func (c Jenkins) jobsFor(repo *github.Repository) []JenkinsJob {
+	jobs := append([]JenkinsJob{}, c.Jobs[repo.GetFullName()]...)
+	return append(jobs, c.Jobs[repo.GetOwner().GetLogin()]...)
+}
+
+// jenkinsBuild is a build enqueued for a PR, polled until it's done.
+type jenkinsBuild struct {
+	Job    JenkinsJob         `json:"job"`
+	Repo   *github.Repository `json:"repo"`
+	SHA    string             `json:"sha"`
+	Queued time.Time          `json:"queued"`
+	// QueueURL is the queue item of the build, and BuildURL the build
+	// once it started.
+	QueueURL string `json:"queue_url"`
+	BuildURL string `json:"build_url,omitempty"`
+}
+
+// jenkinsBuilds are the builds being polled, by queue item. With leader
+// election, they are those the replicas share, see modifyState.
+var jenkinsBuilds = struct {
+	sync.Mutex
+	m map[string]*jenkinsBuild
+}{m: map[string]*jenkinsBuild{}}
+
+// withJenkinsBuilds runs modify on the builds being polled.
+func (s *Server) withJenkinsBuilds(modify func() bool) {
+	jenkinsBuilds.Lock()
+	defer jenkinsBuilds.Unlock()
+	if err := s.modifyState("jenkins", &jenkinsBuilds.m, modify); err != nil {
+		glog.Errorf("fail to update the shared Jenkins builds: %v", err)
+	}
+}
+
+// jenkinsRequest calls the Jenkins API at u, decoding the response into
+// out, and returns the response headers.
+func (s *Server) jenkinsRequest(method, u string, out interface{}) (http.Header, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Config.Jenkins.User != "" {
+		req.SetBasicAuth(s.Config.Jenkins.User, s.Config.Jenkins.Token)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s", method, u, resp.Status)
+	}
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			return nil, err
+		}
+	}
+	return resp.Header, nil
+}
+
+// jenkinsJobURL is the URL of a job, whose folders are jobs too.
+func (s *Server) jenkinsJobURL(name string) string {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.TrimSuffix(s.Config.Jenkins.URL, "/") + "/job/" + strings.Join(parts, "/job/")
+}
+
+// triggerJenkinsComment runs the jobs of repo a /test or /retest comment
+// asks for.
+func (s *Server) triggerJenkinsComment(client *github.Client, repo *github.Repository, pr *github.PullRequest, comment string) {
+	jobs := s.Config.Jenkins.jobsFor(repo)
+	if len(jobs) == 0 {
+		return
+	}
+	selected := map[string]bool{}
+	for _, m := range testReg.FindAllStringSubmatch(comment, -1) {
+		for _, j := range jobs {
+			if name := strings.ToLower(m[1]); name == "" || name == "all" || name == strings.ToLower(j.Name) || name == strings.ToLower(j.context()) {
+				selected[j.Name] = true
+			}
+		}
+	}
+	if retestReg.MatchString(comment) {
+		failed, err := failedContexts(client, repo, pr.GetHead().GetSHA())
+		if err != nil {
+			logError(client, "fail to get statuses of %s@%s: %v", repo.GetFullName(), pr.GetHead().GetSHA(), err)
+			return
+		}
+		for _, j := range jobs {
+			if failed[j.context()] {
+				selected[j.Name] = true
+			}
+		}
+	}
+	var run []JenkinsJob
+	for _, j := range jobs {
+		if selected[j.Name] {
+			run = append(run, j)
+		}
+	}
+	s.triggerJenkins(client, repo, pr, run)
+}
+
+// failedContexts returns the status contexts failing on a commit.
+func failedContexts(client *github.Client, repo *github.Repository, sha string) (map[string]bool, error) {
+	combined, err := scmFor(client).GetCombinedStatus(repo.GetOwner().GetLogin(), repo.GetName(), sha)
+	if err != nil {
+		return nil, err
+	}
+	failed := map[string]bool{}
+	for _, st := range combined.Statuses {
+		if st.GetState() == "failure" || st.GetState() == "error" {
+			failed[st.GetContext()] = true
+		}
+	}
+	return failed, nil
+}
+
+// triggerJenkins enqueues a build of each job with the parameters of pr.
+func (s *Server) triggerJenkins(client *github.Client, repo *github.Repository, pr *github.PullRequest, jobs []JenkinsJob) {
+	sha := pr.GetHead().GetSHA()
+	params := url.Values{
+		"REPO_OWNER":    {repo.GetOwner().GetLogin()},
+		"REPO_NAME":     {repo.GetName()},
+		"PULL_NUMBER":   {strconv.Itoa(pr.GetNumber())},
+		"PULL_BASE_REF": {pr.GetBase().GetRef()},
+		"PULL_BASE_SHA": {pr.GetBase().GetSHA()},
+		"PULL_HEAD_REF": {pr.GetHead().GetRef()},
+		"PULL_PULL_SHA": {sha},
+	}
+	for _, j := range jobs {
+		header, err := s.jenkinsRequest(http.MethodPost, s.jenkinsJobURL(j.Name)+"/buildWithParameters?"+params.Encode(), nil)
+		if err != nil {
+			logError(client, "fail to enqueue Jenkins job %s for %s#%d: %v", j.Name, repo.GetFullName(), pr.GetNumber(), err)
+			createStatus(client, repo, sha, j.context(), "error", "Failed to enqueue the build", "")
+			continue
+		}
+		queueURL := header.Get("Location")
+		if queueURL == "" {
+			logError(client, "Jenkins returned no queue item for job %s", j.Name)
+			continue
+		}
+		createStatus(client, repo, sha, j.context(), "pending", "Build queued", "")
+		b := jenkinsBuild{Job: j, Repo: stateRepo(repo), SHA: sha, Queued: time.Now(), QueueURL: queueURL}
+		s.withJenkinsBuilds(func() bool {
+			added := b
+			jenkinsBuilds.m[queueURL] = &added
+			return true
+		})
+	}
+}
+
+// processJenkinsBuilds reports the builds being polled, forgetting those
+// that are done.
+func (s *Server) processJenkinsBuilds(client *github.Client) {
+	var builds []jenkinsBuild
+	s.withJenkinsBuilds(func() bool {
+		builds = nil
+		for _, b := range jenkinsBuilds.m {
+			builds = append(builds, *b)
+		}
+		return false
+	})
+
+	for i := range builds {
+		b := &builds[i]
+		started := b.BuildURL
+		done := s.reportJenkinsBuild(client, b)
+		if !done && time.Since(b.Queued) > jenkinsMaxAge {
+			createStatus(client, b.Repo, b.SHA, b.Job.context(), "error", "Timed out", b.logURL())
+			done = true
+		}
+		if !done && b.BuildURL == started {
+			continue
+		}
+		s.withJenkinsBuilds(func() bool {
+			if _, ok := jenkinsBuilds.m[b.QueueURL]; !ok {
+				return false
+			}
+			if done {
+				delete(jenkinsBuilds.m, b.QueueURL)
+			} else {
+				updated := *b
+				jenkinsBuilds.m[b.QueueURL] = &updated
+			}
+			return true
+		})
+	}
+}
+
+func (b *jenkinsBuild) logURL() string {
+	if b.BuildURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(b.BuildURL, "/") + "/console"
+}
+
+// jenkinsState maps the result of a build to the state of a commit status.
+func jenkinsState(result string) string {
+	switch result {
+	case "SUCCESS":
+		return "success"
+	case "FAILURE", "UNSTABLE":
+		return "failure"
+	default:
+		// ABORTED and NOT_BUILT builds didn't get to test anything.
+		return "error"
+	}
+}
+
+// reportJenkinsBuild sets the status of a build, following it from the
+// queue. It reports whether the build is done.
+func (s *Server) reportJenkinsBuild(client *github.Client, b *jenkinsBuild) bool {
+	if b.BuildURL == "" {
+		var item struct {
+			Cancelled  bool `json:"cancelled"`
+			Executable *struct {
+				URL string `json:"url"`
+			} `json:"executable"`
+		}
+		if _, err := s.jenkinsRequest(http.MethodGet, strings.TrimSuffix(b.QueueURL, "/")+"/api/json", &item); err != nil {
+			logError(client, "fail to get Jenkins queue item %s: %v", b.QueueURL, err)
+			return false
+		}
+		if item.Cancelled {
+			createStatus(client, b.Repo, b.SHA, b.Job.context(), "error", "Build cancelled", "")
+			return true
+		}
+		if item.Executable == nil {
+			return false
+		}
+		b.BuildURL = item.Executable.URL
+		createStatus(client, b.Repo, b.SHA, b.Job.context(), "pending", "Build running", b.logURL())
+	}
+
+	var build struct {
+		Building bool   `json:"building"`
+		Result   string `json:"result"`
+	}
+	if _, err := s.jenkinsRequest(http.MethodGet, strings.TrimSuffix(b.BuildURL, "/")+"/api/json", &build); err != nil {
+		logError(client, "fail to get Jenkins build %s: %v", b.BuildURL, err)
+		return false
+	}
+	if build.Building || build.Result == "" {
+		return false
+	}
+	createStatus(client, b.Repo, b.SHA, b.Job.context(), jenkinsState(build.Result), "Build "+strings.ToLower(build.Result), b.logURL())
+	return true
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"ci-bot/commentpruner"
+
+	"github.com/google/go-github/github"
+)
+
+const jobResultsMarker = "<!-- ci-bot:job-results -->"
+
+// reportJobResults keeps a single comment on the PR of a presubmit that
+// just completed, listing the presubmits failing on its commit and how to
+// run them again. The comment is removed once none fails.
+func (s *Server) reportJobResults(client *github.Client, done *Job) {
+	sha := done.sha()
+	number := done.Refs.Pulls[0].Number
+	runs := s.listJobs(func(j *Job) bool {
+		return j.Type == PresubmitJob && j.Refs.Org == done.Refs.Org && j.Refs.Repo == done.Refs.Repo &&
+			j.Refs.Pulls[0].Number == number && j.sha() == sha
+	})
+	// Runs are sorted oldest first, so the newest run of each job wins.
+	latest := map[string]Job{}
+	for _, j := range runs {
+		if j.State != AbortedState {
+			latest[j.Name] = j
+		}
+	}
+	var failed []Job
+	for _, j := range latest {
+		if j.State == FailureState || j.State == ErrorState {
+			failed = append(failed, j)
+		}
+	}
+
+	repo := done.Refs.repo()
+	if len(failed) == 0 {
+		s.commentPruner(client, repo, number).PruneComments(commentpruner.HasMarker(jobResultsMarker))
+		return
+	}
+	sort.Slice(failed, func(a, b int) bool { return failed[a].Name < failed[b].Name })
+	lines := []string{
+		jobResultsMarker,
+		transientMarker("job-results"),
+		fmt.Sprintf("@%s: The following jobs failed for commit %s:", done.Refs.Pulls[0].Author, sha),
+		"",
+		"| Job | Result | Details | Rerun command |",
+		"| --- | --- | --- | --- |",
+	}
+	for _, j := range failed {
+		details := "-"
+		if j.URL != "" {
+			details = fmt.Sprintf("[link](%s)", j.URL)
+		}
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s | `/test %s` |", j.Name, j.Description, details, j.Name))
+	}
+	lines = append(lines, "", "Comment `/retest` to run all the failed jobs again.")
+	s.upsertComment(client, repo, number, jobResultsMarker, strings.Join(lines, "\n"))
+}
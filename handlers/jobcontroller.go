@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const (
+	jobControllerInterval = 30 * time.Second
+	// jobIDLabel labels the pod of a job with its ID.
+	jobIDLabel = "ci-bot.job-id"
+	// jobCreatedByLabel labels every pod of a job.
+	jobCreatedByLabel = "created-by-ci-bot"
+	jobNameAnnotation = "ci-bot.job"
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "jobs",
+		Events:      []string{"issue_comment", "pull_request", "push"},
+		Description: "Runs jobs in Kubernetes pods: the presubmit jobs of a PR when a trusted author opens or pushes to it (those that always run, or that run if the PR changes matching files) and on /test and /retest, the postsubmit jobs of a branch on pushes to it, and the periodic jobs on their interval or cron schedule. Each presubmit and postsubmit reports a commit status; required presubmits a PR doesn't need are reported as skipped. The presubmits failing on the head of a PR are listed in a single comment, with the commands running them again, and the ci-bot/required-jobs status sums up its required presubmits.",
+		ConfigKey:   "jobs",
+		Commands: []PluginCommand{{
+			Usage:       "/test [all|<job>]",
+			Description: "Runs the presubmit jobs that run by themselves for the PR, or the given one.",
+			WhoCanUse:   trustedUsers,
+			Example:     "/test unit",
+		}, {
+			Usage:       "/retest",
+			Description: "Runs again the presubmit jobs that failed on the head of the PR.",
+			WhoCanUse:   trustedUsers,
+			Example:     "/retest",
+		}},
+	}, func(c *Config) []string {
+		var enabled []string
+		for k := range c.Jobs.Presubmits {
+			enabled = append(enabled, k)
+		}
+		for k := range c.Jobs.Postsubmits {
+			if !stringInSlice(k, enabled) {
+				enabled = append(enabled, k)
+			}
+		}
+		return enabled
+	})
+	registerPeriodic("job-controller", jobControllerInterval, func(c *Config) bool {
+		return c.Jobs.enabled()
+	}, (*Server).syncJobs)
+}
+
+// jobKube is the client of the cluster running the pods of jobs, created
+// on first use.
+var jobKube = struct {
+	sync.Mutex
+	client *kubeClient
+}{}
+
+func (s *Server) jobKubeClient() (*kubeClient, error) {
+	jobKube.Lock()
+	defer jobKube.Unlock()
+	if jobKube.client == nil {
+		k, err := newKubeClient(s.Config.Jobs.Kube)
+		if err != nil {
+			return nil, err
+		}
+		jobKube.client = k
+	}
+	return jobKube.client, nil
+}
+
+// jobNamespace is the namespace of the pods of jobs.
+func (s *Server) jobNamespace(k *kubeClient) string {
+	if s.Config.Jobs.Namespace != "" {
+		return s.Config.Jobs.Namespace
+	}
+	return k.namespace
+}
+
+// syncJobs starts the pods of triggered jobs and follows the pods of
+// pending ones, reporting the jobs whose state changed.
+func (s *Server) syncJobs(client *github.Client) {
+	k, err := s.jobKubeClient()
+	if err != nil {
+		logError(client, "fail to create Kubernetes client for jobs: %v", err)
+		return
+	}
+	active := s.listJobs(func(j *Job) bool { return !j.Complete() })
+	// The newest run of each presubmit of a PR supersedes the others.
+	newest := map[string]string{}
+	for _, j := range active {
+		if j.Type == PresubmitJob {
+			newest[j.pullKey()] = j.ID
+		}
+	}
+	for _, j := range active {
+		before := j.State
+		switch {
+		case j.Type == PresubmitJob && newest[j.pullKey()] != j.ID:
+			s.abortJob(k, &j, "Superseded by a newer run of the job")
+		case j.State == TriggeredState:
+			s.startJob(k, &j)
+		case j.State == PendingState:
+			s.followJob(k, &j)
+		}
+		if j.State != before {
+			s.updateJob(j)
+			client := s.providerClient(j.Refs.Org, client)
+			if j.State == FailureState && j.Type == PresubmitJob && s.retryFlaky(client, &j) {
+				// The retry reported the status pending.
+				continue
+			}
+			// The newer run reports the status of aborted jobs.
+			if j.State != AbortedState {
+				reportJob(client, &j)
+				if j.Type == PresubmitJob && j.Complete() {
+					s.reportJobResults(client, &j)
+					s.reportRequiredJobs(client, j.Refs.repo(), j.sha())
+					if j.State == SuccessState {
+						s.reportCoverage(client, &j)
+						s.reportBenchmarks(client, &j)
+					}
+				}
+			}
+		}
+	}
+}
+
+// pullKey identifies the runs of a presubmit on a PR.
+func (j *Job) pullKey() string {
+	return fmt.Sprintf("%s/%s#%d/%s", j.Refs.Org, j.Refs.Repo, j.Refs.Pulls[0].Number, j.Name)
+}
+
+// jobEnv is the environment telling the pod of a job what to test.
+func jobEnv(j *Job) []EnvVar {
+	spec, _ := json.Marshal(struct {
+		Type  JobType `json:"type"`
+		Job   string  `json:"job"`
+		ID    string  `json:"buildid"`
+		*Refs `json:"refs"`
+	}{j.Type, j.Name, j.ID, &j.Refs})
+	env := []EnvVar{
+		{Name: "JOB_NAME", Value: j.Name},
+		{Name: "JOB_TYPE", Value: string(j.Type)},
+		{Name: "JOB_SPEC", Value: string(spec)},
+		{Name: "BUILD_ID", Value: j.ID},
+		{Name: "REPO_OWNER", Value: j.Refs.Org},
+		{Name: "REPO_NAME", Value: j.Refs.Repo},
+		{Name: "PULL_BASE_REF", Value: j.Refs.BaseRef},
+		{Name: "PULL_BASE_SHA", Value: j.Refs.BaseSHA},
+	}
+	if len(j.Refs.Pulls) > 0 {
+		env = append(env,
+			EnvVar{Name: "PULL_NUMBER", Value: strconv.Itoa(j.Refs.Pulls[0].Number)},
+			EnvVar{Name: "PULL_PULL_SHA", Value: j.Refs.Pulls[0].SHA},
+		)
+	}
+	return env
+}
+
+// jobPod is the pod running a job, decorated by the pod utilities if it
+// decorates.
+func jobPod(j *Job, namespace string, decoration *DecorationConfig) Pod {
+	spec := j.Spec
+	spec.RestartPolicy = "Never"
+	env := jobEnv(j)
+	spec.Containers = append([]Container{}, spec.Containers...)
+	for i := range spec.Containers {
+		spec.Containers[i].Env = append(append([]EnvVar{}, env...), spec.Containers[i].Env...)
+	}
+	if j.Decorate && decoration != nil {
+		spec.InitContainers = append([]Container{}, spec.InitContainers...)
+		spec.Volumes = append([]Volume{}, spec.Volumes...)
+		decoration.decorate(j, &spec, env)
+	}
+	return Pod{
+		Metadata: ObjectMeta{
+			Name:        "ci-bot-" + j.ID,
+			Namespace:   namespace,
+			Labels:      map[string]string{jobCreatedByLabel: "true", jobIDLabel: j.ID},
+			Annotations: map[string]string{jobNameAnnotation: j.Name},
+		},
+		Spec: spec,
+	}
+}
+
+// startJob creates the pod of a triggered job.
+func (s *Server) startJob(k *kubeClient, j *Job) {
+	pod, err := k.createPod(jobPod(j, s.jobNamespace(k), s.Config.Jobs.Decoration))
+	if err != nil {
+		glog.Errorf("fail to create the pod of job %s: %v", j.ID, err)
+		j.finish(ErrorState, "Failed to create the pod of the job")
+		return
+	}
+	j.PodName = pod.Metadata.Name
+	j.State = PendingState
+	j.Description = "Job running"
+}
+
+// abortJob stops a job, deleting its pod.
+func (s *Server) abortJob(k *kubeClient, j *Job, description string) {
+	if j.PodName != "" {
+		if err := k.deletePod(s.jobNamespace(k), j.PodName); err != nil {
+			if _, ok := err.(kubeNotFound); !ok {
+				glog.Errorf("fail to delete the pod of job %s: %v", j.ID, err)
+				return
+			}
+		}
+	}
+	j.finish(AbortedState, description)
+}
+
+// followJob updates a pending job from its pod.
+func (s *Server) followJob(k *kubeClient, j *Job) {
+	pod, err := k.getPod(s.jobNamespace(k), j.PodName)
+	if _, ok := err.(kubeNotFound); ok {
+		j.finish(ErrorState, "The pod of the job was deleted")
+		return
+	}
+	if err != nil {
+		glog.Errorf("fail to get the pod of job %s: %v", j.ID, err)
+		return
+	}
+	switch pod.Status.Phase {
+	case PodSucceeded:
+		j.finish(SuccessState, "Job succeeded")
+	case PodFailed:
+		description := "Job failed"
+		if pod.Status.Reason != "" {
+			description = fmt.Sprintf("Job failed: %s", pod.Status.Reason)
+		}
+		j.finish(FailureState, description)
+	case PodUnknown:
+		j.finish(ErrorState, "The pod of the job was lost")
+	}
+}
+
+func (j *Job) finish(state JobState, description string) {
+	now := time.Now()
+	j.State = state
+	j.Description = description
+	j.EndTime = &now
+}
+
+// jobStatusState maps the state of a job to the state of a commit status.
+func jobStatusState(state JobState) string {
+	switch state {
+	case SuccessState:
+		return "success"
+	case FailureState:
+		return "failure"
+	case AbortedState, ErrorState:
+		return "error"
+	default:
+		return "pending"
+	}
+}
+
+// reportJob sets the commit status of a job. Periodics test no commit.
+func reportJob(client *github.Client, j *Job) {
+	if j.Type == PeriodicJob {
+		return
+	}
+	createStatus(client, j.Refs.repo(), j.sha(), j.Context, jobStatusState(j.State), j.Description, j.URL)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// maxJobHistory caps how many jobs /jobs returns by default.
+const maxJobHistory = 500
+
+// serveJobHistory serves /jobs, the jobs the bot knows of as JSON, newest
+// first. They can be filtered by repo=org/repo, pr=<number>,
+// branch=<base ref>, type, job=<name> and state, and capped with limit.
+func (s *Server) serveJobHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := maxJobHistory
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	pr := 0
+	if v := q.Get("pr"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid pr", http.StatusBadRequest)
+			return
+		}
+		pr = n
+	}
+	var org, repo string
+	if v := q.Get("repo"); v != "" {
+		parts := strings.SplitN(v, "/", 2)
+		if len(parts) != 2 {
+			http.Error(w, "repo must be org/repo", http.StatusBadRequest)
+			return
+		}
+		org, repo = parts[0], parts[1]
+	}
+
+	found := s.listJobs(func(j *Job) bool {
+		switch {
+		case org != "" && (j.Refs.Org != org || j.Refs.Repo != repo):
+			return false
+		case pr != 0 && (len(j.Refs.Pulls) == 0 || j.Refs.Pulls[0].Number != pr):
+			return false
+		case q.Get("branch") != "" && j.Refs.BaseRef != q.Get("branch"):
+			return false
+		case q.Get("type") != "" && string(j.Type) != q.Get("type"):
+			return false
+		case q.Get("job") != "" && j.Name != q.Get("job"):
+			return false
+		case q.Get("state") != "" && string(j.State) != q.Get("state"):
+			return false
+		}
+		return true
+	})
+	// listJobs sorts oldest first.
+	history := make([]Job, 0, limit)
+	for i := len(found) - 1; i >= 0 && len(history) < limit; i-- {
+		j := found[i]
+		// The pod spec is config rather than history, and may hold secrets
+		// in its env.
+		j.Spec = PodSpec{}
+		history = append(history, j)
+	}
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		glog.Errorf("fail to encode job history: %v", err)
+	}
+}
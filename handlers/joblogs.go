@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// jobURL is what the status of a job links to: its page on the bot when
+// the bot serves logs, else its build log in the storage of decorated jobs.
+func (c JobConfig) jobURL(j *Job) string {
+	if c.LogsURL != "" {
+		return strings.TrimSuffix(c.LogsURL, "/") + "/" + j.ID
+	}
+	if j.Decorate && c.Decoration != nil {
+		return c.Decoration.logURL(j)
+	}
+	return ""
+}
+
+// openStored opens a file a decorated job uploaded.
+func (d *DecorationConfig) openStored(j *Job, name string) (io.ReadCloser, error) {
+	u, err := url.Parse(d.Storage.URL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, j.uploadPath(), name)
+	if u.Scheme == "file" {
+		return os.Open(u.Path)
+	}
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// storedArtifacts lists the artifacts a decorated job uploaded, for
+// storage the bot can list: mounted directories.
+func (d *DecorationConfig) storedArtifacts(j *Job) []string {
+	u, err := url.Parse(d.Storage.URL)
+	if err != nil || u.Scheme != "file" {
+		return nil
+	}
+	dir := filepath.Join(u.Path, j.uploadPath(), "artifacts")
+	var artifacts []string
+	filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, file)
+			artifacts = append(artifacts, path.Join("artifacts", filepath.ToSlash(rel)))
+		}
+		return nil
+	})
+	return artifacts
+}
+
+// serveJobLogs serves /logs/<id>, the page of a job with its build log
+// and artifacts, and /logs/<id>/<file>, the files a decorated job
+// uploaded. The log of jobs that aren't decorated is read from their pod
+// while it exists.
+func (s *Server) serveJobLogs(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/logs/"), "/", 2)
+	found := s.listJobs(func(j *Job) bool { return j.ID == parts[0] })
+	if len(found) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	j := found[0]
+	decoration := s.Config.Jobs.Decoration
+	if !j.Decorate {
+		decoration = nil
+	}
+
+	if len(parts) == 2 {
+		name := path.Clean("/" + parts[1])[1:]
+		if decoration == nil || name == "" {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := decoration.openStored(&j, name)
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			glog.Errorf("fail to read %s of job %s: %v", name, j.ID, err)
+			http.Error(w, "fail to read the file", http.StatusBadGateway)
+			return
+		}
+		defer f.Close()
+		if strings.HasSuffix(name, ".txt") || strings.HasSuffix(name, ".log") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		io.Copy(w, f)
+		return
+	}
+
+	page := jobLogsPage{Job: j}
+	if decoration != nil {
+		page.Artifacts = decoration.storedArtifacts(&j)
+		if f, err := decoration.openStored(&j, "build-log.txt"); err == nil {
+			page.Log = readLog(f)
+			f.Close()
+		} else if !os.IsNotExist(err) {
+			glog.Errorf("fail to read the log of job %s: %v", j.ID, err)
+		}
+	} else if j.PodName != "" && len(j.Spec.Containers) > 0 {
+		if k, err := s.jobKubeClient(); err == nil {
+			if log, err := k.podLog(s.jobNamespace(k), j.PodName, j.Spec.Containers[0].Name); err == nil {
+				page.Log = string(log)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := jobLogsTemplate.Execute(w, page); err != nil {
+		glog.Errorf("fail to render the page of job %s: %v", j.ID, err)
+	}
+}
+
+// maxLogSize is how much of a build log the page of a job shows.
+const maxLogSize = 4 << 20
+
+func readLog(r io.Reader) string {
+	var b strings.Builder
+	n, _ := io.Copy(&b, io.LimitReader(r, maxLogSize+1))
+	if n > maxLogSize {
+		return b.String()[:maxLogSize] + "\n[log truncated, see build-log.txt]"
+	}
+	return b.String()
+}
+
+type jobLogsPage struct {
+	Job       Job
+	Log       string
+	Artifacts []string
+}
+
+func (p jobLogsPage) Duration() string {
+	if p.Job.EndTime == nil {
+		return time.Since(p.Job.StartTime).Round(time.Second).String() + " so far"
+	}
+	return p.Job.EndTime.Sub(p.Job.StartTime).Round(time.Second).String()
+}
+
+var jobLogsTemplate = template.Must(template.New("joblogs").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Job.Name}} #{{.Job.ID}}</title></head>
+<body>
+<h1>{{.Job.Name}} #{{.Job.ID}}</h1>
+<p><b>{{.Job.State}}</b>: {{.Job.Description}}</p>
+<p>{{.Job.Type}} job{{with .Job.Refs}}{{if .Org}} of {{.Org}}/{{.Repo}} {{.BaseRef}}{{range .Pulls}}, PR #{{.Number}} at {{.SHA}}{{end}}{{end}}{{end}}, started {{.Job.StartTime.Format "2006-01-02 15:04:05 MST"}}, took {{.Duration}}</p>
+{{if .Job.Decorate}}<p><a href="{{.Job.ID}}/build-log.txt">Raw build log</a> · <a href="{{.Job.ID}}/clone-log.txt">Clone log</a> · <a href="{{.Job.ID}}/finished.json">finished.json</a></p>{{end}}
+{{if .Artifacts}}
+<h2>Artifacts</h2>
+<ul>
+{{range .Artifacts}}<li><a href="{{$.Job.ID}}/{{.}}">{{.}}</a></li>
+{{end}}
+</ul>
+{{end}}
+<h2>Build log</h2>
+{{if .Log}}<pre>{{.Log}}</pre>{{else}}<p>No log available.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,596 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// JobConfig is the config of the jobs the bot runs in Kubernetes pods.
+type JobConfig struct {
+	Kube KubeConfig `json:"kube,omitempty"`
+	// Namespace the pods run in, the default namespace of Kube by default.
+	Namespace string `json:"namespace,omitempty"`
+	// StateFile is where jobs are kept across restarts of the bot. Jobs
+	// only live in memory without one. With leader election, jobs are
+	// kept in the state the replicas share instead.
+	StateFile string `json:"state_file,omitempty"`
+	// Presubmits map an org or org/repo to the jobs testing its PRs.
+	Presubmits map[string][]Presubmit `json:"presubmits,omitempty"`
+	// Postsubmits map an org or org/repo to the jobs run on pushes to its
+	// branches.
+	Postsubmits map[string][]Postsubmit `json:"postsubmits,omitempty"`
+	// Periodics are the jobs run on a schedule.
+	Periodics []Periodic `json:"periodics,omitempty"`
+	// Presets are env and volumes added to the pods of the jobs whose
+	// labels they match.
+	Presets []Preset `json:"presets,omitempty"`
+	// Decoration configures the pod utilities of the jobs that decorate.
+	Decoration *DecorationConfig `json:"decoration,omitempty"`
+	// Flaky map an org or org/repo to the contexts of its presubmits that
+	// are retried when they fail.
+	Flaky map[string][]FlakyContext `json:"flaky,omitempty"`
+	// LogsURL is where the /logs endpoint of the bot is reachable, e.g.
+	// https://ci-bot.example.com/logs. Statuses of jobs link to their page
+	// there when set.
+	LogsURL string `json:"logs_url,omitempty"`
+	// Sinker configures how long completed jobs and pods are kept.
+	Sinker SinkerConfig `json:"sinker,omitempty"`
+}
+
+// JobBase is what every kind of job has.
+type JobBase struct {
+	// Name of the job, unique among the jobs of a repo.
+	Name string `json:"name"`
+	// Labels of the job, which select the presets applied to it.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Spec of the pod the job runs in.
+	Spec *PodSpec `json:"spec"`
+	// Decorate runs the job with the pod utilities, see Decoration.
+	Decorate bool `json:"decorate,omitempty"`
+}
+
+// Presubmit is a job testing PRs.
+type Presubmit struct {
+	JobBase
+	// Context of the commit status of the job, its name by default.
+	Context string `json:"context,omitempty"`
+	// AlwaysRun runs the job whenever a PR of a trusted author is opened
+	// or pushed to.
+	AlwaysRun bool `json:"always_run,omitempty"`
+	// RunIfChanged runs the job like AlwaysRun, but only for PRs changing
+	// a file matching this regexp.
+	RunIfChanged string `json:"run_if_changed,omitempty"`
+	// Optional jobs aren't required for PRs to merge.
+	Optional bool `json:"optional,omitempty"`
+
+	runIfChanged *regexp.Regexp
+}
+
+// Postsubmit is a job run on pushes to branches.
+type Postsubmit struct {
+	JobBase
+	// Branches the job runs on, every branch by default.
+	Branches []string `json:"branches,omitempty"`
+}
+
+// Periodic is a job run on a schedule, after its previous run completed.
+type Periodic struct {
+	JobBase
+	// Interval between the starts of two runs, e.g. 24h.
+	Interval string `json:"interval,omitempty"`
+	// Cron is the schedule of the runs in cron syntax, in UTC, e.g.
+	// "0 2 * * *" for nightly builds.
+	Cron string `json:"cron,omitempty"`
+
+	interval time.Duration
+	cron     *cronSchedule
+}
+
+func (c *Config) validateJobs() error {
+	validate := func(key string, jobs []JobBase) error {
+		names := map[string]bool{}
+		for _, j := range jobs {
+			if j.Name == "" {
+				return fmt.Errorf("jobs: job without name for %s", key)
+			}
+			if names[j.Name] {
+				return fmt.Errorf("jobs: duplicate job %s for %s", j.Name, key)
+			}
+			names[j.Name] = true
+			if j.Spec == nil || len(j.Spec.Containers) == 0 {
+				return fmt.Errorf("jobs: job %s for %s has no containers", j.Name, key)
+			}
+			if _, err := c.Jobs.applyPresets(j.Labels, *j.Spec); err != nil {
+				return fmt.Errorf("jobs: presets of job %s for %s: %v", j.Name, key, err)
+			}
+			if err := c.validateDecorated(j); err != nil {
+				return fmt.Errorf("jobs: job %s for %s: %v", j.Name, key, err)
+			}
+		}
+		return nil
+	}
+	for key, presubmits := range c.Jobs.Presubmits {
+		var jobs []JobBase
+		contexts := map[string]bool{}
+		for i, p := range presubmits {
+			jobs = append(jobs, p.JobBase)
+			if contexts[p.context()] {
+				return fmt.Errorf("jobs: duplicate context %q for %s", p.context(), key)
+			}
+			contexts[p.context()] = true
+			if p.RunIfChanged == "" {
+				continue
+			}
+			if p.AlwaysRun {
+				return fmt.Errorf("jobs: job %s for %s can't both always run and run if changed", p.Name, key)
+			}
+			re, err := regexp.Compile(p.RunIfChanged)
+			if err != nil {
+				return fmt.Errorf("jobs: invalid run_if_changed of job %s for %s: %v", p.Name, key, err)
+			}
+			presubmits[i].runIfChanged = re
+		}
+		if err := validate(key, jobs); err != nil {
+			return err
+		}
+	}
+	for key, postsubmits := range c.Jobs.Postsubmits {
+		var jobs []JobBase
+		for _, p := range postsubmits {
+			jobs = append(jobs, p.JobBase)
+		}
+		if err := validate(key, jobs); err != nil {
+			return err
+		}
+	}
+	var periodics []JobBase
+	for i, p := range c.Jobs.Periodics {
+		periodics = append(periodics, p.JobBase)
+		if (p.Interval == "") == (p.Cron == "") {
+			return fmt.Errorf("jobs: periodic %s needs either an interval or a cron", p.Name)
+		}
+		if p.Interval != "" {
+			d, err := time.ParseDuration(p.Interval)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("jobs: invalid interval %q of periodic %s", p.Interval, p.Name)
+			}
+			c.Jobs.Periodics[i].interval = d
+			continue
+		}
+		cron, err := parseCron(p.Cron)
+		if err != nil {
+			return fmt.Errorf("jobs: invalid cron of periodic %s: %v", p.Name, err)
+		}
+		c.Jobs.Periodics[i].cron = cron
+	}
+	return validate("periodics", periodics)
+}
+
+// presubmitsFor returns the presubmits of repo, those of the repo then
+// those of its org.
+func (c JobConfig) presubmitsFor(repo *github.Repository) []Presubmit {
+	jobs := append([]Presubmit{}, c.Presubmits[repo.GetFullName()]...)
+	return append(jobs, c.Presubmits[repo.GetOwner().GetLogin()]...)
+}
+
+func (p Presubmit) context() string {
+	if p.Context != "" {
+		return p.Context
+	}
+	return p.Name
+}
+
+// runsFor reports whether the job runs by itself for a PR changing files.
+func (p Presubmit) runsFor(files []string) bool {
+	if p.AlwaysRun {
+		return true
+	}
+	if p.runIfChanged == nil {
+		return false
+	}
+	for _, f := range files {
+		if p.runIfChanged.MatchString(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// postsubmitsFor returns the postsubmits of org/repo running on branch.
+func (c JobConfig) postsubmitsFor(org, repo, branch string) []Postsubmit {
+	var jobs []Postsubmit
+	for _, key := range []string{org + "/" + repo, org} {
+		for _, p := range c.Postsubmits[key] {
+			if len(p.Branches) == 0 || stringInSlice(branch, p.Branches) {
+				jobs = append(jobs, p)
+			}
+		}
+	}
+	return jobs
+}
+
+func (c JobConfig) enabled() bool {
+	return len(c.Presubmits) > 0 || len(c.Postsubmits) > 0 || len(c.Periodics) > 0
+}
+
+// JobType tells what triggered a job.
+type JobType string
+
+const (
+	PresubmitJob  JobType = "presubmit"
+	PostsubmitJob JobType = "postsubmit"
+	PeriodicJob   JobType = "periodic"
+)
+
+// JobState is where a job is in its life.
+type JobState string
+
+const (
+	// TriggeredState jobs wait for the controller to start their pod.
+	TriggeredState JobState = "triggered"
+	PendingState   JobState = "pending"
+	SuccessState   JobState = "success"
+	FailureState   JobState = "failure"
+	// AbortedState jobs were stopped, e.g. by a newer run of the job.
+	AbortedState JobState = "aborted"
+	// ErrorState jobs couldn't run.
+	ErrorState JobState = "error"
+)
+
+// Refs are the git references a job tests, none for periodics.
+type Refs struct {
+	Org     string `json:"org"`
+	Repo    string `json:"repo"`
+	BaseRef string `json:"base_ref"`
+	BaseSHA string `json:"base_sha"`
+	// Pulls are the PRs merged onto the base, for presubmits.
+	Pulls []Pull `json:"pulls,omitempty"`
+	// CloneURI is where the repo is fetched from, GitHub by default.
+	CloneURI string `json:"clone_uri,omitempty"`
+}
+
+// Pull is a PR tested by a job.
+type Pull struct {
+	Number int    `json:"number"`
+	Author string `json:"author"`
+	SHA    string `json:"sha"`
+	// Ref the PR is fetched from, pull/<number>/head by default.
+	Ref string `json:"ref,omitempty"`
+}
+
+// Job is a run of a configured job, from its trigger to its result.
+type Job struct {
+	ID      string            `json:"id"`
+	Type    JobType           `json:"type"`
+	Name    string            `json:"name"`
+	Context string            `json:"context"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Spec    PodSpec           `json:"spec"`
+	Refs    Refs              `json:"refs"`
+	// Decorate is whether the pod runs with the pod utilities.
+	Decorate bool `json:"decorate,omitempty"`
+
+	State       JobState   `json:"state"`
+	Description string     `json:"description,omitempty"`
+	PodName     string     `json:"pod_name,omitempty"`
+	URL         string     `json:"url,omitempty"`
+	StartTime   time.Time  `json:"start_time"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+}
+
+// Complete reports whether the job is done.
+func (j *Job) Complete() bool {
+	return j.EndTime != nil
+}
+
+// repo is the GitHub repo of the job.
+func (r Refs) repo() *github.Repository {
+	return &github.Repository{
+		Name:     github.String(r.Repo),
+		FullName: github.String(r.Org + "/" + r.Repo),
+		Owner:    &github.User{Login: github.String(r.Org)},
+	}
+}
+
+// sha is the commit the job reports its status on: the head of the PR of
+// presubmits, the base of the others.
+func (j *Job) sha() string {
+	if len(j.Refs.Pulls) > 0 {
+		return j.Refs.Pulls[0].SHA
+	}
+	return j.Refs.BaseSHA
+}
+
+// jobsStateKey is the key of the jobs in the state the replicas share.
+const jobsStateKey = "jobs"
+
+// jobs are the jobs the bot knows of, by ID.
+var jobs = struct {
+	sync.Mutex
+	loaded bool
+	m      map[string]*Job
+	// last makes IDs unique when jobs are created in the same nanosecond.
+	last int64
+}{m: map[string]*Job{}}
+
+// loadJobs reads the state file once. It must be called with the lock held.
+func (s *Server) loadJobs() {
+	if jobs.loaded {
+		return
+	}
+	jobs.loaded = true
+	path := s.Config.Jobs.StateFile
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("fail to read job state: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &jobs.m); err != nil {
+		glog.Errorf("fail to unmarshal: %v", err)
+	}
+}
+
+// saveJobs writes the state file. It must be called with the lock held.
+func (s *Server) saveJobs() {
+	path := s.Config.Jobs.StateFile
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(jobs.m)
+	if err != nil {
+		glog.Errorf("fail to marshal: %v", err)
+		return
+	}
+	// Written aside and renamed so that a crash can't leave half a file.
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		glog.Errorf("fail to save job state: %v", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		glog.Errorf("fail to save job state: %v", err)
+	}
+}
+
+// withJobs runs modify on the jobs, saving them if it reports a change.
+// With leader election, the jobs are those the replicas share, so that the
+// jobs created by a webhook any replica handled are started by the
+// leader.
+func (s *Server) withJobs(modify func() bool) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	if s.sharedState() {
+		if err := s.modifyState(jobsStateKey, &jobs.m, modify); err != nil {
+			glog.Errorf("fail to update the shared jobs: %v", err)
+		}
+		return
+	}
+	s.loadJobs()
+	if modify() {
+		s.saveJobs()
+	}
+}
+
+// createJob records a new triggered job for the controller to start, with
+// the presets matching its labels applied to its spec.
+func (s *Server) createJob(j Job) Job {
+	spec, err := s.Config.Jobs.applyPresets(j.Labels, j.Spec)
+	jobs.Lock()
+	id := time.Now().UnixNano()
+	if id <= jobs.last {
+		id = jobs.last + 1
+	}
+	jobs.last = id
+	jobs.Unlock()
+	j.ID = strconv.FormatInt(id, 36)
+	j.State = TriggeredState
+	j.Description = "Job triggered"
+	j.StartTime = time.Now()
+	j.Spec = spec
+	j.URL = s.Config.Jobs.jobURL(&j)
+	if err != nil {
+		// Only possible for configs that didn't pass validation.
+		j.finish(ErrorState, "Invalid presets: "+err.Error())
+	}
+	s.withJobs(func() bool {
+		created := j
+		jobs.m[j.ID] = &created
+		return true
+	})
+	glog.Infof("created %s job %s (%s) for %s/%s", j.Type, j.Name, j.ID, j.Refs.Org, j.Refs.Repo)
+	return j
+}
+
+// listJobs returns copies of the jobs matching keep, oldest first.
+func (s *Server) listJobs(keep func(j *Job) bool) []Job {
+	var list []Job
+	s.withJobs(func() bool {
+		list = nil
+		for _, j := range jobs.m {
+			if keep(j) {
+				list = append(list, *j)
+			}
+		}
+		return false
+	})
+	sort.Slice(list, func(a, b int) bool { return list[a].StartTime.Before(list[b].StartTime) })
+	return list
+}
+
+// updateJob replaces the job of the same ID by j.
+func (s *Server) updateJob(j Job) {
+	s.withJobs(func() bool {
+		if _, ok := jobs.m[j.ID]; !ok {
+			return false
+		}
+		updated := j
+		jobs.m[j.ID] = &updated
+		return true
+	})
+}
+
+// triggerPresubmits creates a job for each presubmit testing pr, and
+// sets their statuses pending right away.
+func (s *Server) triggerPresubmits(client *github.Client, repo *github.Repository, pr *github.PullRequest, presubmits []Presubmit) {
+	refs := Refs{
+		Org:     repo.GetOwner().GetLogin(),
+		Repo:    repo.GetName(),
+		BaseRef: pr.GetBase().GetRef(),
+		BaseSHA: pr.GetBase().GetSHA(),
+		Pulls: []Pull{{
+			Number: pr.GetNumber(),
+			Author: pr.GetUser().GetLogin(),
+			SHA:    pr.GetHead().GetSHA(),
+		}},
+	}
+	if provider, ok := s.Config.Providers[refs.Org]; ok && provider.Type == ProviderGerrit {
+		// Changes are fetched from Gerrit by their ref.
+		refs.CloneURI = strings.TrimSuffix(provider.URL, "/") + "/" + refs.Repo
+		refs.Pulls[0].Ref = pr.GetHead().GetRef()
+	}
+	for _, p := range presubmits {
+		j := s.createJob(Job{
+			Type:     PresubmitJob,
+			Name:     p.Name,
+			Context:  p.context(),
+			Labels:   p.Labels,
+			Spec:     *p.Spec,
+			Decorate: p.Decorate,
+			Refs:     refs,
+		})
+		reportJob(client, &j)
+	}
+	s.reportRequiredJobs(client, repo, pr.GetHead().GetSHA())
+}
+
+// presubmitsToRun splits the presubmits of repo that run by themselves for
+// pr from the required ones that don't. Files are only listed when some
+// presubmit runs if they changed.
+func (s *Server) presubmitsToRun(client *github.Client, repo *github.Repository, pr *github.PullRequest) (run, skip []Presubmit, err error) {
+	presubmits := s.Config.Jobs.presubmitsFor(repo)
+	var files []string
+	for _, p := range presubmits {
+		if p.runIfChanged == nil {
+			continue
+		}
+		changed, err := listPullRequestFiles(client, repo.GetOwner().GetLogin(), repo.GetName(), pr.GetNumber())
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range changed {
+			files = append(files, f.GetFilename())
+		}
+		break
+	}
+	for _, p := range presubmits {
+		switch {
+		case p.runsFor(files):
+			run = append(run, p)
+		case p.runIfChanged != nil && !p.Optional:
+			skip = append(skip, p)
+		}
+	}
+	return run, skip, nil
+}
+
+// triggerPresubmitsPullRequest runs the presubmits of a PR that run by
+// themselves, and marks the required ones it doesn't need as skipped so
+// they don't block merging.
+func (s *Server) triggerPresubmitsPullRequest(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	run, skip, err := s.presubmitsToRun(client, repo, pr)
+	if err != nil {
+		logError(client, "fail to list files of %s#%d: %v", repo.GetFullName(), pr.GetNumber(), err)
+		return
+	}
+	for _, p := range skip {
+		createStatus(client, repo, pr.GetHead().GetSHA(), p.context(), "success", skippedDescription, "")
+	}
+	s.triggerPresubmits(client, repo, pr, run)
+}
+
+// triggerPresubmitsComment creates the presubmit jobs a /test or /retest
+// comment asks for: the named ones, those that run by themselves for
+// /test all, and the failed ones for /retest.
+func (s *Server) triggerPresubmitsComment(client *github.Client, repo *github.Repository, pr *github.PullRequest, comment string) {
+	presubmits := s.Config.Jobs.presubmitsFor(repo)
+	if len(presubmits) == 0 {
+		return
+	}
+	selected := map[string]bool{}
+	for _, m := range testReg.FindAllStringSubmatch(comment, -1) {
+		name := m[1]
+		if name == "" || name == "all" {
+			run, _, err := s.presubmitsToRun(client, repo, pr)
+			if err != nil {
+				logError(client, "fail to list files of %s#%d: %v", repo.GetFullName(), pr.GetNumber(), err)
+				return
+			}
+			for _, p := range run {
+				selected[p.Name] = true
+			}
+			continue
+		}
+		for _, p := range presubmits {
+			if name == p.Name || name == p.context() {
+				selected[p.Name] = true
+			}
+		}
+	}
+	if retestReg.MatchString(comment) {
+		failed, err := failedContexts(client, repo, pr.GetHead().GetSHA())
+		if err != nil {
+			logError(client, "fail to get statuses of %s@%s: %v", repo.GetFullName(), pr.GetHead().GetSHA(), err)
+			return
+		}
+		for _, p := range presubmits {
+			if failed[p.context()] {
+				selected[p.Name] = true
+			}
+		}
+	}
+	var run []Presubmit
+	for _, p := range presubmits {
+		if selected[p.Name] {
+			run = append(run, p)
+		}
+	}
+	s.triggerPresubmits(client, repo, pr, run)
+}
+
+// handlePostsubmits creates the postsubmit jobs of a push to a branch.
+func (s *Server) handlePostsubmits(push *github.PushEvent) {
+	if push.GetDeleted() {
+		return
+	}
+	org, name := push.GetRepo().GetOwner().GetLogin(), push.GetRepo().GetName()
+	if org == "" {
+		org = push.GetRepo().GetOwner().GetName()
+	}
+	branch := strings.TrimPrefix(push.GetRef(), "refs/heads/")
+	for _, p := range s.Config.Jobs.postsubmitsFor(org, name, branch) {
+		s.createJob(Job{
+			Type:     PostsubmitJob,
+			Name:     p.Name,
+			Context:  p.Name,
+			Labels:   p.Labels,
+			Spec:     *p.Spec,
+			Decorate: p.Decorate,
+			Refs:     Refs{Org: org, Repo: name, BaseRef: branch, BaseSHA: push.GetAfter()},
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const jobSchedulerInterval = time.Minute
+
+func init() {
+	registerPeriodic("job-scheduler", jobSchedulerInterval, func(c *Config) bool {
+		return len(c.Jobs.Periodics) > 0
+	}, (*Server).schedulePeriodics)
+}
+
+// schedulePeriodics creates the runs of the periodics that are due.
+func (s *Server) schedulePeriodics(client *github.Client) {
+	now := time.Now()
+	for _, p := range s.Config.Jobs.Periodics {
+		if s.periodicDue(p, now) {
+			s.createJob(Job{
+				Type:     PeriodicJob,
+				Name:     p.Name,
+				Context:  p.Name,
+				Labels:   p.Labels,
+				Spec:     *p.Spec,
+				Decorate: p.Decorate,
+			})
+		}
+	}
+}
+
+// periodicDue reports whether a periodic should run at now: when its last
+// run completed and its interval elapsed or its schedule fired since.
+func (s *Server) periodicDue(p Periodic, now time.Time) bool {
+	runs := s.listJobs(func(j *Job) bool { return j.Type == PeriodicJob && j.Name == p.Name })
+	if len(runs) == 0 {
+		if p.cron != nil {
+			// Never run yet: wait for the schedule rather than run at
+			// startup.
+			return p.cron.firedBetween(now.Add(-2*jobSchedulerInterval), now)
+		}
+		return true
+	}
+	last := runs[len(runs)-1]
+	if !last.Complete() {
+		return false
+	}
+	if p.cron != nil {
+		return p.cron.firedBetween(last.StartTime, now)
+	}
+	return now.Sub(last.StartTime) >= p.interval
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/github"
+)
+
+const defaultJokeURL = "https://official-joke-api.appspot.com/jokes/programming/random"
+
+// Joke is the config of the joke plugin.
+type Joke struct {
+	// URL is the API jokes come from. It must return a JSON object, or an
+	// array of them, with either a "joke" or a "setup" and a "punchline".
+	URL string `json:"url,omitempty"`
+}
+
+type joke struct {
+	Joke      string `json:"joke"`
+	Setup     string `json:"setup"`
+	Punchline string `json:"punchline"`
+}
+
+func (j joke) String() string {
+	if j.Joke != "" {
+		return j.Joke
+	}
+	if j.Setup == "" {
+		return ""
+	}
+	return j.Setup + "\n\n" + j.Punchline
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "joke",
+		Events:      []string{"issue_comment"},
+		Description: "The joke plugin comments with a programming joke. It is a minimal example of a plugin calling an external API.",
+		ConfigKey:   "joke",
+		Commands: []PluginCommand{{
+			Usage:       "/joke",
+			Description: "Tells a joke.",
+			WhoCanUse:   "Anyone",
+			Example:     "/joke",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleJoke replies with a joke.
+func (s *Server) handleJoke(client *github.Client, ic *github.IssueCommentEvent) {
+	url := s.Config.Joke.URL
+	if url == "" {
+		url = defaultJokeURL
+	}
+	var raw json.RawMessage
+	if err := getJSON(url, http.Header{"Accept": {ContentTypeJSON}}, &raw); err != nil {
+		logError(client, "fail to get a joke: %v", err)
+		return
+	}
+	var j joke
+	if err := json.Unmarshal(raw, &j); err != nil {
+		var jokes []joke
+		if err := json.Unmarshal(raw, &jokes); err != nil || len(jokes) == 0 {
+			logError(client, "fail to get a joke: unexpected response %s", raw)
+			return
+		}
+		j = jokes[0]
+	}
+	if j.String() == "" {
+		logError(client, "fail to get a joke: unexpected response %s", raw)
+		return
+	}
+	createComment(client, ic.Repo, ic.GetIssue().GetNumber(), fmt.Sprintf("@%s: %s", ic.GetComment().GetUser().GetLogin(), j))
+}
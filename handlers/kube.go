@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	inClusterTokenFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAFile        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// KubeConfig locates the Kubernetes API server. Unset fields default to the
+// service account of the pod the bot runs in.
+type KubeConfig struct {
+	APIServer string `json:"api_server,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+	CAFile    string `json:"ca_file,omitempty"`
+	// Namespace is the default namespace, the one of the pod by default.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// kubeClient serves the bot's uses of the Kubernetes API through a
+// clientset, exchanging the subsets of the objects the bot manages.
+type kubeClient struct {
+	clientset kubernetes.Interface
+	namespace string
+}
+
+// kubeNotFound is returned when the requested object doesn't exist.
+type kubeNotFound struct {
+	path string
+}
+
+func (e kubeNotFound) Error() string {
+	return fmt.Sprintf("%s not found", e.path)
+}
+
+// kubeConflict is returned when an object changed since it was read, or
+// already exists.
+type kubeConflict struct {
+	path string
+}
+
+func (e kubeConflict) Error() string {
+	return fmt.Sprintf("%s changed meanwhile", e.path)
+}
+
+func newKubeClient(c KubeConfig) (*kubeClient, error) {
+	var config *rest.Config
+	if c.APIServer == "" {
+		var err error
+		if config, err = rest.InClusterConfig(); err != nil {
+			return nil, fmt.Errorf("no api_server configured and not running in a cluster: %v", err)
+		}
+	} else {
+		config = &rest.Config{Host: c.APIServer}
+	}
+	// The service account of the pod fills in what isn't configured.
+	if c.TokenFile == "" && config.BearerTokenFile == "" {
+		c.TokenFile = inClusterTokenFile
+	}
+	if c.CAFile == "" && config.TLSClientConfig.CAFile == "" {
+		c.CAFile = inClusterCAFile
+	}
+	if c.TokenFile != "" {
+		token, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read token: %v", err)
+		}
+		config.BearerToken = strings.TrimSpace(string(token))
+		config.BearerTokenFile = c.TokenFile
+	}
+	if c.CAFile != "" {
+		config.TLSClientConfig.CAFile = c.CAFile
+	}
+	config.Timeout = time.Minute
+	if c.Namespace == "" {
+		ns, err := ioutil.ReadFile(inClusterNamespaceFile)
+		if err != nil {
+			c.Namespace = "default"
+		} else {
+			c.Namespace = strings.TrimSpace(string(ns))
+		}
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kubeClient{clientset: clientset, namespace: c.Namespace}, nil
+}
+
+// kubeError turns the API errors callers tell apart into kubeNotFound and
+// kubeConflict.
+func kubeError(err error, path string) error {
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsNotFound(err):
+		return kubeNotFound{path}
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return kubeConflict{path}
+	}
+	return err
+}
+
+// convertKube copies in into out, a subset of the bot and the API type of
+// an object or the reverse. The subsets keep the JSON of the API, which is
+// also how jobs configure their pods.
+func convertKube(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// ConfigMap is the subset of a Kubernetes ConfigMap the bot manages.
+type ConfigMap struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   ObjectMeta        `json:"metadata"`
+	Data       map[string]string `json:"data,omitempty"`
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+}
+
+// ObjectMeta is the subset of Kubernetes object metadata the bot uses.
+type ObjectMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	CreationTimestamp *time.Time        `json:"creationTimestamp,omitempty"`
+}
+
+func (k *kubeClient) getConfigMap(namespace, name string) (ConfigMap, error) {
+	var cm ConfigMap
+	got, err := k.clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return cm, kubeError(err, "configmap "+namespace+"/"+name)
+	}
+	err = convertKube(got, &cm)
+	return cm, err
+}
+
+func (k *kubeClient) createConfigMap(cm ConfigMap) error {
+	cm.APIVersion, cm.Kind = "v1", "ConfigMap"
+	var in corev1.ConfigMap
+	if err := convertKube(cm, &in); err != nil {
+		return err
+	}
+	_, err := k.clientset.CoreV1().ConfigMaps(in.Namespace).Create(context.Background(), &in, metav1.CreateOptions{})
+	return kubeError(err, "configmap "+in.Namespace+"/"+in.Name)
+}
+
+func (k *kubeClient) replaceConfigMap(cm ConfigMap) error {
+	cm.APIVersion, cm.Kind = "v1", "ConfigMap"
+	var in corev1.ConfigMap
+	if err := convertKube(cm, &in); err != nil {
+		return err
+	}
+	_, err := k.clientset.CoreV1().ConfigMaps(in.Namespace).Update(context.Background(), &in, metav1.UpdateOptions{})
+	return kubeError(err, "configmap "+in.Namespace+"/"+in.Name)
+}
+
+// Pod is the subset of a Kubernetes Pod the bot runs jobs in.
+type Pod struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       PodSpec    `json:"spec"`
+	Status     PodStatus  `json:"status,omitempty"`
+}
+
+// PodSpec is the subset of a pod spec jobs can configure.
+type PodSpec struct {
+	InitContainers        []Container       `json:"initContainers,omitempty"`
+	Containers            []Container       `json:"containers"`
+	Volumes               []Volume          `json:"volumes,omitempty"`
+	RestartPolicy         string            `json:"restartPolicy,omitempty"`
+	ServiceAccountName    string            `json:"serviceAccountName,omitempty"`
+	NodeSelector          map[string]string `json:"nodeSelector,omitempty"`
+	ActiveDeadlineSeconds *int64            `json:"activeDeadlineSeconds,omitempty"`
+}
+
+// Container is the subset of a container spec jobs can configure.
+type Container struct {
+	Name         string               `json:"name"`
+	Image        string               `json:"image"`
+	Command      []string             `json:"command,omitempty"`
+	Args         []string             `json:"args,omitempty"`
+	WorkingDir   string               `json:"workingDir,omitempty"`
+	Env          []EnvVar             `json:"env,omitempty"`
+	VolumeMounts []VolumeMount        `json:"volumeMounts,omitempty"`
+	Resources    ResourceRequirements `json:"resources,omitempty"`
+}
+
+// EnvVar is an environment variable of a container, with a literal value.
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ResourceRequirements are the requests and limits of a container, e.g.
+// {"cpu": "2", "memory": "4Gi"}.
+type ResourceRequirements struct {
+	Limits   map[string]string `json:"limits,omitempty"`
+	Requests map[string]string `json:"requests,omitempty"`
+}
+
+// Volume is a volume of a pod, with the sources jobs use.
+type Volume struct {
+	Name      string                 `json:"name"`
+	EmptyDir  *EmptyDirVolumeSource  `json:"emptyDir,omitempty"`
+	Secret    *SecretVolumeSource    `json:"secret,omitempty"`
+	ConfigMap *ConfigMapVolumeSource `json:"configMap,omitempty"`
+	HostPath  *HostPathVolumeSource  `json:"hostPath,omitempty"`
+}
+
+type EmptyDirVolumeSource struct {
+	Medium string `json:"medium,omitempty"`
+}
+
+type SecretVolumeSource struct {
+	SecretName string `json:"secretName"`
+}
+
+type ConfigMapVolumeSource struct {
+	Name string `json:"name"`
+}
+
+type HostPathVolumeSource struct {
+	Path string `json:"path"`
+}
+
+// VolumeMount mounts a volume of the pod into a container.
+type VolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+	SubPath   string `json:"subPath,omitempty"`
+}
+
+// PodStatus is the subset of the status of a pod the bot follows jobs by.
+type PodStatus struct {
+	Phase     string     `json:"phase,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Message   string     `json:"message,omitempty"`
+	StartTime *time.Time `json:"startTime,omitempty"`
+}
+
+// Pod phases.
+const (
+	PodPending   = "Pending"
+	PodRunning   = "Running"
+	PodSucceeded = "Succeeded"
+	PodFailed    = "Failed"
+	PodUnknown   = "Unknown"
+)
+
+func (k *kubeClient) createPod(p Pod) (Pod, error) {
+	p.APIVersion, p.Kind = "v1", "Pod"
+	var in corev1.Pod
+	if err := convertKube(p, &in); err != nil {
+		return Pod{}, err
+	}
+	var created Pod
+	got, err := k.clientset.CoreV1().Pods(in.Namespace).Create(context.Background(), &in, metav1.CreateOptions{})
+	if err != nil {
+		return created, kubeError(err, "pod "+in.Namespace+"/"+in.Name)
+	}
+	err = convertKube(got, &created)
+	return created, err
+}
+
+func (k *kubeClient) getPod(namespace, name string) (Pod, error) {
+	var p Pod
+	got, err := k.clientset.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return p, kubeError(err, "pod "+namespace+"/"+name)
+	}
+	err = convertKube(got, &p)
+	return p, err
+}
+
+// listPods returns the pods of namespace matching a label selector.
+func (k *kubeClient) listPods(namespace string, selector map[string]string) ([]Pod, error) {
+	list, err := k.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return nil, kubeError(err, "pods of "+namespace)
+	}
+	pods := make([]Pod, 0, len(list.Items))
+	for i := range list.Items {
+		var p Pod
+		if err := convertKube(&list.Items[i], &p); err != nil {
+			return nil, err
+		}
+		pods = append(pods, p)
+	}
+	return pods, nil
+}
+
+// podLog returns the log of a container of a pod.
+func (k *kubeClient) podLog(namespace, name, container string) ([]byte, error) {
+	log, err := k.clientset.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{Container: container}).DoRaw(context.Background())
+	return log, kubeError(err, "pod "+namespace+"/"+name)
+}
+
+func (k *kubeClient) deletePod(namespace, name string) error {
+	err := k.clientset.CoreV1().Pods(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	return kubeError(err, "pod "+namespace+"/"+name)
+}
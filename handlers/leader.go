@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	defaultLeaderLockName = "ci-bot-leader"
+	defaultStateName      = "ci-bot-state"
+	defaultLeaseSeconds   = 60
+	leaderHolderKey       = "ci-bot.leader"
+	leaderRenewKey        = "ci-bot.leader-renew-time"
+)
+
+// LeaderElection makes a single replica of the bot run periodic tasks,
+// holding a lease recorded on a ConfigMap. The replicas then share what the
+// periodic tasks work on, e.g. jobs and the CI builds being polled, in
+// another ConfigMap, see modifyState.
+type LeaderElection struct {
+	Enabled bool       `json:"enabled,omitempty"`
+	Kube    KubeConfig `json:"kube,omitempty"`
+	// Namespace of the ConfigMap, the default namespace of Kube by default.
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the ConfigMap, ci-bot-leader by default.
+	Name string `json:"name,omitempty"`
+	// LeaseSeconds the leader holds the lease without renewing it, 60 by
+	// default.
+	LeaseSeconds int `json:"lease_seconds,omitempty"`
+	// StateName is the ConfigMap of the state the replicas share,
+	// ci-bot-state by default.
+	StateName string `json:"state_name,omitempty"`
+}
+
+// leader is the leadership of this replica.
+var leader = struct {
+	sync.Mutex
+	kube     *kubeClient
+	identity string
+	leading  bool
+	// renewed is when the lease was last taken or renewed.
+	renewed time.Time
+}{}
+
+func (c LeaderElection) lease() time.Duration {
+	if c.LeaseSeconds == 0 {
+		return defaultLeaseSeconds * time.Second
+	}
+	return time.Duration(c.LeaseSeconds) * time.Second
+}
+
+// isLeader reports whether this replica runs the periodic tasks, always
+// true without leader election. The leader stays so until its lease
+// expires, even when it fails to renew it: no other replica takes over
+// before.
+func (s *Server) isLeader() bool {
+	c := s.Config.LeaderElection
+	if !c.Enabled {
+		return true
+	}
+	leader.Lock()
+	defer leader.Unlock()
+	return leader.leading && time.Since(leader.renewed) < c.lease()
+}
+
+// runLeaderElection takes and renews the lease a few times per lease
+// duration, in its own goroutine, so that the lease doesn't depend on how
+// long the periodic tasks take.
+func (s *Server) runLeaderElection() {
+	c := s.Config.LeaderElection
+	if !c.Enabled {
+		return
+	}
+	go func() {
+		for {
+			s.electLeader()
+			time.Sleep(c.lease() / 3)
+		}
+	}()
+}
+
+// electLeader takes or renews the lease once.
+func (s *Server) electLeader() {
+	c := s.Config.LeaderElection
+	start := time.Now()
+	leading, err := s.acquireLease(c.lease())
+	leader.Lock()
+	defer leader.Unlock()
+	if err != nil {
+		// Still leading until the lease expires, see isLeader.
+		glog.Errorf("fail to acquire leader lease: %v", err)
+		return
+	}
+	if leading != leader.leading {
+		glog.Infof("leader election: leading is now %v", leading)
+	}
+	leader.leading = leading
+	if leading {
+		leader.renewed = start
+	}
+}
+
+// leaderKube returns the client of the cluster holding the lease, and the
+// namespace and name of its ConfigMap.
+func leaderKube(c LeaderElection) (*kubeClient, string, error) {
+	leader.Lock()
+	defer leader.Unlock()
+	if leader.kube == nil {
+		k, err := newKubeClient(c.Kube)
+		if err != nil {
+			return nil, "", err
+		}
+		host, _ := os.Hostname()
+		leader.kube = k
+		leader.identity = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	namespace := c.Namespace
+	if namespace == "" {
+		namespace = leader.kube.namespace
+	}
+	return leader.kube, namespace, nil
+}
+
+// acquireLease takes or renews the lease if it's ours or expired.
+func (s *Server) acquireLease(lease time.Duration) (bool, error) {
+	c := s.Config.LeaderElection
+	kube, namespace, err := leaderKube(c)
+	if err != nil {
+		return false, err
+	}
+	name := c.Name
+	if name == "" {
+		name = defaultLeaderLockName
+	}
+	now := time.Now().UTC()
+	leader.Lock()
+	identity := leader.identity
+	leader.Unlock()
+	annotations := map[string]string{leaderHolderKey: identity, leaderRenewKey: now.Format(time.RFC3339)}
+
+	cm, err := kube.getConfigMap(namespace, name)
+	if _, ok := err.(kubeNotFound); ok {
+		cm = ConfigMap{Metadata: ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations}}
+		if err := kube.createConfigMap(cm); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	holder := cm.Metadata.Annotations[leaderHolderKey]
+	renewed, _ := time.Parse(time.RFC3339, cm.Metadata.Annotations[leaderRenewKey])
+	if holder != identity && now.Sub(renewed) < lease {
+		return false, nil
+	}
+	// The resource version makes the update fail if another replica got
+	// the lease meanwhile.
+	cm.Metadata.Annotations = annotations
+	if err := kube.replaceConfigMap(cm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	lgtmCommentMarker = "<!-- ci-bot:lgtm -->"
+	// teamMembershipTTL is how long team memberships are cached.
+	teamMembershipTTL = 10 * time.Minute
+)
+
+var treeHashReg = regexp.MustCompile(`Git tree hash: ([0-9a-f]{40})`)
+
+// Lgtm is the config of the lgtm plugin for a set of repos.
+type Lgtm struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos []string `json:"repos"`
+	// StoreTreeHash records the git tree hash of the PR when it gets the
+	// lgtm label. New commits then keep the label as long as the tree stays
+	// the same, i.e. when a PR is only squashed or rebased without changes.
+	StoreTreeHash bool `json:"store_tree_hash,omitempty"`
+	// StickyLgtmTeam is the slug of a team of the org whose members keep
+	// the lgtm label of their PRs when pushing new commits: the name of the
+	// team on Gitea, of a group on Gerrit.
+	StickyLgtmTeam string `json:"sticky_lgtm_team,omitempty"`
+	// ReviewActsAsLgtm makes approving GitHub reviews add the lgtm label
+	// and reviews requesting changes remove it.
+	ReviewActsAsLgtm bool `json:"review_acts_as_lgtm,omitempty"`
+}
+
+type teamMembership struct {
+	member  bool
+	expires time.Time
+}
+
+// teamMemberships caches team memberships by org/team/login, pushes come in
+// bursts and the lookup takes two API calls.
+var teamMemberships = struct {
+	sync.Mutex
+	cache map[string]teamMembership
+}{cache: map[string]teamMembership{}}
+
+// isTeamMember reports whether login is an active member of the team of org
+// with the given slug.
+func isTeamMember(client *github.Client, org, slug, login string) (bool, error) {
+	key := org + "/" + slug + "/" + login
+	teamMemberships.Lock()
+	cached, ok := teamMemberships.cache[key]
+	teamMemberships.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.member, nil
+	}
+
+	member, err := scmFor(client).IsTeamMember(org, slug, login)
+	if err != nil {
+		return false, err
+	}
+
+	teamMemberships.Lock()
+	teamMemberships.cache[key] = teamMembership{member: member, expires: time.Now().Add(teamMembershipTTL)}
+	teamMemberships.Unlock()
+	return member, nil
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "lgtm",
+		Events:      []string{"issue_comment", "pull_request", "pull_request_review"},
+		Description: "The lgtm plugin manages the application and removal of the '" + lgtmLabel + "' label, which is typically used to gate merging. New commits remove the label, except on PRs of members of the sticky lgtm team.",
+		ConfigKey:   "lgtm",
+		Commands: []PluginCommand{{
+			Usage:       "/lgtm [cancel]",
+			Description: "Adds or removes the '" + lgtmLabel + "' label which is typically used to gate merging.",
+			WhoCanUse:   "Collaborators of the repo, or only the reviewers and approvers of the changed files in repos skipping collaborators. The PR author can /lgtm cancel but not /lgtm.",
+			Example:     "/lgtm\n/lgtm cancel",
+		}, {
+			Usage:       "Submit an approving or a changes requesting GitHub review",
+			Description: "Adds or removes the '" + lgtmLabel + "' label in repos with review_acts_as_lgtm.",
+			WhoCanUse:   "Those who can /lgtm, other than the PR author.",
+		}},
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, l := range c.Lgtm {
+			enabled = append(enabled, l.Repos...)
+		}
+		return enabled
+	})
+}
+
+// lgtmFor returns the lgtm config of a repo, repo entries taking precedence
+// over org ones.
+func (c *Config) lgtmFor(org, repo string) (Lgtm, bool) {
+	var orgConfig *Lgtm
+	for i, l := range c.Lgtm {
+		if stringInSlice(org+"/"+repo, l.Repos) {
+			return l, true
+		}
+		if orgConfig == nil && stringInSlice(org, l.Repos) {
+			orgConfig = &c.Lgtm[i]
+		}
+	}
+	if orgConfig != nil {
+		return *orgConfig, true
+	}
+	return Lgtm{}, false
+}
+
+// validateLgtm rejects what the code hosts of the orgs configured can't
+// do: Bitbucket and Gerrit have no tree hashes, Bitbucket no teams.
+func (c *Config) validateLgtm() error {
+	for _, l := range c.Lgtm {
+		for _, r := range l.Repos {
+			org := strings.SplitN(r, "/", 2)[0]
+			p, ok := c.Providers[org]
+			if !ok {
+				continue
+			}
+			if l.StoreTreeHash && (p.Type == ProviderBitbucket || p.Type == ProviderGerrit) {
+				return fmt.Errorf("lgtm: store_tree_hash isn't supported by %s, the provider of %s", p.Type, r)
+			}
+			if l.StickyLgtmTeam != "" && p.Type == ProviderBitbucket {
+				return fmt.Errorf("lgtm: sticky_lgtm_team isn't supported by %s, the provider of %s", p.Type, r)
+			}
+		}
+	}
+	return nil
+}
+
+// canLgtm reports whether login can lgtm PR number of repo: its
+// collaborators can, or in repos skipping collaborators the reviewers and
+// approvers of the changed files in OWNERS.
+func (s *Server) canLgtm(client *github.Client, repo *github.Repository, number int, login string) (bool, error) {
+	org := repo.GetOwner().GetLogin()
+	if !s.Config.skipCollaborators(org, repo.GetName()) {
+		return isCollaborator(client, repo, login)
+	}
+	pr, err := scmFor(client).GetPullRequest(org, repo.GetName(), number)
+	if err != nil {
+		return false, err
+	}
+	owners, err := s.repoOwners(client, repo, pr.GetBase().GetSHA())
+	if err != nil {
+		return false, err
+	}
+	files, err := listPullRequestFiles(client, org, repo.GetName(), number)
+	if err != nil {
+		return false, err
+	}
+	login = strings.ToLower(login)
+	for _, f := range files {
+		if stringInSlice(login, owners.Reviewers(f.GetFilename())) || stringInSlice(login, owners.Approvers(f.GetFilename())) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// treeHash returns the hash of the git tree of a commit.
+func treeHash(client *github.Client, repo *github.Repository, sha string) (string, error) {
+	return scmFor(client).GetTreeHash(repo.GetOwner().GetLogin(), repo.GetName(), sha)
+}
+
+// handleLgtm handles the /lgtm command.
+func (s *Server) handleLgtm(client *github.Client, ic *github.IssueCommentEvent) {
+	if !ic.GetIssue().IsPullRequest() {
+		return
+	}
+	if _, ok := s.Config.lgtmFor(ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName()); !ok {
+		return
+	}
+	number := ic.GetIssue().GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+	author := ic.GetIssue().GetUser().GetLogin()
+	cancel := lgtmCancelReg.MatchString(ic.GetComment().GetBody())
+
+	if login == author && !cancel {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: you cannot LGTM your own PR.", login))
+		return
+	}
+	if login != author {
+		trusted, err := s.canLgtm(client, ic.Repo, number, login)
+		if err != nil {
+			logError(client, "fail to check if %s can lgtm: %v", login, err)
+			return
+		}
+		if !trusted {
+			who := "collaborators"
+			if s.Config.skipCollaborators(ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName()) {
+				who = "reviewers and approvers in OWNERS"
+			}
+			createComment(client, ic.Repo, number, fmt.Sprintf("@%s: changing LGTM is restricted to %s.", login, who))
+			return
+		}
+	}
+	s.setLgtm(client, ic.Repo, number, !cancel, issueLabels(ic.GetIssue()))
+}
+
+// handleLgtmReview treats approving reviews as /lgtm and reviews requesting
+// changes as /lgtm cancel.
+func (s *Server) handleLgtmReview(client *github.Client, re *github.PullRequestReviewEvent) {
+	repo := re.Repo
+	if config, _ := s.Config.lgtmFor(repo.GetOwner().GetLogin(), repo.GetName()); !config.ReviewActsAsLgtm {
+		return
+	}
+	var lgtm bool
+	switch re.GetReview().GetState() {
+	case "approved":
+		lgtm = true
+	case "changes_requested":
+		lgtm = false
+	default:
+		return
+	}
+	pr := re.GetPullRequest()
+	login := re.GetReview().GetUser().GetLogin()
+	if login == pr.GetUser().GetLogin() {
+		return
+	}
+	trusted, err := s.canLgtm(client, repo, pr.GetNumber(), login)
+	if err != nil {
+		logError(client, "fail to check if %s can lgtm: %v", login, err)
+		return
+	}
+	if !trusted {
+		return
+	}
+	s.setLgtm(client, repo, pr.GetNumber(), lgtm, pr.Labels)
+}
+
+// setLgtm adds or removes the lgtm label, recording the tree hash of the PR
+// when configured to.
+func (s *Server) setLgtm(client *github.Client, repo *github.Repository, number int, lgtm bool, labels []*github.Label) {
+	labeled := hasLabel(labels, lgtmLabel)
+	if !lgtm {
+		if labeled {
+			removeLabel(client, repo, number, lgtmLabel)
+		}
+		return
+	}
+	if labeled {
+		return
+	}
+	if addLabel(client, repo, number, lgtmLabel) != nil {
+		return
+	}
+	if config, _ := s.Config.lgtmFor(repo.GetOwner().GetLogin(), repo.GetName()); !config.StoreTreeHash {
+		return
+	}
+	pr, err := scmFor(client).GetPullRequest(repo.GetOwner().GetLogin(), repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to get PR %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	hash, err := treeHash(client, repo, pr.GetHead().GetSHA())
+	if err != nil {
+		if !s.reportUnsupported(client, repo, number, "lgtm", err) {
+			logError(client, "fail to get tree hash of %s@%s: %v", repo.GetFullName(), pr.GetHead().GetSHA(), err)
+		}
+		return
+	}
+	s.upsertComment(client, repo, number, lgtmCommentMarker, fmt.Sprintf("%s\n%s\nLGTM label has been added.\n\n<details>Git tree hash: %s</details>", lgtmCommentMarker, transientMarker("lgtm"), hash))
+}
+
+// handleLgtmSynchronize removes the lgtm label from PRs receiving new
+// commits, unless the tree is the one recorded when the label was added or
+// the author is in the sticky lgtm team.
+func (s *Server) handleLgtmSynchronize(client *github.Client, pe *github.PullRequestEvent) {
+	pr := pe.GetPullRequest()
+	if !hasLabel(pr.Labels, lgtmLabel) {
+		return
+	}
+	repo := pe.Repo
+	number := pr.GetNumber()
+	config, ok := s.Config.lgtmFor(repo.GetOwner().GetLogin(), repo.GetName())
+	if !ok {
+		return
+	}
+	if config.StickyLgtmTeam != "" {
+		member, err := isTeamMember(client, repo.GetOwner().GetLogin(), config.StickyLgtmTeam, pr.GetUser().GetLogin())
+		switch {
+		case err == nil && member:
+			return
+		case s.reportUnsupported(client, repo, number, "lgtm", err):
+		case err != nil:
+			logError(client, "fail to check if %s is in team %s: %v", pr.GetUser().GetLogin(), config.StickyLgtmTeam, err)
+		}
+	}
+	// Without a tree hash the label goes, as if the tree changed.
+	hash := ""
+	if config.StoreTreeHash {
+		var err error
+		hash, err = treeHash(client, repo, pr.GetHead().GetSHA())
+		if err != nil && !s.reportUnsupported(client, repo, number, "lgtm", err) {
+			logError(client, "fail to get tree hash of %s@%s: %v", repo.GetFullName(), pr.GetHead().GetSHA(), err)
+			return
+		}
+	}
+	if hash != "" {
+		comments, err := listIssueComments(client, repo.GetOwner().GetLogin(), repo.GetName(), number)
+		if err != nil {
+			logError(client, "fail to list comments of %s#%d: %v", repo.GetFullName(), number, err)
+			return
+		}
+		for _, c := range comments {
+			if c.GetUser().GetLogin() != s.BotName {
+				continue
+			}
+			if m := treeHashReg.FindStringSubmatch(c.GetBody()); m != nil && m[1] == hash {
+				return
+			}
+		}
+	}
+	if removeLabel(client, repo, number, lgtmLabel) != nil {
+		return
+	}
+	s.upsertComment(client, repo, number, lgtmCommentMarker, lgtmCommentMarker+"\n"+transientMarker("lgtm")+"\nNew changes are detected. LGTM label has been removed.")
+}
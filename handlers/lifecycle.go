@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// lifecycleLabels are mutually exclusive, an issue or PR is in at most one
+// lifecycle state at a time.
+var lifecycleLabels = []string{lifecycleStaleLabel, lifecycleRottenLabel, lifecycleFrozenLabel}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "lifecycle",
+		Events:      []string{"issue_comment"},
+		Description: "The lifecycle plugin flags and unflags issues and PRs as frozen, stale or rotten, keeping at most one lifecycle label on each.",
+		Commands: []PluginCommand{{
+			Usage:       "/[remove-]lifecycle <frozen|stale|rotten>",
+			Description: "Flags an issue or PR as frozen/stale/rotten, replacing any other lifecycle state. Frozen issues and PRs are never marked stale by automation.",
+			WhoCanUse:   "Anyone can trigger this command.",
+			Example:     "/lifecycle frozen\n/remove-lifecycle stale",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleLifecycle applies or removes lifecycle labels.
+func (s *Server) handleLifecycle(client *github.Client, ic *github.IssueCommentEvent) {
+	number := ic.GetIssue().GetNumber()
+	labels := issueLabels(ic.GetIssue())
+	for _, m := range lifecycleReg.FindAllStringSubmatch(ic.GetComment().GetBody(), -1) {
+		label := "lifecycle/" + strings.ToLower(m[2])
+		if m[1] != "" {
+			if hasLabel(labels, label) {
+				removeLabel(client, ic.Repo, number, label)
+			}
+			continue
+		}
+		if hasLabel(labels, label) {
+			continue
+		}
+		for _, other := range lifecycleLabels {
+			if other != label && hasLabel(labels, other) {
+				removeLabel(client, ic.Repo, number, other)
+			}
+		}
+		addLabel(client, ic.Repo, number, label)
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// lockReasons are the reasons GitHub knows of for locking a conversation.
+var lockReasons = []string{"off-topic", "too heated", "resolved", "spam"}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "lock",
+		Events:      []string{"issue_comment"},
+		Description: "Locks and unlocks the conversation of issues and PRs, leaving a comment with who did it and why.",
+		Commands: []PluginCommand{{
+			Usage:       "/lock [reason]",
+			Description: "Locks the conversation. Reasons GitHub knows of (" + strings.Join(lockReasons, ", ") + ") are also shown on the lock.",
+			WhoCanUse:   "Collaborators of the repo.",
+			Example:     "/lock too heated",
+		}, {
+			Usage:       "/unlock",
+			Description: "Unlocks the conversation.",
+			WhoCanUse:   "Collaborators of the repo.",
+			Example:     "/unlock",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleLock locks or unlocks the conversation of an issue or PR.
+func (s *Server) handleLock(client *github.Client, ic *github.IssueCommentEvent) {
+	issue := ic.GetIssue()
+	number := issue.GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+	m := lockReg.FindStringSubmatch(ic.GetComment().GetBody())
+	unlock := m[1] != ""
+	reason := strings.TrimSpace(m[2])
+	if unlock == !issue.GetLocked() {
+		return
+	}
+
+	ok, err := isCollaborator(client, ic.Repo, login)
+	if err != nil {
+		logError(client, "fail to check whether %s is a collaborator of %s: %v", login, ic.Repo.GetFullName(), err)
+		return
+	}
+	verb := "lock"
+	if unlock {
+		verb = "unlock"
+	}
+	if !ok {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Only collaborators can %s conversations.", login, verb))
+		return
+	}
+
+	scm := scmFor(client)
+	owner, name := ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName()
+	body := fmt.Sprintf("This conversation was unlocked by @%s.", login)
+	if unlock {
+		err = scm.UnlockIssue(owner, name, number)
+	} else {
+		lockReason := ""
+		if stringInSlice(strings.ToLower(reason), lockReasons) {
+			lockReason = strings.ToLower(reason)
+		}
+		err = scm.LockIssue(owner, name, number, lockReason)
+		body = fmt.Sprintf("This conversation was locked by @%s.", login)
+		if reason != "" {
+			body = fmt.Sprintf("This conversation was locked by @%s: %s", login, reason)
+		}
+	}
+	if err != nil {
+		logError(client, "fail to %s %s#%d: %v", verb, ic.Repo.GetFullName(), number, err)
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Failed to %s this conversation.", login, verb))
+		return
+	}
+	createComment(client, ic.Repo, number, body)
+	s.recordAudit(AuditRecord{
+		Repo:   ic.Repo.GetFullName(),
+		Number: number,
+		Action: verb,
+		Actor:  login,
+		Detail: reason,
+	})
+}
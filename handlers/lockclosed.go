@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	lockClosedInterval = 6 * time.Hour
+	defaultLockComment = "This issue has been closed for a while and is now locked. If you are still affected, please open a new issue and link back to this one."
+)
+
+// LockClosed is the config of the periodic locking of closed issues and PRs.
+type LockClosed struct {
+	// Repos are the orgs and org/repos processed.
+	Repos []string `json:"repos,omitempty"`
+	// ExcludedRepos are org/repos of Repos' orgs opting out.
+	ExcludedRepos []string `json:"excluded_repos,omitempty"`
+	// Days closed issues and PRs stay unlocked.
+	Days int `json:"days,omitempty"`
+	// Comment is posted before locking, a standard "please open a new
+	// issue" by default.
+	Comment string `json:"comment,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "lock-closed",
+		Description: "Periodically locks issues and PRs that have been closed for the configured number of days, after a comment pointing to opening a new issue instead.",
+		ConfigKey:   "lock_closed",
+	}, func(c *Config) []string {
+		return c.LockClosed.Repos
+	})
+	registerPeriodic("lock-closed", lockClosedInterval, func(c *Config) bool {
+		return len(c.LockClosed.Repos) > 0 && c.LockClosed.Days > 0
+	}, (*Server).processLockClosed)
+}
+
+// processLockClosed locks the issues and PRs closed for long enough.
+func (s *Server) processLockClosed(client *github.Client) {
+	c := s.Config.LockClosed
+	query := []string{"is:closed", "is:unlocked", fmt.Sprintf("closed:<%s", time.Now().AddDate(0, 0, -c.Days).Format("2006-01-02"))}
+	query = append(query, searchScopes(c.Repos, c.ExcludedRepos)...)
+	issues, err := searchIssues(client, strings.Join(query, " "))
+	if err != nil {
+		logError(client, "fail to search closed issues to lock: %v", err)
+		return
+	}
+	comment := c.Comment
+	if comment == "" {
+		comment = defaultLockComment
+	}
+
+	for _, issue := range issues {
+		repo, err := searchResultRepo(issue)
+		if err != nil {
+			logError(client, "fail to get the repo of a search result: %v", err)
+			continue
+		}
+		number := issue.GetNumber()
+		if createComment(client, repo, number, comment) != nil {
+			continue
+		}
+		if err := scmFor(client).LockIssue(repo.GetOwner().GetLogin(), repo.GetName(), number, "resolved"); err != nil {
+			logError(client, "fail to lock %s#%d: %v", repo.GetFullName(), number, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is a family of time series served on /metrics in the Prometheus
+// text format.
+type metric interface {
+	write(w io.Writer)
+}
+
+// metrics are the metrics the plugins registered.
+var metrics = struct {
+	sync.Mutex
+	all []metric
+}{}
+
+func registerMetric(m metric) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.all = append(metrics.all, m)
+}
+
+// serveMetrics serves the metrics in the Prometheus text format.
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Lock()
+	all := append([]metric(nil), metrics.all...)
+	metrics.Unlock()
+	for _, m := range all {
+		m.write(w)
+	}
+}
+
+// metricVec is a counter or gauge, with a value per combination of its
+// labels.
+type metricVec struct {
+	sync.Mutex
+	name, help, kind string
+	labels           []string
+	values           map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	m := &metricVec{name: name, help: help, kind: "counter", labels: labels, values: map[string]float64{}}
+	registerMetric(m)
+	return m
+}
+
+func newGaugeVec(name, help string, labels ...string) *metricVec {
+	m := &metricVec{name: name, help: help, kind: "gauge", labels: labels, values: map[string]float64{}}
+	registerMetric(m)
+	return m
+}
+
+// add adds v to the value of the labels.
+func (m *metricVec) add(v float64, labels ...string) {
+	m.Lock()
+	defer m.Unlock()
+	m.values[seriesKey(labels)] += v
+}
+
+func (m *metricVec) inc(labels ...string) {
+	m.add(1, labels...)
+}
+
+// set sets the value of the labels, for gauges.
+func (m *metricVec) set(v float64, labels ...string) {
+	m.Lock()
+	defer m.Unlock()
+	m.values[seriesKey(labels)] = v
+}
+
+// reset drops every value, for gauges recomputed from scratch.
+func (m *metricVec) reset() {
+	m.Lock()
+	defer m.Unlock()
+	m.values = map[string]float64{}
+}
+
+// expire drops the series at zero whose labels keep rejects, for gauges
+// of things that went away.
+func (m *metricVec) expire(keep func(labels []string) bool) {
+	m.Lock()
+	defer m.Unlock()
+	for key, v := range m.values {
+		if v == 0 && !keep(strings.Split(key, "\xff")) {
+			delete(m.values, key)
+		}
+	}
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, key := range sortedSeries(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, key, ""), formatValue(m.values[key]))
+	}
+}
+
+// defaultBuckets are the upper bounds of histograms of durations in
+// seconds.
+var defaultBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// histogramVec counts observations in buckets, per combination of its
+// labels.
+type histogramVec struct {
+	sync.Mutex
+	name, help string
+	labels     []string
+	buckets    []float64
+	series     map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+	registerMetric(h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, labels ...string) {
+	h.Lock()
+	defer h.Unlock()
+	key := seriesKey(labels)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatValue(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), s.count)
+	}
+}
+
+// seriesKey joins the values of the labels of a series.
+func seriesKey(labels []string) string {
+	return strings.Join(labels, "\xff")
+}
+
+func sortedSeries(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats the labels of a series, with the le label of a
+// histogram bucket if any.
+func formatLabels(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			if i < len(names) {
+				pairs = append(pairs, fmt.Sprintf(`%s="%s"`, names[i], labelEscaper.Replace(v)))
+			}
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=%q", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-github/github"
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "milestoneapplier",
+		Events:      []string{"pull_request"},
+		Description: "The milestoneapplier plugin sets the milestone of PRs from the branch they target, when they are opened or retargeted.",
+		ConfigKey:   "milestone_applier",
+	}, func(c *Config) []string {
+		var enabled []string
+		for repo := range c.MilestoneApplier {
+			enabled = append(enabled, repo)
+		}
+		return enabled
+	})
+}
+
+// pullRequestRetargeted reads whether an edited pull_request payload
+// changed the base branch, which the vendored go-github doesn't know about
+// yet.
+func pullRequestRetargeted(body []byte) bool {
+	var payload struct {
+		Changes struct {
+			Base *struct {
+				Ref struct {
+					From string `json:"from"`
+				} `json:"ref"`
+			} `json:"base"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	return payload.Changes.Base != nil
+}
+
+// findMilestone returns the number of the milestone of repo with the given
+// title.
+func findMilestone(client *github.Client, repo *github.Repository, title string) (int, error) {
+	milestones, err := scmFor(client).ListMilestones(repo.GetOwner().GetLogin(), repo.GetName())
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range milestones {
+		if m.GetTitle() == title {
+			return m.GetNumber(), nil
+		}
+	}
+	return 0, fmt.Errorf("there is no milestone %q", title)
+}
+
+// handleMilestoneApplier sets the milestone of a PR to the one of its base
+// branch. It is only called when the PR is opened or retargeted, so that a
+// milestone set by hand afterwards is kept.
+func (s *Server) handleMilestoneApplier(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	title, ok := s.Config.MilestoneApplier[repo.GetFullName()][pr.GetBase().GetRef()]
+	if !ok || pr.GetMilestone().GetTitle() == title {
+		return
+	}
+	number, err := findMilestone(client, repo, title)
+	if err != nil {
+		logError(client, "fail to find milestone of %s for %s: %v", repo.GetFullName(), pr.GetBase().GetRef(), err)
+		return
+	}
+	err = scmFor(client).EditIssue(repo.GetOwner().GetLogin(), repo.GetName(), pr.GetNumber(), &github.IssueRequest{Milestone: &number})
+	if err != nil {
+		logError(client, "fail to set milestone of %s#%d: %v", repo.GetFullName(), pr.GetNumber(), err)
+	}
+}
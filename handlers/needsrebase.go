@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"ci-bot/commentpruner"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const (
+	needsRebaseMarker = "<!-- ci-bot:needs-rebase -->"
+	// mergeabilityRetries bounds the wait for GitHub to compute whether a PR
+	// merges cleanly, which it does asynchronously after a push.
+	mergeabilityRetries = 5
+	// needsRebaseWorkers bounds the PRs rechecked at once after a push to
+	// their base branch.
+	needsRebaseWorkers = 5
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "needs-rebase",
+		Events:      []string{"pull_request", "push"},
+		Description: "The needs-rebase plugin manages the '" + needsRebaseLabel + "' label by removing it from PRs when they are mergeable and adding it when they are not. It also explains how to rebase in a comment that is removed once the PR merges cleanly again.",
+	}, enabledEverywhere)
+}
+
+// handleNeedsRebasePush rechecks the open PRs against the pushed branch, the
+// push may have brought conflicts in. PRs are rechecked concurrently as each
+// may wait for GitHub to compute its mergeability.
+func (s *Server) handleNeedsRebasePush(client *github.Client, push *github.PushEvent) {
+	if push.GetDeleted() {
+		return
+	}
+	scm := scmFor(client)
+	owner, name := push.GetRepo().GetOwner().GetName(), push.GetRepo().GetName()
+	if owner == "" {
+		owner = push.GetRepo().GetOwner().GetLogin()
+	}
+	branch := strings.TrimPrefix(push.GetRef(), "refs/heads/")
+	repo, err := scm.GetRepo(owner, name)
+	if err != nil {
+		logError(client, "fail to get repo %s/%s: %v", owner, name, err)
+		return
+	}
+
+	prs, err := scm.ListPullRequests(owner, name, branch)
+	if err != nil {
+		logError(client, "fail to list PRs of %s against %s: %v", repo.GetFullName(), branch, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, needsRebaseWorkers)
+	defer wg.Wait()
+	for _, pr := range prs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(number int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			defer recoverPlugin("needs-rebase", "push", client)
+			s.handleNeedsRebase(client, repo, number)
+		}(pr.GetNumber())
+	}
+}
+
+// handleNeedsRebase labels a PR with conflicts and unlabels it once they are
+// resolved.
+func (s *Server) handleNeedsRebase(client *github.Client, repo *github.Repository, number int) {
+	var pr *github.PullRequest
+	for i := 0; i < mergeabilityRetries; i++ {
+		var err error
+		pr, err = scmFor(client).GetPullRequest(repo.GetOwner().GetLogin(), repo.GetName(), number)
+		if err != nil {
+			logError(client, "fail to get PR %s#%d: %v", repo.GetFullName(), number, err)
+			return
+		}
+		if pr.Mergeable != nil {
+			break
+		}
+		time.Sleep(time.Duration(i+1) * 2 * time.Second)
+	}
+	if pr.Mergeable == nil {
+		glog.Infof("mergeability of %s#%d is still unknown", repo.GetFullName(), number)
+		return
+	}
+
+	labeled := hasLabel(pr.Labels, needsRebaseLabel)
+	if pr.GetMergeable() {
+		if labeled {
+			removeLabel(client, repo, number, needsRebaseLabel)
+		}
+		s.commentPruner(client, repo, number).PruneComments(commentpruner.HasMarker(needsRebaseMarker))
+		return
+	}
+	if labeled {
+		return
+	}
+	addLabel(client, repo, number, needsRebaseLabel)
+	s.upsertComment(client, repo, number, needsRebaseMarker, needsRebaseMarker+"\n"+transientMarker("needs-rebase")+"\n"+
+		"@"+pr.GetUser().GetLogin()+": PR needs rebase.\n\n"+
+		"It conflicts with `"+pr.GetBase().GetRef()+"`. Rebase it with `git fetch upstream && git rebase upstream/"+pr.GetBase().GetRef()+"`, resolve the conflicts and force push.")
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// The formats of outbound webhooks with a default template.
+const (
+	outboundFormatTeams      = "teams"
+	outboundFormatDiscord    = "discord"
+	outboundFormatMattermost = "mattermost"
+)
+
+var outboundTemplates = map[string]string{
+	outboundFormatTeams:      `{"@type": "MessageCard", "@context": "https://schema.org/extensions", "text": {{json .Summary}}}`,
+	outboundFormatDiscord:    `{"content": {{json .Summary}}}`,
+	outboundFormatMattermost: `{"text": {{json .Summary}}}`,
+}
+
+const outboundAttempts = 3
+
+// OutboundWebhook POSTs JSON rendered from the events of Repos to URL,
+// e.g. to post them to a Teams, Discord or Mattermost channel.
+type OutboundWebhook struct {
+	URL string `json:"url"`
+	// Repos are the orgs and org/repos whose events are sent, all by
+	// default.
+	Repos []string `json:"repos,omitempty"`
+	// Events are the types of the events sent, as in the proxy plugin, or
+	// type.action, e.g. pull_request.closed.
+	Events []string `json:"events"`
+	// Format is teams, discord or mattermost, for a default Template
+	// posting a summary of the event.
+	Format string `json:"format,omitempty"`
+	// Template is a Go template of the JSON posted, executed on the
+	// ProxyEvent, whose Summary is a line of text describing it. The json
+	// function quotes a string.
+	Template string `json:"template,omitempty"`
+
+	template *template.Template
+}
+
+var outboundFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func (o OutboundWebhook) appliesTo(event *ProxyEvent) bool {
+	if !stringInSlice(event.Type, o.Events) && !stringInSlice(event.Type+"."+event.Action, o.Events) {
+		return false
+	}
+	return len(o.Repos) == 0 || stringInSlice(event.Org, o.Repos) || stringInSlice(event.Org+"/"+event.Repo, o.Repos)
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "outbound-webhook",
+		Events:      webhookEventTypes,
+		Description: "The outbound-webhook plugin posts templated JSON to the configured webhook URLs, e.g. of Teams, Discord or Mattermost channels, for the configured types of events, retrying failed deliveries.",
+		ConfigKey:   "outbound_webhooks",
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, o := range c.OutboundWebhooks {
+			if len(o.Repos) == 0 {
+				return []string{"*"}
+			}
+			enabled = append(enabled, o.Repos...)
+		}
+		return enabled
+	})
+}
+
+func (c *Config) validateOutboundWebhooks() error {
+	for i := range c.OutboundWebhooks {
+		o := &c.OutboundWebhooks[i]
+		if o.URL == "" || len(o.Events) == 0 {
+			return fmt.Errorf("outbound_webhooks %d: url and events must be set", i)
+		}
+		for _, e := range o.Events {
+			if !stringInSlice(strings.SplitN(e, ".", 2)[0], proxyEvents) {
+				return fmt.Errorf("outbound_webhooks %d: unknown event %q", i, e)
+			}
+		}
+		text := o.Template
+		if text == "" {
+			var ok bool
+			if text, ok = outboundTemplates[o.Format]; !ok {
+				return fmt.Errorf("outbound_webhooks %d: template or a format of teams, discord or mattermost must be set", i)
+			}
+		}
+		t, err := template.New("outbound").Funcs(outboundFuncs).Parse(text)
+		if err != nil {
+			return fmt.Errorf("outbound_webhooks %d: invalid template: %v", i, err)
+		}
+		o.template = t
+	}
+	return nil
+}
+
+// sendOutboundWebhooks posts the event to every outbound webhook it
+// applies to.
+func (s *Server) sendOutboundWebhooks(event *ProxyEvent) {
+	data := struct {
+		*ProxyEvent
+		Summary string
+	}{event, eventSummary(event)}
+	for _, o := range s.Config.OutboundWebhooks {
+		if !o.appliesTo(event) {
+			continue
+		}
+		var body bytes.Buffer
+		if err := o.template.Execute(&body, data); err != nil {
+			glog.Errorf("fail to render %s event for %s: %v", event.Type, o.URL, err)
+			continue
+		}
+		go func(url string, body []byte) {
+			for attempt := 1; ; attempt++ {
+				err := postOutboundWebhook(url, body)
+				if err == nil {
+					return
+				}
+				if attempt == outboundAttempts {
+					glog.Errorf("fail to post %s event %s to %s: %v", event.Type, event.ID, url, err)
+					return
+				}
+				time.Sleep(time.Duration(attempt*attempt) * 5 * time.Second)
+			}
+		}(o.URL, body.Bytes())
+	}
+}
+
+func postOutboundWebhook(url string, body []byte) error {
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Post(url, ContentTypeJSON, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// eventSummary describes an event in a line of text.
+func eventSummary(e *ProxyEvent) string {
+	repo := e.Org + "/" + e.Repo
+	switch {
+	case e.Comment != nil:
+		return fmt.Sprintf("%s commented on %s#%d: %s", e.Comment.Author, repo, e.Issue.Number, e.Comment.URL)
+	case e.Review != nil:
+		return fmt.Sprintf("%s reviewed %s#%d (%s): %s", e.Review.Author, repo, e.Issue.Number, e.Review.State, e.Issue.URL)
+	case e.Issue != nil && e.Label != "":
+		return fmt.Sprintf("%s %s %s#%d %s: %s", e.Sender, e.Action, repo, e.Issue.Number, e.Label, e.Issue.Title)
+	case e.Issue != nil:
+		action := e.Action
+		if e.Issue.Merged && action == "closed" {
+			action = "merged"
+		}
+		return fmt.Sprintf("%s %s %s#%d: %s %s", e.Sender, action, repo, e.Issue.Number, e.Issue.Title, e.Issue.URL)
+	case e.Push != nil:
+		return fmt.Sprintf("%s pushed %s to %s of %s", e.Sender, e.Push.After, strings.TrimPrefix(e.Push.Ref, "refs/heads/"), repo)
+	case e.Status != nil:
+		return fmt.Sprintf("%s is %s on %s@%s: %s %s", e.Status.Context, e.Status.State, repo, e.Status.SHA, e.Status.Description, e.Status.TargetURL)
+	}
+	return fmt.Sprintf("%s event on %s", e.Type, repo)
+}
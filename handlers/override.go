@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "override",
+		Events:      []string{"issue_comment"},
+		Description: "The override plugin allows repo admins and top-level approvers to force a github status context to pass. It is an escape hatch for jobs that cannot pass for reasons unrelated to the PR, every use is recorded in a comment and the audit log.",
+		Commands: []PluginCommand{{
+			Usage:       "/override <context>",
+			Description: "Forces a failing github status context to success. The rest of the comment is recorded as the reason.",
+			WhoCanUse:   "Repo administrators and the approvers of the root OWNERS file.",
+			Example:     "/override ci/circleci: build\nThe job is broken on master, see #123.",
+		}},
+	}, enabledEverywhere)
+}
+
+// isRepoAdmin reports whether login administers repo.
+func isRepoAdmin(client *github.Client, repo *github.Repository, login string) (bool, error) {
+	level, err := scmFor(client).GetPermissionLevel(repo.GetOwner().GetLogin(), repo.GetName(), login)
+	if err != nil {
+		return false, err
+	}
+	return level == "admin", nil
+}
+
+// canOverride reports whether login administers repo or is an approver of
+// its root OWNERS file at the base of pr.
+func (s *Server) canOverride(client *github.Client, repo *github.Repository, pr *github.PullRequest, login string) (bool, error) {
+	admin, err := isRepoAdmin(client, repo, login)
+	if err != nil || admin {
+		return admin, err
+	}
+	owners, err := s.repoOwners(client, repo, pr.GetBase().GetSHA())
+	if err != nil {
+		return false, err
+	}
+	for _, a := range owners.TopLevelApprovers() {
+		if strings.EqualFold(a, login) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// handleOverride forces the requested status contexts of a PR to success.
+func (s *Server) handleOverride(client *github.Client, ic *github.IssueCommentEvent) {
+	if !ic.GetIssue().IsPullRequest() {
+		return
+	}
+	owner, repo := ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName()
+	number := ic.GetIssue().GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+
+	pr, err := scmFor(client).GetPullRequest(owner, repo, number)
+	if err != nil {
+		logError(client, "fail to get PR %s#%d: %v", ic.Repo.GetFullName(), number, err)
+		return
+	}
+	allowed, err := s.canOverride(client, ic.Repo, pr, login)
+	if err != nil {
+		logError(client, "fail to check whether %s can override on %s: %v", login, ic.Repo.GetFullName(), err)
+		return
+	}
+	if !allowed {
+		createComment(client, ic.Repo, number, "@"+login+": only repo administrators and top-level approvers can use `/override`.")
+		return
+	}
+
+	body := ic.GetComment().GetBody()
+	var contexts []string
+	for _, m := range overrideReg.FindAllStringSubmatch(body, -1) {
+		contexts = append(contexts, strings.TrimSpace(m[1]))
+	}
+	reason := strings.TrimSpace(overrideReg.ReplaceAllString(body, ""))
+	if reason == "" {
+		reason = "no reason given"
+	}
+
+	sha := pr.GetHead().GetSHA()
+	combined, err := scmFor(client).GetCombinedStatus(owner, repo, sha)
+	if err != nil {
+		logError(client, "fail to get statuses of %s@%s: %v", ic.Repo.GetFullName(), sha, err)
+		return
+	}
+	statuses := map[string]github.RepoStatus{}
+	for _, st := range combined.Statuses {
+		statuses[st.GetContext()] = st
+	}
+
+	var overridden, unknown []string
+	for _, c := range contexts {
+		st, ok := statuses[c]
+		if !ok {
+			unknown = append(unknown, "`"+c+"`")
+			continue
+		}
+		if st.GetState() == "success" {
+			continue
+		}
+		description := fmt.Sprintf("Overridden by %s", login)
+		if err := createStatus(client, ic.Repo, sha, c, "success", description, st.GetTargetURL()); err != nil {
+			continue
+		}
+		overridden = append(overridden, "`"+c+"`")
+		s.recordAudit(AuditRecord{
+			Repo:   ic.Repo.GetFullName(),
+			Number: number,
+			Action: "override",
+			Actor:  login,
+			Detail: fmt.Sprintf("%s at %s: %s", c, sha, reason),
+		})
+	}
+
+	var msg []string
+	if len(overridden) > 0 {
+		msg = append(msg, fmt.Sprintf("@%s overrode %s at %s.\n\nReason: %s", login, strings.Join(overridden, ", "), sha, reason))
+	}
+	if len(unknown) > 0 {
+		msg = append(msg, fmt.Sprintf("@%s: %s did not report on %s and cannot be overridden.", login, strings.Join(unknown, ", "), sha))
+	}
+	if len(msg) > 0 {
+		createComment(client, ic.Repo, number, strings.Join(msg, "\n\n"))
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"ci-bot/repoowners"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	ownersCacheSize = 500
+	ownersCacheTTL  = time.Hour
+)
+
+var (
+	// ownersCache keeps the OWNERS of the commits PRs are based on.
+	ownersCache = repoowners.NewCache(ownersCacheSize, ownersCacheTTL)
+
+	ownersCacheLookups   = newCounterVec("ci_bot_owners_cache_lookups_total", "Lookups of the OWNERS cache, by result.", "result")
+	ownersCacheEvictions = newCounterVec("ci_bot_owners_cache_evictions_total", "OWNERS dropped from the cache, by reason.", "reason")
+)
+
+func init() {
+	ownersCache.Observe(func(event string) {
+		switch event {
+		case repoowners.CacheHit, repoowners.CacheMiss:
+			ownersCacheLookups.inc(event)
+		case repoowners.CacheExpired, repoowners.CacheEvicted:
+			ownersCacheEvictions.inc(event)
+		}
+	})
+}
+
+// Owners is the config of how OWNERS files are read.
+type Owners struct {
+	// MDYAMLRepos are the orgs and org/repos whose markdown files can start
+	// with a YAML header, bracketed by '---' lines, configuring the OWNERS
+	// of the file alone.
+	MDYAMLRepos []string `json:"mdyamlrepos,omitempty"`
+	// SkipCollaborators are the orgs and org/repos whose approve and lgtm
+	// trust only OWNERS files, never the collaborators of the repo.
+	SkipCollaborators []string `json:"skip_collaborators,omitempty"`
+	// LabelsBlackList are the labels OWNERS files can't apply, e.g. the
+	// ones gating merges.
+	LabelsBlackList []string `json:"labels_blacklist,omitempty"`
+}
+
+// OwnersDirBlacklist are the directories, e.g. vendored or generated code,
+// whose OWNERS files are ignored along with those under them.
+type OwnersDirBlacklist struct {
+	// Repos maps an org or org/repo to the regexps of its blacklisted
+	// directories, matched against their path from the root of the repo.
+	Repos map[string][]string `json:"repos,omitempty"`
+	// Default are the regexps of the directories blacklisted in every repo.
+	Default []string `json:"default,omitempty"`
+
+	res map[string][]*regexp.Regexp
+}
+
+// ownersDirBlacklist returns the regexps of the blacklisted directories of
+// org/repo, the default ones and those of the org and of the repo.
+func (c *Config) ownersDirBlacklist(org, repo string) []*regexp.Regexp {
+	b := c.OwnersDirBlacklist
+	var res []*regexp.Regexp
+	for _, key := range []string{"", org, org + "/" + repo} {
+		res = append(res, b.res[key]...)
+	}
+	return res
+}
+
+func (c *Config) validateOwnersDirBlacklist() error {
+	b := &c.OwnersDirBlacklist
+	b.res = map[string][]*regexp.Regexp{}
+	compile := func(key string, patterns []string) error {
+		for _, p := range patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("owners_dir_blacklist: invalid regexp %q: %v", p, err)
+			}
+			b.res[key] = append(b.res[key], re)
+		}
+		return nil
+	}
+	// The default regexps are kept under "", which no org is named.
+	if err := compile("", b.Default); err != nil {
+		return err
+	}
+	for key, patterns := range b.Repos {
+		if err := compile(key, patterns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mdYAMLEnabled reports whether the markdown files of org/repo can carry
+// OWNERS config.
+func (c *Config) mdYAMLEnabled(org, repo string) bool {
+	return stringInSlice(org+"/"+repo, c.Owners.MDYAMLRepos) || stringInSlice(org, c.Owners.MDYAMLRepos)
+}
+
+// skipCollaborators reports whether only OWNERS files are trusted in
+// org/repo.
+func (c *Config) skipCollaborators(org, repo string) bool {
+	return stringInSlice(org+"/"+repo, c.Owners.SkipCollaborators) || stringInSlice(org, c.Owners.SkipCollaborators)
+}
+
+// repoOwners loads the OWNERS files of repo at sha.
+func (s *Server) repoOwners(client *github.Client, repo *github.Repository, sha string) (*repoowners.RepoOwners, error) {
+	return repoowners.NewClient(scmFor(client), s.Config.mdYAMLEnabled, s.Config.ownersDirBlacklist, ownersCache).LoadRepoOwners(repo.GetOwner().GetLogin(), repo.GetName(), sha)
+}
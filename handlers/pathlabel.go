@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/google/go-github/github"
+)
+
+// PathLabel maps regexps of file paths to the labels PRs changing matching
+// files get, e.g. "docs/.*" to "area/docs".
+type PathLabel struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos  []string          `json:"repos"`
+	Labels map[string]string `json:"labels"`
+
+	res map[string]*regexp.Regexp
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "path-label",
+		Events:      []string{"pull_request"},
+		Description: "The path-label plugin labels PRs based on the files they change, as configured by a map of file path regexps to labels. Labels are added when PRs are opened or receive new commits, and never removed.",
+		ConfigKey:   "path_label",
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, p := range c.PathLabel {
+			enabled = append(enabled, p.Repos...)
+		}
+		return enabled
+	})
+}
+
+// handlePathLabel adds the labels of the files changed by a PR.
+func (s *Server) handlePathLabel(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	org := repo.GetOwner().GetLogin()
+	var configs []PathLabel
+	for _, p := range s.Config.PathLabel {
+		if stringInSlice(repo.GetFullName(), p.Repos) || stringInSlice(org, p.Repos) {
+			configs = append(configs, p)
+		}
+	}
+	if len(configs) == 0 {
+		return
+	}
+	number := pr.GetNumber()
+	files, err := listPullRequestFiles(client, org, repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list files of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+
+	wanted := map[string]bool{}
+	for _, p := range configs {
+		for pattern, re := range p.res {
+			label := p.Labels[pattern]
+			if wanted[label] || hasLabel(pr.Labels, label) {
+				continue
+			}
+			for _, f := range files {
+				if re.MatchString(f.GetFilename()) {
+					wanted[label] = true
+					break
+				}
+			}
+		}
+	}
+	labels := make([]string, 0, len(wanted))
+	for l := range wanted {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		addLabel(client, repo, number, l)
+	}
+}
+
+func (c *Config) validatePathLabel() error {
+	for i, p := range c.PathLabel {
+		c.PathLabel[i].res = map[string]*regexp.Regexp{}
+		for pattern, label := range p.Labels {
+			if label == "" {
+				return fmt.Errorf("path_label %d: no label for %q", i, pattern)
+			}
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return fmt.Errorf("path_label %d: invalid regexp %q: %v", i, pattern, err)
+			}
+			c.PathLabel[i].res[pattern] = re
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// periodicTask is work the bot does on a schedule rather than in response
+// to a webhook.
+type periodicTask struct {
+	name     string
+	interval time.Duration
+	run      func(s *Server, client *github.Client)
+	// enabled tells whether the config turns the task on.
+	enabled func(c *Config) bool
+}
+
+// periodicTasks are the tasks registered by the plugins.
+var periodicTasks []periodicTask
+
+// registerPeriodic adds a task to run every interval, when enabled.
+func registerPeriodic(name string, interval time.Duration, enabled func(c *Config) bool, run func(s *Server, client *github.Client)) {
+	periodicTasks = append(periodicTasks, periodicTask{name: name, interval: interval, run: run, enabled: enabled})
+}
+
+// runPeriodics starts every enabled periodic task, each in its own
+// goroutine running first right away and then on its interval, with the
+// config last loaded. With leader election, only the leader runs them.
+func (s *Server) runPeriodics(client *github.Client) {
+	for _, t := range periodicTasks {
+		if !t.enabled(&s.Config) {
+			continue
+		}
+		glog.Infof("starting periodic task %s every %s", t.name, t.interval)
+		go func(t periodicTask) {
+			ticker := time.NewTicker(t.interval)
+			defer ticker.Stop()
+			for {
+				if s.isLeader() {
+					start := time.Now()
+					sp := startTrace("", "periodic "+t.name, spanKindInternal)
+					c := tracedClient(client, t.name, sp)
+					t.run(s.current(), c)
+					sp.finish()
+					releaseClient(c)
+					glog.Infof("periodic task %s done in %s", t.name, time.Since(start))
+				}
+				<-ticker.C
+			}
+		}(t)
+	}
+}
+
+// searchIssues returns every issue and PR matching a search query.
+func searchIssues(client *github.Client, query string) ([]github.Issue, error) {
+	issues, _, err := scmFor(client).SearchIssues(query, "updated", 0)
+	return issues, err
+}
+
+// searchScopes restricts a search to repos, orgs or org/repos, without
+// excluded org/repos.
+func searchScopes(repos, excluded []string) []string {
+	var scopes []string
+	for _, r := range repos {
+		if strings.Contains(r, "/") {
+			scopes = append(scopes, "repo:"+r)
+		} else {
+			scopes = append(scopes, "org:"+r)
+		}
+	}
+	for _, r := range excluded {
+		scopes = append(scopes, "-repo:"+r)
+	}
+	return scopes
+}
+
+// searchResultRepo returns the repo of an issue found by a search. Search
+// results don't carry the repository, only its API URL.
+func searchResultRepo(issue github.Issue) (*github.Repository, error) {
+	parts := strings.Split(issue.GetRepositoryURL(), "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("unexpected repository URL %q", issue.GetRepositoryURL())
+	}
+	owner, name := parts[len(parts)-2], parts[len(parts)-1]
+	return &github.Repository{
+		Owner:    &github.User{Login: github.String(owner)},
+		Name:     github.String(name),
+		FullName: github.String(owner + "/" + name),
+	}, nil
+}
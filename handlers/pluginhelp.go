@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// PluginHelp describes a plugin to the users of the bot.
+type PluginHelp struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Commands    []PluginCommand `json:"commands,omitempty"`
+	// Events lists the webhook events the plugin acts on.
+	Events []string `json:"events,omitempty"`
+	// ConfigKey is the top level key of the config the plugin reads.
+	ConfigKey string `json:"config_key,omitempty"`
+
+	// The following fields are filled in by the HelpAgent.
+	EnabledIn []string `json:"enabled_in"`
+	ConfigURL string   `json:"config_url,omitempty"`
+}
+
+// PluginCommand is a comment command understood by a plugin.
+type PluginCommand struct {
+	Usage       string `json:"usage"`
+	Description string `json:"description"`
+	WhoCanUse   string `json:"who_can_use,omitempty"`
+	Example     string `json:"example,omitempty"`
+}
+
+type pluginHelpProvider struct {
+	help PluginHelp
+	// enabledIn lists the orgs and org/repos the plugin acts on, "*" when
+	// it acts everywhere.
+	enabledIn func(c *Config) []string
+	// unconfigured is set for the plugins enabled everywhere without any
+	// config.
+	unconfigured bool
+}
+
+var pluginHelpProviders = map[string]pluginHelpProvider{}
+
+// registerPluginHelp is called by plugins from init to describe themselves.
+func registerPluginHelp(help PluginHelp, enabledIn func(c *Config) []string) {
+	unconfigured := stringInSlice("*", enabledIn(&Config{}))
+	pluginHelpProviders[help.Name] = pluginHelpProvider{help: help, enabledIn: enabledIn, unconfigured: unconfigured}
+}
+
+// enabledEverywhere is the enabledIn of plugins that need no configuration.
+// They act in the repos the config of any other plugin covers, see
+// repoConfigured.
+func enabledEverywhere(*Config) []string {
+	return []string{"*"}
+}
+
+// HelpAgent serves the catalog of plugin help. The catalog is built in the
+// background whenever config is loaded, so requests never wait on it.
+type HelpAgent struct {
+	mu      sync.RWMutex
+	catalog []PluginHelp
+}
+
+// Refresh rebuilds the catalog for config, read from the file at path.
+func (ha *HelpAgent) Refresh(config Config, path string) {
+	go func() {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			glog.Errorf("fail to read config for plugin help: %v", err)
+		}
+		lines := configKeyLines(raw)
+
+		catalog := make([]PluginHelp, 0, len(pluginHelpProviders))
+		for _, p := range pluginHelpProviders {
+			help := p.help
+			help.EnabledIn = p.enabledIn(&config)
+			sort.Strings(help.EnabledIn)
+			if line, ok := lines[help.ConfigKey]; ok && config.PluginHelpSourceURL != "" {
+				help.ConfigURL = fmt.Sprintf("%s#L%d", config.PluginHelpSourceURL, line)
+			}
+			catalog = append(catalog, help)
+		}
+		sort.Slice(catalog, func(i, j int) bool { return catalog[i].Name < catalog[j].Name })
+
+		ha.mu.Lock()
+		ha.catalog = catalog
+		ha.mu.Unlock()
+	}()
+}
+
+// ServeHTTP responds with the catalog as HTML to browsers, JSON otherwise.
+func (ha *HelpAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ha.mu.RLock()
+	catalog := ha.catalog
+	ha.mu.RUnlock()
+
+	if r.URL.Query().Get("format") == "html" || strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := pluginHelpTemplate.Execute(w, catalog); err != nil {
+			glog.Errorf("fail to render plugin help: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	if err := json.NewEncoder(w).Encode(catalog); err != nil {
+		glog.Errorf("fail to encode plugin help: %v", err)
+	}
+}
+
+// configKeyLines maps the top level keys of a JSON document to the line
+// they are declared on.
+func configKeyLines(raw []byte) map[string]int {
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	depth := 0
+	expectKey := false
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err != nil {
+			return lines
+		}
+		if d, ok := tok.(json.Delim); ok {
+			if d == '{' || d == '[' {
+				depth++
+			} else {
+				depth--
+			}
+			// Either the document was opened or a nested value just ended,
+			// both are followed by a key at the top level.
+			expectKey = depth == 1
+			continue
+		}
+		if depth != 1 {
+			continue
+		}
+		if !expectKey {
+			expectKey = true
+			continue
+		}
+		if key, ok := tok.(string); ok {
+			// offset points right after the previous token, the key
+			// itself starts at the next quote.
+			start := offset
+			if i := bytes.IndexByte(raw[offset:], '"'); i >= 0 {
+				start += int64(i)
+			}
+			lines[key] = bytes.Count(raw[:start], []byte("\n")) + 1
+		}
+		expectKey = false
+	}
+}
+
+var pluginHelpTemplate = template.Must(template.New("pluginhelp").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Plugin help</title></head>
+<body>
+<h1>Plugins</h1>
+{{range .}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+<p>{{.Description}}</p>
+<p>Enabled in: {{range $i, $e := .EnabledIn}}{{if $i}}, {{end}}<code>{{$e}}</code>{{else}}nowhere{{end}}</p>
+{{if .ConfigURL}}<p><a href="{{.ConfigURL}}">Configuration</a></p>{{end}}
+{{if .Commands}}
+<table>
+<tr><th>Command</th><th>Description</th><th>Who can use</th><th>Example</th></tr>
+{{range .Commands}}<tr><td><code>{{.Usage}}</code></td><td>{{.Description}}</td><td>{{.WhoCanUse}}</td><td><code>{{.Example}}</code></td></tr>
+{{end}}
+</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+var (
+	pluginInvocations = newCounterVec("ci_bot_plugin_invocations_total", "Invocations of the plugins by event.", "plugin", "event")
+	pluginDuration    = newHistogramVec("ci_bot_plugin_duration_seconds", "Time the plugins took to handle an event.", defaultBuckets, "plugin", "event")
+	pluginErrors      = newCounterVec("ci_bot_plugin_errors_total", "Invocations of the plugins by event that panicked or logged errors.", "plugin", "event")
+)
+
+// runPlugin runs the handler of plugin for an event and records it in the
+// plugin metrics. A panicking handler is logged, counted as an error and
+// reported rather than bringing the bot down, as are the errors it logs
+// with logError. The handler gets the client of the plugin, for its GitHub
+// requests to be recorded, and traced, as the plugin's.
+func (s *Server) runPlugin(plugin, event string, client *github.Client, handle func(client *github.Client)) {
+	start := time.Now()
+	parent, webhook := clientScope(client)
+	sp := parent.child("plugin "+plugin, spanKindInternal)
+	sp.setAttribute("plugin", plugin)
+	sp.setAttribute("event", event)
+	traced := tracedClient(client, plugin, sp)
+	// Clients traced for a span or a webhook are the run's own, the errors
+	// logged with them are the run's.
+	own := traced != client && (sp != nil || webhook != nil)
+	client = traced
+	if own {
+		pluginRuns.Lock()
+		pluginRuns.byClient[client] = &pluginRun{}
+		pluginRuns.Unlock()
+	}
+	defer func() {
+		pluginInvocations.inc(plugin, event)
+		pluginDuration.observe(time.Since(start).Seconds(), plugin, event)
+		if r := recover(); r != nil {
+			reportPluginPanic(plugin, event, webhook, sp, r)
+		}
+		if own {
+			pluginRuns.Lock()
+			run := pluginRuns.byClient[client]
+			delete(pluginRuns.byClient, client)
+			pluginRuns.Unlock()
+			reportPluginErrors(plugin, event, webhook, sp, run.errors)
+		}
+		sp.finish()
+		releaseClient(client)
+	}()
+	handle(client)
+}
+
+// pluginRuns are the plugin runs in progress on a client of their own,
+// collecting the errors their handlers log.
+var pluginRuns = struct {
+	sync.Mutex
+	byClient map[*github.Client]*pluginRun
+}{byClient: map[*github.Client]*pluginRun{}}
+
+type pluginRun struct {
+	errors []string
+}
+
+// logError logs an error of a handler making its requests with client. The
+// errors of a plugin run are reported by runPlugin once the handler is
+// done, the others right away, along the webhook and plugin of client.
+func logError(client *github.Client, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	glog.ErrorDepth(1, msg)
+	pluginRuns.Lock()
+	run, ok := pluginRuns.byClient[client]
+	if ok {
+		run.errors = append(run.errors, msg)
+	}
+	pluginRuns.Unlock()
+	if !ok {
+		reportError(msg, clientTags(client))
+	}
+}
+
+func reportPluginErrors(plugin, event string, webhook *webhookContext, sp *span, errors []string) {
+	if len(errors) == 0 {
+		return
+	}
+	pluginErrors.inc(plugin, event)
+	sp.setError(errors[0])
+	for _, msg := range errors {
+		tags := webhook.tags()
+		tags["plugin"], tags["event"] = plugin, event
+		reportError(msg, tags)
+	}
+}
+
+// recoverPlugin recovers a panic in a goroutine a plugin started to handle
+// an event, reporting it like runPlugin does. It must be deferred.
+func recoverPlugin(plugin, event string, client *github.Client) {
+	if r := recover(); r != nil {
+		sp, webhook := clientScope(client)
+		reportPluginPanic(plugin, event, webhook, sp, r)
+	}
+}
+
+func reportPluginPanic(plugin, event string, webhook *webhookContext, sp *span, r interface{}) {
+	pluginErrors.inc(plugin, event)
+	sp.setError(fmt.Sprintf("panic: %v", r))
+	glog.Errorf("plugin %s panicked on %s event: %v\n%s", plugin, event, r, debug.Stack())
+	tags := webhook.tags()
+	tags["plugin"], tags["event"] = plugin, event
+	reportPanic(r, debug.Stack(), tags)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"github.com/google/go-github/github"
+)
+
+const ponyURL = "https://theponyapi.com/api/v1/pony/random"
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "pony",
+		Events:      []string{"issue_comment"},
+		Description: "The pony plugin adds a pony image to an issue or PR in response to the `/pony` command.",
+		Commands: []PluginCommand{{
+			Usage:       "/pony",
+			Description: "Add a little pony image to the issue or PR.",
+			WhoCanUse:   "Anyone",
+			Example:     "/pony",
+		}},
+	}, enabledEverywhere)
+}
+
+// handlePony replies with a random pony picture.
+func (s *Server) handlePony(client *github.Client, ic *github.IssueCommentEvent) {
+	var pony struct {
+		Pony struct {
+			Representations struct {
+				Full  string `json:"full"`
+				Small string `json:"small"`
+			} `json:"representations"`
+		} `json:"pony"`
+	}
+	if err := getJSON(ponyURL, nil, &pony); err != nil {
+		logError(client, "fail to get a pony: %v", err)
+		return
+	}
+	url := pony.Pony.Representations.Small
+	if url == "" {
+		url = pony.Pony.Representations.Full
+	}
+	if url == "" {
+		logError(client, "fail to get a pony: no image returned")
+		return
+	}
+	createComment(client, ic.Repo, ic.GetIssue().GetNumber(), imageComment(ic.GetComment().GetUser().GetLogin(), url))
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import "fmt"
+
+// Preset is env and volumes declared once for every job whose labels
+// match, e.g. the credentials of a cloud account or a shared cache.
+type Preset struct {
+	// Labels a job must all have for the preset to apply.
+	Labels map[string]string `json:"labels"`
+	// Env is added to every container of the job.
+	Env []EnvVar `json:"env,omitempty"`
+	// Volumes are added to the pod of the job.
+	Volumes []Volume `json:"volumes,omitempty"`
+	// VolumeMounts are added to every container of the job.
+	VolumeMounts []VolumeMount `json:"volume_mounts,omitempty"`
+}
+
+// matches reports whether a job with labels gets the preset.
+func (p Preset) matches(labels map[string]string) bool {
+	for k, v := range p.Labels {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Config) validatePresets() error {
+	for i, p := range c.Jobs.Presets {
+		if len(p.Labels) == 0 {
+			// It would apply to every job.
+			return fmt.Errorf("jobs: preset %d has no labels", i)
+		}
+	}
+	return nil
+}
+
+// applyPresets returns spec with the env and volumes of the presets
+// matching labels added. Presets can't redefine what the spec or another
+// preset defines.
+func (c JobConfig) applyPresets(labels map[string]string, spec PodSpec) (PodSpec, error) {
+	spec.Volumes = append([]Volume{}, spec.Volumes...)
+	spec.Containers = append([]Container{}, spec.Containers...)
+	for i := range spec.Containers {
+		spec.Containers[i].Env = append([]EnvVar{}, spec.Containers[i].Env...)
+		spec.Containers[i].VolumeMounts = append([]VolumeMount{}, spec.Containers[i].VolumeMounts...)
+	}
+	for _, p := range c.Presets {
+		if !p.matches(labels) {
+			continue
+		}
+		for _, v := range p.Volumes {
+			for _, existing := range spec.Volumes {
+				if existing.Name == v.Name {
+					return spec, fmt.Errorf("volume %s defined twice", v.Name)
+				}
+			}
+			spec.Volumes = append(spec.Volumes, v)
+		}
+		for i := range spec.Containers {
+			container := &spec.Containers[i]
+			for _, e := range p.Env {
+				for _, existing := range container.Env {
+					if existing.Name == e.Name {
+						return spec, fmt.Errorf("env %s of container %s defined twice", e.Name, container.Name)
+					}
+				}
+				container.Env = append(container.Env, e)
+			}
+			for _, m := range p.VolumeMounts {
+				for _, existing := range container.VolumeMounts {
+					if existing.MountPath == m.MountPath {
+						return spec, fmt.Errorf("mount path %s of container %s defined twice", m.MountPath, container.Name)
+					}
+				}
+				container.VolumeMounts = append(container.VolumeMounts, m)
+			}
+		}
+	}
+	return spec, nil
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// ProjectConfig places the issues and PRs of an org or repo onto project
+// boards.
+type ProjectConfig struct {
+	// Columns are the boards and columns issues and PRs are put into
+	// automatically, the first matching rule wins.
+	Columns []ProjectColumnRule `json:"columns,omitempty"`
+}
+
+// ProjectColumnRule puts issues and PRs matching any of Labels, or set to
+// Milestone, into Column of the board Project.
+type ProjectColumnRule struct {
+	Project   string   `json:"project"`
+	Column    string   `json:"column"`
+	Labels    []string `json:"labels,omitempty"`
+	Milestone string   `json:"milestone,omitempty"`
+}
+
+func (r ProjectColumnRule) matches(labels []*github.Label, milestone string) bool {
+	if r.Milestone != "" && r.Milestone == milestone {
+		return true
+	}
+	for _, l := range r.Labels {
+		if hasLabel(labels, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "project",
+		Events:      []string{"issue_comment", "issues", "pull_request"},
+		Description: "The project plugin places issues and PRs onto GitHub project boards, on request or automatically based on their labels and milestone.",
+		ConfigKey:   "project",
+		Commands: []PluginCommand{{
+			Usage:       "/project <board> [column]",
+			Description: "Adds an issue or PR to a column of a project board of the repo or org, the first column by default. Issues and PRs already on the board are moved.",
+			WhoCanUse:   "Collaborators of the repo.",
+			Example:     "/project 0.5.0 To do",
+		}},
+	}, func(c *Config) []string {
+		var enabled []string
+		for k := range c.Project {
+			enabled = append(enabled, k)
+		}
+		return enabled
+	})
+}
+
+// projectConfigFor returns the project config of a repo, repo entries taking
+// precedence over org ones.
+func (c *Config) projectConfigFor(org, repo string) ProjectConfig {
+	if p, ok := c.Project[org+"/"+repo]; ok {
+		return p
+	}
+	return c.Project[org]
+}
+
+// findProjectColumn looks the board up in the repo, then in the org, and
+// returns its column, or its first one when column is empty.
+func findProjectColumn(client *github.Client, repo *github.Repository, board, column string) (*github.Project, *github.ProjectColumn, error) {
+	scm := scmFor(client)
+	projects, err := scm.ListProjects(repo.GetOwner().GetLogin(), repo.GetName())
+	if err != nil {
+		return nil, nil, err
+	}
+	var project *github.Project
+	for _, p := range projects {
+		if strings.EqualFold(p.GetName(), board) {
+			project = p
+			break
+		}
+	}
+	if project == nil {
+		return nil, nil, fmt.Errorf("there is no project board %q", board)
+	}
+
+	columns, err := scm.ListProjectColumns(project.GetID())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range columns {
+		if column == "" || strings.EqualFold(c.GetName(), column) {
+			return project, c, nil
+		}
+	}
+	if column == "" {
+		return nil, nil, fmt.Errorf("project board %q has no columns", board)
+	}
+	return nil, nil, fmt.Errorf("project board %q has no column %q", board, column)
+}
+
+// placeOnProject puts an issue or PR into column, moving its card if it is
+// on the board already.
+func placeOnProject(client *github.Client, repo *github.Repository, number int, project *github.Project, column *github.ProjectColumn) error {
+	scm := scmFor(client)
+	issue, err := scm.GetIssue(repo.GetOwner().GetLogin(), repo.GetName(), number)
+	if err != nil {
+		return err
+	}
+
+	columns, err := scm.ListProjectColumns(project.GetID())
+	if err != nil {
+		return err
+	}
+	for _, c := range columns {
+		cards, err := scm.ListProjectCards(c.GetID())
+		if err != nil {
+			return err
+		}
+		for _, card := range cards {
+			if card.GetContentURL() != issue.GetURL() {
+				continue
+			}
+			if c.GetID() == column.GetID() {
+				return nil
+			}
+			return scm.MoveProjectCard(card.GetID(), column.GetID())
+		}
+	}
+
+	content, contentType := issue.GetID(), "Issue"
+	if issue.IsPullRequest() {
+		pr, err := scm.GetPullRequest(repo.GetOwner().GetLogin(), repo.GetName(), number)
+		if err != nil {
+			return err
+		}
+		content, contentType = pr.GetID(), "PullRequest"
+	}
+	return scm.CreateProjectCard(column.GetID(), content, contentType)
+}
+
+// handleProject handles the /project command.
+func (s *Server) handleProject(client *github.Client, ic *github.IssueCommentEvent) {
+	number := ic.GetIssue().GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+	trusted, err := isCollaborator(client, ic.Repo, login)
+	if err != nil {
+		logError(client, "fail to check if %s is a collaborator: %v", login, err)
+		return
+	}
+	if !trusted {
+		createComment(client, ic.Repo, number, "@"+login+": only collaborators can use `/project`.")
+		return
+	}
+
+	for _, m := range projectReg.FindAllStringSubmatch(ic.GetComment().GetBody(), -1) {
+		project, column, err := findProjectColumn(client, ic.Repo, m[1], strings.TrimSpace(m[2]))
+		if err != nil {
+			createComment(client, ic.Repo, number, fmt.Sprintf("@%s: %v.", login, err))
+			continue
+		}
+		if err := placeOnProject(client, ic.Repo, number, project, column); err != nil {
+			logError(client, "fail to place %s#%d onto %s: %v", ic.Repo.GetFullName(), number, project.GetName(), err)
+		}
+	}
+}
+
+// handleProjectColumns places an issue or PR onto the board of the first
+// column rule it matches.
+func (s *Server) handleProjectColumns(client *github.Client, repo *github.Repository, number int, labels []*github.Label, milestone string) {
+	for _, r := range s.Config.projectConfigFor(repo.GetOwner().GetLogin(), repo.GetName()).Columns {
+		if !r.matches(labels, milestone) {
+			continue
+		}
+		project, column, err := findProjectColumn(client, repo, r.Project, r.Column)
+		if err != nil {
+			logError(client, "fail to find column %q of project %q: %v", r.Column, r.Project, err)
+			return
+		}
+		if err := placeOnProject(client, repo, number, project, column); err != nil {
+			logError(client, "fail to place %s#%d onto %s: %v", repo.GetFullName(), number, project.GetName(), err)
+		}
+		return
+	}
+}
+
+func (c *Config) validateProject() error {
+	for k, p := range c.Project {
+		for i, r := range p.Columns {
+			if r.Project == "" {
+				return fmt.Errorf("project %s: column rule %d has no project", k, i)
+			}
+			if len(r.Labels) == 0 && r.Milestone == "" {
+				return fmt.Errorf("project %s: column rule %d matches neither labels nor a milestone", k, i)
+			}
+		}
+	}
+	return nil
+}
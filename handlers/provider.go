@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/google/go-github/github"
+)
+
+// Providers of the repos the bot serves besides GitHub.
+const (
+	ProviderGitea     = "gitea"
+	ProviderBitbucket = "bitbucket"
+	ProviderGerrit    = "gerrit"
+)
+
+// Provider is the code host of the repos of an org hosted elsewhere than
+// on GitHub.
+type Provider struct {
+	// Type of the code host: gitea, bitbucket or gerrit.
+	Type string `json:"type"`
+	// URL of the instance, e.g. https://gitea.example.com. Bitbucket Cloud
+	// needs none.
+	URL string `json:"url,omitempty"`
+	// User the bot acts as, for Bitbucket, whose Token is an app password,
+	// and Gerrit, whose Token is an HTTP password.
+	User string `json:"user,omitempty"`
+	// Token of the account the bot acts as.
+	Token string `json:"token"`
+	// WebhookSecret signs the webhooks of the org, required for Gitea and
+	// Bitbucket. Gerrit is polled.
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+	// Projects are the Gerrit projects whose changes are polled, all by
+	// default.
+	Projects []string `json:"projects,omitempty"`
+	// TrustedGroup is the Gerrit group whose members count as
+	// collaborators.
+	TrustedGroup string `json:"trusted_group,omitempty"`
+}
+
+func (c *Config) validateProviders() error {
+	for org, p := range c.Providers {
+		switch p.Type {
+		case ProviderGitea:
+			if p.URL == "" || p.WebhookSecret == "" {
+				return fmt.Errorf("providers: url and webhook_secret must be set for %s", org)
+			}
+		case ProviderBitbucket:
+			if p.User == "" || p.WebhookSecret == "" {
+				return fmt.Errorf("providers: user and webhook_secret must be set for %s", org)
+			}
+		case ProviderGerrit:
+			if p.URL == "" || p.User == "" {
+				return fmt.Errorf("providers: url and user must be set for %s", org)
+			}
+		default:
+			return fmt.Errorf("providers: unknown type %q for %s", p.Type, org)
+		}
+		if p.Token == "" {
+			return fmt.Errorf("providers: token must be set for %s", org)
+		}
+	}
+	return nil
+}
+
+// providerClient returns the client of org when it's hosted elsewhere than
+// on GitHub, client otherwise. It's for the tasks not started by a webhook
+// of the org, e.g. reporting the jobs of its repos.
+func (s *Server) providerClient(org string, client *github.Client) *github.Client {
+	p, ok := s.Config.Providers[org]
+	if !ok {
+		return client
+	}
+	switch p.Type {
+	case ProviderGitea:
+		if c, err := giteaClient(org, p); err == nil {
+			return c
+		}
+	case ProviderBitbucket:
+		return bitbucketClient(org, p)
+	case ProviderGerrit:
+		c, _ := gerritClient(org, p)
+		return c
+	}
+	return client
+}
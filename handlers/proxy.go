@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// The types of the events re-emitted to external plugins.
+const (
+	proxyEventIssue        = "issue"
+	proxyEventIssueComment = "issue_comment"
+	proxyEventPullRequest  = "pull_request"
+	proxyEventReview       = "review"
+	proxyEventPush         = "push"
+	proxyEventStatus       = "status"
+)
+
+var proxyEvents = []string{proxyEventIssue, proxyEventIssueComment, proxyEventPullRequest, proxyEventReview, proxyEventPush, proxyEventStatus}
+
+// Proxy re-emits the webhooks of every provider to external plugins, as
+// events in the single schema of ProxyEvent, so that their authors don't
+// deal with the payloads of each provider.
+type Proxy struct {
+	Endpoints []ProxyEndpoint `json:"endpoints,omitempty"`
+	// Only stops the bot's own plugins from handling the events, making it
+	// a mere translation proxy.
+	Only bool `json:"only,omitempty"`
+}
+
+// ProxyEndpoint is an external plugin the events are POSTed to.
+type ProxyEndpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret signs the events, as the hex HMAC-SHA256 of the body in the
+	// X-CI-Bot-Signature header, prefixed by sha256=.
+	Secret string `json:"secret,omitempty"`
+	// Events are the types of the events sent, all by default.
+	Events []string `json:"events,omitempty"`
+	// Repos are the orgs and org/repos whose events are sent, all by
+	// default.
+	Repos []string `json:"repos,omitempty"`
+}
+
+func (e ProxyEndpoint) appliesTo(event *ProxyEvent) bool {
+	if len(e.Events) > 0 && !stringInSlice(event.Type, e.Events) {
+		return false
+	}
+	return len(e.Repos) == 0 || stringInSlice(event.Org, e.Repos) || stringInSlice(event.Org+"/"+event.Repo, e.Repos)
+}
+
+// ProxyEvent is an event of a provider, in the schema external plugins get
+// whichever provider it came from.
+type ProxyEvent struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Provider is github, gitea, bitbucket or gerrit.
+	Provider string `json:"provider"`
+	// Type is issue, issue_comment, pull_request, review, push or status.
+	Type   string `json:"type"`
+	Action string `json:"action,omitempty"`
+	Org    string `json:"org"`
+	Repo   string `json:"repo"`
+	Sender string `json:"sender,omitempty"`
+	// Label is the label added or removed by labeled and unlabeled actions.
+	Label   string        `json:"label,omitempty"`
+	Issue   *ProxyIssue   `json:"issue,omitempty"`
+	Comment *ProxyComment `json:"comment,omitempty"`
+	Review  *ProxyReview  `json:"review,omitempty"`
+	Push    *ProxyPush    `json:"push,omitempty"`
+	Status  *ProxyStatus  `json:"status,omitempty"`
+}
+
+// ProxyIssue is the issue or PR of an event.
+type ProxyIssue struct {
+	Number      int      `json:"number"`
+	PullRequest bool     `json:"pull_request"`
+	Title       string   `json:"title"`
+	Body        string   `json:"body,omitempty"`
+	Author      string   `json:"author"`
+	State       string   `json:"state"`
+	URL         string   `json:"url,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	// BaseRef, HeadSHA and Merged are only set for PRs.
+	BaseRef string `json:"base_ref,omitempty"`
+	HeadSHA string `json:"head_sha,omitempty"`
+	Merged  bool   `json:"merged,omitempty"`
+}
+
+type ProxyComment struct {
+	ID     int64  `json:"id"`
+	Body   string `json:"body"`
+	Author string `json:"author"`
+	URL    string `json:"url,omitempty"`
+}
+
+type ProxyReview struct {
+	// State is approved, changes_requested or commented.
+	State  string `json:"state"`
+	Body   string `json:"body,omitempty"`
+	Author string `json:"author"`
+}
+
+type ProxyPush struct {
+	Ref    string `json:"ref"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type ProxyStatus struct {
+	SHA         string `json:"sha"`
+	Context     string `json:"context"`
+	State       string `json:"state"`
+	Description string `json:"description,omitempty"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "proxy",
+		Events:      webhookEventTypes,
+		Description: "The proxy plugin re-emits the events of every code host to external plugins in a single JSON schema, signed with their secret. In proxy-only mode the bot's own plugins don't handle the events.",
+		ConfigKey:   "proxy",
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, e := range c.Proxy.Endpoints {
+			if len(e.Repos) == 0 {
+				return []string{"*"}
+			}
+			enabled = append(enabled, e.Repos...)
+		}
+		return enabled
+	})
+}
+
+func (c *Config) validateProxy() error {
+	for i, e := range c.Proxy.Endpoints {
+		if e.URL == "" {
+			return fmt.Errorf("proxy: endpoint %d needs a url", i)
+		}
+		for _, t := range e.Events {
+			if !stringInSlice(t, proxyEvents) {
+				return fmt.Errorf("proxy: unknown event %q for endpoint %d", t, i)
+			}
+		}
+	}
+	return nil
+}
+
+// proxyEvent sends the event of payload to the external plugins, the bus
+// and the outbound webhooks, and reports whether the bot's own plugins
+// should leave it alone.
+func (s *Server) proxyEvent(eventType string, payload []byte) bool {
+	if len(s.Config.Proxy.Endpoints) == 0 && s.Config.Bus.PublishSubject == "" && len(s.Config.OutboundWebhooks) == 0 {
+		return false
+	}
+	event, err := normalizeEvent(eventType, payload)
+	if err != nil {
+		glog.Errorf("fail to normalize %s event: %v", eventType, err)
+		return s.Config.Proxy.Only
+	}
+	event.Provider = "github"
+	if p, ok := s.Config.Providers[event.Org]; ok {
+		event.Provider = p.Type
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		glog.Errorf("fail to marshal: %v", err)
+		return s.Config.Proxy.Only
+	}
+	s.publishBusEvent(event, body)
+	s.sendOutboundWebhooks(event)
+	for _, e := range s.Config.Proxy.Endpoints {
+		if e.appliesTo(event) {
+			go func(e ProxyEndpoint) {
+				if err := deliverProxyEvent(e, event, body); err != nil {
+					glog.Errorf("fail to send %s event %s to %s: %v", event.Type, event.ID, e.URL, err)
+				}
+			}(e)
+		}
+	}
+	return s.Config.Proxy.Only
+}
+
+func deliverProxyEvent(e ProxyEndpoint, event *ProxyEvent, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	req.Header.Set("X-CI-Bot-Event", event.Type)
+	req.Header.Set("X-CI-Bot-Delivery", event.ID)
+	if e.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(e.Secret))
+		mac.Write(body)
+		req.Header.Set("X-CI-Bot-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// normalizeEvent translates the GitHub payload of an event, which every
+// provider's webhooks are translated to first, into a ProxyEvent.
+func normalizeEvent(eventType string, payload []byte) (*ProxyEvent, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	event := &ProxyEvent{ID: hex.EncodeToString(id), Time: time.Now(), Type: eventType}
+	setRepo := func(repo *github.Repository) {
+		event.Org, event.Repo = repo.GetOwner().GetLogin(), repo.GetName()
+	}
+
+	switch eventType {
+	case proxyEventIssue:
+		var ie github.IssuesEvent
+		if err := json.Unmarshal(payload, &ie); err != nil {
+			return nil, err
+		}
+		setRepo(ie.Repo)
+		event.Action, event.Sender = ie.GetAction(), ie.GetSender().GetLogin()
+		event.Label = ie.GetLabel().GetName()
+		event.Issue = proxyIssue(ie.GetIssue())
+	case proxyEventIssueComment:
+		var ic github.IssueCommentEvent
+		if err := json.Unmarshal(payload, &ic); err != nil {
+			return nil, err
+		}
+		setRepo(ic.Repo)
+		event.Action, event.Sender = ic.GetAction(), ic.GetSender().GetLogin()
+		event.Issue = proxyIssue(ic.GetIssue())
+		event.Comment = &ProxyComment{
+			ID:     ic.GetComment().GetID(),
+			Body:   ic.GetComment().GetBody(),
+			Author: ic.GetComment().GetUser().GetLogin(),
+			URL:    ic.GetComment().GetHTMLURL(),
+		}
+	case proxyEventPullRequest:
+		var pe github.PullRequestEvent
+		if err := json.Unmarshal(payload, &pe); err != nil {
+			return nil, err
+		}
+		setRepo(pe.Repo)
+		event.Action, event.Sender = pe.GetAction(), pe.GetSender().GetLogin()
+		event.Label = pe.GetLabel().GetName()
+		event.Issue = proxyPullRequest(pe.GetPullRequest())
+	case proxyEventReview:
+		var re github.PullRequestReviewEvent
+		if err := json.Unmarshal(payload, &re); err != nil {
+			return nil, err
+		}
+		setRepo(re.Repo)
+		event.Action, event.Sender = re.GetAction(), re.GetSender().GetLogin()
+		event.Issue = proxyPullRequest(re.GetPullRequest())
+		event.Review = &ProxyReview{
+			State:  strings.ToLower(re.GetReview().GetState()),
+			Body:   re.GetReview().GetBody(),
+			Author: re.GetReview().GetUser().GetLogin(),
+		}
+	case proxyEventPush:
+		var pe github.PushEvent
+		if err := json.Unmarshal(payload, &pe); err != nil {
+			return nil, err
+		}
+		full := strings.SplitN(pe.GetRepo().GetFullName(), "/", 2)
+		if len(full) == 2 {
+			event.Org, event.Repo = full[0], full[1]
+		}
+		event.Sender = pe.GetSender().GetLogin()
+		event.Push = &ProxyPush{Ref: pe.GetRef(), Before: pe.GetBefore(), After: pe.GetAfter()}
+	case proxyEventStatus:
+		var se github.StatusEvent
+		if err := json.Unmarshal(payload, &se); err != nil {
+			return nil, err
+		}
+		setRepo(se.Repo)
+		event.Sender = se.GetSender().GetLogin()
+		event.Status = &ProxyStatus{
+			SHA:         se.GetSHA(),
+			Context:     se.GetContext(),
+			State:       se.GetState(),
+			Description: se.GetDescription(),
+			TargetURL:   se.GetTargetURL(),
+		}
+	default:
+		return nil, fmt.Errorf("unknown event type %q", eventType)
+	}
+	return event, nil
+}
+
+func proxyIssue(issue *github.Issue) *ProxyIssue {
+	return &ProxyIssue{
+		Number:      issue.GetNumber(),
+		PullRequest: issue.IsPullRequest(),
+		Title:       issue.GetTitle(),
+		Body:        issue.GetBody(),
+		Author:      issue.GetUser().GetLogin(),
+		State:       issue.GetState(),
+		URL:         issue.GetHTMLURL(),
+		Labels:      labelNames(issueLabels(issue)),
+	}
+}
+
+func proxyPullRequest(pr *github.PullRequest) *ProxyIssue {
+	return &ProxyIssue{
+		Number:      pr.GetNumber(),
+		PullRequest: true,
+		Title:       pr.GetTitle(),
+		Body:        pr.GetBody(),
+		Author:      pr.GetUser().GetLogin(),
+		State:       pr.GetState(),
+		URL:         pr.GetHTMLURL(),
+		Labels:      labelNames(pr.Labels),
+		BaseRef:     pr.GetBase().GetRef(),
+		HeadSHA:     pr.GetHead().GetSHA(),
+		Merged:      pr.GetMerged(),
+	}
+}
+
+func labelNames(labels []*github.Label) []string {
+	var names []string
+	for _, l := range labels {
+		names = append(names, l.GetName())
+	}
+	return names
+}
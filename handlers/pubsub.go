@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	pubSubAPIURL        = "https://pubsub.googleapis.com/v1"
+	pubSubMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	// pubSubEventAttribute is the attribute of a message holding the
+	// X-GitHub-Event of the webhook in its data.
+	pubSubEventAttribute = "X-GitHub-Event"
+	// pubSubDeliveryAttribute is the optional attribute holding its
+	// X-GitHub-Delivery.
+	pubSubDeliveryAttribute = "X-GitHub-Delivery"
+)
+
+// PubSub is the config of the Cloud Pub/Sub subscriptions GitHub webhooks
+// are relayed through, for deployments GitHub can't deliver them to.
+type PubSub struct {
+	// Subscriptions maps a GCP project to the subscriptions pulled in it.
+	Subscriptions map[string][]string `json:"subscriptions,omitempty"`
+	// TokenFile holds the OAuth2 access token the subscriptions are pulled
+	// with. The token of the service account of the GCE metadata server is
+	// used by default.
+	TokenFile string `json:"token_file,omitempty"`
+	// MaxMessages pulled at once, 10 by default.
+	MaxMessages int `json:"max_messages,omitempty"`
+}
+
+type pubSubMessage struct {
+	AckID   string `json:"ackId"`
+	Message struct {
+		// Data is the webhook payload, base64 encoded.
+		Data       []byte            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
+		MessageID  string            `json:"messageId"`
+	} `json:"message"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "pubsub",
+		Description: "The pubsub plugin pulls GitHub webhooks relayed through Cloud Pub/Sub subscriptions and handles them as if GitHub had delivered them. Messages carry the payload as data and its event type in the X-GitHub-Event attribute.",
+		ConfigKey:   "pubsub",
+	}, func(c *Config) []string {
+		if len(c.PubSub.Subscriptions) > 0 {
+			return []string{"*"}
+		}
+		return nil
+	})
+}
+
+func (c *Config) validatePubSub() error {
+	for project, subs := range c.PubSub.Subscriptions {
+		if len(subs) == 0 {
+			return fmt.Errorf("pubsub: no subscriptions for %s", project)
+		}
+	}
+	if c.PubSub.MaxMessages < 0 {
+		return fmt.Errorf("pubsub: max_messages must be positive")
+	}
+	return nil
+}
+
+// runPubSub pulls every subscription until the bot exits. Messages are
+// only delivered to one puller, so every replica pulls.
+func (s *Server) runPubSub(client *github.Client) {
+	for project, subs := range s.Config.PubSub.Subscriptions {
+		for _, sub := range subs {
+			go s.pullPubSub(client, fmt.Sprintf("projects/%s/subscriptions/%s", project, sub))
+		}
+	}
+}
+
+func (s *Server) pullPubSub(client *github.Client, sub string) {
+	max := s.Config.PubSub.MaxMessages
+	if max == 0 {
+		max = 10
+	}
+	for {
+		var pulled struct {
+			ReceivedMessages []pubSubMessage `json:"receivedMessages"`
+		}
+		if err := s.pubSubRequest(sub+":pull", map[string]int{"maxMessages": max}, &pulled); err != nil {
+			logError(client, "fail to pull %s: %v", sub, err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+		// Messages are acked once queued for their handler. The others are
+		// nacked for Pub/Sub to redeliver them, or move them to the
+		// dead-letter topic of the subscription.
+		var acks, nacks []string
+		for _, m := range pulled.ReceivedMessages {
+			eventType := m.Message.Attributes[pubSubEventAttribute]
+			event, err := github.ParseWebHook(eventType, m.Message.Data)
+			if err != nil {
+				logError(client, "fail to parse message %s of %s: %v", m.Message.MessageID, sub, err)
+				nacks = append(nacks, m.AckID)
+				continue
+			}
+			if err := s.dispatchEvent(event, eventType, m.Message.Attributes[pubSubDeliveryAttribute], m.Message.Data, client); err != nil {
+				logError(client, "fail to dispatch message %s of %s: %v", m.Message.MessageID, sub, err)
+				nacks = append(nacks, m.AckID)
+				continue
+			}
+			acks = append(acks, m.AckID)
+		}
+		if len(acks) > 0 {
+			if err := s.pubSubRequest(sub+":acknowledge", map[string][]string{"ackIds": acks}, nil); err != nil {
+				logError(client, "fail to ack messages of %s: %v", sub, err)
+			}
+		}
+		if len(nacks) > 0 {
+			// A zero ack deadline makes the messages available again right
+			// away.
+			nack := map[string]interface{}{"ackIds": nacks, "ackDeadlineSeconds": 0}
+			if err := s.pubSubRequest(sub+":modifyAckDeadline", nack, nil); err != nil {
+				logError(client, "fail to nack messages of %s: %v", sub, err)
+			}
+		}
+	}
+}
+
+// pubSubRequest POSTs in to a method of the Pub/Sub API and decodes the
+// response into out.
+func (s *Server) pubSubRequest(method string, in, out interface{}) error {
+	token, err := s.pubSubToken()
+	if err != nil {
+		return fmt.Errorf("fail to get token: %v", err)
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, pubSubAPIURL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	// Pulls wait for messages for a while before returning none.
+	resp, err := (&http.Client{Timeout: 2 * time.Minute}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, respBody)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// pubSubToken returns the access token of the token file, or else of the
+// service account of the instance.
+func (s *Server) pubSubToken() (string, error) {
+	if s.Config.PubSub.TokenFile != "" {
+		b, err := ioutil.ReadFile(s.Config.PubSub.TokenFile)
+		return strings.TrimSpace(string(b)), err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := getJSON(pubSubMetadataToken, http.Header{"Metadata-Flavor": {"Google"}}, &token)
+	return token.AccessToken, err
+}
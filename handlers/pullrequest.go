@@ -1,9 +1,8 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
+
 	"github.com/golang/glog"
 	"github.com/google/go-github/github"
 )
@@ -13,50 +12,130 @@ type GithubPR github.PullRequestEvent
 var client github.Client
 
 func (s *Server) handlePullRequestEvent(body []byte, client *github.Client) {
-	ctx := context.Background()
-	glog.Infof("***********Received an PullRequest Event *****************")
+	if !s.receiveEvent(proxyEventPullRequest, body) {
+		return
+	}
+	glog.Infof("Received an PullRequest Event")
 	var pull github.PullRequestEvent
 	err := json.Unmarshal(body, &pull)
 	if err != nil {
-		glog.Errorf("fail to unmarshal: %v", err)
+		logError(client, "fail to unmarshal: %v", err)
+		return
+	}
+
+	if pull.GetAction() == "opened" {
+		s.runPlugin("first-time-contributor", "pull_request", client, func(client *github.Client) { s.handleFirstTimeContributor(client, pull.Repo, pull.PullRequest) })
+		s.runPlugin("heart", "pull_request", client, func(client *github.Client) { s.handlePRHeart(client, &pull) })
+		s.runPlugin("sigmention", "pull_request", client, func(client *github.Client) {
+			s.handleSigMention(client, pull.Repo, pull.GetNumber(), pull.GetPullRequest().GetBody(), pull.GetPullRequest().Labels)
+		})
+		s.runPlugin("blunderbuss", "pull_request", client, func(client *github.Client) { s.handleBlunderbuss(client, pull.Repo, pull.PullRequest) })
 	}
-	glog.Infof("pull: %v", pull)
-	fmt.Println(" @@@@@@@@@@@@@@@@ pull request @@@@@@@@@@@@",pull.PullRequest)
-	PRList, _, err := client.Repositories.ListCollaborators(ctx, "swx457056", "test-ci-bot", nil)
-	fmt.Println("*********** err ***************", err)
-	fmt.Println("&&&&&&&&&&&& PRLIst Collaborators", PRList)
-	fmt.Println()
-	fmt.Println("pull request event", pull)
 
-	contributors, resp, err := client.Repositories.ListContributors(ctx, "swx457056", "test-ci-bot", nil)
-	fmt.Println("*******contributors**************", &contributors)
-	fmt.Println()
-	fmt.Println("resp", resp)
-	fmt.Println("err", err)
-	fmt.Println()
+	if pull.GetAction() == "closed" {
+		s.runPlugin("comment-cleanup", "pull_request", client, func(client *github.Client) { s.cleanupTransientComments(client, pull.Repo, pull.GetNumber()) })
+		if pull.GetPullRequest().GetMerged() {
+			s.runPlugin("config-updater", "pull_request", client, func(client *github.Client) { s.handleConfigUpdater(client, &pull) })
+			s.runPlugin("branchcleaner", "pull_request", client, func(client *github.Client) { s.handleBranchCleaner(client, &pull) })
+			s.runPlugin("slack", "pull_request", client, func(client *github.Client) { s.handleSlackMerge(client, &pull) })
+			s.runPlugin("cherrypicker", "pull_request", client, func(client *github.Client) { s.handleCherryPickMerged(client, &pull) })
+		}
+	}
 
-	var reviewreq github.ReviewersRequest
-	reviewreq.Reviewers = []string{"sids-b", "swx457056"}
-	reviewreq.TeamReviewers = []string{"sids-b", "swx457056"}
-	fmt.Println("######## reviewreq.Reviewers ##############", reviewreq.Reviewers)
+	switch pull.GetAction() {
+	case "closed", "labeled":
+		s.runPlugin("backport", "pull_request", client, func(client *github.Client) { s.handleBackport(client, &pull) })
+	}
 
-	rr, _, _ := client.PullRequests.RequestReviewers(ctx, "swx457056", "test-ci-bot", 39, reviewreq)
-	fmt.Println(" $$$$$$$$$$$$$ rr merged $$$$$$$$$$$$$$",rr.Merged)
+	switch pull.GetAction() {
+	case "opened", "reopened", "edited", "ready_for_review", "converted_to_draft":
+		s.runPlugin("wip", "pull_request", client, func(client *github.Client) { s.handleWIP(client, &pull, pullRequestIsDraft(body)) })
+	}
 
-	fmt.Println(" %%%%%%%%%%% rr %%%%%%%%%%%", rr)
-	
-	if !*rr.Merged{
-		merged,_,_ := client.PullRequests.Merge(ctx,"swx457056","test-ci-bot",39,"TEST",nil)
-		fmt.Println("************ Merged ***************",merged)
+	switch pull.GetAction() {
+	case "opened", "reopened", "edited", "synchronize":
+		s.runPlugin("title", "pull_request", client, func(client *github.Client) { s.handleTitleCheck(client, pull.Repo, pull.PullRequest) })
+	}
 
+	switch pull.GetAction() {
+	case "opened", "edited":
+		if pull.GetAction() == "opened" || pullRequestRetargeted(body) {
+			s.runPlugin("milestoneapplier", "pull_request", client, func(client *github.Client) { s.handleMilestoneApplier(client, pull.Repo, pull.PullRequest) })
+		}
+		s.runPlugin("crosslink", "pull_request", client, func(client *github.Client) {
+			s.handleCrossLink(client, pull.Repo, pull.GetNumber(), pull.GetPullRequest().GetTitle(), pull.GetPullRequest().GetBody())
+		})
 	}
 
-	testmerge,_,err := client.PullRequests.IsMerged(ctx,"swx457056","test-ci-bot",37)
-	fmt.Println(" ########### test merge ###########",testmerge)
+	if pull.GetAction() == "synchronize" {
+		s.runPlugin("lgtm", "pull_request", client, func(client *github.Client) { s.handleLgtmSynchronize(client, &pull) })
+	}
+
+	switch pull.GetAction() {
+	case "opened", "reopened", "edited", "synchronize":
+		s.runPlugin("approve", "pull_request", client, func(client *github.Client) { s.updateApproval(client, pull.Repo, pull.GetNumber()) })
+	}
+
+	switch pull.GetAction() {
+	case "opened", "reopened", "synchronize":
+		s.runPlugin("dco", "pull_request", client, func(client *github.Client) { s.handleDCO(client, pull.Repo, pull.PullRequest) })
+		s.runPlugin("cla", "pull_request", client, func(client *github.Client) { s.handleCLA(client, pull.Repo, pull.PullRequest) })
+		s.runPlugin("path-label", "pull_request", client, func(client *github.Client) { s.handlePathLabel(client, pull.Repo, pull.PullRequest) })
+		s.runPlugin("needs-rebase", "pull_request", client, func(client *github.Client) { s.handleNeedsRebase(client, pull.Repo, pull.GetNumber()) })
+		s.runPlugin("verify-owners", "pull_request", client, func(client *github.Client) { s.handleVerifyOwners(client, pull.Repo, pull.PullRequest) })
+		s.runPlugin("jobs", "pull_request", client, func(client *github.Client) { s.handleTriggerPullRequest(client, pull.Repo, pull.PullRequest) })
+	}
+
+	switch pull.GetAction() {
+	case "opened", "labeled":
+		s.runPlugin("project", "pull_request", client, func(client *github.Client) {
+			s.handleProjectColumns(client, pull.Repo, pull.GetNumber(), pull.GetPullRequest().Labels, pull.GetPullRequest().GetMilestone().GetTitle())
+		})
+	}
+
+	switch pull.GetAction() {
+	case "opened", "reopened", "edited", "labeled", "unlabeled":
+		s.runPlugin("cherry-pick-unapproved", "pull_request", client, func(client *github.Client) { s.handleCherryPickUnapproved(client, &pull) })
+	}
+
+	switch pull.GetAction() {
+	case "opened", "reopened", "synchronize", "labeled", "unlabeled":
+		s.runPlugin("merge-gate", "pull_request", client, func(client *github.Client) {
+			s.updateSummaryStatus(client, pull.Repo, pull.PullRequest)
+			s.updateMergeBlockersStatus(client, pull.Repo, pull.PullRequest)
+		})
+	}
+
+	switch pull.GetAction() {
+	case "opened", "reopened", "labeled", "unlabeled":
+		s.runPlugin("require-matching-label", "pull_request", client, func(client *github.Client) {
+			s.handleRequireMatchingLabel(client, pull.Repo, pull.GetNumber(), true, pull.GetPullRequest().GetBase().GetRef(), pull.GetAction())
+		})
+	}
+}
+
+func (s *Server) handlePullRequestReviewEvent(body []byte, client *github.Client) {
+	if !s.receiveEvent(proxyEventReview, body) {
+		return
+	}
+	glog.Infof("Received a PullRequestReview Event")
+	var review github.PullRequestReviewEvent
+	err := json.Unmarshal(body, &review)
+	if err != nil {
+		logError(client, "fail to unmarshal: %v", err)
+		return
+	}
+
+	if review.GetAction() == "submitted" {
+		s.runPlugin("lgtm", "pull_request_review", client, func(client *github.Client) { s.handleLgtmReview(client, &review) })
+	}
 }
 
 func (s *Server) handlePullRequestCommentEvent(body []byte) {
 	glog.Infof("Received an PullRequestComment Event")
-	fmt.Println("**************** handlePullRequestCommentEvent *********")
+}
 
+// listPullRequestFiles returns every file changed by a PR.
+func listPullRequestFiles(client *github.Client, owner, repo string, number int) ([]*github.CommitFile, error) {
+	return scmFor(client).ListFiles(owner, repo, number)
 }
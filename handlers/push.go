@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// handlePushEvent handles pushes to a branch of a repo.
+func (s *Server) handlePushEvent(body []byte, client *github.Client) {
+	if !s.receiveEvent(proxyEventPush, body) {
+		return
+	}
+	var push github.PushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		logError(client, "fail to unmarshal: %v", err)
+		return
+	}
+	if !strings.HasPrefix(push.GetRef(), "refs/heads/") {
+		return
+	}
+	s.runPlugin("needs-rebase", "push", client, func(client *github.Client) { s.handleNeedsRebasePush(client, &push) })
+	s.runPlugin("slack", "push", client, func(client *github.Client) { s.handleSlackPush(client, &push) })
+	s.runPlugin("jobs", "push", client, func(client *github.Client) { s.handlePostsubmits(&push) })
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const (
+	redeliveryInterval      = 10 * time.Minute
+	defaultRedeliveryMaxAge = 24 * time.Hour
+)
+
+// Redelivery recovers the webhooks GitHub failed to deliver while the bot
+// was down, by asking GitHub to deliver them again.
+type Redelivery struct {
+	// Repos are the orgs and org/repos whose hooks are checked: org hooks
+	// for orgs, repo hooks for org/repos.
+	Repos []string `json:"repos,omitempty"`
+	// HookURL is the payload URL of the bot's hooks, telling them apart
+	// from the others, the url of webhooks by default.
+	HookURL string `json:"hook_url,omitempty"`
+	// MaxAge of the deliveries recovered, 24h by default. GitHub keeps
+	// deliveries for 3 days at most.
+	MaxAge string `json:"max_age,omitempty"`
+
+	maxAge time.Duration
+}
+
+// hookDelivery is an attempt of GitHub to deliver a webhook.
+type hookDelivery struct {
+	ID          int64     `json:"id"`
+	GUID        string    `json:"guid"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	StatusCode  int       `json:"status_code"`
+	Event       string    `json:"event"`
+}
+
+// seenDeliveries are the GUIDs of the webhooks handled since the bot
+// started.
+var seenDeliveries = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+// markDelivered records that the webhook of guid was handled.
+func markDelivered(guid string) {
+	if guid == "" {
+		return
+	}
+	seenDeliveries.Lock()
+	defer seenDeliveries.Unlock()
+	seenDeliveries.m[guid] = time.Now()
+}
+
+// redelivered are the GUIDs of the webhooks the bot had redelivered, so
+// that each is redelivered once. With leader election they are shared by
+// the replicas, so that a new leader doesn't redeliver them again.
+var redelivered = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+// withRedelivered runs modify on the redelivered GUIDs.
+func (s *Server) withRedelivered(modify func() bool) {
+	redelivered.Lock()
+	defer redelivered.Unlock()
+	if err := s.modifyState("redelivered", &redelivered.m, modify); err != nil {
+		glog.Errorf("fail to update the redelivered webhooks: %v", err)
+	}
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "redelivery",
+		Description: "The redelivery plugin looks for the webhooks GitHub failed to deliver to the bot, e.g. while it was down, and has GitHub deliver them again, so that no comment or PR event is lost.",
+		ConfigKey:   "redelivery",
+	}, func(c *Config) []string {
+		return c.Redelivery.Repos
+	})
+	registerPeriodic("redelivery", redeliveryInterval, func(c *Config) bool {
+		return len(c.Redelivery.Repos) > 0
+	}, (*Server).redeliverMissed)
+}
+
+func (c *Config) validateRedelivery() error {
+	r := &c.Redelivery
+	r.maxAge = defaultRedeliveryMaxAge
+	if r.HookURL == "" {
+		r.HookURL = c.Webhooks.URL
+	}
+	if len(r.Repos) > 0 && r.HookURL == "" {
+		return fmt.Errorf("redelivery: hook_url must be set")
+	}
+	if r.MaxAge != "" {
+		d, err := time.ParseDuration(r.MaxAge)
+		if err != nil {
+			return fmt.Errorf("redelivery: invalid max_age: %v", err)
+		}
+		r.maxAge = d
+	}
+	return nil
+}
+
+// redeliverMissed has GitHub deliver again the webhooks of the bot's hooks
+// none of whose attempts succeeded and which the bot never handled, once.
+// Those the bot rejected, whose latest attempt got a 4xx, would be
+// rejected again and are left alone.
+func (s *Server) redeliverMissed(client *github.Client) {
+	since := time.Now().Add(-s.Config.Redelivery.maxAge)
+	seenDeliveries.Lock()
+	for guid, t := range seenDeliveries.m {
+		if t.Before(since) {
+			delete(seenDeliveries.m, guid)
+		}
+	}
+	seenDeliveries.Unlock()
+	s.withRedelivered(func() bool {
+		changed := false
+		for guid, t := range redelivered.m {
+			if t.Before(since) {
+				delete(redelivered.m, guid)
+				changed = true
+			}
+		}
+		return changed
+	})
+
+	for _, target := range s.Config.Redelivery.Repos {
+		hooks, err := s.botHooks(client, target)
+		if err != nil {
+			logError(client, "fail to list hooks of %s: %v", target, err)
+			continue
+		}
+		for _, path := range hooks {
+			if err := s.redeliverHook(client, path, since); err != nil {
+				logError(client, "fail to recover deliveries of %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// botHooks returns the API paths of the hooks of an org or org/repo
+// delivering to the bot.
+func (s *Server) botHooks(client *github.Client, target string) ([]string, error) {
+	hooks, prefix, err := listHooks(client, target)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, h := range hooks {
+		if u, _ := h.Config["url"].(string); u == s.Config.Redelivery.HookURL {
+			paths = append(paths, fmt.Sprintf("%s/%d", prefix, h.GetID()))
+		}
+	}
+	return paths, nil
+}
+
+// redeliverHook redelivers the missed deliveries of the hook at path since
+// the given time. The deliveries API isn't in the vendored go-github, so
+// it's called directly.
+func (s *Server) redeliverHook(client *github.Client, path string, since time.Time) error {
+	ctx := context.Background()
+	// Deliveries are listed newest first, each attempt on its own.
+	latest := map[string]hookDelivery{}
+	delivered := map[string]bool{}
+	cursor := ""
+	for done := false; !done; {
+		req, err := client.NewRequest("GET", fmt.Sprintf("%s/deliveries?per_page=100%s", path, cursor), nil)
+		if err != nil {
+			return err
+		}
+		var page []hookDelivery
+		resp, err := client.Do(ctx, req, &page)
+		if err != nil {
+			return err
+		}
+		for _, d := range page {
+			if d.DeliveredAt.Before(since) {
+				done = true
+				break
+			}
+			if d.StatusCode >= 200 && d.StatusCode < 300 {
+				delivered[d.GUID] = true
+			}
+			if _, ok := latest[d.GUID]; !ok {
+				latest[d.GUID] = d
+			}
+		}
+		cursor = nextCursor(resp)
+		if cursor == "" {
+			done = true
+		}
+	}
+
+	var missed []hookDelivery
+	s.withRedelivered(func() bool {
+		missed = nil
+		seenDeliveries.Lock()
+		defer seenDeliveries.Unlock()
+		for guid, d := range latest {
+			_, seen := seenDeliveries.m[guid]
+			_, done := redelivered.m[guid]
+			rejected := d.StatusCode >= 400 && d.StatusCode < 500
+			if !seen && !done && !rejected && !delivered[guid] {
+				missed = append(missed, d)
+			}
+		}
+		return false
+	})
+	var redone []string
+	defer func() {
+		if len(redone) == 0 {
+			return
+		}
+		now := time.Now()
+		s.withRedelivered(func() bool {
+			for _, guid := range redone {
+				redelivered.m[guid] = now
+			}
+			return true
+		})
+	}()
+	for _, d := range missed {
+		req, err := client.NewRequest("POST", fmt.Sprintf("%s/deliveries/%d/attempts", path, d.ID), nil)
+		if err != nil {
+			return err
+		}
+		if _, err := client.Do(ctx, req, nil); err != nil {
+			if _, ok := err.(*github.AcceptedError); !ok {
+				logError(client, "fail to redeliver %s event %s of %s: %v", d.Event, d.GUID, path, err)
+				continue
+			}
+		}
+		redone = append(redone, d.GUID)
+		glog.Infof("redelivered %s event %s of %s", d.Event, d.GUID, path)
+	}
+	return nil
+}
+
+// nextCursor returns the query of the next page of a cursor paginated
+// list, which go-github doesn't parse.
+func nextCursor(resp *github.Response) string {
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) != `rel="next"` {
+			continue
+		}
+		u := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		if i := strings.Index(u, "cursor="); i >= 0 {
+			cursor := u[i:]
+			if j := strings.Index(cursor, "&"); j >= 0 {
+				cursor = cursor[:j]
+			}
+			return "&" + cursor
+		}
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Relay is a smee.io style channel the bot receives its webhooks through,
+// for deployments without an inbound port GitHub can deliver them to.
+type Relay struct {
+	// URL of the channel, e.g. https://smee.io/<channel>, set as the
+	// payload URL of the webhooks.
+	URL string `json:"url,omitempty"`
+}
+
+// relayDelivery is a webhook as the relay streams it.
+type relayDelivery struct {
+	Event        string          `json:"x-github-event"`
+	Delivery     string          `json:"x-github-delivery"`
+	Signature    string          `json:"x-hub-signature"`
+	Signature256 string          `json:"x-hub-signature-256"`
+	Body         json.RawMessage `json:"body"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "relay",
+		Description: "The relay plugin connects the bot to a smee.io style relay channel and handles the webhooks delivered to it, so that the bot needs no inbound port.",
+		ConfigKey:   "relay",
+	}, func(c *Config) []string {
+		if c.Relay.URL != "" {
+			return []string{"*"}
+		}
+		return nil
+	})
+}
+
+func (c *Config) validateRelay() error {
+	if c.Relay.URL != "" && !strings.HasPrefix(c.Relay.URL, "https://") && !strings.HasPrefix(c.Relay.URL, "http://") {
+		return fmt.Errorf("relay: invalid url %q", c.Relay.URL)
+	}
+	return nil
+}
+
+// runRelay keeps the bot connected to the relay channel.
+func (s *Server) runRelay() {
+	if s.Config.Relay.URL == "" {
+		return
+	}
+	go func() {
+		for {
+			if err := s.consumeRelay(); err != nil {
+				glog.Errorf("fail to read relay %s: %v", s.Config.Relay.URL, err)
+			}
+			time.Sleep(10 * time.Second)
+		}
+	}()
+}
+
+// consumeRelay reads the server-sent events of the channel until it's
+// closed, and serves each webhook as if GitHub had delivered it, signature
+// included.
+func (s *Server) consumeRelay() error {
+	req, err := http.NewRequest(http.MethodGet, s.Config.Relay.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 25*1024*1024)
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			if strings.HasPrefix(line, "data:") {
+				data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			}
+			continue
+		}
+		// A blank line ends an event.
+		if len(data) > 0 {
+			s.serveRelayDelivery([]byte(strings.Join(data, "\n")))
+		}
+		data = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("channel closed")
+}
+
+func (s *Server) serveRelayDelivery(data []byte) {
+	var d relayDelivery
+	if err := json.Unmarshal(data, &d); err != nil || d.Event == "" {
+		// The relay also streams its own ready and ping events.
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, "/hook", bytes.NewReader(d.Body))
+	if err != nil {
+		glog.Errorf("fail to replay delivery %s: %v", d.Delivery, err)
+		return
+	}
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	req.Header.Set("X-GitHub-Event", d.Event)
+	req.Header.Set("X-GitHub-Delivery", d.Delivery)
+	req.Header.Set("X-Hub-Signature", d.Signature)
+	if d.Signature256 != "" {
+		req.Header.Set("X-Hub-Signature-256", d.Signature256)
+	}
+	s.current().ServeHTTP(discardResponse{}, req)
+}
+
+// discardResponse is the response of a webhook nobody waits for.
+type discardResponse struct{}
+
+func (discardResponse) Header() http.Header         { return http.Header{} }
+func (discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponse) WriteHeader(int)             {}
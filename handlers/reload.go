@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// configReloadInterval is how often the config file is checked for
+// changes, e.g. made by the config-updater plugin to its ConfigMap.
+const configReloadInterval = time.Minute
+
+// configs holds the config last reloaded. Servers are never changed in
+// place: handlers work on a snapshot taken by current, so that a reload
+// doesn't change the config under their feet.
+var configs = struct {
+	sync.RWMutex
+	latest *Config
+}{}
+
+// current returns a copy of the server with the config last loaded.
+func (s *Server) current() *Server {
+	configs.RLock()
+	defer configs.RUnlock()
+	c := *s
+	if configs.latest != nil {
+		c.Config = *configs.latest
+	}
+	return &c
+}
+
+// withConfig serves requests with a snapshot of the server, see current.
+func (s *Server) withConfig(serve func(s *Server, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serve(s.current(), w, r)
+	}
+}
+
+// watchConfig reloads the config whenever the file at path changes, and
+// refreshes the plugin help with it. A config that fails to load is logged
+// and the current one kept. The periodic tasks that run, and the
+// connections made at startup, stay those of the config the bot started
+// with.
+func (s *Server) watchConfig(path string, help *HelpAgent) {
+	modTime := func() time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			glog.Errorf("fail to stat config file: %v", err)
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	loaded := modTime()
+	go func() {
+		for range time.Tick(configReloadInterval) {
+			changed := modTime()
+			if changed.IsZero() || changed.Equal(loaded) {
+				continue
+			}
+			config, err := LoadConfig(path)
+			if err != nil {
+				glog.Errorf("fail to reload config, keeping the current one: %v", err)
+				loaded = changed
+				continue
+			}
+			configs.Lock()
+			configs.latest = &config
+			configs.Unlock()
+			loaded = changed
+			glog.Infof("reloaded config from %s", path)
+			help.Refresh(config, path)
+			s.current().expireWebhookSeries()
+		}
+	}()
+}
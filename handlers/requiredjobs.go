@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// requiredJobsContext is the status summing up the required presubmits of
+// a commit, so that branch protection and merge automation need only it.
+const requiredJobsContext = "ci-bot/required-jobs"
+
+// reportRequiredJobs sets the status summing up the required presubmits
+// of repo on sha: failed when one fails, pending while one hasn't passed
+// and successful once all passed or were skipped.
+func (s *Server) reportRequiredJobs(client *github.Client, repo *github.Repository, sha string) {
+	var required []string
+	for _, p := range s.Config.Jobs.presubmitsFor(repo) {
+		if !p.Optional && !stringInSlice(p.context(), required) {
+			required = append(required, p.context())
+		}
+	}
+	if len(required) == 0 {
+		return
+	}
+	combined, err := scmFor(client).GetCombinedStatus(repo.GetOwner().GetLogin(), repo.GetName(), sha)
+	if err != nil {
+		logError(client, "fail to get statuses of %s@%s: %v", repo.GetFullName(), sha, err)
+		return
+	}
+	states := map[string]string{}
+	var current github.RepoStatus
+	for _, st := range combined.Statuses {
+		states[st.GetContext()] = st.GetState()
+		if st.GetContext() == requiredJobsContext {
+			current = st
+		}
+	}
+
+	var failed, waiting []string
+	for _, c := range required {
+		switch states[c] {
+		case "success":
+		case "failure", "error":
+			failed = append(failed, c)
+		default:
+			waiting = append(waiting, c)
+		}
+	}
+	sort.Strings(failed)
+	state, description := "success", fmt.Sprintf("All %d required jobs passed", len(required))
+	switch {
+	case len(failed) > 0:
+		state, description = "failure", "Failed: "+strings.Join(failed, ", ")
+	case len(waiting) > 0:
+		state, description = "pending", fmt.Sprintf("%d of %d required jobs passed", len(required)-len(waiting), len(required))
+	}
+	// Descriptions are cut at 140 characters by GitHub.
+	if len(description) > 140 {
+		description = description[:137] + "..."
+	}
+	if current.GetState() == state && current.GetDescription() == description {
+		return
+	}
+	createStatus(client, repo, sha, requiredJobsContext, state, description, "")
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const defaultGracePeriod = 5 * time.Second
+
+// RequireMatchingLabel is the config for a single label requirement. Issues
+// and PRs of the matching org/repo lacking a label that matches Regexp get
+// MissingLabel and, optionally, MissingComment.
+type RequireMatchingLabel struct {
+	// Org is the GitHub organization that this config applies to.
+	Org string `json:"org"`
+	// Repo is the GitHub repository within Org that this config applies to.
+	// This field may be omitted to apply this config across all repos in Org.
+	Repo string `json:"repo,omitempty"`
+	// Branch is the branch ref of PRs that this config applies to.
+	// This field is only valid if PRs is true and may be omitted to apply
+	// this config across all branches in the repo or org.
+	Branch string `json:"branch,omitempty"`
+	// PRs is true if the config applies to PRs.
+	PRs bool `json:"prs,omitempty"`
+	// Issues is true if the config applies to issues.
+	Issues bool `json:"issues,omitempty"`
+	// Regexp is the string specifying the regular expression used to look for
+	// matching labels.
+	Regexp string `json:"regexp"`
+	// MissingLabel is the label to apply if an issue does not have any label
+	// matching the Regexp.
+	MissingLabel string `json:"missing_label"`
+	// MissingComment is the comment to post when we add the MissingLabel to an
+	// issue. This is typically used to explain why MissingLabel was added and
+	// how to move forward.
+	// This field is optional. If unspecified, no comment is created when labeling.
+	MissingComment string `json:"missing_comment,omitempty"`
+	// GracePeriod is the amount of time to wait before processing newly opened
+	// or reopened issues and PRs. This delay allows other automation to apply
+	// labels before we look for matching labels.
+	// Defaults to '5s'.
+	GracePeriod         string        `json:"grace_period,omitempty"`
+	GracePeriodDuration time.Duration `json:"-"`
+
+	re *regexp.Regexp
+}
+
+// Describe returns a string representation of the requirement.
+func (r RequireMatchingLabel) Describe() string {
+	str := fmt.Sprintf("Applies %q to ", r.MissingLabel)
+	switch {
+	case r.PRs && r.Issues:
+		str += "PRs and issues"
+	case r.PRs:
+		str += "PRs"
+	default:
+		str += "issues"
+	}
+	str += fmt.Sprintf(" in %s", r.Org)
+	if r.Repo != "" {
+		str += "/" + r.Repo
+	}
+	if r.Branch != "" {
+		str += fmt.Sprintf(" on branch %s", r.Branch)
+	}
+	return str + fmt.Sprintf(" without a label matching %q.", r.Regexp)
+}
+
+func (r RequireMatchingLabel) appliesTo(org, repo, branch string, isPR bool) bool {
+	if r.Org != org || (r.Repo != "" && r.Repo != repo) {
+		return false
+	}
+	if !isPR {
+		return r.Issues
+	}
+	return r.PRs && (r.Branch == "" || r.Branch == branch)
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "require-matching-label",
+		Events:      []string{"issues", "pull_request"},
+		Description: "The require-matching-label plugin is a configurable plugin that applies a label to issues and/or PRs that do not have any labels matching a regular expression. An example of this is applying a 'needs-sig' label to all issues that do not have a 'sig/*' label.",
+		ConfigKey:   "require_matching_label",
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, r := range c.RequireMatchingLabel {
+			if r.Repo == "" {
+				enabled = append(enabled, r.Org)
+			} else {
+				enabled = append(enabled, r.Org+"/"+r.Repo)
+			}
+		}
+		return enabled
+	})
+}
+
+// handleRequireMatchingLabel makes sure that every applicable requirement
+// is met by the labels of an issue or PR. Newly (re)opened items are given
+// the grace period of the requirement for other automation to label them.
+func (s *Server) handleRequireMatchingLabel(client *github.Client, repo *github.Repository, number int, isPR bool, branch, action string) {
+	org := repo.GetOwner().GetLogin()
+	var reqs []RequireMatchingLabel
+	for _, r := range s.Config.RequireMatchingLabel {
+		if r.appliesTo(org, repo.GetName(), branch, isPR) {
+			reqs = append(reqs, r)
+		}
+	}
+	if len(reqs) == 0 {
+		return
+	}
+
+	if action == "opened" || action == "reopened" {
+		grace := time.Duration(0)
+		for _, r := range reqs {
+			if r.GracePeriodDuration > grace {
+				grace = r.GracePeriodDuration
+			}
+		}
+		time.Sleep(grace)
+	}
+
+	labels, err := scmFor(client).ListIssueLabels(org, repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list labels of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+
+	for _, r := range reqs {
+		matched := false
+		for _, l := range labels {
+			if r.re.MatchString(l.GetName()) {
+				matched = true
+				break
+			}
+		}
+		missing := hasLabel(labels, r.MissingLabel)
+		switch {
+		case matched && missing:
+			err := scmFor(client).RemoveLabel(org, repo.GetName(), number, r.MissingLabel)
+			if err != nil {
+				logError(client, "fail to remove %s from %s#%d: %v", r.MissingLabel, repo.GetFullName(), number, err)
+			}
+		case !matched && !missing:
+			err := scmFor(client).AddLabels(org, repo.GetName(), number, r.MissingLabel)
+			if err != nil {
+				logError(client, "fail to add %s to %s#%d: %v", r.MissingLabel, repo.GetFullName(), number, err)
+				continue
+			}
+			if r.MissingComment == "" {
+				continue
+			}
+			_, err = scmFor(client).CreateComment(org, repo.GetName(), number, r.MissingComment)
+			if err != nil {
+				logError(client, "fail to comment on %s#%d: %v", repo.GetFullName(), number, err)
+			}
+		}
+	}
+}
+
+func (c *Config) validateRequireMatchingLabel() error {
+	for i, r := range c.RequireMatchingLabel {
+		if r.Org == "" {
+			return fmt.Errorf("require_matching_label %d: org is required", i)
+		}
+		if !r.PRs && !r.Issues {
+			return fmt.Errorf("require_matching_label %d: neither prs nor issues is set", i)
+		}
+		if r.Branch != "" && !r.PRs {
+			return fmt.Errorf("require_matching_label %d: branch is only valid for prs", i)
+		}
+		if r.MissingLabel == "" {
+			return fmt.Errorf("require_matching_label %d: missing_label is required", i)
+		}
+		re, err := regexp.Compile(r.Regexp)
+		if err != nil {
+			return fmt.Errorf("require_matching_label %d: invalid regexp %q: %v", i, r.Regexp, err)
+		}
+		c.RequireMatchingLabel[i].re = re
+		c.RequireMatchingLabel[i].GracePeriodDuration = defaultGracePeriod
+		if r.GracePeriod != "" {
+			d, err := time.ParseDuration(r.GracePeriod)
+			if err != nil {
+				return fmt.Errorf("require_matching_label %d: invalid grace_period %q: %v", i, r.GracePeriod, err)
+			}
+			c.RequireMatchingLabel[i].GracePeriodDuration = d
+		}
+	}
+	return nil
+}
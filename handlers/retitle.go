@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+var (
+	mentionReg = regexp.MustCompile(`\B@([\w-]+(?:/[\w-]+)?)`)
+	// closingKeywordReg matches the references GitHub closes issues for,
+	// e.g. "fixes #12" or "closes org/repo#12".
+	closingKeywordReg = regexp.MustCompile(`(?i)\b(close[sd]?|fix(?:e[sd])?|resolve[sd]?)(:?\s+)([\w.-]+/[\w.-]+)?#(\d+)`)
+)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "retitle",
+		Events:      []string{"issue_comment"},
+		Description: "The retitle plugin allows users to re-title pull requests and issues where GitHub permissions don't allow them to.",
+		Commands: []PluginCommand{{
+			Usage:       "/retitle <title>",
+			Description: "Edits the pull request or issue title. @mentions and issue references GitHub would close are neutralized.",
+			WhoCanUse:   "Collaborators of the repo.",
+			Example:     "/retitle New Title",
+		}},
+	}, enabledEverywhere)
+}
+
+// sanitizeTitle keeps a title from mentioning anyone or closing issues once
+// it ends up in a merge commit.
+func sanitizeTitle(title string) string {
+	title = mentionReg.ReplaceAllString(title, "$1")
+	return closingKeywordReg.ReplaceAllStringFunc(title, func(m string) string {
+		// Dropping the # leaves the reference readable but inert.
+		parts := closingKeywordReg.FindStringSubmatch(m)
+		if parts[3] != "" {
+			return fmt.Sprintf("%s%s%s %s", parts[1], parts[2], parts[3], parts[4])
+		}
+		return fmt.Sprintf("%s%s%s", parts[1], parts[2], parts[4])
+	})
+}
+
+// handleRetitle renames an issue or PR on behalf of a collaborator.
+func (s *Server) handleRetitle(client *github.Client, ic *github.IssueCommentEvent) {
+	login := ic.GetComment().GetUser().GetLogin()
+	number := ic.GetIssue().GetNumber()
+	title := sanitizeTitle(strings.TrimSpace(retitleReg.FindStringSubmatch(ic.GetComment().GetBody())[1]))
+	if title == "" {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Titles may not be empty.", login))
+		return
+	}
+
+	trusted, err := isCollaborator(client, ic.Repo, login)
+	if err != nil {
+		logError(client, "fail to check whether %s is a collaborator of %s: %v", login, ic.Repo.GetFullName(), err)
+		return
+	}
+	if !trusted {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Re-titling can only be requested by collaborators.", login))
+		return
+	}
+
+	err = scmFor(client).EditIssue(ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName(), number, &github.IssueRequest{Title: github.String(title)})
+	if err != nil {
+		logError(client, "fail to retitle %s#%d: %v", ic.Repo.GetFullName(), number, err)
+	}
+}
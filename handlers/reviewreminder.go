@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"ci-bot/commentpruner"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	reviewReminderInterval = time.Hour
+	reviewReminderMarker   = "<!-- ci-bot:review-reminder -->"
+)
+
+// ReviewReminder is the config of the reminders of pending review requests.
+type ReviewReminder struct {
+	// Repos are the orgs and org/repos processed.
+	Repos []string `json:"repos,omitempty"`
+	// ExcludedRepos are org/repos of Repos' orgs opting out.
+	ExcludedRepos []string `json:"excluded_repos,omitempty"`
+	// Days a review request stays pending before reviewers are reminded.
+	Days int `json:"days,omitempty"`
+	// PeriodDays between two reminders on the same PR, Days by default.
+	PeriodDays int `json:"period_days,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "review-reminder",
+		Description: "Periodically reminds the reviewers of open pull requests whose review requests have been pending for the configured number of days, at most once per period on each pull request.",
+		ConfigKey:   "review_reminder",
+	}, func(c *Config) []string {
+		return c.ReviewReminder.Repos
+	})
+	registerPeriodic("review-reminder", reviewReminderInterval, func(c *Config) bool {
+		return len(c.ReviewReminder.Repos) > 0 && c.ReviewReminder.Days > 0
+	}, (*Server).processReviewReminders)
+}
+
+// reviewRequestTimes returns when each reviewer was last requested to
+// review a PR.
+func reviewRequestTimes(client *github.Client, repo *github.Repository, number int) (map[string]time.Time, error) {
+	events, err := scmFor(client).ListIssueEvents(repo.GetOwner().GetLogin(), repo.GetName(), number)
+	if err != nil {
+		return nil, err
+	}
+	times := map[string]time.Time{}
+	for _, e := range events {
+		if e.Event == "review_requested" && e.RequestedReviewer != "" {
+			times[strings.ToLower(e.RequestedReviewer)] = e.CreatedAt
+		}
+	}
+	return times, nil
+}
+
+// processReviewReminders reminds the reviewers of the PRs whose review
+// requests are pending for too long.
+func (s *Server) processReviewReminders(client *github.Client) {
+	c := s.Config.ReviewReminder
+	period := c.PeriodDays
+	if period == 0 {
+		period = c.Days
+	}
+	threshold := time.Now().AddDate(0, 0, -c.Days)
+	// A review can't have been requested before the PR was created.
+	query := []string{"is:pr", "is:open", "-is:draft", "created:<" + threshold.Format("2006-01-02")}
+	query = append(query, searchScopes(c.Repos, c.ExcludedRepos)...)
+	issues, err := searchIssues(client, strings.Join(query, " "))
+	if err != nil {
+		logError(client, "fail to search PRs pending review: %v", err)
+		return
+	}
+	for _, issue := range issues {
+		repo, err := searchResultRepo(issue)
+		if err != nil {
+			logError(client, "fail to get the repo of a search result: %v", err)
+			continue
+		}
+		s.remindReviewers(client, repo, issue.GetNumber(), threshold, time.Now().AddDate(0, 0, -period))
+	}
+}
+
+// remindReviewers mentions the reviewers of a PR requested before threshold,
+// unless the bot already reminded them after lastReminder.
+func (s *Server) remindReviewers(client *github.Client, repo *github.Repository, number int, threshold, lastReminder time.Time) {
+	org := repo.GetOwner().GetLogin()
+	pr, err := scmFor(client).GetPullRequest(org, repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to get PR %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	if len(pr.RequestedReviewers) == 0 {
+		return
+	}
+	requested, err := reviewRequestTimes(client, repo, number)
+	if err != nil {
+		logError(client, "fail to list events of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	var pending []string
+	// since is when the most recent of the pending requests was made.
+	var since time.Time
+	for _, r := range pr.RequestedReviewers {
+		if at, ok := requested[strings.ToLower(r.GetLogin())]; ok && at.Before(threshold) {
+			pending = append(pending, "@"+r.GetLogin())
+			if at.After(since) {
+				since = at
+			}
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	comments, err := listIssueComments(client, org, repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list comments of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+	for _, c := range comments {
+		if c.GetUser().GetLogin() == s.BotName && strings.Contains(c.GetBody(), reviewReminderMarker) && c.GetCreatedAt().After(lastReminder) {
+			return
+		}
+	}
+	// A new comment rather than an edited one, as only new comments notify
+	// the mentioned reviewers.
+	s.commentPruner(client, repo, number).PruneComments(commentpruner.HasMarker(reviewReminderMarker))
+	sort.Strings(pending)
+	ago := s.Config.FormatterFor(org).Duration(time.Since(since))
+	createComment(client, repo, number, fmt.Sprintf("%s\n%s\n%s: your review was requested on this pull request %s ago and is still pending. "+
+		"Please take a look when you can, or let the author know if someone else should review it.", reviewReminderMarker, transientMarker("review-reminder"), strings.Join(pending, " "), ago))
+}
@@ -0,0 +1,747 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// SCM is what the plugins need of the code host of a repo. Plugins go
+// through it rather than through a *github.Client, so that a code host is
+// added by implementing SCM once instead of touching every plugin. The
+// GitHub types serve as the data model of every code host. Code hosts
+// return an error made by unsupported for what they have no equivalent of.
+type SCM interface {
+	GetPullRequest(org, repo string, number int) (*github.PullRequest, error)
+	// ListPullRequests lists the open PRs against base.
+	ListPullRequests(org, repo, base string) ([]*github.PullRequest, error)
+	// ListPullRequestsWithCommit lists the PRs sha is a commit of, or the
+	// merge commit of.
+	ListPullRequestsWithCommit(org, repo, sha string) ([]*github.PullRequest, error)
+	ListFiles(org, repo string, number int) ([]*github.CommitFile, error)
+	ListChangedFiles(org, repo string, number int) ([]ChangedFile, error)
+	ListCommits(org, repo string, number int) ([]*github.RepositoryCommit, error)
+	// GetPatch returns the commits of a PR in the format of git am.
+	GetPatch(org, repo string, number int) (string, error)
+	CreatePullRequest(org, repo string, pr *github.NewPullRequest) (*github.PullRequest, error)
+	CreateReview(org, repo string, number int, review *github.PullRequestReviewRequest) error
+
+	GetIssue(org, repo string, number int) (*github.Issue, error)
+	// EditIssue sets the title, state or milestone of an issue or PR.
+	EditIssue(org, repo string, number int, edit *github.IssueRequest) error
+	LockIssue(org, repo string, number int, reason string) error
+	UnlockIssue(org, repo string, number int) error
+	ListIssueEvents(org, repo string, number int) ([]IssueEvent, error)
+	// SearchIssues returns the issues and PRs matching a GitHub search
+	// query, sorted by sort, at most limit of them unless limit is 0, and
+	// how many match in all.
+	SearchIssues(query, sort string, limit int) ([]github.Issue, int, error)
+	// TransferIssue moves an issue to another repo of org and returns its
+	// new number.
+	TransferIssue(org, repo string, number int, dest string) (int, error)
+	CreateIssueReaction(org, repo string, number int, reaction string) error
+	CreateCommentReaction(org, repo string, id int64, reaction string) error
+
+	ListComments(org, repo string, number int) ([]*github.IssueComment, error)
+	CreateComment(org, repo string, number int, body string) (*github.IssueComment, error)
+	EditComment(org, repo string, id int64, body string) (*github.IssueComment, error)
+	DeleteComment(org, repo string, id int64) error
+
+	ListLabels(org, repo string) ([]*github.Label, error)
+	ListIssueLabels(org, repo string, number int) ([]*github.Label, error)
+	AddLabels(org, repo string, number int, labels ...string) error
+	RemoveLabel(org, repo string, number int, label string) error
+	ListMilestones(org, repo string) ([]*github.Milestone, error)
+
+	IsAssignee(org, repo, login string) (bool, error)
+	AddAssignees(org, repo string, number int, logins ...string) error
+	RemoveAssignees(org, repo string, number int, logins ...string) error
+	RequestReviewers(org, repo string, number int, logins ...string) error
+	RemoveReviewers(org, repo string, number int, logins ...string) error
+
+	IsCollaborator(org, repo, login string) (bool, error)
+	IsMember(org, login string) (bool, error)
+	// IsTeamMember reports whether login is an active member of the team
+	// of org with the given slug.
+	IsTeamMember(org, team, login string) (bool, error)
+	// GetPermissionLevel returns admin, write, read or none.
+	GetPermissionLevel(org, repo, login string) (string, error)
+
+	GetRepo(org, repo string) (*github.Repository, error)
+	GetBranch(org, repo, branch string) (*github.Branch, error)
+	DeleteBranch(org, repo, branch string) error
+	// GetRequiredStatusChecks returns the contexts the protection of
+	// branch requires, none for unprotected branches.
+	GetRequiredStatusChecks(org, repo, branch string) ([]string, error)
+	// GetFile returns the content of a file at ref, an error isNotFound
+	// reports when there is none.
+	GetFile(org, repo, path, ref string) ([]byte, error)
+	// ListTree returns the paths of the files of the tree of sha.
+	ListTree(org, repo, sha string) ([]string, error)
+	// GetTreeHash returns the hash of the git tree of a commit.
+	GetTreeHash(org, repo, sha string) (string, error)
+	// Blame returns who last changed each range of lines of a file at sha.
+	Blame(org, repo, sha, path string) ([]BlameRange, error)
+
+	CreateStatus(org, repo, sha string, status *github.RepoStatus) error
+	GetCombinedStatus(org, repo, sha string) (*github.CombinedStatus, error)
+
+	// ListProjects lists the project boards of repo, then the ones of org.
+	ListProjects(org, repo string) ([]*github.Project, error)
+	ListProjectColumns(project int64) ([]*github.ProjectColumn, error)
+	ListProjectCards(column int64) ([]*github.ProjectCard, error)
+	MoveProjectCard(card, column int64) error
+	CreateProjectCard(column, content int64, contentType string) error
+}
+
+// ChangedFile is a file changed by a PR, with the name it had before when
+// renamed, which the vendored client doesn't decode.
+type ChangedFile struct {
+	Filename         string `json:"filename"`
+	Status           string `json:"status"`
+	PreviousFilename string `json:"previous_filename"`
+}
+
+// IssueEvent is an event of the timeline of an issue or PR: the label
+// added or removed, or the reviewer requested, by Actor.
+type IssueEvent struct {
+	Event             string
+	Actor             string
+	Label             string
+	RequestedReviewer string
+	CreatedAt         time.Time
+}
+
+// BlameRange is a range of lines last changed by Login, at Date.
+type BlameRange struct {
+	StartLine, EndLine int
+	Login              string
+	Date               time.Time
+}
+
+var scms = struct {
+	sync.Mutex
+	byClient map[*github.Client]SCM
+}{byClient: map[*github.Client]SCM{}}
+
+// registerSCM makes scm the code host behind client, for providers that
+// implement SCM natively rather than behind a GitHub-compatible client.
+func registerSCM(client *github.Client, scm SCM) {
+	scms.Lock()
+	defer scms.Unlock()
+	scms.byClient[client] = scm
+}
+
+// scmFor returns the code host behind client: the one registered for it,
+// or the GitHub API client talks to.
+func scmFor(client *github.Client) SCM {
+	scms.Lock()
+	scm, ok := scms.byClient[client]
+	scms.Unlock()
+	if ok {
+		return scm
+	}
+	return githubSCM{client}
+}
+
+// unsupportedError is the error of the SCM methods a code host has no
+// equivalent for.
+type unsupportedError struct {
+	host, feature string
+}
+
+func (e *unsupportedError) Error() string {
+	return fmt.Sprintf("%s doesn't support %s", e.host, e.feature)
+}
+
+func unsupported(host, feature string) error {
+	return &unsupportedError{host: host, feature: feature}
+}
+
+// isUnsupported reports whether err is that of a feature the code host
+// doesn't have.
+func isUnsupported(err error) bool {
+	_, ok := err.(*unsupportedError)
+	return ok
+}
+
+// apiError is an error response of the API of a code host.
+type apiError struct {
+	method, path string
+	status       int
+	body         []byte
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.method, e.path, e.status, http.StatusText(e.status), e.body)
+}
+
+// isNotFound reports whether err is a 404 of a code host.
+func isNotFound(err error) bool {
+	switch err := err.(type) {
+	case *github.ErrorResponse:
+		return err.Response != nil && err.Response.StatusCode == http.StatusNotFound
+	case *apiError:
+		return err.status == http.StatusNotFound
+	}
+	return false
+}
+
+// unsupportedTransport is the transport of the clients of the providers
+// implementing SCM natively. Plugins reach them through scmFor, what
+// remains is GitHub's API only, e.g. the management of hooks, and fails on
+// them.
+type unsupportedTransport string
+
+func (t unsupportedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	glog.Errorf("%s doesn't support %s %s", string(t), req.Method, req.URL.Path)
+	return emptyResponse(req, http.StatusNotImplemented), nil
+}
+
+// githubSCM is the SCM of repos reached through the GitHub API, or an API
+// compatible with it.
+type githubSCM struct {
+	client *github.Client
+}
+
+func (g githubSCM) GetPullRequest(org, repo string, number int) (*github.PullRequest, error) {
+	pr, _, err := g.client.PullRequests.Get(context.Background(), org, repo, number)
+	return pr, err
+}
+
+func (g githubSCM) ListPullRequests(org, repo, base string) ([]*github.PullRequest, error) {
+	ctx := context.Background()
+	opt := &github.PullRequestListOptions{State: "open", Base: base, ListOptions: github.ListOptions{PerPage: 100}}
+	var prs []*github.PullRequest
+	for {
+		page, resp, err := g.client.PullRequests.List(ctx, org, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range page {
+			if pr.GetBase().GetRef() == base {
+				prs = append(prs, pr)
+			}
+		}
+		if resp.NextPage == 0 {
+			return prs, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (g githubSCM) ListPullRequestsWithCommit(org, repo, sha string) ([]*github.PullRequest, error) {
+	req, err := g.client.NewRequest(http.MethodGet, fmt.Sprintf("repos/%s/%s/commits/%s/pulls", org, repo, sha), nil)
+	if err != nil {
+		return nil, err
+	}
+	var prs []*github.PullRequest
+	_, err = g.client.Do(context.Background(), req, &prs)
+	return prs, err
+}
+
+func (g githubSCM) ListFiles(org, repo string, number int) ([]*github.CommitFile, error) {
+	ctx := context.Background()
+	opt := &github.ListOptions{PerPage: 100}
+	var files []*github.CommitFile
+	for {
+		page, resp, err := g.client.PullRequests.ListFiles(ctx, org, repo, number, opt)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, page...)
+		if resp.NextPage == 0 {
+			return files, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (g githubSCM) ListChangedFiles(org, repo string, number int) ([]ChangedFile, error) {
+	ctx := context.Background()
+	var files []ChangedFile
+	for page := 1; page != 0; {
+		u := fmt.Sprintf("repos/%s/%s/pulls/%d/files?per_page=100&page=%d", org, repo, number, page)
+		req, err := g.client.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch []ChangedFile
+		resp, err := g.client.Do(ctx, req, &batch)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, batch...)
+		page = resp.NextPage
+	}
+	return files, nil
+}
+
+func (g githubSCM) ListCommits(org, repo string, number int) ([]*github.RepositoryCommit, error) {
+	ctx := context.Background()
+	opt := &github.ListOptions{PerPage: 100}
+	var commits []*github.RepositoryCommit
+	for {
+		page, resp, err := g.client.PullRequests.ListCommits(ctx, org, repo, number, opt)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, page...)
+		if resp.NextPage == 0 {
+			return commits, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (g githubSCM) GetPatch(org, repo string, number int) (string, error) {
+	patch, _, err := g.client.PullRequests.GetRaw(context.Background(), org, repo, number, github.RawOptions{Type: github.Patch})
+	return patch, err
+}
+
+func (g githubSCM) CreatePullRequest(org, repo string, pr *github.NewPullRequest) (*github.PullRequest, error) {
+	created, _, err := g.client.PullRequests.Create(context.Background(), org, repo, pr)
+	return created, err
+}
+
+func (g githubSCM) CreateReview(org, repo string, number int, review *github.PullRequestReviewRequest) error {
+	_, _, err := g.client.PullRequests.CreateReview(context.Background(), org, repo, number, review)
+	return err
+}
+
+func (g githubSCM) GetIssue(org, repo string, number int) (*github.Issue, error) {
+	issue, _, err := g.client.Issues.Get(context.Background(), org, repo, number)
+	return issue, err
+}
+
+func (g githubSCM) EditIssue(org, repo string, number int, edit *github.IssueRequest) error {
+	_, _, err := g.client.Issues.Edit(context.Background(), org, repo, number, edit)
+	return err
+}
+
+func (g githubSCM) LockIssue(org, repo string, number int, reason string) error {
+	_, err := g.client.Issues.Lock(context.Background(), org, repo, number, &github.LockIssueOptions{LockReason: reason})
+	return err
+}
+
+func (g githubSCM) UnlockIssue(org, repo string, number int) error {
+	_, err := g.client.Issues.Unlock(context.Background(), org, repo, number)
+	return err
+}
+
+func (g githubSCM) ListIssueEvents(org, repo string, number int) ([]IssueEvent, error) {
+	ctx := context.Background()
+	var events []IssueEvent
+	// The vendored client doesn't decode the requested reviewers.
+	for page := 1; page != 0; {
+		u := fmt.Sprintf("repos/%s/%s/issues/%d/events?per_page=100&page=%d", org, repo, number, page)
+		req, err := g.client.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch []struct {
+			Event string `json:"event"`
+			Actor struct {
+				Login string `json:"login"`
+			} `json:"actor"`
+			Label struct {
+				Name string `json:"name"`
+			} `json:"label"`
+			RequestedReviewer struct {
+				Login string `json:"login"`
+			} `json:"requested_reviewer"`
+			CreatedAt time.Time `json:"created_at"`
+		}
+		resp, err := g.client.Do(ctx, req, &batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range batch {
+			events = append(events, IssueEvent{
+				Event:             e.Event,
+				Actor:             e.Actor.Login,
+				Label:             e.Label.Name,
+				RequestedReviewer: e.RequestedReviewer.Login,
+				CreatedAt:         e.CreatedAt,
+			})
+		}
+		page = resp.NextPage
+	}
+	return events, nil
+}
+
+func (g githubSCM) SearchIssues(query, sort string, limit int) ([]github.Issue, int, error) {
+	ctx := context.Background()
+	opt := &github.SearchOptions{Sort: sort, ListOptions: github.ListOptions{PerPage: 100}}
+	if sort != "" {
+		opt.Order = "asc"
+	}
+	if limit > 0 && limit < opt.PerPage {
+		opt.PerPage = limit
+	}
+	var issues []github.Issue
+	for {
+		result, resp, err := g.client.Search.Issues(ctx, query, opt)
+		if err != nil {
+			return nil, 0, err
+		}
+		issues = append(issues, result.Issues...)
+		if limit > 0 && len(issues) >= limit {
+			return issues[:limit], result.GetTotal(), nil
+		}
+		if resp.NextPage == 0 {
+			return issues, result.GetTotal(), nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (g githubSCM) TransferIssue(org, repo string, number int, dest string) (int, error) {
+	issue, err := g.GetIssue(org, repo, number)
+	if err != nil {
+		return 0, err
+	}
+	to, err := g.GetRepo(org, dest)
+	if err != nil {
+		return 0, err
+	}
+	var out struct {
+		TransferIssue struct {
+			Issue struct {
+				Number int `json:"number"`
+			} `json:"issue"`
+		} `json:"transferIssue"`
+	}
+	err = graphql(g.client, transferIssueMutation, map[string]interface{}{
+		"issueId":      issue.GetNodeID(),
+		"repositoryId": to.GetNodeID(),
+	}, &out)
+	return out.TransferIssue.Issue.Number, err
+}
+
+func (g githubSCM) CreateIssueReaction(org, repo string, number int, reaction string) error {
+	_, _, err := g.client.Reactions.CreateIssueReaction(context.Background(), org, repo, number, reaction)
+	return err
+}
+
+func (g githubSCM) CreateCommentReaction(org, repo string, id int64, reaction string) error {
+	_, _, err := g.client.Reactions.CreateIssueCommentReaction(context.Background(), org, repo, id, reaction)
+	return err
+}
+
+func (g githubSCM) ListComments(org, repo string, number int) ([]*github.IssueComment, error) {
+	ctx := context.Background()
+	opt := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	var comments []*github.IssueComment
+	for {
+		page, resp, err := g.client.Issues.ListComments(ctx, org, repo, number, opt)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, page...)
+		if resp.NextPage == 0 {
+			return comments, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (g githubSCM) CreateComment(org, repo string, number int, body string) (*github.IssueComment, error) {
+	comment, _, err := g.client.Issues.CreateComment(context.Background(), org, repo, number, &github.IssueComment{Body: github.String(body)})
+	return comment, err
+}
+
+func (g githubSCM) EditComment(org, repo string, id int64, body string) (*github.IssueComment, error) {
+	comment, _, err := g.client.Issues.EditComment(context.Background(), org, repo, id, &github.IssueComment{Body: github.String(body)})
+	return comment, err
+}
+
+func (g githubSCM) DeleteComment(org, repo string, id int64) error {
+	_, err := g.client.Issues.DeleteComment(context.Background(), org, repo, id)
+	return err
+}
+
+func (g githubSCM) ListLabels(org, repo string) ([]*github.Label, error) {
+	ctx := context.Background()
+	opt := &github.ListOptions{PerPage: 100}
+	var labels []*github.Label
+	for {
+		page, resp, err := g.client.Issues.ListLabels(ctx, org, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, page...)
+		if resp.NextPage == 0 {
+			return labels, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (g githubSCM) ListIssueLabels(org, repo string, number int) ([]*github.Label, error) {
+	labels, _, err := g.client.Issues.ListLabelsByIssue(context.Background(), org, repo, number, &github.ListOptions{PerPage: 100})
+	return labels, err
+}
+
+func (g githubSCM) AddLabels(org, repo string, number int, labels ...string) error {
+	_, _, err := g.client.Issues.AddLabelsToIssue(context.Background(), org, repo, number, labels)
+	return err
+}
+
+func (g githubSCM) RemoveLabel(org, repo string, number int, label string) error {
+	_, err := g.client.Issues.RemoveLabelForIssue(context.Background(), org, repo, number, label)
+	return err
+}
+
+func (g githubSCM) ListMilestones(org, repo string) ([]*github.Milestone, error) {
+	ctx := context.Background()
+	opt := &github.MilestoneListOptions{State: "all", ListOptions: github.ListOptions{PerPage: 100}}
+	var milestones []*github.Milestone
+	for {
+		page, resp, err := g.client.Issues.ListMilestones(ctx, org, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		milestones = append(milestones, page...)
+		if resp.NextPage == 0 {
+			return milestones, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (g githubSCM) IsAssignee(org, repo, login string) (bool, error) {
+	ok, _, err := g.client.Issues.IsAssignee(context.Background(), org, repo, login)
+	return ok, err
+}
+
+func (g githubSCM) AddAssignees(org, repo string, number int, logins ...string) error {
+	_, _, err := g.client.Issues.AddAssignees(context.Background(), org, repo, number, logins)
+	return err
+}
+
+func (g githubSCM) RemoveAssignees(org, repo string, number int, logins ...string) error {
+	_, _, err := g.client.Issues.RemoveAssignees(context.Background(), org, repo, number, logins)
+	return err
+}
+
+func (g githubSCM) RequestReviewers(org, repo string, number int, logins ...string) error {
+	_, _, err := g.client.PullRequests.RequestReviewers(context.Background(), org, repo, number, github.ReviewersRequest{Reviewers: logins})
+	return err
+}
+
+func (g githubSCM) RemoveReviewers(org, repo string, number int, logins ...string) error {
+	_, err := g.client.PullRequests.RemoveReviewers(context.Background(), org, repo, number, github.ReviewersRequest{Reviewers: logins})
+	return err
+}
+
+func (g githubSCM) IsCollaborator(org, repo, login string) (bool, error) {
+	ok, _, err := g.client.Repositories.IsCollaborator(context.Background(), org, repo, login)
+	return ok, err
+}
+
+func (g githubSCM) IsMember(org, login string) (bool, error) {
+	ok, _, err := g.client.Organizations.IsMember(context.Background(), org, login)
+	return ok, err
+}
+
+func (g githubSCM) IsTeamMember(org, team, login string) (bool, error) {
+	ctx := context.Background()
+	var id int64
+	opt := &github.ListOptions{PerPage: 100}
+	for id == 0 {
+		teams, resp, err := g.client.Teams.ListTeams(ctx, org, opt)
+		if err != nil {
+			return false, err
+		}
+		for _, t := range teams {
+			if t.GetSlug() == team {
+				id = t.GetID()
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	if id == 0 {
+		return false, fmt.Errorf("there is no team %s in %s", team, org)
+	}
+	membership, _, err := g.client.Teams.GetTeamMembership(ctx, id, login)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return membership.GetState() == "active", nil
+}
+
+func (g githubSCM) GetPermissionLevel(org, repo, login string) (string, error) {
+	level, _, err := g.client.Repositories.GetPermissionLevel(context.Background(), org, repo, login)
+	return level.GetPermission(), err
+}
+
+func (g githubSCM) GetRepo(org, repo string) (*github.Repository, error) {
+	r, _, err := g.client.Repositories.Get(context.Background(), org, repo)
+	return r, err
+}
+
+func (g githubSCM) GetBranch(org, repo, branch string) (*github.Branch, error) {
+	b, _, err := g.client.Repositories.GetBranch(context.Background(), org, repo, branch)
+	return b, err
+}
+
+func (g githubSCM) DeleteBranch(org, repo, branch string) error {
+	_, err := g.client.Git.DeleteRef(context.Background(), org, repo, "heads/"+branch)
+	return err
+}
+
+func (g githubSCM) GetRequiredStatusChecks(org, repo, branch string) ([]string, error) {
+	checks, _, err := g.client.Repositories.GetRequiredStatusChecks(context.Background(), org, repo, branch)
+	if isNotFound(err) {
+		// Unprotected branches have no required checks.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checks.Contexts, nil
+}
+
+func (g githubSCM) GetFile(org, repo, path, ref string) ([]byte, error) {
+	fc, _, _, err := g.client.Repositories.GetContents(context.Background(), org, repo, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		return nil, err
+	}
+	if fc == nil {
+		return nil, fmt.Errorf("%s of %s/%s is a directory", path, org, repo)
+	}
+	content, err := fc.GetContent()
+	return []byte(content), err
+}
+
+func (g githubSCM) ListTree(org, repo, sha string) ([]string, error) {
+	tree, _, err := g.client.Git.GetTree(context.Background(), org, repo, sha, true)
+	if err != nil {
+		return nil, err
+	}
+	if tree.GetTruncated() {
+		return nil, fmt.Errorf("the tree of %s/%s@%s is too large to list", org, repo, sha)
+	}
+	var paths []string
+	for _, e := range tree.Entries {
+		if e.GetType() == "blob" {
+			paths = append(paths, e.GetPath())
+		}
+	}
+	return paths, nil
+}
+
+func (g githubSCM) GetTreeHash(org, repo, sha string) (string, error) {
+	commit, _, err := g.client.Git.GetCommit(context.Background(), org, repo, sha)
+	if err != nil {
+		return "", err
+	}
+	return commit.GetTree().GetSHA(), nil
+}
+
+func (g githubSCM) Blame(org, repo, sha, path string) ([]BlameRange, error) {
+	var out struct {
+		Repository struct {
+			Object struct {
+				Blame struct {
+					Ranges []blameRange `json:"ranges"`
+				} `json:"blame"`
+			} `json:"object"`
+		} `json:"repository"`
+	}
+	err := graphql(g.client, blameQuery, map[string]interface{}{
+		"owner": org,
+		"name":  repo,
+		"sha":   sha,
+		"path":  path,
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []BlameRange
+	for _, r := range out.Repository.Object.Blame.Ranges {
+		login := ""
+		if r.Commit.Author.User != nil {
+			login = r.Commit.Author.User.Login
+		}
+		ranges = append(ranges, BlameRange{StartLine: r.StartingLine, EndLine: r.EndingLine, Login: login, Date: r.Commit.CommittedDate})
+	}
+	return ranges, nil
+}
+
+func (g githubSCM) CreateStatus(org, repo, sha string, status *github.RepoStatus) error {
+	_, _, err := g.client.Repositories.CreateStatus(context.Background(), org, repo, sha, status)
+	return err
+}
+
+func (g githubSCM) GetCombinedStatus(org, repo, sha string) (*github.CombinedStatus, error) {
+	combined, _, err := g.client.Repositories.GetCombinedStatus(context.Background(), org, repo, sha, &github.ListOptions{PerPage: 100})
+	return combined, err
+}
+
+func (g githubSCM) ListProjects(org, repo string) ([]*github.Project, error) {
+	ctx := context.Background()
+	opt := &github.ProjectListOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	projects, _, err := g.client.Repositories.ListProjects(ctx, org, repo, opt)
+	if err != nil {
+		return nil, err
+	}
+	orgProjects, _, err := g.client.Organizations.ListProjects(ctx, org, opt)
+	if err != nil {
+		return nil, err
+	}
+	return append(projects, orgProjects...), nil
+}
+
+func (g githubSCM) ListProjectColumns(project int64) ([]*github.ProjectColumn, error) {
+	columns, _, err := g.client.Projects.ListProjectColumns(context.Background(), project, &github.ListOptions{PerPage: 100})
+	return columns, err
+}
+
+func (g githubSCM) ListProjectCards(column int64) ([]*github.ProjectCard, error) {
+	cards, _, err := g.client.Projects.ListProjectCards(context.Background(), column, &github.ProjectCardListOptions{ListOptions: github.ListOptions{PerPage: 100}})
+	return cards, err
+}
+
+func (g githubSCM) MoveProjectCard(card, column int64) error {
+	_, err := g.client.Projects.MoveProjectCard(context.Background(), card, &github.ProjectCardMoveOptions{Position: "top", ColumnID: column})
+	return err
+}
+
+func (g githubSCM) CreateProjectCard(column, content int64, contentType string) error {
+	_, _, err := g.client.Projects.CreateProjectCard(context.Background(), column, &github.ProjectCardOptions{ContentID: content, ContentType: contentType})
+	return err
+}
+
+// pathEscape escapes each segment of a file path.
+func pathEscape(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// reviewMessage is a review as a single comment, for the code hosts
+// without reviews commenting diff positions.
+func reviewMessage(review *github.PullRequestReviewRequest) string {
+	lines := []string{review.GetBody()}
+	for _, c := range review.Comments {
+		lines = append(lines, fmt.Sprintf("* `%s`: %s", c.GetPath(), c.GetBody()))
+	}
+	return strings.Join(lines, "\n")
+}
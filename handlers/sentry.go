@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Sentry reports the panics of the handlers, the errors they log and the
+// failures of their GitHub API calls, with the webhook they were handling,
+// to a Sentry project.
+type Sentry struct {
+	// DSN is the client key of the project, e.g.
+	// https://key@o0.ingest.sentry.io/0.
+	DSN string `json:"dsn,omitempty"`
+	// Environment tells the deployments of the bot apart, e.g. staging.
+	Environment string `json:"environment,omitempty"`
+}
+
+func (c *Config) validateSentry() error {
+	if c.Sentry.DSN == "" {
+		return nil
+	}
+	if _, _, err := sentryEndpoint(c.Sentry.DSN); err != nil {
+		return fmt.Errorf("sentry: %v", err)
+	}
+	return nil
+}
+
+// sentryEndpoint returns the envelope endpoint of the project of dsn and
+// its key.
+func sentryEndpoint(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid dsn: %v", err)
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || i < 0 || u.Path[i+1:] == "" {
+		return "", "", fmt.Errorf("dsn %q has no key or project", u.Redacted())
+	}
+	project := u.Path[i+1:]
+	return fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, u.Path[:i], project), u.User.Username(), nil
+}
+
+// errorReporter holds the project errors are reported to. Nothing is
+// reported until enableSentry sets it.
+var errorReporter = struct {
+	sync.Mutex
+	config   Sentry
+	endpoint string
+	key      string
+}{}
+
+// enableSentry starts reporting errors to Sentry, if configured.
+func (s *Server) enableSentry() {
+	if s.Config.Sentry.DSN == "" {
+		return
+	}
+	endpoint, key, err := sentryEndpoint(s.Config.Sentry.DSN)
+	if err != nil {
+		glog.Errorf("fail to enable sentry: %v", err)
+		return
+	}
+	errorReporter.Lock()
+	defer errorReporter.Unlock()
+	errorReporter.config, errorReporter.endpoint, errorReporter.key = s.Config.Sentry, endpoint, key
+}
+
+// sentryEvent is an error as Sentry ingests it.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// reportError reports an error, tagged with what the bot was doing, e.g.
+// the webhook and plugin of the handler that failed.
+func reportError(message string, tags map[string]string) {
+	sendSentryEvent("error", message, tags, nil)
+}
+
+// reportPanic reports a recovered panic with its stack.
+func reportPanic(r interface{}, stack []byte, tags map[string]string) {
+	sendSentryEvent("fatal", fmt.Sprintf("panic: %v", r), tags, map[string]string{"stack": string(stack)})
+}
+
+func sendSentryEvent(level, message string, tags, extra map[string]string) {
+	errorReporter.Lock()
+	config, endpoint, key := errorReporter.config, errorReporter.endpoint, errorReporter.key
+	errorReporter.Unlock()
+	if endpoint == "" {
+		return
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	host, _ := os.Hostname()
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Platform:    "go",
+		Level:       level,
+		Logger:      "ci-bot",
+		ServerName:  host,
+		Environment: config.Environment,
+		Message:     message,
+		Tags:        tags,
+		Extra:       extra,
+	}
+	go func() {
+		if err := postSentryEvent(endpoint, key, event); err != nil {
+			glog.Errorf("fail to report %s to sentry: %v", event.EventID, err)
+		}
+	}()
+}
+
+// postSentryEvent sends an event in an envelope, the one item of which is
+// the event.
+func postSentryEvent(endpoint, key string, event sentryEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "{\"event_id\":%q}\n", event.EventID)
+	fmt.Fprintf(&body, "{\"type\":\"event\",\"length\":%d}\n", len(payload))
+	body.Write(payload)
+	body.WriteString("\n")
+	req, err := http.NewRequest(http.MethodPost, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=ci-bot/1.0, sentry_key=%s", key))
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
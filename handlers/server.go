@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"regexp"
 	"strconv"
 	"encoding/json"
 	"github.com/golang/glog"
@@ -25,6 +26,10 @@ type Server struct {
 	Config       Config
 	GithubClient *github.Client
 	Context      context.Context
+	// BotName is the login of the account the bot acts as.
+	BotName string
+
+	mergeWindows *mergeWindowRefresh
 }
 
 type Config struct {
@@ -32,7 +37,200 @@ type Config struct {
 	Repo          string `json:"repo"`
 	GitHubToken   string `json:"git_hub_token"`
 	WebhookSecret string `json:"webhook_secret"`
+	// WebhookSecretFile lists more webhook secrets, one per line, the
+	// first of which hooks are created with. cmd/hmac rotates them.
+	WebhookSecretFile string `json:"webhook_secret_file,omitempty"`
 	CircleCIToken string `json:"circle_ci_token"`
+	// Providers map the orgs hosted elsewhere than on GitHub to their code
+	// host.
+	Providers map[string]Provider `json:"providers,omitempty"`
+	// Proxy re-emits the events of every provider to external plugins.
+	Proxy Proxy `json:"proxy,omitempty"`
+	// PubSub relays the GitHub webhooks through Cloud Pub/Sub.
+	PubSub PubSub `json:"pubsub,omitempty"`
+	// Bus is the NATS server webhooks are consumed from and events
+	// published to.
+	Bus Bus `json:"bus,omitempty"`
+	// Relay is the channel the webhooks are received through when GitHub
+	// can't reach the bot.
+	Relay Relay `json:"relay,omitempty"`
+	// Redelivery recovers the webhooks missed while the bot was down.
+	Redelivery Redelivery `json:"redelivery,omitempty"`
+	// Tracing exports traces of the handling of webhooks.
+	Tracing Tracing `json:"tracing,omitempty"`
+	// Sentry reports the errors of the handlers.
+	Sentry Sentry `json:"sentry,omitempty"`
+	// Webhooks registers the hooks delivering to the bot.
+	Webhooks Webhooks `json:"webhooks,omitempty"`
+	// CircleCI, Jenkins, GitLabCI and Travis run the CI jobs of PRs.
+	CircleCI CircleCI `json:"circleci,omitempty"`
+	Jenkins  Jenkins  `json:"jenkins,omitempty"`
+	GitLabCI GitLabCI `json:"gitlab_ci,omitempty"`
+	Travis   Travis   `json:"travis,omitempty"`
+	// Jobs are run by the bot itself in Kubernetes pods.
+	Jobs JobConfig `json:"jobs,omitempty"`
+	// Coverage maps an org or org/repo to the presubmits whose coverage
+	// is reported on PRs.
+	Coverage map[string][]Coverage `json:"coverage,omitempty"`
+	// Benchmark maps an org or org/repo to the presubmits whose benchmarks
+	// are compared with the base branch on PRs.
+	Benchmark map[string][]Benchmark `json:"benchmark,omitempty"`
+	// LeaderElection elects the replica running the periodic tasks.
+	LeaderElection LeaderElection `json:"leader_election,omitempty"`
+	// AuditLog is the path of the JSON lines file the bot appends records
+	// of its destructive actions to.
+	AuditLog string `json:"audit_log,omitempty"`
+	Heart    Heart  `json:"heart,omitempty"`
+	Golint   Golint `json:"golint,omitempty"`
+
+	// GateURL is where the /gate endpoint of the bot is reachable, e.g.
+	// https://ci-bot.example.com/gate. The summary status of PRs links to
+	// their merge gate decision there when set.
+	GateURL string `json:"gate_url,omitempty"`
+	// GateToken is the token clients of /gate must send as a bearer token.
+	// /gate is disabled while it's unset.
+	GateToken string `json:"gate_token,omitempty"`
+	// GateRepos lists the orgs and org/repos /gate serves the decisions of.
+	GateRepos []string `json:"gate_repos,omitempty"`
+	// BranchPolicies maps an org to the branch policies of its repos.
+	BranchPolicies map[string]OrgBranchPolicy `json:"branch_policies,omitempty"`
+	// Locales maps an org to the locale its reports are formatted in.
+	Locales map[string]LocaleConfig `json:"locales,omitempty"`
+	// CommentCleanup decides what happens to transient bot comments once
+	// the issue or PR they were posted on is closed.
+	CommentCleanup CommentCleanup `json:"comment_cleanup,omitempty"`
+	// RequireMatchingLabel lists the label requirements of issues and PRs.
+	RequireMatchingLabel []RequireMatchingLabel `json:"require_matching_label,omitempty"`
+	SigMention           SigMention             `json:"sigmention,omitempty"`
+	// PluginHelpSourceURL is where the config file can be browsed, e.g.
+	// https://github.com/org/repo/blob/master/config.json. Plugin help
+	// links to the lines of the file configuring each plugin.
+	PluginHelpSourceURL string        `json:"plugin_help_source_url,omitempty"`
+	ConfigUpdater       ConfigUpdater `json:"config_updater,omitempty"`
+	Help                Help          `json:"help,omitempty"`
+	// Dco is keyed by the org or org/repo the DCO check applies to.
+	Dco           map[string]Dco `json:"dco,omitempty"`
+	BranchCleaner BranchCleaner  `json:"branch_cleaner,omitempty"`
+	// Project is keyed by the org or org/repo the boards belong to.
+	Project map[string]ProjectConfig `json:"project,omitempty"`
+	Slack   Slack                    `json:"slack,omitempty"`
+	Email   Email                    `json:"email,omitempty"`
+	// OutboundWebhooks post events to Teams, Discord, Mattermost...
+	OutboundWebhooks []OutboundWebhook `json:"outbound_webhooks,omitempty"`
+	Cat     Cat                      `json:"cat,omitempty"`
+	Joke    Joke                     `json:"joke,omitempty"`
+	// Cla is keyed by the org or org/repo the CLA check applies to.
+	Cla     map[string]Cla `json:"cla,omitempty"`
+	Lgtm    []Lgtm         `json:"lgtm,omitempty"`
+	Approve []Approve      `json:"approve,omitempty"`
+	// PathLabel labels PRs by the files they change.
+	PathLabel  []PathLabel  `json:"path_label,omitempty"`
+	TitleCheck []TitleCheck `json:"title_check,omitempty"`
+	CrossLink  []CrossLink  `json:"crosslink,omitempty"`
+
+	FirstTimeContributor FirstTimeContributor `json:"first_time_contributor,omitempty"`
+	// MilestoneApplier maps an org/repo to the milestone of the PRs
+	// targeting each of its branches.
+	MilestoneApplier map[string]map[string]string `json:"milestone_applier,omitempty"`
+	Cherrypicker     Cherrypicker                 `json:"cherrypicker,omitempty"`
+	Stale            Stale                        `json:"stale,omitempty"`
+	LockClosed       LockClosed                   `json:"lock_closed,omitempty"`
+	Duplicate        Duplicate                    `json:"duplicate,omitempty"`
+	Owners           Owners                       `json:"owners,omitempty"`
+	Blunderbuss      Blunderbuss                  `json:"blunderbuss,omitempty"`
+	// OwnersDirBlacklist applies to every plugin reading OWNERS files.
+	OwnersDirBlacklist OwnersDirBlacklist `json:"owners_dir_blacklist,omitempty"`
+	VerifyOwners       VerifyOwners       `json:"verify_owners,omitempty"`
+	ReviewReminder     ReviewReminder     `json:"review_reminder,omitempty"`
+	Availability       Availability       `json:"availability,omitempty"`
+}
+
+// Golint holds configuration for the golint plugin
+type Golint struct {
+	// MinimumConfidence is the smallest permissible confidence
+	// in (0,1] over which problems will be printed. Defaults to
+	// 0.8, as does the `go lint` tool.
+	MinimumConfidence *float64 `json:"minimum_confidence,omitempty"`
+}
+
+// Heart contains the configuration for reacting to comments and PRs with
+// emojis.
+type Heart struct {
+	// Adorees is the list of GitHub logins for members
+	// for whom we will add emojis to comments
+	Adorees []string `json:"adorees,omitempty"`
+	// CommentRegexp is the regular expression for comments
+	// made by adorees that the bot adds emojis to.
+	// If not specified, only the adorees are matched.
+	CommentRegexp string `json:"comment_regexp,omitempty"`
+
+	// commentRe is CommentRegexp compiled by validateHeart.
+	commentRe *regexp.Regexp
+}
+
+// LoadConfig reads, parses and validates the config file at path.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	configContent, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("could not read config file: %v", err)
+	}
+	err = json.Unmarshal(configContent, &config)
+	if err != nil {
+		return config, fmt.Errorf("fail to unmarshal: %v", err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("invalid config: %v", err)
+	}
+	return config, nil
+}
+
+// validate checks the parts of the config that unmarshalling can't.
+func (c *Config) validate() error {
+	validators := []func() error{
+		c.validateBranchPolicies,
+		c.validateLocales,
+		c.validateCommentCleanup,
+		c.validateRequireMatchingLabel,
+		c.validateSigMention,
+		c.validateConfigUpdater,
+		c.validateProject,
+		c.validateSlack,
+		c.validateCla,
+		c.validatePathLabel,
+		c.validateHeart,
+		c.validateTitleCheck,
+		c.validateCrossLink,
+		c.validateCherrypicker,
+		c.validateOwnersDirBlacklist,
+		c.validateProviders,
+		c.validateLgtm,
+		c.validateJenkins,
+		c.validateGitLabCI,
+		c.validatePresets,
+		c.validateDecoration,
+		c.validateSinker,
+		c.validateFlaky,
+		c.validateJobs,
+		c.validateCoverage,
+		c.validateBenchmark,
+		c.validateProxy,
+		c.validatePubSub,
+		c.validateBus,
+		c.validateRelay,
+		c.validateRedelivery,
+		c.validateWebhooks,
+		c.validateEmail,
+		c.validateOutboundWebhooks,
+		c.validateTracing,
+		c.validateSentry,
+	}
+	for _, v := range validators {
+		if err := v(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type WebHookServer struct {
@@ -59,60 +257,64 @@ func  AddFlags(fs *pflag.FlagSet,s *WebHookServer) {
 
 // ServeHTTP validates an incoming webhook and invoke its handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	payload, err := github.ValidatePayload(r, []byte(s.Config.WebhookSecret))
+	payload, err := s.validateWebhook(r)
 	if err != nil {
 		glog.Errorf("Invalid payload: %v", err)
+		webhooksInvalidSignature.inc("github", github.WebHookType(r))
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
 		return
 	}
 	event, err := github.ParseWebHook(github.WebHookType(r), payload)
-	fmt.Println("************ event payload **************",event)
 	if err != nil {
-		glog.Errorf("Failed to parse webhook")
-		fmt.Println()
-		fmt.Println("******************Inside error condition********************")
-		fmt.Println()
+		glog.Errorf("Failed to parse webhook: %v", err)
+		http.Error(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 	fmt.Fprint(w, "Received a webhook event")
+	markDelivered(github.DeliveryID(r))
 
 	//glog.Infof("body: %v", string(payload))
 
 	var client http.Client
 	client.Do(r)
+	if err := s.dispatchEvent(event, github.WebHookType(r), github.DeliveryID(r), payload, ClientRepo); err != nil {
+		glog.V(2).Infof("ignoring %s event %s: %v", github.WebHookType(r), github.DeliveryID(r), err)
+	}
+}
+
+// dispatchEvent queues a parsed event for its handler, whichever way it was
+// delivered, with the config last loaded. It fails for the events the bot
+// has no handler for.
+func (s *Server) dispatchEvent(event interface{}, eventType, delivery string, payload []byte, client *github.Client) error {
+	s = s.current()
 	switch event.(type) {
-	case *github.IssueEvent:
-		fmt.Println(" $$$$$$$$$$ Switch IssueEvent $$$$$$$$$$$$$$$")
-		go s.handleIssueEvent(payload)
+	case *github.IssuesEvent:
+		s.handleAsync(eventType, delivery, payload, client, func(client *github.Client) { s.handleIssueEvent(payload, client) })
 	case *github.IssueCommentEvent:
 		// Comments on PRs belong to IssueCommentEvent
-		fmt.Println(" $$$$$$$$$$ Switch IssueCommentEvent $$$$$$$$$$$$$$$")
-		go s.handleIssueCommentEvent(payload,ClientRepo)
+		s.handleAsync(eventType, delivery, payload, client, func(client *github.Client) { s.handleIssueCommentEvent(payload,client) })
 	case *github.PullRequestEvent:
-		fmt.Println(" $$$$$$$$$$ Switch Pull Request $$$$$$$$$$$$$$$")
-		go s.handlePullRequestEvent(payload,ClientRepo)
+		s.handleAsync(eventType, delivery, payload, client, func(client *github.Client) { s.handlePullRequestEvent(payload,client) })
+	case *github.PullRequestReviewEvent:
+		s.handleAsync(eventType, delivery, payload, client, func(client *github.Client) { s.handlePullRequestReviewEvent(payload, client) })
+	case *github.PushEvent:
+		s.handleAsync(eventType, delivery, payload, client, func(client *github.Client) { s.handlePushEvent(payload, client) })
+	case *github.StatusEvent:
+		s.handleAsync(eventType, delivery, payload, client, func(client *github.Client) { s.handleStatusEvent(payload, client) })
 	case *github.PullRequestComment:
-		fmt.Println(" $$$$$$$$$$ Switch Pull Request Comment $$$$$$$$$$$$$$$")
-		go s.handlePullRequestCommentEvent(payload)
+		s.handleAsync(eventType, delivery, payload, client, func(client *github.Client) { s.handlePullRequestCommentEvent(payload) })
 	default:
-		fmt.Println()
-		fmt.Println("**************default payload***********", event)
-		fmt.Println()
-
+		return fmt.Errorf("no handler for %s events", eventType)
 	}
+	return nil
 }
 
 var ClientRepo *github.Client
 
 func  Run(s * WebHookServer) {
-	fmt.Println("Inside RUN()")
-	configContent, err := ioutil.ReadFile(s.ConfigFile)
+	config, err := LoadConfig(s.ConfigFile)
 	if err != nil {
-		glog.Fatal("Could not read config file: %v", err)
-	}
-	var config Config
-	err = json.Unmarshal(configContent, &config)
-	if err != nil {
-		glog.Fatal("fail to unmarshal: %v", err)
+		glog.Fatal(err)
 	}
 //	oauthSecret := config.GitHubToken
 //	fmt.Println("oauthSecret",oauthSecret)
@@ -135,10 +337,9 @@ func  Run(s * WebHookServer) {
 		Password: strings.TrimSpace(password),
 	}
 
-	client := github.NewClient(tp.Client())
+	client := newGitHubClient(&tp)
 	ctx = context.Background()
 	user, _, err := client.Users.Get(ctx, "")
-	fmt.Println("user",user)
 	// Is this a two-factor auth error? If so, prompt for OTP and try again.
 	if _, ok := err.(*github.TwoFactorAuthError); ok {
 		fmt.Print("\nGitHub OTP: ")
@@ -153,7 +354,6 @@ func  Run(s * WebHookServer) {
 	}
 
 	ClientRepo = client
-	fmt.Println("Inside RUN() ", *(ClientRepo.Repositories))
 	// return 200 on / for health checks.
 	//http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {fmt.Print("hello")})
 
@@ -162,9 +362,29 @@ func  Run(s * WebHookServer) {
 		Config:       config,
 		GithubClient: client,
 		Context:      ctx,
+		BotName:      user.GetLogin(),
+		mergeWindows: &mergeWindowRefresh{},
 	}
 	//setting handler
-	http.HandleFunc("/hook", webHookHandler.ServeHTTP)
+	http.HandleFunc("/hook", webHookHandler.withConfig((*Server).ServeHTTP))
+	http.HandleFunc("/gitea-hook", webHookHandler.withConfig((*Server).serveGiteaHook))
+	http.HandleFunc("/bitbucket-hook", webHookHandler.withConfig((*Server).serveBitbucketHook))
+	http.HandleFunc("/logs/", webHookHandler.withConfig((*Server).serveJobLogs))
+	http.HandleFunc("/jobs", webHookHandler.withConfig((*Server).serveJobHistory))
+	http.HandleFunc("/metrics", webHookHandler.serveMetrics)
+	http.HandleFunc("/gate", webHookHandler.withConfig((*Server).serveGate))
+	webHookHandler.runTracing()
+	webHookHandler.enableSentry()
+	webHookHandler.runLeaderElection()
+	webHookHandler.runPeriodics(client)
+	webHookHandler.runPubSub(client)
+	webHookHandler.runBus(client)
+	webHookHandler.runRelay()
+
+	helpAgent := &HelpAgent{}
+	helpAgent.Refresh(config, s.ConfigFile)
+	http.Handle("/plugin-help", helpAgent)
+	webHookHandler.watchConfig(s.ConfigFile, helpAgent)
 
 	address := s.Address + ":" + strconv.FormatInt(s.Port, 10)
 	//starting server
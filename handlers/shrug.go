@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"github.com/google/go-github/github"
+)
+
+const shrugLabel = `¯\_(ツ)_/¯`
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "shrug",
+		Events:      []string{"issue_comment"},
+		Description: "The shrug plugin adds or removes the '" + shrugLabel + "' label. It is a minimal example of a command plugin.",
+		Commands: []PluginCommand{{
+			Usage:       "/[un]shrug",
+			Description: "Adds or removes the '" + shrugLabel + "' label.",
+			WhoCanUse:   "Anyone",
+			Example:     "/shrug",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleShrug adds or removes the shrug label.
+func (s *Server) handleShrug(client *github.Client, ic *github.IssueCommentEvent) {
+	number := ic.GetIssue().GetNumber()
+	shrugged := hasLabel(issueLabels(ic.GetIssue()), shrugLabel)
+	m := shrugReg.FindStringSubmatch(ic.GetComment().GetBody())
+	if m[1] != "" {
+		if shrugged {
+			removeLabel(client, ic.Repo, number, shrugLabel)
+		}
+		return
+	}
+	if !shrugged {
+		addLabel(client, ic.Repo, number, shrugLabel)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const defaultSigMentionRegexp = `(?m)@[\w-]+/sig-([\w-]*)-(misc|test-failures|bugs|feature-requests|proposals|pr-reviews|api-reviews)`
+
+// sigMentionKinds maps the suffix of a team mention to the kind label it
+// implies.
+var sigMentionKinds = map[string]string{
+	"bugs":             "kind/bug",
+	"feature-requests": "kind/feature",
+	"api-reviews":      "kind/api-change",
+	"proposals":        "kind/design",
+}
+
+// SigMention specifies configuration for the sigmention plugin.
+type SigMention struct {
+	// Regexp parses comments and should return matches to team mentions.
+	// These mentions enable labeling issues or PRs with sig/team labels.
+	// Furthermore, teams with the following suffixes will be mapped to
+	// kind/* labels:
+	//
+	// * @org/team-bugs             --maps to--> kind/bug
+	// * @org/team-feature-requests --maps to--> kind/feature
+	// * @org/team-api-reviews      --maps to--> kind/api-change
+	// * @org/team-proposals        --maps to--> kind/design
+	//
+	// Note that you need to make sure your regexp covers the above
+	// mentions if you want to use the extra labeling functionality.
+	Regexp string `json:"regexp,omitempty"`
+	// Re is the compiled version of Regexp. It should not be specified in config.
+	Re *regexp.Regexp `json:"-"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "sigmention",
+		Events:      []string{"issue_comment", "issues", "pull_request"},
+		Description: "The sigmention plugin responds to SIG (Special Interest Group) GitHub team mentions like '@org/sig-testing-bugs' by applying the matching 'sig/*' and 'kind/*' labels, in this case 'sig/testing' and 'kind/bug', as long as the labels exist in the repo.",
+		ConfigKey:   "sigmention",
+	}, enabledEverywhere)
+}
+
+// handleSigMention labels an issue or PR after the sig teams mentioned in
+// body. Only labels that exist in the repo and aren't already applied are
+// added.
+func (s *Server) handleSigMention(client *github.Client, repo *github.Repository, number int, body string, current []*github.Label) {
+	matches := s.Config.SigMention.Re.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		return
+	}
+
+	org := repo.GetOwner().GetLogin()
+	repoLabels, err := scmFor(client).ListLabels(org, repo.GetName())
+	if err != nil {
+		logError(client, "fail to list labels of %s: %v", repo.GetFullName(), err)
+		return
+	}
+	exists := map[string]bool{}
+	for _, l := range repoLabels {
+		exists[strings.ToLower(l.GetName())] = true
+	}
+
+	var toAdd []string
+	seen := map[string]bool{}
+	for _, m := range matches {
+		candidates := []string{"sig/" + strings.ToLower(m[1])}
+		if kind, ok := sigMentionKinds[m[2]]; ok {
+			candidates = append(candidates, kind)
+		}
+		for _, l := range candidates {
+			if seen[l] || !exists[l] || hasLabel(current, l) {
+				continue
+			}
+			seen[l] = true
+			toAdd = append(toAdd, l)
+		}
+	}
+	if len(toAdd) == 0 {
+		return
+	}
+	if err := scmFor(client).AddLabels(org, repo.GetName(), number, toAdd...); err != nil {
+		logError(client, "fail to add %v to %s#%d: %v", toAdd, repo.GetFullName(), number, err)
+	}
+}
+
+func (c *Config) validateSigMention() error {
+	if c.SigMention.Regexp == "" {
+		c.SigMention.Regexp = defaultSigMentionRegexp
+	}
+	re, err := regexp.Compile(c.SigMention.Regexp)
+	if err != nil {
+		return fmt.Errorf("invalid sigmention regexp %q: %v", c.SigMention.Regexp, err)
+	}
+	if re.NumSubexp() != 2 {
+		return fmt.Errorf("sigmention regexp %q must have two capturing groups", c.SigMention.Regexp)
+	}
+	c.SigMention.Re = re
+	return nil
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const (
+	sinkerInterval = 10 * time.Minute
+
+	defaultSinkerSuccessTTL = 24 * time.Hour
+	defaultSinkerFailureTTL = 72 * time.Hour
+	defaultSinkerJobTTL     = 7 * 24 * time.Hour
+)
+
+// SinkerConfig configures how long completed jobs and their pods are kept.
+type SinkerConfig struct {
+	// SuccessTTL is how long the pods of successful jobs are kept after
+	// they complete, 24h by default.
+	SuccessTTL string `json:"success_ttl,omitempty"`
+	// FailureTTL is how long the pods of the other completed jobs are kept,
+	// for debugging, 72h by default.
+	FailureTTL string `json:"failure_ttl,omitempty"`
+	// JobTTL is how long completed jobs are kept in the job history, 168h
+	// by default. The last run of each periodic is always kept.
+	JobTTL string `json:"job_ttl,omitempty"`
+
+	successTTL time.Duration
+	failureTTL time.Duration
+	jobTTL     time.Duration
+}
+
+func init() {
+	registerPeriodic("sinker", sinkerInterval, func(c *Config) bool {
+		return c.Jobs.enabled()
+	}, (*Server).sink)
+}
+
+func (c *Config) validateSinker() error {
+	s := &c.Jobs.Sinker
+	s.successTTL, s.failureTTL, s.jobTTL = defaultSinkerSuccessTTL, defaultSinkerFailureTTL, defaultSinkerJobTTL
+	for _, f := range []struct {
+		name  string
+		value string
+		out   *time.Duration
+	}{{"success_ttl", s.SuccessTTL, &s.successTTL}, {"failure_ttl", s.FailureTTL, &s.failureTTL}, {"job_ttl", s.JobTTL, &s.jobTTL}} {
+		if f.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.value)
+		if err != nil {
+			return fmt.Errorf("jobs: invalid sinker %s: %v", f.name, err)
+		}
+		*f.out = d
+	}
+	return nil
+}
+
+// podTTL is how long the pod of a completed job is kept.
+func (c SinkerConfig) podTTL(j *Job) time.Duration {
+	if j.State == SuccessState {
+		return c.successTTL
+	}
+	return c.failureTTL
+}
+
+// sink deletes the pods of jobs completed for longer than their TTL, the
+// completed pods of jobs the bot doesn't know of, and the old jobs.
+func (s *Server) sink(client *github.Client) {
+	k, err := s.jobKubeClient()
+	if err != nil {
+		logError(client, "fail to create Kubernetes client for jobs: %v", err)
+		return
+	}
+	now := time.Now()
+	cfg := s.Config.Jobs.Sinker
+
+	known := map[string]*Job{}
+	for _, j := range s.listJobs(func(j *Job) bool { return true }) {
+		j := j
+		known[j.ID] = &j
+	}
+	namespace := s.jobNamespace(k)
+	pods, err := k.listPods(namespace, map[string]string{jobCreatedByLabel: "true"})
+	if err != nil {
+		logError(client, "fail to list the pods of jobs: %v", err)
+		return
+	}
+	for _, pod := range pods {
+		var expired bool
+		if j, ok := known[pod.Metadata.Labels[jobIDLabel]]; ok {
+			expired = j.Complete() && now.Sub(*j.EndTime) > cfg.podTTL(j)
+		} else {
+			// The job was forgotten: the pod can go once it stopped running
+			// for as long as failed jobs are kept.
+			done := pod.Status.Phase == PodSucceeded || pod.Status.Phase == PodFailed
+			created := pod.Metadata.CreationTimestamp
+			expired = done && created != nil && now.Sub(*created) > cfg.failureTTL
+		}
+		if !expired {
+			continue
+		}
+		if err := k.deletePod(namespace, pod.Metadata.Name); err != nil {
+			if _, ok := err.(kubeNotFound); !ok {
+				logError(client, "fail to delete pod %s: %v", pod.Metadata.Name, err)
+			}
+			continue
+		}
+		glog.Infof("sinker deleted pod %s", pod.Metadata.Name)
+	}
+
+	s.deleteJobs(func(j *Job, newestPeriodic bool) bool {
+		return j.Complete() && !newestPeriodic && now.Sub(*j.EndTime) > cfg.jobTTL
+	})
+}
+
+// deleteJobs deletes the jobs matching remove, told whether a job is the
+// newest run of a periodic, which schedules the next one.
+func (s *Server) deleteJobs(remove func(j *Job, newestPeriodic bool) bool) {
+	deleted := 0
+	s.withJobs(func() bool {
+		newest := map[string]*Job{}
+		for _, j := range jobs.m {
+			if j.Type != PeriodicJob {
+				continue
+			}
+			if n, ok := newest[j.Name]; !ok || j.StartTime.After(n.StartTime) {
+				newest[j.Name] = j
+			}
+		}
+		deleted = 0
+		for id, j := range jobs.m {
+			if remove(j, newest[j.Name] == j) {
+				delete(jobs.m, id)
+				deleted++
+			}
+		}
+		return deleted > 0
+	})
+	if deleted > 0 {
+		glog.Infof("sinker deleted %d jobs", deleted)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"github.com/google/go-github/github"
+)
+
+const skippedDescription = "Skipped"
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "skip",
+		Events:      []string{"issue_comment"},
+		Description: "The skip plugin allows users to clean up GitHub stale commit statuses for non-required jobs on a PR.",
+		Commands: []PluginCommand{{
+			Usage:       "/skip",
+			Description: "Cleans up GitHub stale commit statuses for non-required jobs on a PR, marking failing ones as skipped.",
+			WhoCanUse:   "Collaborators of the repo.",
+			Example:     "/skip",
+		}},
+	}, enabledEverywhere)
+}
+
+// requiredContexts returns the status contexts a PR against branch needs to
+// merge: the ones of the branch protection, of the branch policy and of the
+// presubmits that aren't optional.
+func (s *Server) requiredContexts(client *github.Client, repo *github.Repository, branch string) ([]string, error) {
+	required := append([]string{}, s.Config.BranchPolicyFor(repo.GetOwner().GetLogin(), repo.GetName(), branch).RequiredStatusChecks...)
+	for _, p := range s.Config.Jobs.presubmitsFor(repo) {
+		if !p.Optional && !stringInSlice(p.context(), required) {
+			required = append(required, p.context())
+		}
+	}
+	checks, err := scmFor(client).GetRequiredStatusChecks(repo.GetOwner().GetLogin(), repo.GetName(), branch)
+	if err != nil {
+		return nil, err
+	}
+	contexts := append([]string{}, required...)
+	for _, c := range checks {
+		if !stringInSlice(c, contexts) {
+			contexts = append(contexts, c)
+		}
+	}
+	return contexts, nil
+}
+
+// handleSkip marks the failing statuses of the non-required contexts of a PR
+// as skipped.
+func (s *Server) handleSkip(client *github.Client, ic *github.IssueCommentEvent) {
+	if !ic.GetIssue().IsPullRequest() {
+		return
+	}
+	owner, repo := ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName()
+	number := ic.GetIssue().GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+
+	trusted, err := isCollaborator(client, ic.Repo, login)
+	if err != nil {
+		logError(client, "fail to check if %s is a collaborator: %v", login, err)
+		return
+	}
+	if !trusted {
+		createComment(client, ic.Repo, number, "@"+login+": only collaborators can use `/skip`.")
+		return
+	}
+
+	pr, err := scmFor(client).GetPullRequest(owner, repo, number)
+	if err != nil {
+		logError(client, "fail to get PR %s#%d: %v", ic.Repo.GetFullName(), number, err)
+		return
+	}
+	required, err := s.requiredContexts(client, ic.Repo, pr.GetBase().GetRef())
+	if err != nil {
+		logError(client, "fail to get required contexts of %s@%s: %v", ic.Repo.GetFullName(), pr.GetBase().GetRef(), err)
+		return
+	}
+	sha := pr.GetHead().GetSHA()
+	combined, err := scmFor(client).GetCombinedStatus(owner, repo, sha)
+	if err != nil {
+		logError(client, "fail to get statuses of %s@%s: %v", ic.Repo.GetFullName(), sha, err)
+		return
+	}
+	for _, st := range combined.Statuses {
+		if stringInSlice(st.GetContext(), required) {
+			continue
+		}
+		if st.GetState() != "failure" && st.GetState() != "error" {
+			continue
+		}
+		createStatus(client, ic.Repo, sha, st.GetContext(), "success", skippedDescription, st.GetTargetURL())
+	}
+}
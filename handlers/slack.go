@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// The events Slack notifications can be sent for.
+const (
+	slackEventMerge       = "merge"
+	slackEventManualMerge = "manual_merge"
+	slackEventJobFailure  = "job_failure"
+)
+
+var slackEvents = []string{slackEventMerge, slackEventManualMerge, slackEventJobFailure}
+
+// Slack is the config of the Slack notifications.
+type Slack struct {
+	// Token is the token of the Slack bot user posting the notifications.
+	Token         string              `json:"token,omitempty"`
+	Notifications []SlackNotification `json:"notifications,omitempty"`
+}
+
+// SlackNotification sends Events of Repos to Channels.
+type SlackNotification struct {
+	// Repos are the orgs and org/repos the notification applies to.
+	Repos    []string `json:"repos"`
+	Channels []string `json:"channels"`
+	// Events are any of merge (a PR merged into a protected branch),
+	// manual_merge (a push to a protected branch by someone other than the
+	// bot, that isn't the merge of a PR) and job_failure (a failing status).
+	Events []string `json:"events"`
+	// WhiteList are the users whose manual merges aren't reported.
+	WhiteList []string `json:"whitelist,omitempty"`
+}
+
+func (n SlackNotification) appliesTo(repo *github.Repository, event string) bool {
+	if !stringInSlice(event, n.Events) {
+		return false
+	}
+	return stringInSlice(repo.GetFullName(), n.Repos) || stringInSlice(repo.GetOwner().GetLogin(), n.Repos)
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "slack",
+		Events:      []string{"pull_request", "push", "status"},
+		Description: "The slack plugin posts to the configured Slack channels when PRs merge into protected branches, when someone other than the bot or a whitelisted user pushes to a protected branch, and when jobs fail.",
+		ConfigKey:   "slack",
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, n := range c.Slack.Notifications {
+			enabled = append(enabled, n.Repos...)
+		}
+		return enabled
+	})
+}
+
+// postSlackMessage posts text to a Slack channel.
+func (s *Server) postSlackMessage(channel, text string) error {
+	body, err := json.Marshal(map[string]string{"channel": channel, "text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, slackPostMessageURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Config.Slack.Token)
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("%s: %s", resp.Status, respBody)
+	}
+	if !result.OK {
+		return fmt.Errorf("slack: %s", result.Error)
+	}
+	return nil
+}
+
+// notifySlack posts text to the channels of every notification of event in
+// repo. skip tells whether a notification doesn't want the message.
+func (s *Server) notifySlack(repo *github.Repository, event, text string, skip func(SlackNotification) bool) {
+	if s.Config.Slack.Token == "" {
+		return
+	}
+	sent := map[string]bool{}
+	for _, n := range s.Config.Slack.Notifications {
+		if !n.appliesTo(repo, event) || (skip != nil && skip(n)) {
+			continue
+		}
+		for _, ch := range n.Channels {
+			if sent[ch] {
+				continue
+			}
+			sent[ch] = true
+			if err := s.postSlackMessage(ch, text); err != nil {
+				glog.Errorf("fail to post to slack channel %s: %v", ch, err)
+			}
+		}
+	}
+}
+
+// isProtectedBranch reports whether branch of repo is protected.
+func isProtectedBranch(client *github.Client, repo *github.Repository, branch string) (bool, error) {
+	b, err := scmFor(client).GetBranch(repo.GetOwner().GetLogin(), repo.GetName(), branch)
+	if err != nil {
+		return false, err
+	}
+	return b.GetProtected(), nil
+}
+
+// handleSlackMerge reports PRs merged into protected branches.
+func (s *Server) handleSlackMerge(client *github.Client, pe *github.PullRequestEvent) {
+	pr := pe.GetPullRequest()
+	protected, err := isProtectedBranch(client, pe.Repo, pr.GetBase().GetRef())
+	if err != nil {
+		logError(client, "fail to get branch %s of %s: %v", pr.GetBase().GetRef(), pe.Repo.GetFullName(), err)
+		return
+	}
+	if !protected {
+		return
+	}
+	text := fmt.Sprintf("%s merged <%s|%s#%d: %s> into `%s`.", pr.GetMergedBy().GetLogin(), pr.GetHTMLURL(), pe.Repo.GetFullName(), pr.GetNumber(), pr.GetTitle(), pr.GetBase().GetRef())
+	s.notifySlack(pe.Repo, slackEventMerge, text, nil)
+}
+
+// mergedThroughPR reports whether sha is the merge commit of a merged PR of
+// repo, as pushed when a PR is merged through the GitHub UI.
+func mergedThroughPR(client *github.Client, repo *github.Repository, sha string) (bool, error) {
+	prs, err := scmFor(client).ListPullRequestsWithCommit(repo.GetOwner().GetLogin(), repo.GetName(), sha)
+	if err != nil {
+		return false, err
+	}
+	for _, pr := range prs {
+		// Listed PRs carry merged_at but not merged.
+		if pr.MergedAt != nil && pr.GetMergeCommitSHA() == sha {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// handleSlackPush warns about pushes to protected branches that bypassed
+// the bot and its PRs, i.e. weren't the merge of a PR.
+func (s *Server) handleSlackPush(client *github.Client, push *github.PushEvent) {
+	login := push.GetSender().GetLogin()
+	if login == s.BotName || push.GetDeleted() {
+		return
+	}
+	owner := push.GetRepo().GetOwner().GetLogin()
+	if owner == "" {
+		owner = push.GetRepo().GetOwner().GetName()
+	}
+	repo, err := scmFor(client).GetRepo(owner, push.GetRepo().GetName())
+	if err != nil {
+		logError(client, "fail to get repo %s/%s: %v", owner, push.GetRepo().GetName(), err)
+		return
+	}
+	branch := strings.TrimPrefix(push.GetRef(), "refs/heads/")
+	protected, err := isProtectedBranch(client, repo, branch)
+	if err != nil {
+		logError(client, "fail to get branch %s of %s: %v", branch, repo.GetFullName(), err)
+		return
+	}
+	if !protected {
+		return
+	}
+	merged, err := mergedThroughPR(client, repo, push.GetAfter())
+	if err != nil {
+		logError(client, "fail to list the PRs of %s@%s: %v", repo.GetFullName(), push.GetAfter(), err)
+		return
+	}
+	if merged {
+		return
+	}
+	text := fmt.Sprintf("*Warning:* %s manually merged %s into `%s` of %s (<%s|compare>).", login, push.GetHeadCommit().GetID(), branch, repo.GetFullName(), push.GetCompare())
+	s.notifySlack(repo, slackEventManualMerge, text, func(n SlackNotification) bool {
+		return stringInSlice(login, n.WhiteList)
+	})
+}
+
+// handleSlackStatus reports failing statuses.
+func (s *Server) handleSlackStatus(se *github.StatusEvent) {
+	if se.GetState() != "failure" && se.GetState() != "error" {
+		return
+	}
+	text := fmt.Sprintf("Job <%s|%s> failed on %s@%s: %s", se.GetTargetURL(), se.GetContext(), se.Repo.GetFullName(), se.GetSHA(), se.GetDescription())
+	s.notifySlack(se.Repo, slackEventJobFailure, text, nil)
+}
+
+func (c *Config) validateSlack() error {
+	for i, n := range c.Slack.Notifications {
+		if len(n.Repos) == 0 || len(n.Channels) == 0 {
+			return fmt.Errorf("slack: notification %d needs repos and channels", i)
+		}
+		for _, e := range n.Events {
+			if !stringInSlice(e, slackEvents) {
+				return fmt.Errorf("slack: notification %d: unknown event %q, not one of %s", i, e, strings.Join(slackEvents, ", "))
+			}
+		}
+	}
+	if len(c.Slack.Notifications) > 0 && c.Slack.Token == "" {
+		return fmt.Errorf("slack: token is required")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// stageLabels are mutually exclusive, an issue or PR is in at most one stage
+// at a time.
+var stageLabels = []string{"stage/alpha", "stage/beta", "stage/stable"}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "stage",
+		Events:      []string{"issue_comment"},
+		Description: "Label the stage of an issue as alpha/beta/stable, keeping at most one stage label on each issue or PR.",
+		Commands: []PluginCommand{{
+			Usage:       "/[remove-]stage <alpha|beta|stable>",
+			Description: "Labels the stage of an issue as alpha/beta/stable, replacing any other stage.",
+			WhoCanUse:   "Anyone can trigger this command.",
+			Example:     "/stage alpha\n/remove-stage alpha",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleStage applies or removes stage labels.
+func (s *Server) handleStage(client *github.Client, ic *github.IssueCommentEvent) {
+	number := ic.GetIssue().GetNumber()
+	labels := issueLabels(ic.GetIssue())
+	for _, m := range stageReg.FindAllStringSubmatch(ic.GetComment().GetBody(), -1) {
+		label := "stage/" + strings.ToLower(m[2])
+		if m[1] != "" {
+			if hasLabel(labels, label) {
+				removeLabel(client, ic.Repo, number, label)
+			}
+			continue
+		}
+		if hasLabel(labels, label) {
+			continue
+		}
+		for _, other := range stageLabels {
+			if other != label && hasLabel(labels, other) {
+				removeLabel(client, ic.Repo, number, other)
+			}
+		}
+		addLabel(client, ic.Repo, number, label)
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const staleInterval = time.Hour
+
+// Stale is the config of the periodic processing of inactive issues and PRs.
+// Each step counts the days of inactivity since the previous one, as
+// labeling an issue is activity too.
+type Stale struct {
+	// Repos are the orgs and org/repos processed.
+	Repos []string `json:"repos,omitempty"`
+	// ExcludedRepos are org/repos of Repos' orgs opting out.
+	ExcludedRepos []string `json:"excluded_repos,omitempty"`
+	// StaleDays of inactivity get open issues and PRs the stale label.
+	StaleDays int `json:"stale_days,omitempty"`
+	// RottenDays of inactivity make stale issues and PRs rotten.
+	RottenDays int `json:"rotten_days,omitempty"`
+	// CloseDays of inactivity close rotten issues and PRs.
+	CloseDays int `json:"close_days,omitempty"`
+	// ExemptLabels keep issues and PRs out of the processing, the frozen
+	// lifecycle label always does.
+	ExemptLabels []string `json:"exempt_labels,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "stale",
+		Events:      []string{"issue_comment"},
+		Description: "Periodically marks inactive issues and PRs as stale, then rotten, and finally closes them. Any comment by a human removes the stale and rotten labels, /lifecycle frozen exempts an issue or PR for good.",
+		ConfigKey:   "stale",
+	}, func(c *Config) []string {
+		return c.Stale.Repos
+	})
+	registerPeriodic("stale", staleInterval, func(c *Config) bool {
+		return len(c.Stale.Repos) > 0
+	}, (*Server).processStale)
+}
+
+// staleStep moves the matching issues and PRs to the next lifecycle state.
+type staleStep struct {
+	days  int
+	from  string
+	label string
+	close bool
+	msg   string
+}
+
+func (c Stale) steps() []staleStep {
+	return []staleStep{{
+		days:  c.StaleDays,
+		label: lifecycleStaleLabel,
+		msg:   "Issues go stale after %s of inactivity.\nMark the issue as fresh with `/remove-lifecycle stale`.\nStale issues rot after an additional %s of inactivity and eventually close.\n\nIf this issue is safe to close now please do so with `/close`.",
+	}, {
+		days:  c.RottenDays,
+		from:  lifecycleStaleLabel,
+		label: lifecycleRottenLabel,
+		msg:   "Stale issues rot after %s of inactivity.\nMark the issue as fresh with `/remove-lifecycle rotten`.\nRotten issues close after an additional %s of inactivity.\n\nIf this issue is safe to close now please do so with `/close`.",
+	}, {
+		days:  c.CloseDays,
+		from:  lifecycleRottenLabel,
+		close: true,
+		msg:   "Rotten issues close after %[1]s of inactivity.\nReopen the issue with `/reopen`.\nMark the issue as fresh with `/remove-lifecycle rotten`.",
+	}}
+}
+
+// processStale runs the steps of the stale processing on every repo.
+func (s *Server) processStale(client *github.Client) {
+	c := s.Config.Stale
+	scopes := searchScopes(c.Repos, c.ExcludedRepos)
+	exempt := []string{fmt.Sprintf("-label:%q", lifecycleFrozenLabel)}
+	for _, l := range c.ExemptLabels {
+		exempt = append(exempt, fmt.Sprintf("-label:%q", l))
+	}
+
+	steps := c.steps()
+	for i, step := range steps {
+		if step.days <= 0 {
+			continue
+		}
+		query := []string{"is:open", fmt.Sprintf("updated:<%s", time.Now().AddDate(0, 0, -step.days).Format("2006-01-02"))}
+		query = append(query, scopes...)
+		query = append(query, exempt...)
+		if step.from != "" {
+			query = append(query, fmt.Sprintf("label:%q", step.from))
+		}
+		if step.label != "" {
+			query = append(query, fmt.Sprintf("-label:%q", step.label))
+		}
+		if i == 0 {
+			query = append(query, fmt.Sprintf("-label:%q", lifecycleRottenLabel))
+		}
+		issues, err := searchIssues(client, strings.Join(query, " "))
+		if err != nil {
+			logError(client, "fail to search %s issues: %v", step.label, err)
+			continue
+		}
+		next := 0
+		if i+1 < len(steps) {
+			next = steps[i+1].days
+		}
+		for _, issue := range issues {
+			s.applyStaleStep(client, issue, step, next)
+		}
+	}
+}
+
+func (s *Server) applyStaleStep(client *github.Client, issue github.Issue, step staleStep, next int) {
+	repo, err := searchResultRepo(issue)
+	if err != nil {
+		logError(client, "fail to get the repo of a search result: %v", err)
+		return
+	}
+	number := issue.GetNumber()
+
+	f := s.Config.FormatterFor(repo.GetOwner().GetLogin())
+	days := func(n int) string { return f.Duration(time.Duration(n) * 24 * time.Hour) }
+	if err := createComment(client, repo, number, fmt.Sprintf(step.msg, days(step.days), days(next))); err != nil {
+		return
+	}
+	if step.from != "" {
+		removeLabel(client, repo, number, step.from)
+	}
+	if step.label != "" {
+		addLabel(client, repo, number, step.label)
+	}
+	if step.close {
+		err := scmFor(client).EditIssue(repo.GetOwner().GetLogin(), repo.GetName(), number, &github.IssueRequest{State: github.String("closed")})
+		if err != nil {
+			logError(client, "fail to close %s#%d: %v", repo.GetFullName(), number, err)
+		}
+	}
+}
+
+// handleStaleActivity freshens issues and PRs humans comment on.
+func (s *Server) handleStaleActivity(client *github.Client, ic *github.IssueCommentEvent) {
+	c := s.Config.Stale
+	if !stringInSlice(ic.Repo.GetFullName(), c.Repos) && !stringInSlice(ic.Repo.GetOwner().GetLogin(), c.Repos) {
+		return
+	}
+	if stringInSlice(ic.Repo.GetFullName(), c.ExcludedRepos) || ic.GetComment().GetUser().GetLogin() == s.BotName {
+		return
+	}
+	// Explicit lifecycle commands are handled by the lifecycle plugin.
+	if lifecycleReg.MatchString(ic.GetComment().GetBody()) {
+		return
+	}
+	labels := issueLabels(ic.GetIssue())
+	for _, l := range []string{lifecycleStaleLabel, lifecycleRottenLabel} {
+		if hasLabel(labels, l) {
+			removeLabel(client, ic.Repo, ic.GetIssue().GetNumber(), l)
+		}
+	}
+}
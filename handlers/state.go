@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/google/go-github/github"
+)
+
+// stateRetries bounds the attempts to update the shared state while other
+// replicas update it too.
+const stateRetries = 5
+
+// sharedState reports whether the replicas of the bot share their state,
+// which they do when they elect a leader: webhooks are handled by any
+// replica, but only the leader runs the periodic tasks acting on what
+// they record.
+func (s *Server) sharedState() bool {
+	return s.Config.LeaderElection.Enabled
+}
+
+// loadState decodes the shared state kept under key into v, a pointer. It
+// leaves v as is when the state isn't shared.
+func (s *Server) loadState(key string, v interface{}) error {
+	return s.modifyState(key, v, func() bool { return false })
+}
+
+// modifyState decodes the shared state kept under key into v, a pointer,
+// runs modify on it and saves v back if modify reports a change. The state
+// is kept in a ConfigMap, and the update retried when another replica
+// changed it meanwhile, so modify may run more than once. When the state
+// isn't shared, modify runs on v as is. Callers serialize their uses of v.
+func (s *Server) modifyState(key string, v interface{}, modify func() bool) error {
+	if !s.sharedState() {
+		modify()
+		return nil
+	}
+	c := s.Config.LeaderElection
+	kube, namespace, err := leaderKube(c)
+	if err != nil {
+		return err
+	}
+	name := c.StateName
+	if name == "" {
+		name = defaultStateName
+	}
+	for i := 0; i < stateRetries; i++ {
+		cm, err := kube.getConfigMap(namespace, name)
+		_, notFound := err.(kubeNotFound)
+		if err != nil && !notFound {
+			return err
+		}
+		resetState(v)
+		if data, ok := cm.Data[key]; ok {
+			if err := json.Unmarshal([]byte(data), v); err != nil {
+				return fmt.Errorf("invalid %s state: %v", key, err)
+			}
+		}
+		if !modify() {
+			return nil
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = string(data)
+		if notFound {
+			cm.Metadata = ObjectMeta{Name: name, Namespace: namespace}
+			err = kube.createConfigMap(cm)
+		} else {
+			// The resource version makes the update fail if another
+			// replica changed the state since it was read.
+			err = kube.replaceConfigMap(cm)
+		}
+		if _, conflict := err.(kubeConflict); !conflict {
+			return err
+		}
+	}
+	return fmt.Errorf("%s state kept changing, gave up after %d attempts", key, stateRetries)
+}
+
+// resetState empties v before the shared state is decoded into it, as
+// decoding into a map adds to what it holds.
+func resetState(v interface{}) {
+	e := reflect.ValueOf(v).Elem()
+	if e.Kind() == reflect.Map {
+		e.Set(reflect.MakeMap(e.Type()))
+		return
+	}
+	e.Set(reflect.Zero(e.Type()))
+}
+
+// stateRepo trims a repo to what the shared state needs of it to report
+// on it.
+func stateRepo(repo *github.Repository) *github.Repository {
+	return &github.Repository{
+		Name:     github.String(repo.GetName()),
+		FullName: github.String(repo.GetFullName()),
+		Owner:    &github.User{Login: github.String(repo.GetOwner().GetLogin())},
+	}
+}
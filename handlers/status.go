@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/google/go-github/github"
+)
+
+// handleStatusEvent handles status changes of commits.
+func (s *Server) handleStatusEvent(body []byte, client *github.Client) {
+	if !s.receiveEvent(proxyEventStatus, body) {
+		return
+	}
+	var se github.StatusEvent
+	if err := json.Unmarshal(body, &se); err != nil {
+		logError(client, "fail to unmarshal: %v", err)
+		return
+	}
+	s.runPlugin("email", "status", client, func(client *github.Client) { s.handleEmailStatus(&se) })
+	s.runPlugin("slack", "status", client, func(client *github.Client) { s.handleSlackStatus(&se) })
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+
+	"ci-bot/commentpruner"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	titleContext       = "ci-bot/title"
+	titleCommentMarker = "<!-- ci-bot:title -->"
+)
+
+// TitleCheck is the title convention of the PRs of a set of repos.
+type TitleCheck struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos []string `json:"repos"`
+	// Regexp the title must match, e.g. a conventional commit like
+	// "^(feat|fix|docs|chore)(\([-\w]+\))?: .+" or "^\[[-\w]+\] .+".
+	Regexp string `json:"regexp"`
+	// Guidance explains the convention to authors of PRs not following it.
+	Guidance string `json:"guidance,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "title",
+		Events:      []string{"pull_request"},
+		Description: "The title plugin checks that PR titles follow the convention of the repo. It sets the '" + titleContext + "' status and explains the convention in a comment while the title doesn't follow it.",
+		ConfigKey:   "title_check",
+	}, func(c *Config) []string {
+		var enabled []string
+		for _, t := range c.TitleCheck {
+			enabled = append(enabled, t.Repos...)
+		}
+		return enabled
+	})
+}
+
+// titleCheckFor returns the title convention of a repo, repo entries taking
+// precedence over org ones.
+func (c *Config) titleCheckFor(org, repo string) (TitleCheck, bool) {
+	var orgConfig *TitleCheck
+	for i, t := range c.TitleCheck {
+		if stringInSlice(org+"/"+repo, t.Repos) {
+			return t, true
+		}
+		if orgConfig == nil && stringInSlice(org, t.Repos) {
+			orgConfig = &c.TitleCheck[i]
+		}
+	}
+	if orgConfig != nil {
+		return *orgConfig, true
+	}
+	return TitleCheck{}, false
+}
+
+// handleTitleCheck checks the title of a PR against the convention of its
+// repo.
+func (s *Server) handleTitleCheck(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	check, ok := s.Config.titleCheckFor(repo.GetOwner().GetLogin(), repo.GetName())
+	if !ok {
+		return
+	}
+	number := pr.GetNumber()
+	if check.re.MatchString(pr.GetTitle()) {
+		createStatus(client, repo, pr.GetHead().GetSHA(), titleContext, "success", "Title follows the convention", "")
+		s.commentPruner(client, repo, number).PruneComments(commentpruner.HasMarker(titleCommentMarker))
+		return
+	}
+	createStatus(client, repo, pr.GetHead().GetSHA(), titleContext, "failure", "Title doesn't follow the convention", "")
+	msg := fmt.Sprintf("%s\n%s\n@%s: the title of this PR doesn't follow the convention of %s. It must match `%s`.",
+		titleCommentMarker, transientMarker("title"), pr.GetUser().GetLogin(), repo.GetFullName(), check.Regexp)
+	if check.Guidance != "" {
+		msg += "\n\n" + check.Guidance
+	}
+	msg += "\n\nUse `/retitle <title>` or edit the title, it is checked again right away."
+	s.upsertComment(client, repo, number, titleCommentMarker, msg)
+}
+
+func (c *Config) validateTitleCheck() error {
+	for i, t := range c.TitleCheck {
+		re, err := regexp.Compile(t.Regexp)
+		if err != nil {
+			return fmt.Errorf("title_check %d: invalid regexp %q: %v", i, t.Regexp, err)
+		}
+		c.TitleCheck[i].re = re
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// The kinds of spans, as numbered by OTLP.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+const (
+	tracingFlushInterval = 5 * time.Second
+	tracingBatchSize     = 512
+)
+
+// Tracing exports traces of the webhooks, through the plugins handling
+// them down to their GitHub API calls, to an OpenTelemetry collector. The
+// trace of a webhook has the ID of its delivery GUID.
+type Tracing struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver of the collector,
+	// e.g. http://otel-collector:4318.
+	Endpoint string `json:"endpoint,omitempty"`
+	// ServiceName is the service.name of the spans, ci-bot by default.
+	ServiceName string `json:"service_name,omitempty"`
+	// Headers are sent along the spans, e.g. for authentication.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (c *Config) validateTracing() error {
+	if c.Tracing.Endpoint != "" && !strings.HasPrefix(c.Tracing.Endpoint, "https://") && !strings.HasPrefix(c.Tracing.Endpoint, "http://") {
+		return fmt.Errorf("tracing: invalid endpoint %q", c.Tracing.Endpoint)
+	}
+	return nil
+}
+
+// tracer holds the ended spans until they are exported. No spans are
+// recorded until runTracing enables it.
+var tracer = struct {
+	sync.Mutex
+	config  Tracing
+	enabled bool
+	spans   []*span
+}{}
+
+// span is an operation of a trace.
+type span struct {
+	traceID    [16]byte
+	id, parent [8]byte
+	name       string
+	kind       int
+	start, end time.Time
+	attributes map[string]string
+	err        string
+}
+
+// startTrace starts the root span of a trace. The ID of the trace is
+// delivery, a GUID, or derived from it, or random if it is empty. It
+// returns nil if tracing is disabled, which the methods of span accept.
+func startTrace(delivery, name string, kind int) *span {
+	tracer.Lock()
+	enabled := tracer.enabled
+	tracer.Unlock()
+	if !enabled {
+		return nil
+	}
+	sp := &span{name: name, kind: kind, start: time.Now(), attributes: map[string]string{}}
+	switch id, err := hex.DecodeString(strings.Replace(delivery, "-", "", -1)); {
+	case err == nil && len(id) == len(sp.traceID):
+		copy(sp.traceID[:], id)
+	case delivery != "":
+		sum := sha256.Sum256([]byte(delivery))
+		copy(sp.traceID[:], sum[:])
+	default:
+		rand.Read(sp.traceID[:])
+	}
+	rand.Read(sp.id[:])
+	if delivery != "" {
+		sp.attributes["github.delivery"] = delivery
+	}
+	return sp
+}
+
+// child starts a span of the operation of name within sp.
+func (sp *span) child(name string, kind int) *span {
+	if sp == nil {
+		return nil
+	}
+	c := &span{traceID: sp.traceID, parent: sp.id, name: name, kind: kind, start: time.Now(), attributes: map[string]string{}}
+	rand.Read(c.id[:])
+	return c
+}
+
+func (sp *span) setAttribute(key, value string) {
+	if sp != nil {
+		sp.attributes[key] = value
+	}
+}
+
+// setError marks the operation of sp failed.
+func (sp *span) setError(err string) {
+	if sp != nil {
+		sp.err = err
+	}
+}
+
+// traceparent is the W3C trace context of sp, for the requests made
+// within it.
+func (sp *span) traceparent() string {
+	return fmt.Sprintf("00-%x-%x-01", sp.traceID, sp.id)
+}
+
+// finish ends sp and queues it for export.
+func (sp *span) finish() {
+	if sp == nil {
+		return
+	}
+	sp.end = time.Now()
+	tracer.Lock()
+	tracer.spans = append(tracer.spans, sp)
+	full := len(tracer.spans) >= tracingBatchSize
+	tracer.Unlock()
+	if full {
+		go flushSpans()
+	}
+}
+
+// runTracing enables tracing and exports the spans periodically.
+func (s *Server) runTracing() {
+	if s.Config.Tracing.Endpoint == "" {
+		return
+	}
+	tracer.Lock()
+	tracer.config = s.Config.Tracing
+	if tracer.config.ServiceName == "" {
+		tracer.config.ServiceName = "ci-bot"
+	}
+	tracer.enabled = true
+	tracer.Unlock()
+	go func() {
+		for range time.Tick(tracingFlushInterval) {
+			flushSpans()
+		}
+	}()
+}
+
+// flushSpans exports the ended spans to the collector. Spans that fail to
+// be exported are dropped.
+func flushSpans() {
+	tracer.Lock()
+	spans, config := tracer.spans, tracer.config
+	tracer.spans = nil
+	tracer.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := exportSpans(config, spans); err != nil {
+		glog.Errorf("fail to export %d spans: %v", len(spans), err)
+	}
+}
+
+// The OTLP/HTTP JSON encoding of spans.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	otlpStatus struct {
+		// Code is 2 for errors.
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	var a []otlpAttribute
+	for k, v := range attributes {
+		attr := otlpAttribute{Key: k}
+		attr.Value.StringValue = v
+		a = append(a, attr)
+	}
+	return a
+}
+
+func exportSpans(config Tracing, spans []*span) error {
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{{}}}
+	rs.Resource.Attributes = otlpAttributes(map[string]string{"service.name": config.ServiceName})
+	rs.ScopeSpans[0].Scope.Name = "ci-bot"
+	for _, sp := range spans {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(sp.traceID[:]),
+			SpanID:            hex.EncodeToString(sp.id[:]),
+			Name:              sp.name,
+			Kind:              sp.kind,
+			StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sp.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(sp.attributes),
+		}
+		if sp.parent != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(sp.parent[:])
+		}
+		if sp.err != "" {
+			s.Status = &otlpStatus{Code: 2, Message: sp.err}
+		}
+		rs.ScopeSpans[0].Spans = append(rs.ScopeSpans[0].Spans, s)
+	}
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(config.Endpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	for k, v := range config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// transferIssueMutation moves an issue to another repo, which only the
+// GraphQL API can do.
+const transferIssueMutation = `mutation($issueId: ID!, $repositoryId: ID!) {
+  transferIssue(input: {issueId: $issueId, repositoryId: $repositoryId}) {
+    issue { number }
+  }
+}`
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "transfer-issue",
+		Events:      []string{"issue_comment"},
+		Description: "Transfers issues to another repo of the same org.",
+		Commands: []PluginCommand{{
+			Usage:       "/transfer-issue <repo>",
+			Description: "Transfers the issue to a repo of the same org.",
+			WhoCanUse:   "Members of the org.",
+			Example:     "/transfer-issue docs",
+		}},
+	}, enabledEverywhere)
+}
+
+// handleTransferIssue transfers an issue to the repo named in the comment.
+func (s *Server) handleTransferIssue(client *github.Client, ic *github.IssueCommentEvent) {
+	issue := ic.GetIssue()
+	number := issue.GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+	org := ic.Repo.GetOwner().GetLogin()
+	if issue.IsPullRequest() {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Only issues can be transferred, not pull requests.", login))
+		return
+	}
+
+	member, err := isOrgMember(client, org, login)
+	if err != nil {
+		logError(client, "fail to check whether %s is a member of %s: %v", login, org, err)
+		return
+	}
+	if !member {
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Only members of %s can transfer issues.", login, org))
+		return
+	}
+
+	scm := scmFor(client)
+	name := transferIssueReg.FindStringSubmatch(ic.GetComment().GetBody())[1]
+	dest, err := scm.GetRepo(org, name)
+	if err != nil {
+		if isNotFound(err) {
+			createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Repo %s/%s doesn't exist.", login, org, name))
+			return
+		}
+		logError(client, "fail to get %s/%s: %v", org, name, err)
+		return
+	}
+	if dest.GetFullName() == ic.Repo.GetFullName() {
+		return
+	}
+
+	moved, err := scm.TransferIssue(org, ic.Repo.GetName(), number, name)
+	if err != nil {
+		logError(client, "fail to transfer %s#%d to %s: %v", ic.Repo.GetFullName(), number, dest.GetFullName(), err)
+		createComment(client, ic.Repo, number, fmt.Sprintf("@%s: Failed to transfer this issue to %s.", login, dest.GetFullName()))
+		return
+	}
+	createComment(client, dest, moved, fmt.Sprintf("This issue was transferred from %s#%d on request of @%s.", ic.Repo.GetFullName(), number, login))
+	glog.Infof("transferred %s#%d to %s#%d", ic.Repo.GetFullName(), number, dest.GetFullName(), moved)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const defaultTravisURL = "https://api.travis-ci.com"
+
+// Travis is the config of the restarting of Travis CI builds.
+type Travis struct {
+	// URL of the Travis API, https://api.travis-ci.com by default.
+	URL string `json:"url,omitempty"`
+	// Tokens maps an org/repo built on Travis to an API token able to
+	// restart its builds.
+	Tokens map[string]string `json:"tokens,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "travis",
+		Description: "Restarts the failed Travis CI build of the head of a PR.",
+		ConfigKey:   "travis",
+		Commands: []PluginCommand{{
+			Usage:       "/retest",
+			Description: "Restarts the Travis CI build of the PR if it failed, errored or was canceled.",
+			WhoCanUse:   trustedUsers,
+			Example:     "/retest",
+		}},
+	}, func(c *Config) []string {
+		var enabled []string
+		for k := range c.Travis.Tokens {
+			enabled = append(enabled, k)
+		}
+		return enabled
+	})
+}
+
+// travisBuild is a build as the Travis API returns it.
+type travisBuild struct {
+	ID                int    `json:"id"`
+	State             string `json:"state"`
+	PullRequestNumber int    `json:"pull_request_number"`
+	Commit            struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+// travisRequest calls the Travis API with the token of a repo, decoding
+// the response into out.
+func (s *Server) travisRequest(token, method, path string, out interface{}) error {
+	base := s.Config.Travis.URL
+	if base == "" {
+		base = defaultTravisURL
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Travis-API-Version", "3")
+	req.Header.Set("Authorization", "token "+token)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, b)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// restartTravis restarts the Travis build of the head of pr on /retest,
+// unless it passed or is still running.
+func (s *Server) restartTravis(client *github.Client, repo *github.Repository, pr *github.PullRequest, comment string) {
+	token, ok := s.Config.Travis.Tokens[repo.GetFullName()]
+	if !ok || !retestReg.MatchString(comment) {
+		return
+	}
+	var builds struct {
+		Builds []travisBuild `json:"builds"`
+	}
+	path := fmt.Sprintf("/repo/%s/builds?event_type=pull_request&sort_by=id:desc&limit=100", url.PathEscape(repo.GetFullName()))
+	if err := s.travisRequest(token, http.MethodGet, path, &builds); err != nil {
+		logError(client, "fail to list Travis builds of %s: %v", repo.GetFullName(), err)
+		return
+	}
+	var build *travisBuild
+	for i, b := range builds.Builds {
+		if b.PullRequestNumber == pr.GetNumber() && b.Commit.SHA == pr.GetHead().GetSHA() {
+			build = &builds.Builds[i]
+			break
+		}
+	}
+	if build == nil {
+		createComment(client, repo, pr.GetNumber(), transientMarker("retest")+"\nNo Travis CI build of the head of this PR was found to restart.")
+		return
+	}
+	switch build.State {
+	case "failed", "errored", "canceled":
+	default:
+		return
+	}
+	if err := s.travisRequest(token, http.MethodPost, fmt.Sprintf("/build/%d/restart", build.ID), nil); err != nil {
+		logError(client, "fail to restart Travis build %d of %s#%d: %v", build.ID, repo.GetFullName(), pr.GetNumber(), err)
+		createComment(client, repo, pr.GetNumber(), transientMarker("retest")+"\nFailed to restart the Travis CI build, please try again later.")
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"github.com/google/go-github/github"
+)
+
+// trustedUsers is who can trigger CI jobs, as told by plugin help.
+const trustedUsers = "Collaborators of the repo and members of its org."
+
+// isTrusted reports whether login may have CI run: a collaborator of repo
+// or a member of its org.
+func isTrusted(client *github.Client, repo *github.Repository, login string) (bool, error) {
+	trusted, err := isCollaborator(client, repo, login)
+	if err != nil || trusted {
+		return trusted, err
+	}
+	return isOrgMember(client, repo.GetOwner().GetLogin(), login)
+}
+
+// triggerEnabled reports whether any CI runs jobs for the PRs of repo.
+func (c *Config) triggerEnabled(repo *github.Repository) bool {
+	_, gitLab := c.GitLabCI.gitLabProjectFor(repo)
+	_, travis := c.Travis.Tokens[repo.GetFullName()]
+	return c.circleCIEnabled(repo) || len(c.Jenkins.jobsFor(repo)) > 0 || gitLab || travis ||
+		len(c.Jobs.presubmitsFor(repo)) > 0
+}
+
+// handleTrigger runs the CI jobs a /test or /retest comment asks for.
+func (s *Server) handleTrigger(client *github.Client, ic *github.IssueCommentEvent) {
+	if !ic.GetIssue().IsPullRequest() || ic.GetIssue().GetState() != "open" {
+		return
+	}
+	if !s.Config.triggerEnabled(ic.Repo) {
+		return
+	}
+	number := ic.GetIssue().GetNumber()
+	login := ic.GetComment().GetUser().GetLogin()
+
+	trusted, err := isTrusted(client, ic.Repo, login)
+	if err != nil {
+		logError(client, "fail to check if %s can trigger tests on %s: %v", login, ic.Repo.GetFullName(), err)
+		return
+	}
+	if !trusted {
+		createComment(client, ic.Repo, number, "@"+login+": only collaborators and org members can trigger tests.")
+		return
+	}
+
+	pr, err := scmFor(client).GetPullRequest(ic.Repo.GetOwner().GetLogin(), ic.Repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to get PR %s#%d: %v", ic.Repo.GetFullName(), number, err)
+		return
+	}
+	comment := ic.GetComment().GetBody()
+	if s.Config.circleCIEnabled(ic.Repo) && isCircleCITest(comment) {
+		s.triggerCircleCI(client, ic.Repo, pr)
+	}
+	s.triggerJenkinsComment(client, ic.Repo, pr, comment)
+	s.triggerGitLabComment(client, ic.Repo, pr, comment)
+	s.restartTravis(client, ic.Repo, pr, comment)
+	s.triggerPresubmitsComment(client, ic.Repo, pr, comment)
+}
+
+// handleTriggerPullRequest runs the CI jobs that always run on the PRs of
+// trusted authors when they are opened or pushed to.
+func (s *Server) handleTriggerPullRequest(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	var always []JenkinsJob
+	for _, j := range s.Config.Jenkins.jobsFor(repo) {
+		if j.AlwaysRun {
+			always = append(always, j)
+		}
+	}
+	gitLabProject, gitLab := s.Config.GitLabCI.gitLabProjectFor(repo)
+	if len(always) == 0 && !gitLab && len(s.Config.Jobs.presubmitsFor(repo)) == 0 {
+		return
+	}
+	login := pr.GetUser().GetLogin()
+	trusted, err := isTrusted(client, repo, login)
+	if err != nil {
+		logError(client, "fail to check if %s can trigger tests on %s: %v", login, repo.GetFullName(), err)
+		return
+	}
+	if !trusted {
+		return
+	}
+	s.triggerJenkins(client, repo, pr, always)
+	if gitLab {
+		s.triggerGitLab(client, repo, pr, gitLabProject)
+	}
+	s.triggerPresubmitsPullRequest(client, repo, pr)
+}
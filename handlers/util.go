@@ -1,7 +1,19 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"ci-bot/commentpruner"
+
+	"github.com/google/go-github/github"
 )
 
 var (
@@ -11,16 +23,285 @@ var (
 
 	// test
 	okToTestReg = regexp.MustCompile("^/[Oo][Kk]-[Tt][Oo]-[Tt][Ee][Ss][Tt]")
-	retestReg   = regexp.MustCompile("^/[Rr][Ee][Tt][Ee][Ss][Tt]")
-	testReg     = regexp.MustCompile("^/[Tt][Ee][Ss][Tt]")
+	retestReg   = regexp.MustCompile(`(?mi)^/retest\s*$`)
+	testReg     = regexp.MustCompile(`(?mi)^/test(?: +(\S+))?\s*$`)
+
+	// assign
+	assignReg = regexp.MustCompile(`(?mi)^/(un)?assign(( +@?[-\w]+)*)\s*$`)
+	ccReg     = regexp.MustCompile(`(?mi)^/(un)?cc(( +@?[-\w]+)*)\s*$`)
+
+	// lint
+	lintReg = regexp.MustCompile(`(?mi)^/lint\s*$`)
+
+	// hold
+	holdReg       = regexp.MustCompile(`(?mi)^/hold(\s+cancel)?\s*$`)
+	holdCancelReg = regexp.MustCompile(`(?mi)^/hold\s+cancel\s*$`)
+
+	// close and reopen
+	closeReg  = regexp.MustCompile(`(?mi)^/close\s*$`)
+	reopenReg = regexp.MustCompile(`(?mi)^/reopen\s*$`)
+
+	// retitle
+	retitleReg = regexp.MustCompile(`(?mi)^/retitle[ \t]+(.+)$`)
+
+	// lifecycle
+	lifecycleReg = regexp.MustCompile(`(?mi)^/(remove-)?lifecycle\s+(stale|rotten|frozen)\s*$`)
+
+	// stage
+	stageReg = regexp.MustCompile(`(?mi)^/(remove-)?stage\s+(alpha|beta|stable)\s*$`)
+
+	// skip
+	skipReg = regexp.MustCompile(`(?mi)^/skip\s*$`)
+
+	// override
+	overrideReg = regexp.MustCompile(`(?mi)^/override +(.+?)\s*$`)
+
+	// project
+	projectReg = regexp.MustCompile(`(?mi)^/project\s+(\S+)(.*)$`)
+
+	// images
+	woofReg = regexp.MustCompile(`(?mi)^/(woof|bark)\s*$`)
+	meowReg = regexp.MustCompile(`(?mi)^/meow(vie)?\s*$`)
+	ponyReg = regexp.MustCompile(`(?mi)^/pony\s*$`)
+
+	// shrug and joke
+	shrugReg = regexp.MustCompile(`(?mi)^/(un)?shrug\s*$`)
+	jokeReg  = regexp.MustCompile(`(?mi)^/joke\s*$`)
+
+	// cla
+	checkCLAReg = regexp.MustCompile(`(?mi)^/check-cla\s*$`)
+
+	// cherrypick
+	cherrypickReg = regexp.MustCompile(`(?mi)^/cherry-?pick\s+(\S+)\s*$`)
+
+	// duplicate
+	duplicateReg = regexp.MustCompile(`(?mi)^/duplicate\s+#?(\d+)\s*$`)
+
+	// transfer-issue
+	transferIssueReg = regexp.MustCompile(`(?mi)^/transfer(?:-issue)?\s+([-\w.]+)\s*$`)
+
+	// lock
+	lockReg = regexp.MustCompile(`(?mi)^/(un)?lock(?:\s+(.*?))?\s*$`)
+
+	// availability
+	busyReg      = regexp.MustCompile(`(?mi)^/busy\s*$`)
+	availableReg = regexp.MustCompile(`(?mi)^/available\s*$`)
+
+	// help
+	helpReg                 = regexp.MustCompile(`(?mi)^/help\s*$`)
+	helpRemoveReg           = regexp.MustCompile(`(?mi)^/remove-help\s*$`)
+	goodFirstIssueReg       = regexp.MustCompile(`(?mi)^/good-first-issue\s*$`)
+	goodFirstIssueRemoveReg = regexp.MustCompile(`(?mi)^/remove-good-first-issue\s*$`)
 
 	// review and approve
-	lgtmReg          = regexp.MustCompile("^/[Ll][Gg][Tt][Mm]")
-	lgtmCancelReg    = regexp.MustCompile("^/[Ll][Gg][Tt][Mm] [Cc][Aa][Nn][Cc][Ee][Ll]")
-	approveReg       = regexp.MustCompile("^/[Aa][Pp][Pp][Rr][Oo][Vv][Ee]")
-	approveCancelReg = regexp.MustCompile("^/[Aa][Pp][Pp][Rr][Oo][Vv][Ee] [Cc][Aa][Nn][Cc][Ee][Ll]")
+	lgtmReg       = regexp.MustCompile(`(?mi)^/lgtm(\s+cancel)?\s*$`)
+	lgtmCancelReg = regexp.MustCompile(`(?mi)^/lgtm\s+cancel\s*$`)
+	approveReg    = regexp.MustCompile(`(?mi)^/approve(?:\s+(no-issue|cancel))?\s*$`)
 )
 
 const (
-	needsOKtoTest = "needs-ok-to-test"
+	needsOKtoTest    = "needs-ok-to-test"
+	lgtmLabel        = "lgtm"
+	approvedLabel    = "approved"
+	holdLabel        = "do-not-merge/hold"
+	wipLabel         = "do-not-merge/work-in-progress"
+	needsRebaseLabel = "needs-rebase"
+
+	lifecycleStaleLabel  = "lifecycle/stale"
+	lifecycleRottenLabel = "lifecycle/rotten"
+	lifecycleFrozenLabel = "lifecycle/frozen"
+
+	helpWantedLabel     = "help wanted"
+	goodFirstIssueLabel = "good first issue"
 )
+
+// hasLabel reports whether name is among labels.
+func hasLabel(labels []*github.Label, name string) bool {
+	for _, l := range labels {
+		if l.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// issueLabels returns the labels of an issue in the same form PRs carry them.
+func issueLabels(issue *github.Issue) []*github.Label {
+	labels := make([]*github.Label, 0, len(issue.Labels))
+	for i := range issue.Labels {
+		labels = append(labels, &issue.Labels[i])
+	}
+	return labels
+}
+
+// stringInSlice reports whether s is in list.
+func stringInSlice(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the members of set in order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// addLabel adds label to an issue or PR.
+func addLabel(client *github.Client, repo *github.Repository, number int, label string) error {
+	err := scmFor(client).AddLabels(repo.GetOwner().GetLogin(), repo.GetName(), number, label)
+	if err != nil {
+		logError(client, "fail to add %s to %s#%d: %v", label, repo.GetFullName(), number, err)
+	}
+	return err
+}
+
+// removeLabel removes label from an issue or PR.
+func removeLabel(client *github.Client, repo *github.Repository, number int, label string) error {
+	err := scmFor(client).RemoveLabel(repo.GetOwner().GetLogin(), repo.GetName(), number, label)
+	if err != nil {
+		logError(client, "fail to remove %s from %s#%d: %v", label, repo.GetFullName(), number, err)
+	}
+	return err
+}
+
+// createComment posts a comment on an issue or PR.
+func createComment(client *github.Client, repo *github.Repository, number int, body string) error {
+	_, err := scmFor(client).CreateComment(repo.GetOwner().GetLogin(), repo.GetName(), number, body)
+	if err != nil {
+		logError(client, "fail to comment on %s#%d: %v", repo.GetFullName(), number, err)
+	}
+	return err
+}
+
+// isCollaborator reports whether login is a collaborator of repo.
+func isCollaborator(client *github.Client, repo *github.Repository, login string) (bool, error) {
+	return scmFor(client).IsCollaborator(repo.GetOwner().GetLogin(), repo.GetName(), login)
+}
+
+// isOrgMember reports whether login is a member of org.
+func isOrgMember(client *github.Client, org, login string) (bool, error) {
+	return scmFor(client).IsMember(org, login)
+}
+
+// createStatus sets the state of a status context on a commit.
+func createStatus(client *github.Client, repo *github.Repository, sha, context_, state, description, targetURL string) error {
+	status := &github.RepoStatus{
+		State:       github.String(state),
+		Context:     github.String(context_),
+		Description: github.String(description),
+	}
+	if targetURL != "" {
+		status.TargetURL = github.String(targetURL)
+	}
+	err := scmFor(client).CreateStatus(repo.GetOwner().GetLogin(), repo.GetName(), sha, status)
+	if err != nil {
+		logError(client, "fail to set status %s on %s@%s: %v", context_, repo.GetFullName(), sha, err)
+	}
+	return err
+}
+
+// getJSON decodes the JSON document at url into out.
+func getJSON(url string, header http.Header, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// graphql runs a GraphQL query or mutation with the credentials of client
+// and decodes its data into out.
+func graphql(client *github.Client, query string, variables map[string]interface{}, out interface{}) error {
+	ctx := context.Background()
+	req, err := client.NewRequest(http.MethodPost, "graphql", map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := client.Do(ctx, req, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("graphql: %s", resp.Errors[0].Message)
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+// imageComment is the reply to a command asking for a picture.
+func imageComment(login, imageURL string) string {
+	return fmt.Sprintf("@%s: ![image](%s)", login, imageURL)
+}
+
+// commentPruner returns a pruner of the bot's comments on an issue or PR.
+// The plugins handling a webhook share one pruner per issue, see
+// webhookContext.commentPruner; other operations, e.g. periodic ones, get
+// their own.
+func (s *Server) commentPruner(client *github.Client, repo *github.Repository, number int) *commentpruner.EventClient {
+	if _, event := clientScope(client); event != nil && event.client != nil {
+		return event.commentPruner(s.BotName, repo, number)
+	}
+	return commentpruner.NewEventClient(scmFor(client), s.BotName, repo.GetOwner().GetLogin(), repo.GetName(), number)
+}
+
+// upsertComment makes body the content of the bot's comment carrying
+// marker, so that repeated runs of a plugin keep a single comment.
+func (s *Server) upsertComment(client *github.Client, repo *github.Repository, number int, marker, body string) {
+	if err := s.commentPruner(client, repo, number).UpsertComment(marker, body); err != nil {
+		logError(client, "fail to update comment on %s#%d: %v", repo.GetFullName(), number, err)
+	}
+}
+
+// reportUnsupported comments on an issue or PR, once per plugin, that
+// plugin can't do its job there when err is that of a feature the code
+// host lacks, so that it doesn't fail unnoticed. It reports whether err was
+// such an error, others are for the caller to handle.
+func (s *Server) reportUnsupported(client *github.Client, repo *github.Repository, number int, plugin string, err error) bool {
+	if !isUnsupported(err) {
+		return false
+	}
+	logError(client, "%s can't run on %s#%d: %v", plugin, repo.GetFullName(), number, err)
+	marker := fmt.Sprintf("<!-- ci-bot:unsupported:%s -->", plugin)
+	comments, lerr := listIssueComments(client, repo.GetOwner().GetLogin(), repo.GetName(), number)
+	if lerr != nil {
+		logError(client, "fail to list comments of %s#%d: %v", repo.GetFullName(), number, lerr)
+		return true
+	}
+	for _, c := range comments {
+		if c.GetUser().GetLogin() == s.BotName && strings.Contains(c.GetBody(), marker) {
+			return true
+		}
+	}
+	createComment(client, repo, number, fmt.Sprintf("%s\nThe %s plugin can't run here: %v.", marker, plugin, err))
+	return true
+}
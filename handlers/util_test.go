@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestCommandRegexps(t *testing.T) {
+	tests := []struct {
+		name     string
+		re       *regexp.Regexp
+		comment  string
+		expected []string
+	}{
+		{name: "retest", re: retestReg, comment: "/retest", expected: []string{"/retest"}},
+		{name: "retest among lines", re: retestReg, comment: "looks flaky\n/RETEST\nthanks", expected: []string{"/RETEST"}},
+		{name: "retest not at line start", re: retestReg, comment: "please /retest"},
+		{name: "retest with suffix", re: retestReg, comment: "/retesting"},
+
+		{name: "test all", re: testReg, comment: "/test", expected: []string{"/test", ""}},
+		{name: "test job", re: testReg, comment: "/test unit", expected: []string{"/test unit", "unit"}},
+		{name: "test without separator", re: testReg, comment: "/testunit"},
+
+		{name: "assign self", re: assignReg, comment: "/assign", expected: []string{"/assign", "", "", ""}},
+		{name: "assign logins", re: assignReg, comment: "/assign @alice bob", expected: []string{"/assign @alice bob", "", " @alice bob", " bob"}},
+		{name: "unassign", re: assignReg, comment: "/unassign @alice", expected: []string{"/unassign @alice", "un", " @alice", " @alice"}},
+		{name: "assign without separator", re: assignReg, comment: "/assignalice"},
+		{name: "cc", re: ccReg, comment: "/cc @alice", expected: []string{"/cc @alice", "", " @alice", " @alice"}},
+
+		{name: "lint", re: lintReg, comment: "/lint", expected: []string{"/lint"}},
+		{name: "lint with trailing space", re: lintReg, comment: "/Lint  \nmore", expected: []string{"/Lint  "}},
+		{name: "lint with suffix", re: lintReg, comment: "/linter"},
+		{name: "lint with argument", re: lintReg, comment: "/lint all"},
+
+		{name: "hold", re: holdReg, comment: "/hold", expected: []string{"/hold", ""}},
+		{name: "hold cancel", re: holdCancelReg, comment: "/hold cancel", expected: []string{"/hold cancel"}},
+		{name: "hold cancel without separator", re: holdCancelReg, comment: "/holdcancel"},
+
+		{name: "retitle", re: retitleReg, comment: "/retitle Fix the build", expected: []string{"/retitle Fix the build", "Fix the build"}},
+		{name: "retitle with tab", re: retitleReg, comment: "/retitle\tFix", expected: []string{"/retitle\tFix", "Fix"}},
+		{name: "retitle stops at line end", re: retitleReg, comment: "/retitle Fix\nthe build", expected: []string{"/retitle Fix", "Fix"}},
+		{name: "retitle without title", re: retitleReg, comment: "/retitle"},
+		{name: "retitle with title on next line", re: retitleReg, comment: "/retitle\nFix"},
+		{name: "retitle without separator", re: retitleReg, comment: "/retitleFix"},
+
+		{name: "lifecycle", re: lifecycleReg, comment: "/lifecycle frozen", expected: []string{"/lifecycle frozen", "", "frozen"}},
+		{name: "remove lifecycle", re: lifecycleReg, comment: "/remove-lifecycle stale", expected: []string{"/remove-lifecycle stale", "remove-", "stale"}},
+		{name: "lifecycle without separator", re: lifecycleReg, comment: "/lifecyclefrozen"},
+		{name: "lifecycle unknown", re: lifecycleReg, comment: "/lifecycle dormant"},
+
+		{name: "stage", re: stageReg, comment: "/stage beta", expected: []string{"/stage beta", "", "beta"}},
+		{name: "remove stage", re: stageReg, comment: "/remove-stage alpha", expected: []string{"/remove-stage alpha", "remove-", "alpha"}},
+		{name: "stage without separator", re: stageReg, comment: "/stagebeta"},
+
+		{name: "override", re: overrideReg, comment: "/override ci/unit ", expected: []string{"/override ci/unit ", "ci/unit"}},
+		{name: "cherrypick", re: cherrypickReg, comment: "/cherry-pick release-1.0", expected: []string{"/cherry-pick release-1.0", "release-1.0"}},
+		{name: "cherrypick without separator", re: cherrypickReg, comment: "/cherrypickrelease-1.0"},
+		{name: "duplicate", re: duplicateReg, comment: "/duplicate #12", expected: []string{"/duplicate #12", "12"}},
+		{name: "transfer", re: transferIssueReg, comment: "/transfer-issue other.repo", expected: []string{"/transfer-issue other.repo", "other.repo"}},
+
+		{name: "lgtm", re: lgtmReg, comment: "/lgtm", expected: []string{"/lgtm", ""}},
+		{name: "lgtm cancel", re: lgtmCancelReg, comment: "/lgtm cancel", expected: []string{"/lgtm cancel"}},
+		{name: "lgtm with suffix", re: lgtmReg, comment: "/lgtmfoo"},
+
+		{name: "approve", re: approveReg, comment: "/approve", expected: []string{"/approve", ""}},
+		{name: "approve no-issue", re: approveReg, comment: "/approve no-issue", expected: []string{"/approve no-issue", "no-issue"}},
+		{name: "approve cancel", re: approveReg, comment: "/APPROVE Cancel", expected: []string{"/APPROVE Cancel", "Cancel"}},
+		{name: "approve without separator", re: approveReg, comment: "/approvecancel"},
+		{name: "approve unknown argument", re: approveReg, comment: "/approve later"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.re.FindStringSubmatch(tc.comment); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("%s on %q: expected %q, got %q", tc.re, tc.comment, tc.expected, got)
+			}
+		})
+	}
+}
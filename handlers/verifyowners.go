@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"ci-bot/commentpruner"
+	"ci-bot/repoowners"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	verifyOwnersContext = "verify-owners"
+	invalidOwnersLabel  = "do-not-merge/invalid-owners-file"
+	verifyOwnersMarker  = "<!-- ci-bot:verify-owners -->"
+)
+
+// VerifyOwners is the config of the checks of the OWNERS files PRs change.
+type VerifyOwners struct {
+	// Repos are the orgs and org/repos the config applies to.
+	Repos []string `json:"repos,omitempty"`
+}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "verify-owners",
+		Events:      []string{"pull_request"},
+		Description: "Checks the OWNERS files changed by pull requests, setting the '" + verifyOwnersContext + "' status and the '" + invalidOwnersLabel + "' label while they are invalid, declare blacklisted labels, have approvers who aren't members of the org or leave their directory without approvers. Problems are listed in a single comment, updated on every push and removed once they are fixed.",
+		ConfigKey:   "verify_owners",
+	}, func(c *Config) []string {
+		return c.VerifyOwners.Repos
+	})
+}
+
+// ownersProblem is a problem with an OWNERS file, at a line of it when
+// line isn't 0.
+type ownersProblem struct {
+	path    string
+	line    int
+	message string
+}
+
+// findListItem returns the first line of an OWNERS file listing item, 0
+// if none does.
+func findListItem(content, item string) int {
+	for i, l := range strings.Split(content, "\n") {
+		tokens := strings.FieldsFunc(l, func(r rune) bool {
+			return strings.ContainsRune(" \t-[],'\"", r)
+		})
+		for _, t := range tokens {
+			if t == "#" {
+				break
+			}
+			if t == item {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// checkOwnersFile returns the problems of the content of an OWNERS file of
+// a repo of org, whose OWNERS_ALIASES define aliases. parentApprovers
+// tells whether the OWNERS files of the parent directories have approvers.
+func (s *Server) checkOwnersFile(client *github.Client, org, p, content string, aliases map[string][]string, parentApprovers bool) ([]ownersProblem, error) {
+	file, err := repoowners.ParseFile([]byte(content))
+	if err != nil {
+		return []ownersProblem{{path: p, message: fmt.Sprintf("The OWNERS file can't be parsed: %v", err)}}, nil
+	}
+	var problems []ownersProblem
+	approvers := map[string]bool{}
+	for _, c := range file.Configs {
+		for _, a := range c.Approvers {
+			if logins, ok := aliases[a]; ok {
+				for _, l := range logins {
+					approvers[l] = true
+				}
+				continue
+			}
+			approvers[a] = true
+		}
+	}
+	switch {
+	case len(approvers) == 0 && file.Options.NoParentOwners:
+		problems = append(problems, ownersProblem{
+			path:    p,
+			line:    findListItem(content, "no_parent_owners:"),
+			message: "The OWNERS file ignores the owners of its parents but lists no approvers: nobody could approve changes to its directory.",
+		})
+	case len(approvers) == 0 && !parentApprovers:
+		problems = append(problems, ownersProblem{path: p, message: "Neither the OWNERS file nor those of its parents list approvers: nobody could approve changes to its directory."})
+	}
+	for _, a := range sortedKeys(approvers) {
+		member, err := isOrgMember(client, org, a)
+		if err != nil {
+			return nil, err
+		}
+		if !member {
+			problems = append(problems, ownersProblem{
+				path:    p,
+				line:    findListItem(content, a),
+				message: fmt.Sprintf("The approver `%s` isn't a member of %s.", a, org),
+			})
+		}
+	}
+
+	for _, c := range file.Configs {
+		for _, l := range c.Labels {
+			if stringInSlice(l, s.Config.Owners.LabelsBlackList) {
+				problems = append(problems, ownersProblem{
+					path:    p,
+					line:    findListItem(content, l),
+					message: fmt.Sprintf("The label `%s` can't be applied by OWNERS files, it is blacklisted.", l),
+				})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// ownersAliases returns the aliases of the OWNERS_ALIASES file of repo at
+// sha, if there is one.
+func ownersAliases(client *github.Client, repo *github.Repository, sha string) (map[string][]string, error) {
+	data, err := scmFor(client).GetFile(repo.GetOwner().GetLogin(), repo.GetName(), "OWNERS_ALIASES", sha)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return repoowners.ParseAliases(data)
+}
+
+// handleVerifyOwners checks the OWNERS files changed by a PR.
+func (s *Server) handleVerifyOwners(client *github.Client, repo *github.Repository, pr *github.PullRequest) {
+	org := repo.GetOwner().GetLogin()
+	if !stringInSlice(repo.GetFullName(), s.Config.VerifyOwners.Repos) && !stringInSlice(org, s.Config.VerifyOwners.Repos) {
+		return
+	}
+	number := pr.GetNumber()
+	files, err := listPullRequestFiles(client, org, repo.GetName(), number)
+	if err != nil {
+		logError(client, "fail to list files of %s#%d: %v", repo.GetFullName(), number, err)
+		return
+	}
+
+	var problems []ownersProblem
+	var aliases map[string][]string
+	aliasesLoaded := false
+	var owners *repoowners.RepoOwners
+	for _, f := range files {
+		if path.Base(f.GetFilename()) != "OWNERS" || f.GetStatus() == "removed" {
+			continue
+		}
+		data, err := scmFor(client).GetFile(org, repo.GetName(), f.GetFilename(), pr.GetHead().GetSHA())
+		if err != nil {
+			logError(client, "fail to get %s of %s#%d: %v", f.GetFilename(), repo.GetFullName(), number, err)
+			return
+		}
+		if !aliasesLoaded {
+			if aliases, err = ownersAliases(client, repo, pr.GetHead().GetSHA()); err != nil {
+				logError(client, "fail to get the OWNERS aliases of %s#%d: %v", repo.GetFullName(), number, err)
+				return
+			}
+			aliasesLoaded = true
+		}
+		dir := path.Dir(f.GetFilename())
+		parentApprovers := false
+		if dir != "." {
+			if owners == nil {
+				if owners, err = s.parentOwners(client, repo, pr); err != nil {
+					logError(client, "fail to load the OWNERS of %s#%d: %v", repo.GetFullName(), number, err)
+					return
+				}
+			}
+			// The approvers of a directory are those of its parents'
+			// OWNERS files, up to one setting no_parent_owners.
+			parentApprovers = len(owners.Approvers(dir)) > 0
+		}
+		found, err := s.checkOwnersFile(client, org, f.GetFilename(), string(data), aliases, parentApprovers)
+		if err != nil {
+			logError(client, "fail to check %s of %s#%d: %v", f.GetFilename(), repo.GetFullName(), number, err)
+			return
+		}
+		problems = append(problems, found...)
+	}
+
+	labeled := hasLabel(pr.Labels, invalidOwnersLabel)
+	if len(problems) == 0 {
+		createStatus(client, repo, pr.GetHead().GetSHA(), verifyOwnersContext, "success", "OWNERS files are valid", "")
+		if labeled {
+			removeLabel(client, repo, number, invalidOwnersLabel)
+		}
+		s.commentPruner(client, repo, number).PruneComments(commentpruner.HasMarker(verifyOwnersMarker))
+		return
+	}
+	createStatus(client, repo, pr.GetHead().GetSHA(), verifyOwnersContext, "failure", "OWNERS files are invalid", "")
+	if !labeled {
+		addLabel(client, repo, number, invalidOwnersLabel)
+	}
+
+	var b strings.Builder
+	b.WriteString(verifyOwnersMarker + "\n")
+	b.WriteString("The OWNERS files changed by this pull request have problems:\n\n")
+	for _, p := range problems {
+		if p.line > 0 {
+			fmt.Fprintf(&b, "- `%s` line %d: %s\n", p.path, p.line, p.message)
+			continue
+		}
+		fmt.Fprintf(&b, "- `%s`: %s\n", p.path, p.message)
+	}
+	s.upsertComment(client, repo, number, verifyOwnersMarker, b.String())
+}
+
+// parentOwners loads the OWNERS of repo the OWNERS files pr changes are
+// combined with: those of its head, or of its base when an OWNERS file of
+// the head is too broken to load.
+func (s *Server) parentOwners(client *github.Client, repo *github.Repository, pr *github.PullRequest) (*repoowners.RepoOwners, error) {
+	owners, err := s.repoOwners(client, repo, pr.GetHead().GetSHA())
+	if err == nil {
+		return owners, nil
+	}
+	return s.repoOwners(client, repo, pr.GetBase().GetSHA())
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"ci-bot/commentpruner"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+var (
+	webhooksReceived         = newCounterVec("ci_bot_webhooks_received_total", "Webhooks received with a valid signature.", "org", "repo", "event", "action")
+	webhooksSkipped          = newCounterVec("ci_bot_webhooks_skipped_total", "Webhooks of repos no plugin acting on the event is enabled in.", "org", "repo", "event", "action")
+	webhooksProcessed        = newCounterVec("ci_bot_webhooks_processed_total", "Webhooks handed to the plugins, external ones included.", "org", "repo", "event", "action")
+	webhooksInvalidSignature = newCounterVec("ci_bot_webhooks_invalid_signature_total", "Webhooks rejected for their signature, by provider.", "provider", "event")
+	webhooksInFlight         = newGaugeVec("ci_bot_webhooks_in_flight", "Webhooks queued or being handled, by repo.", "org", "repo")
+	webhooksQueued           = newGaugeVec("ci_bot_webhooks_queued", "Webhooks waiting for a worker, by repo.", "org", "repo")
+	webhookQueueLength       = newGaugeVec("ci_bot_webhook_queue_length", "Webhooks waiting for a worker.")
+	webhookWorkers           = newGaugeVec("ci_bot_webhook_workers", "Workers handling the webhooks.")
+	webhookWorkersBusy       = newGaugeVec("ci_bot_webhook_workers_busy", "Workers handling a webhook.")
+	webhookQueueWait         = newHistogramVec("ci_bot_webhook_queue_wait_seconds", "Time webhooks waited for a worker.", defaultBuckets, "event")
+)
+
+const (
+	defaultWebhookWorkers   = 20
+	defaultWebhookQueueSize = 1000
+)
+
+// webhookTask is a webhook queued for the workers.
+type webhookTask struct {
+	org, repo string
+	event     string
+	queued    time.Time
+	handle    func()
+}
+
+// webhookQueue is where handleAsync queues the webhooks for the workers,
+// started on first use.
+var webhookQueue = struct {
+	once  sync.Once
+	tasks chan webhookTask
+}{}
+
+// startWebhookWorkers starts the workers of the webhook queue.
+func (s *Server) startWebhookWorkers() {
+	workers, size := s.Config.Webhooks.Workers, s.Config.Webhooks.QueueSize
+	if workers <= 0 {
+		workers = defaultWebhookWorkers
+	}
+	if size <= 0 {
+		size = defaultWebhookQueueSize
+	}
+	webhookQueue.tasks = make(chan webhookTask, size)
+	webhookWorkers.set(float64(workers))
+	for i := 0; i < workers; i++ {
+		go func() {
+			for t := range webhookQueue.tasks {
+				webhookQueueLength.add(-1)
+				webhooksQueued.add(-1, t.org, t.repo)
+				webhookQueueWait.observe(time.Since(t.queued).Seconds(), t.event)
+				webhookWorkersBusy.add(1)
+				t.handle()
+				webhookWorkersBusy.add(-1)
+				webhooksInFlight.add(-1, t.org, t.repo)
+				if c := s.current(); !c.repoConfigured(t.org, t.repo) {
+					c.expireWebhookSeries()
+				}
+			}
+		}()
+	}
+}
+
+// webhookEvents are the GitHub names of the types of proxy events.
+var webhookEvents = map[string]string{
+	proxyEventIssue:        "issues",
+	proxyEventIssueComment: "issue_comment",
+	proxyEventPullRequest:  "pull_request",
+	proxyEventReview:       "pull_request_review",
+	proxyEventPush:         "push",
+	proxyEventStatus:       "status",
+}
+
+// webhookEventTypes are the GitHub names of every type of proxy event.
+var webhookEventTypes = []string{"issues", "issue_comment", "pull_request", "pull_request_review", "push", "status"}
+
+// receiveEvent records an event in the webhook metrics and proxies it,
+// returning whether the plugins should handle it.
+func (s *Server) receiveEvent(eventType string, payload []byte) bool {
+	org, repo, action := eventRepo(payload)
+	labels := []string{org, repo, webhookEvents[eventType], action}
+	webhooksReceived.inc(labels...)
+	if !s.pluginEnabledIn(webhookEvents[eventType], org, repo) {
+		webhooksSkipped.inc(labels...)
+		return false
+	}
+	webhooksProcessed.inc(labels...)
+	return !s.proxyEvent(eventType, payload)
+}
+
+// pluginEnabledIn returns whether any plugin acting on the event, the proxy
+// included, is enabled in the repo. Plugins that need no config only count
+// in the repos the bot is configured for.
+func (s *Server) pluginEnabledIn(event, org, repo string) bool {
+	configured := s.repoConfigured(org, repo)
+	for _, p := range pluginHelpProviders {
+		if !stringInSlice(event, p.help.Events) {
+			continue
+		}
+		for _, e := range p.enabledIn(&s.Config) {
+			if (e == "*" && (configured || !p.unconfigured)) || e == org || e == org+"/"+repo {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// repoConfigured returns whether the config of any plugin covers the repo.
+func (s *Server) repoConfigured(org, repo string) bool {
+	for _, p := range pluginHelpProviders {
+		if p.unconfigured {
+			continue
+		}
+		for _, e := range p.enabledIn(&s.Config) {
+			if e == "*" || e == org || e == org+"/"+repo {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expireWebhookSeries drops the per-repo series of the webhook gauges that
+// are back to zero for repos no longer configured, so that they don't
+// pile up with every repo that ever sent a webhook.
+func (s *Server) expireWebhookSeries() {
+	configured := func(labels []string) bool { return s.repoConfigured(labels[0], labels[1]) }
+	webhooksInFlight.expire(configured)
+	webhooksQueued.expire(configured)
+}
+
+// eventRepo returns the repo and action of the payload of an event. An
+// event that doesn't parse is still counted, its handler reports the
+// error.
+func eventRepo(payload []byte) (org, repo, action string) {
+	var event struct {
+		Action     string `json:"action"`
+		Repository struct {
+			Name  string `json:"name"`
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+		} `json:"repository"`
+	}
+	json.Unmarshal(payload, &event)
+	return event.Repository.Owner.Login, event.Repository.Name, event.Action
+}
+
+// webhookContext describes the webhook an operation is made for, in the
+// errors it reports. It also holds the comment pruners the plugins handling
+// the webhook share.
+type webhookContext struct {
+	Event, Delivery string
+	Org, Repo       string
+	Action          string
+
+	client  *github.Client
+	lock    sync.Mutex
+	pruners map[string]*commentpruner.EventClient
+}
+
+// commentPruner returns the pruner of the bot's comments on repo#number
+// shared by the plugins handling the webhook, so that the comments are
+// listed once per event. It makes its requests with the client of the
+// webhook, which outlives the clients of the plugins.
+func (w *webhookContext) commentPruner(botName string, repo *github.Repository, number int) *commentpruner.EventClient {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	key := fmt.Sprintf("%s#%d", repo.GetFullName(), number)
+	if p, ok := w.pruners[key]; ok {
+		return p
+	}
+	if w.pruners == nil {
+		w.pruners = map[string]*commentpruner.EventClient{}
+	}
+	p := commentpruner.NewEventClient(scmFor(w.client), botName, repo.GetOwner().GetLogin(), repo.GetName(), number)
+	w.pruners[key] = p
+	return p
+}
+
+func (w *webhookContext) tags() map[string]string {
+	tags := map[string]string{}
+	if w == nil {
+		return tags
+	}
+	tags["event"] = w.Event
+	tags["delivery"] = w.Delivery
+	tags["repo"] = w.Org + "/" + w.Repo
+	if w.Action != "" {
+		tags["action"] = w.Action
+	}
+	return tags
+}
+
+// handleAsync queues an event for the webhook workers, counting it in the
+// webhooks in flight until it's handled. It blocks while the queue is
+// full, so that the webhooks the bot can't keep up with time out and are
+// redelivered rather than piling up in memory.
+func (s *Server) handleAsync(eventType, delivery string, payload []byte, client *github.Client, handle func(client *github.Client)) {
+	webhookQueue.once.Do(s.startWebhookWorkers)
+	org, repo, _ := eventRepo(payload)
+	webhooksInFlight.add(1, org, repo)
+	webhooksQueued.add(1, org, repo)
+	webhookQueueLength.add(1)
+	webhookQueue.tasks <- webhookTask{
+		org:    org,
+		repo:   repo,
+		event:  eventType,
+		queued: time.Now(),
+		handle: func() { s.handleEvent(eventType, delivery, payload, client, handle) },
+	}
+}
+
+// handleEvent handles an event in the calling goroutine, e.g. to keep the
+// events translated from polling in order. The handling is traced within
+// the trace of the delivery, and a panicking handler is reported rather
+// than bringing the bot down.
+func (s *Server) handleEvent(eventType, delivery string, payload []byte, client *github.Client, handle func(client *github.Client)) {
+	org, repo, action := eventRepo(payload)
+	event := &webhookContext{Event: eventType, Delivery: delivery, Org: org, Repo: repo, Action: action}
+	sp := startTrace(delivery, "webhook "+eventType, spanKindServer)
+	sp.setAttribute("github.event", eventType)
+	sp.setAttribute("github.repository", org+"/"+repo)
+	sp.setAttribute("github.action", action)
+	client = webhookClient(client, event, sp)
+	event.client = client
+	defer func() {
+		if r := recover(); r != nil {
+			sp.setError(fmt.Sprintf("panic: %v", r))
+			glog.Errorf("handler of %s event panicked: %v\n%s", eventType, r, debug.Stack())
+			reportPanic(r, debug.Stack(), event.tags())
+		}
+		sp.finish()
+		releaseClient(client)
+	}()
+	handle(client)
+}
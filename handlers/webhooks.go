@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const webhooksInterval = time.Hour
+
+// defaultWebhookEvents are the events the bot handles.
+var defaultWebhookEvents = []string{"issues", "issue_comment", "pull_request", "pull_request_review", "push", "status"}
+
+// Webhooks makes sure the repos deliver their webhooks to the bot.
+type Webhooks struct {
+	// URL the webhooks are delivered to, e.g. https://bot.example.com/hook.
+	URL string `json:"url,omitempty"`
+	// Repos are the orgs and org/repos that must have a hook delivering
+	// to URL: org hooks for orgs, repo hooks for org/repos.
+	Repos []string `json:"repos,omitempty"`
+	// Events the hooks deliver, the ones the bot handles by default.
+	Events []string `json:"events,omitempty"`
+	// Workers handling the webhooks received, 20 by default, and the
+	// number of webhooks QueueSize queued for them, 1000 by default. The
+	// bot stops reading webhooks while the queue is full. Both are those of
+	// the config the bot started with.
+	Workers   int `json:"workers,omitempty"`
+	QueueSize int `json:"queue_size,omitempty"`
+}
+
+// webhooksSecretSet are the orgs and org/repos whose hook got the secret
+// since the bot started. GitHub doesn't show the secret of hooks, so it's
+// set once per run in case it changed.
+var webhooksSecretSet = struct {
+	sync.Mutex
+	m map[string]bool
+}{m: map[string]bool{}}
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "webhooks",
+		Description: "The webhooks plugin creates the hook delivering webhooks to the bot on the configured orgs and repos, and fixes its events, secret and content type when they drift.",
+		ConfigKey:   "webhooks",
+	}, func(c *Config) []string {
+		return c.Webhooks.Repos
+	})
+	registerPeriodic("webhooks", webhooksInterval, func(c *Config) bool {
+		return len(c.Webhooks.Repos) > 0
+	}, (*Server).reconcileWebhooks)
+}
+
+func (c *Config) validateWebhooks() error {
+	if len(c.Webhooks.Repos) == 0 {
+		return nil
+	}
+	if c.Webhooks.URL == "" {
+		return fmt.Errorf("webhooks: url must be set")
+	}
+	if c.WebhookSecret == "" && c.WebhookSecretFile == "" {
+		return fmt.Errorf("webhooks: webhook_secret or webhook_secret_file must be set")
+	}
+	return nil
+}
+
+func (w Webhooks) events() []string {
+	events := w.Events
+	if len(events) == 0 {
+		events = defaultWebhookEvents
+	}
+	sorted := append([]string(nil), events...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// reconcileWebhooks creates or fixes the hook of every configured org and
+// repo.
+func (s *Server) reconcileWebhooks(client *github.Client) {
+	for _, target := range s.Config.Webhooks.Repos {
+		if err := s.reconcileWebhook(client, target); err != nil {
+			logError(client, "fail to reconcile the hook of %s: %v", target, err)
+		}
+	}
+}
+
+func (s *Server) reconcileWebhook(client *github.Client, target string) error {
+	ctx := context.Background()
+	secrets, err := s.Config.WebhookSecrets()
+	if err != nil || len(secrets) == 0 {
+		return fmt.Errorf("no webhook secret: %v", err)
+	}
+	hooks, _, err := listHooks(client, target)
+	if err != nil {
+		return err
+	}
+	events := s.Config.Webhooks.events()
+	want := &github.Hook{
+		Name:   github.String("web"),
+		Active: github.Bool(true),
+		Events: events,
+		Config: map[string]interface{}{
+			"url":          s.Config.Webhooks.URL,
+			"content_type": "json",
+			"secret":       secrets[0],
+			"insecure_ssl": "0",
+		},
+	}
+	org, repo := target, ""
+	if parts := strings.SplitN(target, "/", 2); len(parts) == 2 {
+		org, repo = parts[0], parts[1]
+	}
+
+	current := hookTo(hooks, s.Config.Webhooks.URL)
+	if current == nil {
+		if repo != "" {
+			_, _, err = client.Repositories.CreateHook(ctx, org, repo, want)
+		} else {
+			_, _, err = client.Organizations.CreateHook(ctx, org, want)
+		}
+		if err == nil {
+			glog.Infof("created the hook of %s", target)
+			markWebhookSecretSet(target)
+		}
+		return err
+	}
+
+	have := append([]string(nil), current.Events...)
+	sort.Strings(have)
+	contentType, _ := current.Config["content_type"].(string)
+	webhooksSecretSet.Lock()
+	secretSet := webhooksSecretSet.m[target]
+	webhooksSecretSet.Unlock()
+	if current.GetActive() && contentType == "json" && strings.Join(have, ",") == strings.Join(events, ",") && secretSet {
+		return nil
+	}
+	if repo != "" {
+		_, _, err = client.Repositories.EditHook(ctx, org, repo, current.GetID(), want)
+	} else {
+		_, _, err = client.Organizations.EditHook(ctx, org, current.GetID(), want)
+	}
+	if err == nil {
+		glog.Infof("fixed the hook of %s", target)
+		markWebhookSecretSet(target)
+	}
+	return err
+}
+
+func markWebhookSecretSet(target string) {
+	webhooksSecretSet.Lock()
+	defer webhooksSecretSet.Unlock()
+	webhooksSecretSet.m[target] = true
+}
+
+// listHooks returns the hooks of an org or org/repo, and the API path they
+// are under.
+func listHooks(client *github.Client, target string) ([]*github.Hook, string, error) {
+	ctx := context.Background()
+	opt := &github.ListOptions{PerPage: 100}
+	var hooks []*github.Hook
+	parts := strings.SplitN(target, "/", 2)
+	for {
+		var page []*github.Hook
+		var resp *github.Response
+		var err error
+		if len(parts) == 2 {
+			page, resp, err = client.Repositories.ListHooks(ctx, parts[0], parts[1], opt)
+		} else {
+			page, resp, err = client.Organizations.ListHooks(ctx, target, opt)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		hooks = append(hooks, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	if len(parts) == 2 {
+		return hooks, fmt.Sprintf("repos/%s/%s/hooks", parts[0], parts[1]), nil
+	}
+	return hooks, fmt.Sprintf("orgs/%s/hooks", target), nil
+}
+
+// hookTo returns the hook of hooks delivering to url, if any.
+func hookTo(hooks []*github.Hook, url string) *github.Hook {
+	for _, h := range hooks {
+		if u, _ := h.Config["url"].(string); u == url {
+			return h
+		}
+	}
+	return nil
+}
+
+// SetWebhookSecret makes secret the secret of the hook of an org or
+// org/repo delivering to the bot.
+func SetWebhookSecret(client *github.Client, w Webhooks, target, secret string) error {
+	hooks, _, err := listHooks(client, target)
+	if err != nil {
+		return err
+	}
+	current := hookTo(hooks, w.URL)
+	if current == nil {
+		return fmt.Errorf("no hook of %s delivers to %s", target, w.URL)
+	}
+	config := map[string]interface{}{}
+	for k, v := range current.Config {
+		config[k] = v
+	}
+	config["secret"] = secret
+	edit := &github.Hook{Config: config}
+	ctx := context.Background()
+	if parts := strings.SplitN(target, "/", 2); len(parts) == 2 {
+		_, _, err = client.Repositories.EditHook(ctx, parts[0], parts[1], current.GetID(), edit)
+	} else {
+		_, _, err = client.Organizations.EditHook(ctx, target, current.GetID(), edit)
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/google/go-github/github"
+)
+
+var wipTitleReg = regexp.MustCompile(`(?i)^\W?WIP\W`)
+
+func init() {
+	registerPluginHelp(PluginHelp{
+		Name:        "wip",
+		Events:      []string{"pull_request"},
+		Description: "The wip (Work In Progress) plugin applies the '" + wipLabel + "' label to pull requests whose title starts with 'WIP' or '[WIP]' or that are drafts, and removes it from pull requests when they no longer are. This label is typically used to block merging.",
+	}, enabledEverywhere)
+}
+
+// pullRequestIsDraft reads the draft flag of a pull_request payload, which
+// the vendored go-github doesn't know about yet.
+func pullRequestIsDraft(body []byte) bool {
+	var payload struct {
+		PullRequest struct {
+			Draft bool `json:"draft"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	return payload.PullRequest.Draft
+}
+
+// handleWIP keeps the work in progress label in sync with the title and
+// draft state of a PR.
+func (s *Server) handleWIP(client *github.Client, pe *github.PullRequestEvent, draft bool) {
+	pr := pe.GetPullRequest()
+	wip := draft || wipTitleReg.MatchString(pr.GetTitle())
+	labeled := hasLabel(pr.Labels, wipLabel)
+	switch {
+	case wip && !labeled:
+		addLabel(client, pe.Repo, pr.GetNumber(), wipLabel)
+	case !wip && labeled:
+		removeLabel(client, pe.Repo, pr.GetNumber(), wipLabel)
+	}
+}
@@ -0,0 +1,88 @@
+package podutils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// CloneRefs checks out the base of the refs of the job into Dir/Repo and
+// merges its PRs onto it. Jobs without refs have nothing to check out.
+func CloneRefs() error {
+	var o CloneRefsOptions
+	if err := loadEnv(CloneRefsOptionsEnv, &o); err != nil {
+		return err
+	}
+	spec, err := ResolveJobSpec()
+	if err != nil {
+		return err
+	}
+	if spec.Refs == nil {
+		return nil
+	}
+
+	out := io.Writer(os.Stdout)
+	if o.Log != "" {
+		if err := os.MkdirAll(filepath.Dir(o.Log), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(o.Log)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = io.MultiWriter(os.Stdout, f)
+	}
+	return cloneRefs(*spec.Refs, filepath.Join(o.Dir, spec.Refs.Repo), out)
+}
+
+func cloneRefs(refs Refs, dir string, out io.Writer) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	git := func(args ...string) error {
+		fmt.Fprintf(out, "$ git %v\n", args)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Stdout, cmd.Stderr = out, out
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %v: %v", args, err)
+		}
+		return nil
+	}
+	remote := refs.CloneURI
+	if remote == "" {
+		remote = fmt.Sprintf("https://github.com/%s/%s.git", refs.Org, refs.Repo)
+	}
+	base := refs.BaseSHA
+	if base == "" {
+		base = "FETCH_HEAD"
+	}
+	steps := [][]string{
+		{"init"},
+		{"config", "user.name", "ci-bot"},
+		{"config", "user.email", "ci-bot@localhost"},
+		{"fetch", remote, refs.BaseRef},
+		{"checkout", base},
+		{"branch", "--force", refs.BaseRef, base},
+		{"checkout", refs.BaseRef},
+	}
+	for _, p := range refs.Pulls {
+		ref := p.Ref
+		if ref == "" {
+			ref = fmt.Sprintf("pull/%d/head", p.Number)
+		}
+		steps = append(steps,
+			[]string{"fetch", remote, ref},
+			[]string{"merge", "--no-ff", "--no-edit", p.SHA},
+		)
+	}
+	for _, args := range steps {
+		if err := git(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
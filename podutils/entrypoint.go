@@ -0,0 +1,104 @@
+package podutils
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// timedOutCode is the exit code of tests that didn't finish in time.
+const timedOutCode = 124
+
+// Entrypoint runs the test, teeing its output into the process log, and
+// writes its exit code into the marker file for sidecar to find. It
+// returns the exit code of the test.
+func Entrypoint() int {
+	var o EntrypointOptions
+	if err := loadEnv(EntrypointOptionsEnv, &o); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	code := runTest(o)
+	if err := writeMarker(o.MarkerFile, code); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write marker file: %v\n", err)
+	}
+	return code
+}
+
+func runTest(o EntrypointOptions) int {
+	if len(o.Args) == 0 {
+		fmt.Fprintln(os.Stderr, "no test command")
+		return 1
+	}
+	for _, dir := range []string{filepath.Dir(o.ProcessLog), o.ArtifactDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	log, err := os.Create(o.ProcessLog)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer log.Close()
+	out := io.MultiWriter(os.Stdout, log)
+
+	cmd := exec.Command(o.Args[0], o.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.Env = append(os.Environ(), "ARTIFACTS="+o.ArtifactDir)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(out, "could not start the test: %v\n", err)
+		return 1
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var timeout <-chan time.Time
+	if o.Timeout.Duration > 0 {
+		timeout = time.After(o.Timeout.Duration)
+	}
+	select {
+	case err := <-done:
+		return exitCode(err)
+	case <-timeout:
+	}
+
+	fmt.Fprintf(out, "the test did not finish within %s, interrupting it\n", o.Timeout.Duration)
+	cmd.Process.Signal(syscall.SIGINT)
+	select {
+	case <-done:
+	case <-time.After(o.GracePeriod.Duration):
+		fmt.Fprintf(out, "the test did not exit within %s of the interrupt, killing it\n", o.GracePeriod.Duration)
+		cmd.Process.Kill()
+		<-done
+	}
+	return timedOutCode
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exit, ok := err.(*exec.ExitError); ok {
+		if status, ok := exit.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return 1
+}
+
+// writeMarker writes the exit code aside and renames it so that sidecar
+// can't read half a file.
+func writeMarker(path string, code int) error {
+	if err := ioutil.WriteFile(path+".tmp", []byte(strconv.Itoa(code)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
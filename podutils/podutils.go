@@ -0,0 +1,135 @@
+// Package podutils is run in the pods of decorated jobs, around the test
+// container: clonerefs checks out the refs of the job, entrypoint runs the
+// test under a timeout and logs its output, and sidecar uploads the log
+// and artifacts to object storage once the test is done.
+//
+// The job is described to all three by the JOB_SPEC env the bot sets on
+// job pods, and each gets its options as JSON in its own env.
+package podutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	JobSpecEnv           = "JOB_SPEC"
+	CloneRefsOptionsEnv  = "CLONEREFS_OPTIONS"
+	EntrypointOptionsEnv = "ENTRYPOINT_OPTIONS"
+	SidecarOptionsEnv    = "SIDECAR_OPTIONS"
+)
+
+// JobSpec is the job a pod runs.
+type JobSpec struct {
+	Type    string `json:"type"`
+	Job     string `json:"job"`
+	BuildID string `json:"buildid"`
+	Refs    *Refs  `json:"refs,omitempty"`
+}
+
+// Refs are the git references a job tests.
+type Refs struct {
+	Org     string `json:"org"`
+	Repo    string `json:"repo"`
+	BaseRef string `json:"base_ref"`
+	BaseSHA string `json:"base_sha"`
+	Pulls   []Pull `json:"pulls,omitempty"`
+	// CloneURI is where the repo is fetched from, GitHub by default.
+	CloneURI string `json:"clone_uri,omitempty"`
+}
+
+// Pull is a PR merged onto the base of Refs.
+type Pull struct {
+	Number int    `json:"number"`
+	Author string `json:"author"`
+	SHA    string `json:"sha"`
+	// Ref the PR is fetched from, pull/<number>/head by default.
+	Ref string `json:"ref,omitempty"`
+}
+
+// CloneRefsOptions tell clonerefs where to check out the refs.
+type CloneRefsOptions struct {
+	// Dir the repo is cloned into, as Dir/Repo.
+	Dir string `json:"dir"`
+	// Log is the file the output of git is written to.
+	Log string `json:"log,omitempty"`
+}
+
+// EntrypointOptions tell entrypoint what to run and how long for.
+type EntrypointOptions struct {
+	// Args are the test command and its arguments.
+	Args []string `json:"args"`
+	// Timeout of the test, after which it's interrupted.
+	Timeout Duration `json:"timeout"`
+	// GracePeriod the test has to exit once interrupted before it's killed.
+	GracePeriod Duration `json:"grace_period"`
+	// ProcessLog is the file the output of the test is written to.
+	ProcessLog string `json:"process_log"`
+	// MarkerFile gets the exit code of the test once it's done.
+	MarkerFile string `json:"marker_file"`
+	// ArtifactDir is where the test can write artifacts, as $ARTIFACTS.
+	ArtifactDir string `json:"artifact_dir"`
+}
+
+// SidecarOptions tell sidecar what to upload where.
+type SidecarOptions struct {
+	ProcessLog  string `json:"process_log"`
+	MarkerFile  string `json:"marker_file"`
+	ArtifactDir string `json:"artifact_dir"`
+	// CloneLog is the log of clonerefs, uploaded if there is one.
+	CloneLog string  `json:"clone_log,omitempty"`
+	Storage  Storage `json:"storage"`
+	// Path of the job's files in the storage.
+	Path string `json:"path"`
+}
+
+// Storage is where logs and artifacts are uploaded: an http(s) URL files
+// are PUT under, or a file URL of a mounted directory they are copied to.
+type Storage struct {
+	URL string `json:"url"`
+	// TokenFile holds a bearer token for http(s) uploads, if needed.
+	TokenFile string `json:"token_file,omitempty"`
+}
+
+// Duration is a time.Duration written as in "1h30m" in JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// loadEnv decodes the JSON in the env variable name into out.
+func loadEnv(name string, out interface{}) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return fmt.Errorf("$%s is not set", name)
+	}
+	if err := json.Unmarshal([]byte(value), out); err != nil {
+		return fmt.Errorf("invalid $%s: %v", name, err)
+	}
+	return nil
+}
+
+// ResolveJobSpec returns the job of the pod.
+func ResolveJobSpec() (JobSpec, error) {
+	var spec JobSpec
+	err := loadEnv(JobSpecEnv, &spec)
+	return spec, err
+}
@@ -0,0 +1,203 @@
+package podutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// markerPollInterval is how often sidecar checks whether the test is done.
+const markerPollInterval = time.Second
+
+// Started is uploaded as started.json when the job starts.
+type Started struct {
+	Timestamp int64  `json:"timestamp"`
+	Job       string `json:"job"`
+	BuildID   string `json:"buildid"`
+	Refs      *Refs  `json:"refs,omitempty"`
+}
+
+// Finished is uploaded as finished.json once the job is done.
+type Finished struct {
+	Timestamp int64  `json:"timestamp"`
+	Passed    bool   `json:"passed"`
+	Result    string `json:"result"`
+	ExitCode  int    `json:"exit_code"`
+}
+
+// Sidecar uploads started.json, then waits for the test to be done to
+// upload its log, its artifacts and finished.json.
+func Sidecar() error {
+	var o SidecarOptions
+	if err := loadEnv(SidecarOptionsEnv, &o); err != nil {
+		return err
+	}
+	spec, err := ResolveJobSpec()
+	if err != nil {
+		return err
+	}
+	up, err := newUploader(o.Storage, o.Path)
+	if err != nil {
+		return err
+	}
+
+	started, _ := json.Marshal(Started{
+		Timestamp: time.Now().Unix(),
+		Job:       spec.Job,
+		BuildID:   spec.BuildID,
+		Refs:      spec.Refs,
+	})
+	if err := up.upload("started.json", bytes.NewReader(started)); err != nil {
+		return err
+	}
+
+	code := waitForMarker(o.MarkerFile)
+
+	var errs []string
+	files := map[string]string{"build-log.txt": o.ProcessLog}
+	if o.CloneLog != "" {
+		files["clone-log.txt"] = o.CloneLog
+	}
+	for name, file := range files {
+		if err := up.uploadFile(name, file); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+		}
+	}
+	if o.ArtifactDir != "" {
+		err := filepath.Walk(o.ArtifactDir, func(file string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(o.ArtifactDir, file)
+			if err != nil {
+				return err
+			}
+			return up.uploadFile(path.Join("artifacts", filepath.ToSlash(rel)), file)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	result := "SUCCESS"
+	if code != 0 {
+		result = "FAILURE"
+	}
+	finished, _ := json.Marshal(Finished{
+		Timestamp: time.Now().Unix(),
+		Passed:    code == 0,
+		Result:    result,
+		ExitCode:  code,
+	})
+	if err := up.upload("finished.json", bytes.NewReader(finished)); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("fail to upload: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// waitForMarker returns the exit code entrypoint writes in the marker file
+// once the test is done.
+func waitForMarker(file string) int {
+	for {
+		b, err := ioutil.ReadFile(file)
+		if err == nil {
+			code, err := strconv.Atoi(strings.TrimSpace(string(b)))
+			if err != nil {
+				return 1
+			}
+			return code
+		}
+		time.Sleep(markerPollInterval)
+	}
+}
+
+// uploader puts the files of a job under its path in the storage.
+type uploader struct {
+	base  *url.URL
+	token string
+}
+
+func newUploader(s Storage, p string) (*uploader, error) {
+	base, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage url: %v", err)
+	}
+	switch base.Scheme {
+	case "http", "https", "file":
+	default:
+		return nil, fmt.Errorf("unsupported storage url %s", s.URL)
+	}
+	base.Path = path.Join(base.Path, p)
+	up := &uploader{base: base}
+	if s.TokenFile != "" {
+		b, err := ioutil.ReadFile(s.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		up.token = strings.TrimSpace(string(b))
+	}
+	return up, nil
+}
+
+func (u *uploader) uploadFile(name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return u.upload(name, f)
+}
+
+func (u *uploader) upload(name string, body io.Reader) error {
+	dest := *u.base
+	dest.Path = path.Join(dest.Path, name)
+	if dest.Scheme == "file" {
+		if err := os.MkdirAll(filepath.Dir(dest.Path), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(dest.Path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, body); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	req, err := http.NewRequest(http.MethodPut, dest.String(), body)
+	if err != nil {
+		return err
+	}
+	if u.token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.token)
+	}
+	if strings.HasSuffix(name, ".txt") || strings.HasSuffix(name, ".log") {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	} else if strings.HasSuffix(name, ".json") {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("upload of %s: %s", name, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package repoowners
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Events a Cache reports to its observer.
+const (
+	CacheHit     = "hit"
+	CacheMiss    = "miss"
+	CacheExpired = "expired"
+	CacheEvicted = "evicted"
+)
+
+// Cache keeps the OWNERS of the most recently used commits, so that every
+// event on a PR doesn't download the OWNERS files of its repo again. A
+// commit can't change, but the config reading it can, hence the TTL.
+type Cache struct {
+	size int
+	ttl  time.Duration
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	// lru orders the entries from the most recently used.
+	lru *list.List
+	// observe is told of the lookups and evictions, for metrics.
+	observe func(event string)
+}
+
+type cacheEntry struct {
+	key     string
+	owners  *RepoOwners
+	expires time.Time
+}
+
+// NewCache returns a cache of the OWNERS of up to size commits, each kept
+// for ttl at most.
+func NewCache(size int, ttl time.Duration) *Cache {
+	return &Cache{size: size, ttl: ttl, entries: map[string]*list.Element{}, lru: list.New(), observe: func(string) {}}
+}
+
+// Observe makes observe be told of every Cache event, e.g. to count them.
+// It is called with the cache locked.
+func (c *Cache) Observe(observe func(event string)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.observe = observe
+}
+
+func (c *Cache) get(key string) (*RepoOwners, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.observe(CacheMiss)
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		c.observe(CacheExpired)
+		c.observe(CacheMiss)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	c.observe(CacheHit)
+	return entry.owners, true
+}
+
+func (c *Cache) add(key string, owners *RepoOwners) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := &cacheEntry{key: key, owners: owners, expires: time.Now().Add(c.ttl)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.observe(CacheEvicted)
+	}
+}
@@ -0,0 +1,478 @@
+// Package repoowners loads the OWNERS files of a repo and answers who
+// approves, reviews and labels each of its paths.
+//
+// An OWNERS file covers the files of its directory and, unless it sets the
+// no_parent_owners option, is combined with the OWNERS files of the parent
+// directories. It either lists owners directly:
+//
+//	approvers: [alice]
+//	reviewers: [bob]
+//	labels: [sig/docs]
+//
+// or per regexp of the paths, relative to its directory, it applies to:
+//
+//	filters:
+//	  "\\.go$":
+//	    approvers: [alice]
+//
+// Groups of logins can be named in the OWNERS_ALIASES file at the root of
+// the repo and used in place of logins in any OWNERS file:
+//
+//	aliases:
+//	  docs-approvers: [alice, carol]
+//
+// In repos enabling it, markdown files can also start with the config of
+// their own OWNERS, as a YAML header bracketed by "---" lines.
+package repoowners
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ownersFileName  = "OWNERS"
+	aliasesFileName = "OWNERS_ALIASES"
+)
+
+// Config lists the owners an OWNERS file gives the paths it covers.
+type Config struct {
+	Approvers         []string `yaml:"approvers"`
+	Reviewers         []string `yaml:"reviewers"`
+	RequiredReviewers []string `yaml:"required_reviewers"`
+	Labels            []string `yaml:"labels"`
+}
+
+// Options change how an OWNERS file combines with its parents.
+type Options struct {
+	// NoParentOwners stops the owners of parent directories from owning
+	// the paths the file covers.
+	NoParentOwners bool `yaml:"no_parent_owners"`
+}
+
+type filter struct {
+	re     *regexp.Regexp
+	config Config
+}
+
+type ownersFile struct {
+	options Options
+	filters []filter
+}
+
+// RepoOwners are the OWNERS files of a repo at a commit, keyed by their
+// directory, "" being the root.
+type RepoOwners struct {
+	files map[string]*ownersFile
+	// mdFiles are the OWNERS configs of markdown files, keyed by their path.
+	mdFiles map[string]*ownersFile
+}
+
+// Files is the access to the files of repos OWNERS are loaded through.
+type Files interface {
+	// ListTree returns the paths of the files of the tree of sha.
+	ListTree(org, repo, sha string) ([]string, error)
+	// GetFile returns the content of a file at ref.
+	GetFile(org, repo, path, ref string) ([]byte, error)
+}
+
+// Client loads the OWNERS files of repos.
+type Client struct {
+	files         Files
+	mdYAMLEnabled func(org, repo string) bool
+	dirBlacklist  func(org, repo string) []*regexp.Regexp
+	cache         *Cache
+}
+
+// NewClient returns a client loading OWNERS files with files. The YAML
+// headers of markdown files are read in the repos mdYAMLEnabled reports.
+// The directories matching a regexp dirBlacklist returns for a repo, and
+// everything under them, are skipped. Loaded OWNERS are kept in cache,
+// unless it is nil.
+func NewClient(files Files, mdYAMLEnabled func(org, repo string) bool, dirBlacklist func(org, repo string) []*regexp.Regexp, cache *Cache) *Client {
+	return &Client{files: files, mdYAMLEnabled: mdYAMLEnabled, dirBlacklist: dirBlacklist, cache: cache}
+}
+
+// LoadRepoOwners loads the OWNERS files of org/repo at sha. The returned
+// RepoOwners may be shared and must not be modified.
+func (c *Client) LoadRepoOwners(org, repo, sha string) (*RepoOwners, error) {
+	mdYAML := c.mdYAMLEnabled != nil && c.mdYAMLEnabled(org, repo)
+	var blacklist []*regexp.Regexp
+	if c.dirBlacklist != nil {
+		blacklist = c.dirBlacklist(org, repo)
+	}
+	key := fmt.Sprintf("%s/%s@%s mdyaml=%t blacklist=%v", org, repo, sha, mdYAML, blacklist)
+	if c.cache != nil {
+		if o, ok := c.cache.get(key); ok {
+			return o, nil
+		}
+	}
+	o, err := c.load(org, repo, sha, mdYAML, blacklist)
+	if err != nil {
+		return nil, err
+	}
+	if c.cache != nil {
+		c.cache.add(key, o)
+	}
+	return o, nil
+}
+
+func (c *Client) load(org, repo, sha string, mdYAML bool, blacklist []*regexp.Regexp) (*RepoOwners, error) {
+	paths, err := c.files.ListTree(org, repo, sha)
+	if err != nil {
+		// As is, for the caller to tell e.g. a code host without trees.
+		return nil, err
+	}
+	var aliases map[string][]string
+	for _, p := range paths {
+		if p != aliasesFileName {
+			continue
+		}
+		data, err := c.files.GetFile(org, repo, p, sha)
+		if err != nil {
+			return nil, fmt.Errorf("fail to get %s of %s/%s@%s: %v", p, org, repo, sha, err)
+		}
+		if aliases, err = parseAliases(data); err != nil {
+			return nil, fmt.Errorf("invalid %s of %s/%s@%s: %v", p, org, repo, sha, err)
+		}
+	}
+
+	o := &RepoOwners{files: map[string]*ownersFile{}, mdFiles: map[string]*ownersFile{}}
+	for _, p := range paths {
+		isOwners := path.Base(p) == ownersFileName
+		isMD := mdYAML && strings.HasSuffix(p, ".md")
+		if !isOwners && !isMD || blacklisted(dirOf(p), blacklist) {
+			continue
+		}
+		data, err := c.files.GetFile(org, repo, p, sha)
+		if err != nil {
+			return nil, fmt.Errorf("fail to get %s of %s/%s@%s: %v", p, org, repo, sha, err)
+		}
+		if isMD {
+			header, ok := yamlHeader(data)
+			if !ok {
+				continue
+			}
+			data = header
+		}
+		f, err := parseOwnersFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s of %s/%s@%s: %v", p, org, repo, sha, err)
+		}
+		f.expandAliases(aliases)
+		if isMD {
+			o.mdFiles[p] = f
+			continue
+		}
+		o.files[dirOf(p)] = f
+	}
+	return o, nil
+}
+
+// blacklisted reports whether dir or one of its parents matches a regexp
+// of blacklist.
+func blacklisted(dir string, blacklist []*regexp.Regexp) bool {
+	for ; dir != ""; dir = dirOf(dir) {
+		for _, re := range blacklist {
+			if re.MatchString(dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// yamlHeader returns the YAML header a markdown file starts with, and
+// whether it has one.
+func yamlHeader(data []byte) ([]byte, bool) {
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return nil, false
+	}
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "---" {
+			return []byte(strings.Join(lines[1:i], "")), true
+		}
+	}
+	return nil, false
+}
+
+// ParseAliases parses the content of an OWNERS_ALIASES file into the
+// logins of each alias, lowercased.
+func ParseAliases(data []byte) (map[string][]string, error) {
+	return parseAliases(data)
+}
+
+// parseAliases parses the content of an OWNERS_ALIASES file into the
+// logins of each alias.
+func parseAliases(data []byte) (map[string][]string, error) {
+	var doc struct {
+		Aliases map[string][]string `yaml:"aliases"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	aliases := map[string][]string{}
+	for name, logins := range doc.Aliases {
+		for i := range logins {
+			logins[i] = strings.ToLower(logins[i])
+		}
+		aliases[strings.ToLower(name)] = logins
+	}
+	return aliases, nil
+}
+
+// expandAliases replaces the aliases among the owners of f by their logins.
+func (f *ownersFile) expandAliases(aliases map[string][]string) {
+	if len(aliases) == 0 {
+		return
+	}
+	expand := func(list []string) []string {
+		var expanded []string
+		for _, l := range list {
+			if logins, ok := aliases[l]; ok {
+				expanded = append(expanded, logins...)
+				continue
+			}
+			expanded = append(expanded, l)
+		}
+		return expanded
+	}
+	for i := range f.filters {
+		c := &f.filters[i].config
+		c.Approvers = expand(c.Approvers)
+		c.Reviewers = expand(c.Reviewers)
+		c.RequiredReviewers = expand(c.RequiredReviewers)
+	}
+}
+
+// dirOf returns the directory of a path of the repo, "" for the root.
+func dirOf(p string) string {
+	d := path.Dir(p)
+	if d == "." || d == "/" {
+		return ""
+	}
+	return d
+}
+
+// File is the content of an OWNERS file.
+type File struct {
+	Options Options
+	// Configs are keyed by the regexp of the paths they apply to, "" for
+	// the config of a file without filters.
+	Configs map[string]Config
+}
+
+// ParseFile parses the content of an OWNERS file, as found in a PR before
+// it is merged.
+func ParseFile(data []byte) (*File, error) {
+	f, err := parseOwnersFile(data)
+	if err != nil {
+		return nil, err
+	}
+	file := &File{Options: f.options, Configs: map[string]Config{}}
+	for _, flt := range f.filters {
+		pattern := ""
+		if flt.re != nil {
+			pattern = flt.re.String()
+		}
+		file.Configs[pattern] = flt.config
+	}
+	return file, nil
+}
+
+// ownersYAML is the layout of an OWNERS file: either a config or filters.
+type ownersYAML struct {
+	Options Options `yaml:"options"`
+	Config  `yaml:",inline"`
+	Filters map[string]Config `yaml:"filters"`
+}
+
+// parseOwnersFile parses the content of an OWNERS file.
+func parseOwnersFile(data []byte) (*ownersFile, error) {
+	var doc *ownersYAML
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return &ownersFile{}, nil
+	}
+
+	f := &ownersFile{options: doc.Options}
+	if doc.Filters == nil {
+		f.filters = []filter{{config: doc.Config.normalized()}}
+		return f, nil
+	}
+	// Sorted so that lookups are deterministic.
+	patterns := make([]string, 0, len(doc.Filters))
+	for p := range doc.Filters {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("filters: invalid regexp %q: %v", p, err)
+		}
+		f.filters = append(f.filters, filter{re: re, config: doc.Filters[p].normalized()})
+	}
+	return f, nil
+}
+
+// normalized lowercases the logins of c, as they are case insensitive on
+// GitHub.
+func (c Config) normalized() Config {
+	for _, list := range [][]string{c.Approvers, c.Reviewers, c.RequiredReviewers} {
+		for i := range list {
+			list[i] = strings.ToLower(list[i])
+		}
+	}
+	return c
+}
+
+// owner is the config an OWNERS file gives a path.
+type owner struct {
+	dir    string
+	config Config
+}
+
+// owners returns the configs of the OWNERS files covering p, from the
+// closest directory up.
+func (o *RepoOwners) owners(p string) []owner {
+	var found []owner
+	dir := dirOf(p)
+	if f, ok := o.mdFiles[p]; ok {
+		// The owners of a markdown file are those of the file alone.
+		for _, flt := range f.filters {
+			if flt.re == nil || flt.re.MatchString(path.Base(p)) {
+				found = append(found, owner{dir: p, config: flt.config})
+			}
+		}
+		if f.options.NoParentOwners {
+			return found
+		}
+	}
+	for {
+		if f, ok := o.files[dir]; ok {
+			rel := strings.TrimPrefix(strings.TrimPrefix(p, dir), "/")
+			for _, flt := range f.filters {
+				if flt.re == nil || flt.re.MatchString(rel) {
+					found = append(found, owner{dir: dir, config: flt.config})
+				}
+			}
+			if f.options.NoParentOwners {
+				return found
+			}
+		}
+		if dir == "" {
+			return found
+		}
+		dir = dirOf(dir)
+	}
+}
+
+// collect returns the sorted union of the field of the configs covering p.
+func (o *RepoOwners) collect(p string, field func(Config) []string) []string {
+	set := map[string]bool{}
+	for _, ow := range o.owners(p) {
+		for _, v := range field(ow.config) {
+			set[v] = true
+		}
+	}
+	list := make([]string, 0, len(set))
+	for v := range set {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Approvers returns the logins that can approve changes to p.
+func (o *RepoOwners) Approvers(p string) []string {
+	return o.collect(p, func(c Config) []string { return c.Approvers })
+}
+
+// Reviewers returns the logins that can review changes to p.
+func (o *RepoOwners) Reviewers(p string) []string {
+	return o.collect(p, func(c Config) []string { return c.Reviewers })
+}
+
+// RequiredReviewers returns the logins that must review changes to p.
+func (o *RepoOwners) RequiredReviewers(p string) []string {
+	return o.collect(p, func(c Config) []string { return c.RequiredReviewers })
+}
+
+// Labels returns the labels of the PRs changing p.
+func (o *RepoOwners) Labels(p string) []string {
+	return o.collect(p, func(c Config) []string { return c.Labels })
+}
+
+// ApproversDir returns the closest directory whose OWNERS files give p
+// approvers, and whether there is one. The path of a markdown file
+// giving itself approvers is returned as is.
+func (o *RepoOwners) ApproversDir(p string) (string, bool) {
+	for _, ow := range o.owners(p) {
+		if len(ow.config.Approvers) > 0 {
+			return ow.dir, true
+		}
+	}
+	return "", false
+}
+
+// LeafApprovers returns the approvers of p given by the closest directory
+// having any.
+func (o *RepoOwners) LeafApprovers(p string) []string {
+	dir, ok := o.ApproversDir(p)
+	if !ok {
+		return nil
+	}
+	set := map[string]bool{}
+	for _, ow := range o.owners(p) {
+		if ow.dir != dir {
+			continue
+		}
+		for _, a := range ow.config.Approvers {
+			set[a] = true
+		}
+	}
+	list := make([]string, 0, len(set))
+	for a := range set {
+		list = append(list, a)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// TopLevelApprovers returns the approvers the root OWNERS file gives every
+// path of the repo, its filters aside.
+func (o *RepoOwners) TopLevelApprovers() []string {
+	f, ok := o.files[""]
+	if !ok {
+		return nil
+	}
+	set := map[string]bool{}
+	for _, flt := range f.filters {
+		if flt.re != nil {
+			continue
+		}
+		for _, a := range flt.config.Approvers {
+			set[a] = true
+		}
+	}
+	list := make([]string, 0, len(set))
+	for a := range set {
+		list = append(list, a)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Empty reports whether the repo has no OWNERS files.
+func (o *RepoOwners) Empty() bool {
+	return len(o.files) == 0 && len(o.mdFiles) == 0
+}
@@ -0,0 +1,209 @@
+package repoowners
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+// fakeFiles serves the files of a single commit.
+type fakeFiles map[string]string
+
+func (f fakeFiles) ListTree(org, repo, sha string) ([]string, error) {
+	paths := make([]string, 0, len(f))
+	for p := range f {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (f fakeFiles) GetFile(org, repo, path, ref string) ([]byte, error) {
+	data, ok := f[path]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", path)
+	}
+	return []byte(data), nil
+}
+
+var testFiles = fakeFiles{
+	"OWNERS":             "approvers: [Alice]\nreviewers: [bob]\nlabels: [area/root]\n",
+	"OWNERS_ALIASES":     "aliases:\n  Docs-Approvers: [Carol, dave]\n",
+	"main.go":            "package main\n",
+	"docs/OWNERS":        "approvers: [docs-approvers]\nlabels: [area/docs]\n",
+	"docs/README.md":     "---\napprovers: [erin]\n---\n# Docs\n",
+	"docs/guide.md":      "# Guide\n",
+	"pkg/OWNERS":         "filters:\n  \"\\\\.go$\":\n    approvers: [frank]\n  \"_test\\\\.go$\":\n    reviewers: [grace]\n",
+	"pkg/a.go":           "package pkg\n",
+	"pkg/a_test.go":      "package pkg\n",
+	"pkg/doc.txt":        "",
+	"vendor/OWNERS":      "options:\n  no_parent_owners: true\napprovers: [heidi]\n",
+	"vendor/x/x.go":      "package x\n",
+	"third_party/OWNERS": "approvers: [ivan]\n",
+	"third_party/y/y.go": "package y\n",
+}
+
+func TestRepoOwners(t *testing.T) {
+	c := NewClient(testFiles, func(org, repo string) bool { return true }, func(org, repo string) []*regexp.Regexp {
+		return []*regexp.Regexp{regexp.MustCompile("^third_party$")}
+	}, nil)
+	o, err := c.LoadRepoOwners("org", "repo", "sha")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		path          string
+		approvers     []string
+		reviewers     []string
+		labels        []string
+		leafApprovers []string
+		approversDir  string
+	}{
+		{
+			path:          "main.go",
+			approvers:     []string{"alice"},
+			reviewers:     []string{"bob"},
+			labels:        []string{"area/root"},
+			leafApprovers: []string{"alice"},
+		},
+		{
+			path:          "docs/guide.md",
+			approvers:     []string{"alice", "carol", "dave"},
+			reviewers:     []string{"bob"},
+			labels:        []string{"area/docs", "area/root"},
+			leafApprovers: []string{"carol", "dave"},
+			approversDir:  "docs",
+		},
+		{
+			path:          "docs/README.md",
+			approvers:     []string{"alice", "carol", "dave", "erin"},
+			reviewers:     []string{"bob"},
+			labels:        []string{"area/docs", "area/root"},
+			leafApprovers: []string{"erin"},
+			approversDir:  "docs/README.md",
+		},
+		{
+			path:          "pkg/a.go",
+			approvers:     []string{"alice", "frank"},
+			reviewers:     []string{"bob"},
+			labels:        []string{"area/root"},
+			leafApprovers: []string{"frank"},
+			approversDir:  "pkg",
+		},
+		{
+			path:          "pkg/a_test.go",
+			approvers:     []string{"alice", "frank"},
+			reviewers:     []string{"bob", "grace"},
+			labels:        []string{"area/root"},
+			leafApprovers: []string{"frank"},
+			approversDir:  "pkg",
+		},
+		{
+			path:          "pkg/doc.txt",
+			approvers:     []string{"alice"},
+			reviewers:     []string{"bob"},
+			labels:        []string{"area/root"},
+			leafApprovers: []string{"alice"},
+		},
+		{
+			path:          "vendor/x/x.go",
+			approvers:     []string{"heidi"},
+			reviewers:     []string{},
+			labels:        []string{},
+			leafApprovers: []string{"heidi"},
+			approversDir:  "vendor",
+		},
+		{
+			path:          "third_party/y/y.go",
+			approvers:     []string{"alice"},
+			reviewers:     []string{"bob"},
+			labels:        []string{"area/root"},
+			leafApprovers: []string{"alice"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			if got := o.Approvers(tc.path); !reflect.DeepEqual(got, tc.approvers) {
+				t.Errorf("Approvers: expected %v, got %v", tc.approvers, got)
+			}
+			if got := o.Reviewers(tc.path); !reflect.DeepEqual(got, tc.reviewers) {
+				t.Errorf("Reviewers: expected %v, got %v", tc.reviewers, got)
+			}
+			if got := o.Labels(tc.path); !reflect.DeepEqual(got, tc.labels) {
+				t.Errorf("Labels: expected %v, got %v", tc.labels, got)
+			}
+			if got := o.LeafApprovers(tc.path); !reflect.DeepEqual(got, tc.leafApprovers) {
+				t.Errorf("LeafApprovers: expected %v, got %v", tc.leafApprovers, got)
+			}
+			if got, _ := o.ApproversDir(tc.path); got != tc.approversDir {
+				t.Errorf("ApproversDir: expected %q, got %q", tc.approversDir, got)
+			}
+		})
+	}
+
+	if got, expected := o.TopLevelApprovers(), []string{"alice"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("TopLevelApprovers: expected %v, got %v", expected, got)
+	}
+}
+
+func TestLoadRepoOwnersErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fakeFiles
+	}{
+		{
+			name:  "invalid OWNERS",
+			files: fakeFiles{"OWNERS": "approvers: ["},
+		},
+		{
+			name:  "invalid filter",
+			files: fakeFiles{"OWNERS": "filters:\n  \"(\":\n    approvers: [alice]\n"},
+		},
+		{
+			name:  "invalid OWNERS_ALIASES",
+			files: fakeFiles{"OWNERS_ALIASES": "aliases: ["},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClient(tc.files, nil, nil, nil).LoadRepoOwners("org", "repo", "sha"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestYAMLHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		header string
+		ok     bool
+	}{
+		{
+			name:   "header",
+			data:   "---\napprovers: [alice]\n---\n# Title\n",
+			header: "approvers: [alice]\n",
+			ok:     true,
+		},
+		{
+			name: "no header",
+			data: "# Title\n---\n",
+		},
+		{
+			name: "unterminated header",
+			data: "---\napprovers: [alice]\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			header, ok := yamlHeader([]byte(tc.data))
+			if ok != tc.ok || string(header) != tc.header {
+				t.Errorf("expected %q, %t, got %q, %t", tc.header, tc.ok, header, ok)
+			}
+		})
+	}
+}